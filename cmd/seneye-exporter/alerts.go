@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/alert"
+	"github.com/jcodybaker/seneye-exporter/pkg/alert/email"
+	"github.com/jcodybaker/seneye-exporter/pkg/alert/push"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// addAlertFlags registers and binds the flags configuring alert notifications.
+func addAlertFlags(cmd *cobra.Command) {
+	cmd.Flags().Duration("alert-repeat-interval", 4*time.Hour, `Interval at which notifications are resent while a SUD's alerts are firing; 0 only
+notifies when alerts are raised or resolved`)
+	viper.BindPFlag("alert-repeat-interval", cmd.Flags().Lookup("alert-repeat-interval"))
+	viper.SetDefault("alert-repeat-interval", 4*time.Hour)
+
+	cmd.Flags().String("smtp-address", "", `host:port of an SMTP server to email alert notifications through. Alerts are raised
+for the SUD's status flags, and alert-rules in the config file. Disabled if unset.`)
+	viper.BindPFlag("smtp-address", cmd.Flags().Lookup("smtp-address"))
+	cmd.Flags().String("smtp-tls", email.TLSStartTLS, `SMTP TLS mode: "starttls" (required), "tls" for implicit TLS, or "none"`)
+	viper.BindPFlag("smtp-tls", cmd.Flags().Lookup("smtp-tls"))
+	viper.SetDefault("smtp-tls", email.TLSStartTLS)
+	cmd.Flags().String("smtp-username", "", "SMTP PLAIN auth username")
+	viper.BindPFlag("smtp-username", cmd.Flags().Lookup("smtp-username"))
+	cmd.Flags().String("smtp-password", "", "SMTP PLAIN auth password")
+	viper.BindPFlag("smtp-password", cmd.Flags().Lookup("smtp-password"))
+	cmd.Flags().String("smtp-from", "", "Sender address of alert emails, ex. \"Aquarium <alerts@example.com>\"")
+	viper.BindPFlag("smtp-from", cmd.Flags().Lookup("smtp-from"))
+	cmd.Flags().StringSlice("smtp-to", nil, "Recipient address of alert emails. May be specified multiple times.")
+	viper.BindPFlag("smtp-to", cmd.Flags().Lookup("smtp-to"))
+	cmd.Flags().String("smtp-subject", email.DefaultSubject, "Go template for the subject of alert emails")
+	viper.BindPFlag("smtp-subject", cmd.Flags().Lookup("smtp-subject"))
+	viper.SetDefault("smtp-subject", email.DefaultSubject)
+	cmd.Flags().String("smtp-text-template", "", "File containing a Go text/template for the plain text body of alert emails")
+	viper.BindPFlag("smtp-text-template", cmd.Flags().Lookup("smtp-text-template"))
+	cmd.Flags().String("smtp-html-template", "", "File containing a Go html/template for the HTML body of alert emails")
+	viper.BindPFlag("smtp-html-template", cmd.Flags().Lookup("smtp-html-template"))
+
+	cmd.Flags().String("alert-click-url", "", `Go template for the URL opened when a push notification is tapped, ex. the dashboard's
+URL. Available: {{.SUD.ID}}, {{.SUD.Name}}.`)
+	viper.BindPFlag("alert-click-url", cmd.Flags().Lookup("alert-click-url"))
+	cmd.Flags().String("alert-quiet-hours", "", `Local time period, ex. 22:00-07:00, during which push notifications which aren't
+critical are delivered at the lowest priority`)
+	viper.BindPFlag("alert-quiet-hours", cmd.Flags().Lookup("alert-quiet-hours"))
+	cmd.Flags().String("ntfy-url", "", "ntfy topic URL to publish alert notifications to, ex. https://ntfy.sh/my-aquarium. Disabled if unset.")
+	viper.BindPFlag("ntfy-url", cmd.Flags().Lookup("ntfy-url"))
+	cmd.Flags().String("ntfy-token", "", "ntfy access token")
+	viper.BindPFlag("ntfy-token", cmd.Flags().Lookup("ntfy-token"))
+	cmd.Flags().String("ntfy-username", "", "ntfy basic auth username")
+	viper.BindPFlag("ntfy-username", cmd.Flags().Lookup("ntfy-username"))
+	cmd.Flags().String("ntfy-password", "", "ntfy basic auth password")
+	viper.BindPFlag("ntfy-password", cmd.Flags().Lookup("ntfy-password"))
+	cmd.Flags().String("gotify-url", "", "Gotify server to send alert notifications to, ex. https://gotify.example.com. Disabled if unset.")
+	viper.BindPFlag("gotify-url", cmd.Flags().Lookup("gotify-url"))
+	cmd.Flags().String("gotify-token", "", "Gotify application token")
+	viper.BindPFlag("gotify-token", cmd.Flags().Lookup("gotify-token"))
+	cmd.Flags().String("pushover-token", "", "Pushover application API token to send alert notifications with. Disabled if unset.")
+	viper.BindPFlag("pushover-token", cmd.Flags().Lookup("pushover-token"))
+	cmd.Flags().String("pushover-user", "", "Pushover user or group key to notify")
+	viper.BindPFlag("pushover-user", cmd.Flags().Lookup("pushover-user"))
+	cmd.Flags().String("pushover-device", "", "Pushover device to notify; all of the user's devices if unset")
+	viper.BindPFlag("pushover-device", cmd.Flags().Lookup("pushover-device"))
+	cmd.Flags().String("pushover-url", push.DefaultPushoverURL, "Message API of Pushover or a compatible service")
+	viper.BindPFlag("pushover-url", cmd.Flags().Lookup("pushover-url"))
+	viper.SetDefault("pushover-url", push.DefaultPushoverURL)
+	cmd.Flags().Duration("pushover-retry", time.Minute, "Interval at which critical Pushover notifications are repeated until acknowledged; at least 30s")
+	viper.BindPFlag("pushover-retry", cmd.Flags().Lookup("pushover-retry"))
+	viper.SetDefault("pushover-retry", time.Minute)
+	cmd.Flags().Duration("pushover-expire", time.Hour, "Duration for which unacknowledged critical Pushover notifications are repeated; at most 3h")
+	viper.BindPFlag("pushover-expire", cmd.Flags().Lookup("pushover-expire"))
+	viper.SetDefault("pushover-expire", time.Hour)
+}
+
+// alertRuleConfig describes a threshold alert. Rules may be listed under alert-rules in the config
+// file.
+type alertRuleConfig struct {
+	// Name identifies the alert.
+	Name string `mapstructure:"name"`
+	// Reading is the name of the reading tested, ex. "temperature".
+	Reading string `mapstructure:"reading"`
+	// Min and Max are the limits of the reading, if set.
+	Min *float64 `mapstructure:"min"`
+	Max *float64 `mapstructure:"max"`
+	// Severity is "warning" (the default) or "critical".
+	Severity string `mapstructure:"severity"`
+	// SUDs limits the rule to these SUD IDs, if set.
+	SUDs []string `mapstructure:"suds"`
+}
+
+// alertRules returns the alert-rules from the config file.
+func alertRules() ([]alert.Rule, error) {
+	var configs []alertRuleConfig
+	if viper.IsSet("alert-rules") {
+		if err := viper.UnmarshalKey("alert-rules", &configs); err != nil {
+			return nil, fmt.Errorf("parsing alert-rules: %w", err)
+		}
+	}
+	var rules []alert.Rule
+	for _, c := range configs {
+		r := alert.Rule{Name: c.Name, Reading: c.Reading, Min: c.Min, Max: c.Max, SUDs: c.SUDs}
+		if c.Severity != "" {
+			var err error
+			if r.Severity, err = alert.ParseSeverity(c.Severity); err != nil {
+				return nil, fmt.Errorf("alert rule %q: %w", c.Name, err)
+			}
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// alertNotifiers returns the configured notifiers.
+func alertNotifiers() ([]alert.Notifier, error) {
+	var notifiers []alert.Notifier
+	if addr := viper.GetString("smtp-address"); addr != "" {
+		config := email.Config{
+			Address:  addr,
+			TLS:      viper.GetString("smtp-tls"),
+			Username: viper.GetString("smtp-username"),
+			Password: viper.GetString("smtp-password"),
+			From:     viper.GetString("smtp-from"),
+			To:       viper.GetStringSlice("smtp-to"),
+			Subject:  viper.GetString("smtp-subject"),
+		}
+		for flag, tmpl := range map[string]*string{
+			"smtp-text-template": &config.Text,
+			"smtp-html-template": &config.HTML,
+		} {
+			if file := viper.GetString(flag); file != "" {
+				b, err := ioutil.ReadFile(file)
+				if err != nil {
+					return nil, fmt.Errorf("reading %s: %w", flag, err)
+				}
+				*tmpl = string(b)
+			}
+		}
+		n, err := email.New(config)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, n)
+	}
+
+	options := push.Options{ClickURL: viper.GetString("alert-click-url")}
+	if quiet := viper.GetString("alert-quiet-hours"); quiet != "" {
+		var err error
+		if options.QuietHours, err = alert.ParseQuietHours(quiet, time.Local); err != nil {
+			return nil, err
+		}
+	}
+	if u := viper.GetString("ntfy-url"); u != "" {
+		n, err := push.NewNtfy(push.NtfyConfig{
+			Options:  options,
+			URL:      u,
+			Token:    viper.GetString("ntfy-token"),
+			Username: viper.GetString("ntfy-username"),
+			Password: viper.GetString("ntfy-password"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, n)
+	}
+	if u := viper.GetString("gotify-url"); u != "" {
+		n, err := push.NewGotify(push.GotifyConfig{
+			Options: options,
+			URL:     u,
+			Token:   viper.GetString("gotify-token"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, n)
+	}
+	if token := viper.GetString("pushover-token"); token != "" {
+		n, err := push.NewPushover(push.PushoverConfig{
+			Options: options,
+			URL:     viper.GetString("pushover-url"),
+			Token:   token,
+			User:    viper.GetString("pushover-user"),
+			Device:  viper.GetString("pushover-device"),
+			Retry:   viper.GetDuration("pushover-retry"),
+			Expire:  viper.GetDuration("pushover-expire"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, n)
+	}
+	return notifiers, nil
+}
+
+// alertManager returns a Manager notifying of alerts, or nil if no notifiers are configured.
+func alertManager() (*alert.Manager, error) {
+	rules, err := alertRules()
+	if err != nil {
+		return nil, err
+	}
+	notifiers, err := alertNotifiers()
+	if err != nil {
+		return nil, err
+	}
+	if len(notifiers) == 0 {
+		if len(rules) > 0 {
+			return nil, errors.New("alert-rules require a notifier, ex. --smtp-address or --ntfy-url")
+		}
+		return nil, nil
+	}
+	return alert.New(alert.Config{
+		Rules:          rules,
+		Notifiers:      notifiers,
+		RepeatInterval: viper.GetDuration("alert-repeat-interval"),
+	})
+}
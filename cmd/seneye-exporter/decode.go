@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var decodeCmd = &cobra.Command{
+	Use:   "decode [FILE]",
+	Short: "Decode a raw LDE request body for debugging.",
+	Long: `Decode a raw LDE request body, read from FILE or stdin, for debugging. The JWT header and
+claims are shown, the signature is validated if secrets are available, and the metrics the
+exporter would emit for the push are printed. Exits non-zero if the body fails validation.`,
+	Args:   cobra.MaximumNArgs(1),
+	PreRun: configureLog,
+	Run:    decodeExecute,
+}
+
+func init() {
+	decodeCmd.Flags().StringSlice("lde-secret", nil, `Secret used to validate the LDE, in the same format as the root command's
+--lde-secret. Defaults to the lde-secret configured by config file or environment.
+If no secret is available the signature is not checked.`)
+	rootCmd.AddCommand(decodeCmd)
+}
+
+func decodeExecute(cmd *cobra.Command, args []string) {
+	var in io.Reader = os.Stdin
+	if len(args) == 1 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			log.Fatal().Err(err).Msg("opening LDE body")
+		}
+		defer f.Close()
+		in = f
+	}
+	body, err := ioutil.ReadAll(in)
+	if err != nil {
+		log.Fatal().Err(err).Msg("reading LDE body")
+	}
+
+	secrets, err := secretsFromFlags(cmd)
+	if err != nil {
+		log.Fatal().Err(err).Msg("parsing lde-secret")
+	}
+
+	if !writeDecoded(cmd.OutOrStdout(), bytes.TrimSpace(body), secrets) {
+		os.Exit(1)
+	}
+}
+
+// secretsFromFlags parses a subcommand's --lde-secret flag, falling back to the lde-secret
+// configured by config file or environment. It returns nil if no secrets are configured.
+func secretsFromFlags(cmd *cobra.Command) (map[string][]byte, error) {
+	secrets, _ := cmd.Flags().GetStringSlice("lde-secret")
+	if !cmd.Flags().Changed("lde-secret") {
+		secrets = viper.GetStringSlice("lde-secret")
+	}
+	if len(secrets) == 0 {
+		return nil, nil
+	}
+	return parseSecrets(secrets)
+}
+
+// writeDecoded writes a human readable description of the LDE body, returning false if the body
+// isn't valid.
+func writeDecoded(w io.Writer, body []byte, secrets map[string][]byte) bool {
+	d := lde.Decode(body, secrets)
+	fmt.Fprintf(w, "Token:\n%s\n\n", d.Token)
+	fmt.Fprintf(w, "Header:\n%s\n\n", indentJSON(d.Header))
+	fmt.Fprintf(w, "Claims:\n%s\n\n", indentJSON(d.Claims))
+
+	valid := d.Err == nil
+	fmt.Fprint(w, "Signature:\n")
+	switch {
+	case d.ParseErr != nil:
+		fmt.Fprintf(w, "invalid: %v\n", d.ParseErr)
+	case secrets == nil:
+		// Without secrets every token fails validation; only report the parse result.
+		valid = true
+		fmt.Fprint(w, "not checked, no lde-secret provided\n")
+	case d.Err != nil:
+		fmt.Fprintf(w, "invalid: %v\n", d.Err)
+	default:
+		if id, _ := lde.SecretID(d.SUDID, secrets); id != "" {
+			fmt.Fprintf(w, "valid, signed with the secret for SUD %q\n", id)
+		} else {
+			fmt.Fprint(w, "valid, signed with the default secret\n")
+		}
+	}
+
+	if d.LDE == nil {
+		return false
+	}
+	if !lde.SupportedVersion(d.LDE.Version) {
+		fmt.Fprintf(w, "\nVersion:\nunsupported LDE version %q, decoded on a best-effort basis\n", d.LDE.Version)
+	}
+	if unknown := d.LDE.UnknownFields(); len(unknown) > 0 {
+		fmt.Fprint(w, "\nUnknown fields:\n")
+		for _, f := range unknown {
+			fmt.Fprintln(w, f)
+		}
+	}
+	fmt.Fprint(w, "\nMetrics:\n")
+	if err := lde.WriteMetrics(w, d.LDE); err != nil {
+		fmt.Fprintf(w, "error: %v\n", err)
+		return false
+	}
+	return valid
+}
+
+// indentJSON formats v as indented JSON, or "(undecodable)" if it's empty.
+func indentJSON(v interface{}) string {
+	var b []byte
+	switch v := v.(type) {
+	case json.RawMessage:
+		if v == nil {
+			return "(undecodable)"
+		}
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, v, "", "  "); err != nil {
+			return string(v)
+		}
+		return buf.String()
+	case map[string]interface{}:
+		if v == nil {
+			return "(undecodable)"
+		}
+		b, _ = json.MarshalIndent(v, "", "  ")
+	}
+	return string(b)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteDecoded(t *testing.T) {
+	claims := `{"version":"1.0.0","SUD":{"id":"abc","name":"tank","type":1,"TS":1610505992,"data":{"T":25.5}}}`
+	body := []byte(signToken(t, claims, []byte("secret")))
+
+	var buf bytes.Buffer
+	assert.True(t, writeDecoded(&buf, body, map[string][]byte{"abc": []byte("secret")}))
+	assert.Contains(t, buf.String(), `valid, signed with the secret for SUD "abc"`)
+	assert.Contains(t, buf.String(), `temperature_celsius{id="abc",name="tank",sud_type="home"} 25.5`)
+
+	buf.Reset()
+	assert.False(t, writeDecoded(&buf, body, map[string][]byte{"": []byte("wrong")}))
+	assert.Contains(t, buf.String(), "invalid: signature is invalid")
+	assert.Contains(t, buf.String(), "temperature_celsius", "metrics are shown for invalid pushes")
+
+	buf.Reset()
+	assert.True(t, writeDecoded(&buf, body, nil))
+	assert.Contains(t, buf.String(), "not checked")
+
+	buf.Reset()
+	assert.False(t, writeDecoded(&buf, []byte("garbage"), nil))
+	assert.Contains(t, buf.String(), "(undecodable)")
+}
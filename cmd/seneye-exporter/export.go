@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/archive"
+	"github.com/jcodybaker/seneye-exporter/pkg/export"
+	"github.com/jcodybaker/seneye-exporter/pkg/history"
+	"github.com/jcodybaker/seneye-exporter/pkg/store"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export past readings as CSV or Parquet.",
+	Long: `Export past readings as CSV or Parquet, one row per push, from the database or the push
+archive. Readings which the database has downsampled are exported as one row per hour, with the
+hour's average values. Readings can be filtered by SUD and time range.
+
+Parquet exports are written to the --output directory, partitioned by SUD and month as
+sud=ID/month=YYYY-MM/readings.parquet. Partitions are always written whole, so --since and
+--until are widened to month boundaries.`,
+	Args:   cobra.NoArgs,
+	PreRun: configureLog,
+	Run:    exportExecute,
+}
+
+func init() {
+	exportCmd.Flags().String("db", "", "SQLite database to export from. Defaults to the db configured by config file or environment.")
+	exportCmd.Flags().String("archive-dir", "", `Archive directory to export from, if there's no database. Defaults to the archive-dir
+configured by config file or environment.`)
+	exportCmd.Flags().StringSlice("sud-id", nil, "Only export readings from these SUD IDs. May be specified multiple times.")
+	exportCmd.Flags().String("since", "", "Only export readings taken at or after this RFC 3339 time")
+	exportCmd.Flags().String("until", "", "Only export readings taken before this RFC 3339 time")
+	exportCmd.Flags().String("timezone", "", "IANA time zone (ex. Europe/London) of the exported times. Defaults to the local time zone.")
+	exportCmd.Flags().String("format", "csv", `Export format: "csv" or "parquet"`)
+	exportCmd.Flags().StringP("output", "o", "-", "File to write a CSV export to, or - for stdout, or directory to write a Parquet export to")
+	rootCmd.AddCommand(exportCmd)
+}
+
+func exportExecute(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	var src history.Source
+	if path := stringFlagOrConfig(cmd, "db"); path != "" {
+		s, err := store.Open(ctx, store.Config{Path: path})
+		if err != nil {
+			log.Fatal().Err(err).Msg("opening database")
+		}
+		defer s.Close(ctx)
+		src = s
+	} else if dir := stringFlagOrConfig(cmd, "archive-dir"); dir != "" {
+		src = &archive.History{Dir: dir}
+	} else {
+		log.Fatal().Msg("db or archive-dir is required")
+	}
+
+	var q history.Query
+	var err error
+	q.SUDIDs, _ = flags.GetStringSlice("sud-id")
+	if q.Since, err = parseTimeFlag(cmd, "since"); err != nil {
+		log.Fatal().Err(err).Msg("parsing since")
+	}
+	if q.Until, err = parseTimeFlag(cmd, "until"); err != nil {
+		log.Fatal().Err(err).Msg("parsing until")
+	}
+	output, _ := flags.GetString("output")
+	switch format, _ := flags.GetString("format"); format {
+	case "csv":
+	case "parquet":
+		if output == "-" {
+			log.Fatal().Msg("parquet exports require an --output directory")
+		}
+		files, err := export.WriteParquet(ctx, output, src, q)
+		if err != nil {
+			log.Fatal().Err(err).Msg("exporting")
+		}
+		log.Info().Int("files", len(files)).Str("dir", output).Msg("export complete")
+		return
+	default:
+		log.Fatal().Str("format", format).Msg("unknown export format")
+	}
+
+	loc := time.Local
+	if tz, _ := flags.GetString("timezone"); tz != "" {
+		if loc, err = time.LoadLocation(tz); err != nil {
+			log.Fatal().Err(err).Msg("parsing timezone")
+		}
+	}
+
+	var out io.WriteCloser = nopCloser{cmd.OutOrStdout()}
+	if output != "-" {
+		if out, err = os.Create(output); err != nil {
+			log.Fatal().Err(err).Msg("creating output")
+		}
+	}
+	if err := export.WriteCSV(ctx, out, src, q, loc); err != nil {
+		log.Fatal().Err(err).Msg("exporting")
+	}
+	if err := out.Close(); err != nil {
+		log.Fatal().Err(err).Msg("writing output")
+	}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// stringFlagOrConfig returns the named flag of a subcommand, falling back to the value configured
+// by config file or environment.
+func stringFlagOrConfig(cmd *cobra.Command, name string) string {
+	if cmd.Flags().Changed(name) {
+		v, _ := cmd.Flags().GetString(name)
+		return v
+	}
+	return viper.GetString(name)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/grafana"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var genDashboardCmd = &cobra.Command{
+	Use:   "gen-dashboard",
+	Short: "Generate a Grafana dashboard for the exported metrics.",
+	Long: `Generate a Grafana dashboard for the exported metrics, with each SUD's latest readings and
+status flags, and their history. The sud variable selects the SUDs shown, and the datasource
+variable the prometheus they're queried from.
+
+The dashboard is written to --output, or with --grafana-url, pushed to Grafana's HTTP API,
+replacing any dashboard with the same --uid.`,
+	Args:   cobra.NoArgs,
+	PreRun: configureLog,
+	Run:    genDashboardExecute,
+}
+
+func init() {
+	genDashboardCmd.Flags().StringP("output", "o", "-", "File to write the dashboard JSON to, or - for stdout. Not written with --grafana-url unless set.")
+	genDashboardCmd.Flags().String("title", grafana.DefaultTitle, "Title of the dashboard")
+	genDashboardCmd.Flags().String("uid", grafana.DefaultUID, "UID of the dashboard; pushing replaces the dashboard with the same UID")
+	genDashboardCmd.Flags().StringSlice("tag", nil, "Tag of the dashboard. May be specified multiple times.")
+	genDashboardCmd.Flags().Duration("lookback", grafana.DefaultLookback, "Window in which each SUD's latest reading is found")
+	genDashboardCmd.Flags().Bool("fahrenheit", false, "Show temperatures in °F rather than °C")
+	genDashboardCmd.Flags().String("grafana-url", "", "Grafana server to push the dashboard to, ex. https://grafana.example.com")
+	genDashboardCmd.Flags().String("grafana-token", "", "Grafana service account token")
+	genDashboardCmd.Flags().String("grafana-username", "", "Grafana basic auth username")
+	genDashboardCmd.Flags().String("grafana-password", "", "Grafana basic auth password")
+	genDashboardCmd.Flags().String("grafana-folder-uid", "", "UID of the Grafana folder the dashboard is saved in; the General folder if unset")
+	rootCmd.AddCommand(genDashboardCmd)
+}
+
+func genDashboardExecute(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	config := grafana.Config{}
+	config.Title, _ = flags.GetString("title")
+	config.UID, _ = flags.GetString("uid")
+	config.Tags, _ = flags.GetStringSlice("tag")
+	config.Lookback, _ = flags.GetDuration("lookback")
+	config.Fahrenheit, _ = flags.GetBool("fahrenheit")
+	d := grafana.Generate(config)
+
+	output, _ := flags.GetString("output")
+	if u, _ := flags.GetString("grafana-url"); u != "" {
+		push := grafana.PushConfig{URL: u, Message: "Generated by seneye-exporter gen-dashboard"}
+		push.Token, _ = flags.GetString("grafana-token")
+		push.Username, _ = flags.GetString("grafana-username")
+		push.Password, _ = flags.GetString("grafana-password")
+		push.FolderUID, _ = flags.GetString("grafana-folder-uid")
+		dashboardURL, err := grafana.Push(context.Background(), push, d)
+		if err != nil {
+			log.Fatal().Err(err).Msg("pushing dashboard")
+		}
+		log.Info().Str("url", dashboardURL).Msg("pushed dashboard")
+		if !flags.Changed("output") {
+			return
+		}
+	}
+
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		log.Fatal().Err(err).Msg("encoding dashboard")
+	}
+	b = append(b, '\n')
+	var w io.Writer = cmd.OutOrStdout()
+	if output != "-" {
+		file, err := os.Create(output)
+		if err != nil {
+			log.Fatal().Err(err).Msg("creating output")
+		}
+		defer file.Close()
+		w = file
+	}
+	if _, err := w.Write(b); err != nil {
+		log.Fatal().Err(err).Msg("writing dashboard")
+	}
+}
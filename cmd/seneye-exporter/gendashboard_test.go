@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenDashboard(t *testing.T) {
+	// lock guards pushed, which the server decodes while the exporter runs.
+	var lock sync.Mutex
+	var pushed struct {
+		Dashboard struct {
+			UID   string `json:"uid"`
+			Title string `json:"title"`
+		} `json:"dashboard"`
+		FolderUID string `json:"folderUid"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/dashboards/db", r.URL.Path)
+		assert.Equal(t, "Bearer TOKEN", r.Header.Get("Authorization"))
+		lock.Lock()
+		defer lock.Unlock()
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&pushed))
+		w.Write([]byte(`{"status":"success","url":"/d/aquarium/aquarium"}`))
+	}))
+	defer srv.Close()
+
+	output := filepath.Join(t.TempDir(), "dashboard.json")
+	cmd := startExporter(t, "gen-dashboard",
+		"--title=Aquarium",
+		"--uid=aquarium",
+		"--grafana-url="+srv.URL,
+		"--grafana-token=TOKEN",
+		"--grafana-folder-uid=home",
+		"-o", output,
+	)
+	require.NoError(t, cmd.Wait())
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, "aquarium", pushed.Dashboard.UID)
+	assert.Equal(t, "Aquarium", pushed.Dashboard.Title)
+	assert.Equal(t, "home", pushed.FolderUID)
+
+	// The pushed dashboard is also written, as -o was given.
+	b, err := ioutil.ReadFile(output)
+	require.NoError(t, err)
+	var written map[string]interface{}
+	require.NoError(t, json.Unmarshal(b, &written))
+	assert.Equal(t, "aquarium", written["uid"])
+}
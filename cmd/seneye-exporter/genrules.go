@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/rules"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var genRulesCmd = &cobra.Command{
+	Use:   "gen-rules",
+	Short: "Generate Prometheus alerting rules for the exported metrics.",
+	Long: `Generate Prometheus alerting rules for the exported metrics, as a rule file or a
+prometheus-operator PrometheusRule resource. Alerts are raised while a SUD is out of the water,
+while it raises a status flag, while its readings cross the thresholds of alert-rules in the
+config file, and while it hasn't reported for --stale-alert-after. These match the alerts the
+exporter notifies of itself.
+
+Readings are exported with the time the SUD took them, so rules find each SUD's latest reading
+within --lookback, which must exceed the SUD's reporting interval.`,
+	Args:   cobra.NoArgs,
+	PreRun: configureLog,
+	Run:    genRulesExecute,
+}
+
+func init() {
+	genRulesCmd.Flags().String("format", "rules", `Output format: "rules" for a prometheus rule file, or "prometheusrule" for a
+prometheus-operator PrometheusRule resource`)
+	genRulesCmd.Flags().StringP("output", "o", "-", "File to write the rules to, or - for stdout")
+	genRulesCmd.Flags().String("group", rules.DefaultGroup, "Name of the rule group")
+	genRulesCmd.Flags().Duration("lookback", rules.DefaultLookback, "Window in which each SUD's latest reading is found")
+	genRulesCmd.Flags().Duration("stale-alert-after", 2*time.Hour, "Age of a SUD's latest reading after which it's alerted as stale; 0 disables")
+	genRulesCmd.Flags().Duration("for", 0, "Duration conditions must hold before alerts fire")
+	genRulesCmd.Flags().StringSlice("label", nil, "KEY=VALUE label added to every alert. May be specified multiple times.")
+	genRulesCmd.Flags().String("name", "seneye-exporter", "Name of the PrometheusRule resource")
+	genRulesCmd.Flags().String("namespace", "", "Namespace of the PrometheusRule resource")
+	genRulesCmd.Flags().StringSlice("resource-label", nil, `KEY=VALUE label of the PrometheusRule resource, ex. release=prometheus to match
+the prometheus' ruleSelector. May be specified multiple times.`)
+	rootCmd.AddCommand(genRulesCmd)
+}
+
+func genRulesExecute(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	config := rules.Config{}
+	config.Group, _ = flags.GetString("group")
+	config.Lookback, _ = flags.GetDuration("lookback")
+	config.StaleAfter, _ = flags.GetDuration("stale-alert-after")
+	config.For, _ = flags.GetDuration("for")
+	labels, _ := flags.GetStringSlice("label")
+	var err error
+	if config.Labels, err = parseLabels(labels); err != nil {
+		log.Fatal().Err(err).Msg("parsing label")
+	}
+	if config.Rules, err = alertRules(); err != nil {
+		log.Fatal().Err(err).Msg("invalid alert-rules")
+	}
+	f, err := rules.Generate(config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("generating rules")
+	}
+
+	var out interface{} = f
+	switch format, _ := flags.GetString("format"); format {
+	case "rules":
+	case "prometheusrule":
+		name, _ := flags.GetString("name")
+		namespace, _ := flags.GetString("namespace")
+		labels, _ := flags.GetStringSlice("resource-label")
+		resourceLabels, err := parseLabels(labels)
+		if err != nil {
+			log.Fatal().Err(err).Msg("parsing resource-label")
+		}
+		out = rules.NewPrometheusRule(name, namespace, resourceLabels, f)
+	default:
+		log.Fatal().Str("format", format).Msg("unknown format")
+	}
+	b, err := yaml.Marshal(out)
+	if err != nil {
+		log.Fatal().Err(err).Msg("encoding rules")
+	}
+
+	var w io.Writer = cmd.OutOrStdout()
+	if output, _ := flags.GetString("output"); output != "-" {
+		file, err := os.Create(output)
+		if err != nil {
+			log.Fatal().Err(err).Msg("creating output")
+		}
+		defer file.Close()
+		w = file
+	}
+	if _, err := w.Write(b); err != nil {
+		log.Fatal().Err(err).Msg("writing rules")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestGenRules(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(config, []byte(`
+alert-rules:
+  - name: high_temperature
+    reading: temperature
+    max: 27.5
+    severity: critical
+  - name: ph_range
+    reading: ph
+    min: 7.8
+    max: 8.4
+    suds: ["1234"]
+`), 0600))
+	output := filepath.Join(dir, "rules.yaml")
+	cmd := startExporter(t, "gen-rules",
+		"--config="+config,
+		"--format=prometheusrule",
+		"--namespace=monitoring",
+		"--resource-label=release=prometheus",
+		"--label=team=home",
+		"--for=5m",
+		"-o", output,
+	)
+	require.NoError(t, cmd.Wait())
+	got, err := ioutil.ReadFile(output)
+	require.NoError(t, err)
+
+	golden := filepath.Join("testdata", "gen-rules.golden.yaml")
+	if *update {
+		require.NoError(t, ioutil.WriteFile(golden, got, 0644))
+	}
+	want, err := ioutil.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got))
+}
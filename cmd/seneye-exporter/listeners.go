@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/health"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+	"github.com/jcodybaker/seneye-exporter/pkg/listen"
+	"github.com/jcodybaker/seneye-exporter/pkg/realip"
+	"github.com/jcodybaker/seneye-exporter/pkg/tlsconfig"
+	"github.com/jcodybaker/seneye-exporter/pkg/webconfig"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// listener is an HTTP server bound to one address.
+type listener struct {
+	name      string
+	addr      string
+	mux       *http.ServeMux
+	web       *webconfig.Config
+	server    *http.Server
+	listening *health.Condition
+	log       zerolog.Logger
+}
+
+// newListener configures, but doesn't start, an HTTP server for the address.
+func newListener(name, addr string, web *webconfig.Config, tlsConfig tlsconfig.Config, checker *health.Checker) *listener {
+	l := &listener{
+		name:      name,
+		addr:      addr,
+		mux:       http.NewServeMux(),
+		web:       web,
+		listening: checker.AddCondition(name+"-listener", name+" listener not bound"),
+		log:       log.With().Str("listener", name).Str("listen_addr", addr).Logger(),
+	}
+	l.server = &http.Server{
+		Handler: realip.Handler(trustedProxies())(logHandler(l.mux)),
+		BaseContext: func(net.Listener) context.Context {
+			return l.log.WithContext(ctx)
+		},
+		ReadTimeout:       viper.GetDuration("http-read-timeout"),
+		ReadHeaderTimeout: viper.GetDuration("http-read-timeout"),
+		WriteTimeout:      viper.GetDuration("http-write-timeout"),
+		IdleTimeout:       viper.GetDuration("http-idle-timeout"),
+	}
+	if tlsConfig.Enabled() {
+		var err error
+		if l.server.TLSConfig, err = tlsConfig.TLSConfig(); err != nil {
+			l.log.Fatal().Err(err).Msg("configuring TLS")
+		}
+	}
+	if !web.HTTP2() {
+		l.server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	return l
+}
+
+// listenAndServe binds the listener, marking it ready, and serves HTTP until the server is shut
+// down.
+func (l *listener) listenAndServe() error {
+	nl, err := listen.Listen(l.addr)
+	if err != nil {
+		l.listening.SetNotReady(err)
+		l.log.Error().Err(err).Msgf("failed to start %s http server", l.name)
+		return err
+	}
+	l.listening.SetReady()
+	l.log.Info().Bool("tls", l.server.TLSConfig != nil).Msgf("starting %s http server", l.name)
+	if l.server.TLSConfig != nil {
+		err = l.server.ServeTLS(nl, "", "")
+	} else {
+		err = l.server.Serve(nl)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		l.log.Error().Err(err).Msgf("serving %s http", l.name)
+		return err
+	}
+	return nil
+}
+
+// ldeListenerConfig describes an LDE listener. Multiple listeners, each with their own secrets,
+// may be listed under lde-listeners in the config file.
+type ldeListenerConfig struct {
+	// Listen is the address of the listener.
+	Listen string `mapstructure:"listen"`
+	// Secrets are the LDE secrets accepted by the listener, in the --lde-secret format. Defaults to
+	// the --lde-secret flag.
+	Secrets []string `mapstructure:"secrets"`
+
+	secrets map[string][]byte
+}
+
+// ldeListenerConfigs returns the lde-listeners from the config file, or a listener for each
+// --lde-listen address if none are configured.
+func ldeListenerConfigs() ([]ldeListenerConfig, error) {
+	var configs []ldeListenerConfig
+	if viper.IsSet("lde-listeners") {
+		if err := viper.UnmarshalKey("lde-listeners", &configs); err != nil {
+			return nil, fmt.Errorf("parsing lde-listeners: %w", err)
+		}
+		if len(configs) == 0 {
+			return nil, fmt.Errorf("lde-listeners is empty")
+		}
+	} else {
+		for _, addr := range viper.GetStringSlice("lde-listen") {
+			configs = append(configs, ldeListenerConfig{Listen: addr})
+		}
+		if len(configs) == 0 {
+			port := viper.GetUint("lde-port")
+			if port > 0xFFFF {
+				return nil, fmt.Errorf("invalid lde-port %q", viper.GetString("lde-port"))
+			}
+			configs = append(configs, ldeListenerConfig{Listen: fmt.Sprintf(":%d", port)})
+		}
+	}
+	for i := range configs {
+		c := &configs[i]
+		if c.Listen == "" {
+			return nil, fmt.Errorf("lde listener %d has no listen address", i)
+		}
+		for _, other := range configs[:i] {
+			if listen.Equal(c.Listen, other.Listen) || listen.Conflict(c.Listen, other.Listen) {
+				return nil, fmt.Errorf("lde listeners %q and %q conflict", other.Listen, c.Listen)
+			}
+		}
+		if len(c.Secrets) == 0 {
+			c.Secrets = viper.GetStringSlice("lde-secret")
+		}
+		var err error
+		if c.secrets, err = parseSecrets(c.Secrets); err != nil {
+			return nil, fmt.Errorf("lde listener %q: %w", c.Listen, err)
+		}
+	}
+	return configs, nil
+}
+
+// ldeRelayConfig describes a downstream LDE receiver to which accepted pushes are forwarded.
+// Relays may be listed under lde-relays in the config file.
+type ldeRelayConfig struct {
+	// URL receives the pushes.
+	URL string `mapstructure:"url"`
+	// Secret, if set, re-signs pushes for the receiver.
+	Secret string `mapstructure:"secret"`
+	// SUDs limits the forwarded pushes to those from these SUD IDs, if set.
+	SUDs []string `mapstructure:"suds"`
+}
+
+// ldeRelayTargets returns the lde-relays from the config file, and a relay for each --lde-relay
+// URL, which forwards pushes from every SUD as received.
+func ldeRelayTargets() ([]lde.RelayTarget, error) {
+	var configs []ldeRelayConfig
+	if viper.IsSet("lde-relays") {
+		if err := viper.UnmarshalKey("lde-relays", &configs); err != nil {
+			return nil, fmt.Errorf("parsing lde-relays: %w", err)
+		}
+	}
+	for _, u := range viper.GetStringSlice("lde-relay") {
+		configs = append(configs, ldeRelayConfig{URL: u})
+	}
+	var targets []lde.RelayTarget
+	for i, c := range configs {
+		u, err := url.Parse(c.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("lde relay %d: invalid url %q", i, c.URL)
+		}
+		t := lde.RelayTarget{URL: c.URL, SUDs: c.SUDs}
+		if c.Secret != "" {
+			t.Secret = []byte(c.Secret)
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// trustedProxies parses the --trusted-proxy networks.
+func trustedProxies() []*net.IPNet {
+	networks, err := realip.ParseCIDRs(viper.GetStringSlice("trusted-proxy"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid trusted-proxy")
+	}
+	return networks
+}
+
+// listenAddr returns the listen address for the named server (ex. "lde", "prom"), falling back to
+// all interfaces on its port.
+func listenAddr(server string) string {
+	if addr := viper.GetString(server + "-listen"); addr != "" {
+		return addr
+	}
+	port := viper.GetUint(server + "-port")
+	if port > 0xFFFF {
+		log.Fatal().Str(server+"_port", viper.GetString(server+"-port")).Msgf("invalid %s-port", server)
+	}
+	return fmt.Sprintf(":%d", port)
+}
+
+// sudIDPattern matches the SUD ID of a SUD_ID=SECRET pair. It excludes the characters of the
+// base64 alphabet which can't appear in a SUD ID, so padded secrets aren't split.
+var sudIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseSecrets parses LDE secrets, which are either a default secret or SUD_ID=SECRET.
+func parseSecrets(secrets []string) (map[string][]byte, error) {
+	out := make(map[string][]byte)
+	if len(secrets) == 0 {
+		return nil, fmt.Errorf("lde-secret is required")
+	}
+	for _, s := range secrets {
+		if s == "" {
+			return nil, fmt.Errorf("invalid lde-secret")
+		}
+		splitSecret := strings.SplitN(s, "=", 2)
+		if len(splitSecret) == 1 || !sudIDPattern.MatchString(splitSecret[0]) || strings.Trim(splitSecret[1], "=") == "" {
+			// Secrets may contain, or be padded with, '=' so anything that isn't a well formed
+			// SUD_ID=SECRET pair, including a secret followed only by padding, is the default.
+			if _, ok := out[""]; ok {
+				return nil, fmt.Errorf("only one default secret may be provided")
+			}
+			out[""] = []byte(s)
+			continue
+		}
+		if _, ok := out[splitSecret[0]]; ok {
+			return nil, fmt.Errorf("SUD ID %q had >1 secrets", splitSecret[0])
+		}
+		out[splitSecret[0]] = []byte(splitSecret[1])
+	}
+	return out, nil
+}
+
+// addTLSFlags registers the TLS flags for the named listener (ex. "lde", "prom").
+func addTLSFlags(cmd *cobra.Command, listener, description string) {
+	flags := []struct {
+		name, usage string
+	}{
+		{"tls-cert", fmt.Sprintf("PEM certificate file for the %s; enables TLS. Reloaded when changed.", description)},
+		{"tls-key", fmt.Sprintf("PEM private key file for the %s. Reloaded when changed.", description)},
+		{"tls-client-ca", fmt.Sprintf("PEM CA file; if set, %s clients must present a certificate signed by it (mutual TLS)", description)},
+		{"tls-min-version", fmt.Sprintf(`Minimum TLS version for the %s: "1.0", "1.1", "1.2", "1.3" (default "1.2")`, description)},
+	}
+	for _, f := range flags {
+		name := listener + "-" + f.name
+		cmd.Flags().String(name, "", f.usage)
+		viper.BindPFlag(name, cmd.Flags().Lookup(name))
+	}
+}
+
+// tlsConfigFromFlags builds the TLS config for the named listener from its flags, or its web config
+// file.
+func tlsConfigFromFlags(listener string, web *webconfig.Config) tlsconfig.Config {
+	c := tlsconfig.Config{
+		CertFile:     viper.GetString(listener + "-tls-cert"),
+		KeyFile:      viper.GetString(listener + "-tls-key"),
+		ClientCAFile: viper.GetString(listener + "-tls-client-ca"),
+		MinVersion:   viper.GetString(listener + "-tls-min-version"),
+	}
+	webTLS, err := web.TLS()
+	if err != nil {
+		log.Fatal().Err(err).Str("listener", listener).Msg("invalid web config TLS")
+	}
+	if !webTLS.Enabled() {
+		return c
+	}
+	if c.Enabled() {
+		log.Fatal().Str("listener", listener).
+			Msgf("%s-tls-* flags cannot be combined with tls_server_config in %s-web-config", listener, listener)
+	}
+	return webTLS
+}
+
+// webConfigFromFlags loads the web config file for the named listener. An empty config, with no
+// authentication, is returned if none was specified.
+func webConfigFromFlags(listener string) *webconfig.Config {
+	path := viper.GetString(listener + "-web-config")
+	if path == "" {
+		return &webconfig.Config{}
+	}
+	c, err := webconfig.Load(path)
+	if err != nil {
+		log.Fatal().Err(err).Str("listener", listener).Msg("loading web config")
+	}
+	return c
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSecrets(t *testing.T) {
+	for _, tc := range []struct {
+		secrets  []string
+		expected map[string][]byte
+	}{
+		{[]string{"AAAAAAAA"}, map[string][]byte{"": []byte("AAAAAAAA")}},
+		// Padded base64 secrets are the default, rather than a SUD ID and its padding.
+		{[]string{"abc=="}, map[string][]byte{"": []byte("abc==")}},
+		{[]string{"abc="}, map[string][]byte{"": []byte("abc=")}},
+		{[]string{"a+/b=="}, map[string][]byte{"": []byte("a+/b==")}},
+		{[]string{"=abc"}, map[string][]byte{"": []byte("=abc")}},
+		{
+			[]string{"c2VjcmV0==", "1234=SECRET1", "EXAMPLE_SUD_ID=c2VjcmV0=="},
+			map[string][]byte{"": []byte("c2VjcmV0=="), "1234": []byte("SECRET1"), "EXAMPLE_SUD_ID": []byte("c2VjcmV0==")},
+		},
+	} {
+		got, err := parseSecrets(tc.secrets)
+		require.NoError(t, err, tc.secrets)
+		assert.Equal(t, tc.expected, got, tc.secrets)
+	}
+
+	_, err := parseSecrets(nil)
+	assert.EqualError(t, err, "lde-secret is required")
+	_, err = parseSecrets([]string{"abc==", "def=="})
+	assert.EqualError(t, err, "only one default secret may be provided")
+	_, err = parseSecrets([]string{"1234=A", "1234=B"})
+	assert.EqualError(t, err, `SUD ID "1234" had >1 secrets`)
+}
@@ -2,16 +2,28 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"golang.org/x/sync/errgroup"
-	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/jcodybaker/seneye-exporter/pkg/api"
+	"github.com/jcodybaker/seneye-exporter/pkg/dashboard"
+	"github.com/jcodybaker/seneye-exporter/pkg/export"
+	"github.com/jcodybaker/seneye-exporter/pkg/health"
 	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+	"github.com/jcodybaker/seneye-exporter/pkg/listen"
+	"github.com/jcodybaker/seneye-exporter/pkg/pushgateway"
+	"github.com/jcodybaker/seneye-exporter/pkg/realip"
+	"github.com/jcodybaker/seneye-exporter/pkg/version"
+	"github.com/jcodybaker/seneye-exporter/pkg/webconfig"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -22,6 +34,9 @@ import (
 	"github.com/spf13/viper"
 )
 
+// quarantineMaxSources bounds the number of source IPs tracked by the rejected push quarantine.
+const quarantineMaxSources = 256
+
 var (
 	ctx     context.Context
 	cfgFile string
@@ -65,10 +80,124 @@ func init() {
 	viper.BindPFlag("lde-port", rootCmd.Flags().Lookup("lde-port"))
 	viper.SetDefault("lde-port", uint16(8080))
 
+	rootCmd.Flags().StringSlice("lde-listen", nil, `Address for the LDE server, overriding --lde-port. May be specified multiple times.
+Accepts host:port, [ipv6]:port, unix:/path/to.sock, systemd (the first socket passed by
+systemd socket activation) or systemd:NAME (the socket with FileDescriptorName=NAME).
+Listeners with different secrets may be configured with lde-listeners in the config file.`)
+	viper.BindPFlag("lde-listen", rootCmd.Flags().Lookup("lde-listen"))
+
+	rootCmd.Flags().String("prom-listen", "", `Address for the prometheus metrics server, overriding --prom-port. Accepts the same
+formats as --lde-listen. If it matches an LDE server address, the servers are shared.`)
+	viper.BindPFlag("prom-listen", rootCmd.Flags().Lookup("prom-listen"))
+
 	rootCmd.Flags().StringSlice("lde-secret", nil, `Secret used to validate LDE message authenticity. --lde-secret may be specified
 multiple times if paired with the SUD ID. (ex. --lde-secret=DEFAULT_SECRET, or
---lde-secret=EXAMPLE_SUD_ID=SECRET1 --lde-secret=OTHER_SUD_ID=SECRET2)`)
+--lde-secret=EXAMPLE_SUD_ID=SECRET1 --lde-secret=OTHER_SUD_ID=SECRET2). A secret followed only by
+'=' padding, or whose text before the first '=' isn't a SUD ID, is the default.`)
 	viper.BindPFlag("lde-secret", rootCmd.Flags().Lookup("lde-secret"))
+
+	rootCmd.Flags().Int64("lde-max-body-size", 64*1024, "Maximum size in bytes of an LDE request body")
+	viper.BindPFlag("lde-max-body-size", rootCmd.Flags().Lookup("lde-max-body-size"))
+	viper.SetDefault("lde-max-body-size", int64(64*1024))
+
+	rootCmd.Flags().Float64("lde-rate-limit", 1, "Maximum sustained LDE requests per second from each source IP; 0 disables")
+	viper.BindPFlag("lde-rate-limit", rootCmd.Flags().Lookup("lde-rate-limit"))
+	viper.SetDefault("lde-rate-limit", float64(1))
+
+	rootCmd.Flags().Int("lde-rate-burst", 10, "Maximum burst of LDE requests from each source IP; at least 1")
+	viper.BindPFlag("lde-rate-burst", rootCmd.Flags().Lookup("lde-rate-burst"))
+	viper.SetDefault("lde-rate-burst", 10)
+
+	rootCmd.Flags().StringSlice("lde-rate-limit-exempt-cidr", nil, "Source IPs in these CIDRs, ex. the SCA's network, aren't rate limited. May be specified multiple times.")
+	viper.BindPFlag("lde-rate-limit-exempt-cidr", rootCmd.Flags().Lookup("lde-rate-limit-exempt-cidr"))
+
+	rootCmd.Flags().StringSlice("lde-allow-cidr", nil, "Only accept LDE requests from source IPs in these CIDRs. May be specified multiple times.")
+	viper.BindPFlag("lde-allow-cidr", rootCmd.Flags().Lookup("lde-allow-cidr"))
+
+	rootCmd.Flags().StringSlice("lde-relay", nil, `URL of a downstream LDE receiver, ex. another exporter, to forward every accepted push to
+as received. May be specified multiple times. Relays which re-sign pushes or only forward some
+SUDs may be configured with lde-relays in the config file.`)
+	viper.BindPFlag("lde-relay", rootCmd.Flags().Lookup("lde-relay"))
+
+	rootCmd.Flags().StringSlice("trusted-proxy", nil, `CIDRs of reverse proxies trusted to set X-Forwarded-For. The forwarded client address
+is used for logging, rate limiting, lde-rate-limit-exempt-cidr and lde-allow-cidr. May be specified
+multiple times.`)
+	viper.BindPFlag("trusted-proxy", rootCmd.Flags().Lookup("trusted-proxy"))
+
+	rootCmd.Flags().Duration("http-read-timeout", 10*time.Second, "Maximum duration for reading an HTTP request")
+	viper.BindPFlag("http-read-timeout", rootCmd.Flags().Lookup("http-read-timeout"))
+	viper.SetDefault("http-read-timeout", 10*time.Second)
+
+	rootCmd.Flags().Duration("http-write-timeout", 30*time.Second, "Maximum duration for writing an HTTP response")
+	viper.BindPFlag("http-write-timeout", rootCmd.Flags().Lookup("http-write-timeout"))
+	viper.SetDefault("http-write-timeout", 30*time.Second)
+
+	rootCmd.Flags().Duration("http-idle-timeout", 2*time.Minute, "Maximum duration to keep idle HTTP connections open")
+	viper.BindPFlag("http-idle-timeout", rootCmd.Flags().Lookup("http-idle-timeout"))
+	viper.SetDefault("http-idle-timeout", 2*time.Minute)
+
+	rootCmd.Flags().Duration("shutdown-timeout", 25*time.Second, `Maximum duration to wait for in-flight requests and sinks to finish when shutting down.
+Should be less than the kubernetes terminationGracePeriodSeconds.`)
+	viper.BindPFlag("shutdown-timeout", rootCmd.Flags().Lookup("shutdown-timeout"))
+	viper.SetDefault("shutdown-timeout", 25*time.Second)
+
+	rootCmd.Flags().String("state-file", "", "File to persist the latest readings in across restarts. Saved on shutdown.")
+	viper.BindPFlag("state-file", rootCmd.Flags().Lookup("state-file"))
+
+	rootCmd.Flags().String("parquet-dir", "", `Directory to periodically export the current and previous month's readings to as Parquet,
+partitioned by SUD and month. Requires --db or --archive-dir.`)
+	viper.BindPFlag("parquet-dir", rootCmd.Flags().Lookup("parquet-dir"))
+	rootCmd.Flags().Duration("parquet-interval", time.Hour, "Interval between Parquet exports")
+	viper.BindPFlag("parquet-interval", rootCmd.Flags().Lookup("parquet-interval"))
+	viper.SetDefault("parquet-interval", time.Hour)
+
+	addSinkFlags(rootCmd)
+	bindSinkFlags(rootCmd)
+
+	addTLSFlags(rootCmd, "lde", "LDE server")
+	addTLSFlags(rootCmd, "prom", "prometheus metrics server")
+
+	rootCmd.Flags().String("lde-web-config", "", `Prometheus exporter-toolkit web config file for the LDE server, providing TLS and
+basic auth or bearer token authentication. Authentication is never applied to /lde.`)
+	viper.BindPFlag("lde-web-config", rootCmd.Flags().Lookup("lde-web-config"))
+	rootCmd.Flags().String("prom-web-config", "", `Prometheus exporter-toolkit web config file for the prometheus metrics server,
+providing TLS and basic auth or bearer token authentication.`)
+	viper.BindPFlag("prom-web-config", rootCmd.Flags().Lookup("prom-web-config"))
+
+	rootCmd.Flags().Uint("quarantine-size", 10, "Number of rejected LDE pushes to keep per source IP for debugging; 0 disables")
+	viper.BindPFlag("quarantine-size", rootCmd.Flags().Lookup("quarantine-size"))
+	viper.SetDefault("quarantine-size", uint(10))
+
+	rootCmd.Flags().Bool("dashboard", true, `Serve the web dashboard at /dashboard/, and the JSON API and event stream it uses
+at /api/v1/, on the prometheus metrics server`)
+	viper.BindPFlag("dashboard", rootCmd.Flags().Lookup("dashboard"))
+	viper.SetDefault("dashboard", true)
+
+	rootCmd.Flags().Duration("stale-after", 0, `Age of a SUD's latest reading after which it's forgotten, so its metrics stop being
+exported and its pushgateway group is deleted; 0 disables`)
+	viper.BindPFlag("stale-after", rootCmd.Flags().Lookup("stale-after"))
+
+	rootCmd.Flags().String("timezone", "", `IANA time zone (ex. Australia/Sydney) whose midnight resets the daily light integral.
+Defaults to the local time zone.`)
+	viper.BindPFlag("timezone", rootCmd.Flags().Lookup("timezone"))
+
+	rootCmd.Flags().String("pushgateway-url", "", `Prometheus Pushgateway to push each SUD's metrics to after every accepted reading,
+grouped by sud_id. Disabled if unset.`)
+	viper.BindPFlag("pushgateway-url", rootCmd.Flags().Lookup("pushgateway-url"))
+	rootCmd.Flags().String("pushgateway-job", pushgateway.DefaultJob, "Job label of the groups pushed to the Pushgateway")
+	viper.BindPFlag("pushgateway-job", rootCmd.Flags().Lookup("pushgateway-job"))
+	viper.SetDefault("pushgateway-job", pushgateway.DefaultJob)
+	rootCmd.Flags().String("pushgateway-username", "", "Basic auth username for the Pushgateway")
+	viper.BindPFlag("pushgateway-username", rootCmd.Flags().Lookup("pushgateway-username"))
+	rootCmd.Flags().String("pushgateway-password", "", "Basic auth password for the Pushgateway")
+	viper.BindPFlag("pushgateway-password", rootCmd.Flags().Lookup("pushgateway-password"))
+
+	addAlertFlags(rootCmd)
+
+	rootCmd.Flags().String("admin-token", "", `Bearer token required by the admin endpoints on the prometheus server
+(ex. /admin/quarantine), in the X-Admin-Token header if --prom-web-config requires credentials.
+Admin endpoints are disabled if unset.`)
+	viper.BindPFlag("admin-token", rootCmd.Flags().Lookup("admin-token"))
 }
 
 func main() {
@@ -76,102 +205,252 @@ func main() {
 }
 
 func rootExecute(cmd *cobra.Command, args []string) {
-	secrets := parseSecrets(cmd)
-
-	promPort := viper.GetUint("prom-port")
-	if promPort > 0xFFFF {
-		log.Fatal().Str("prom_port", viper.GetString("prom-port")).Msg("invalid prom-port")
-	}
-	ldePort := viper.GetUint("lde-port")
-	if ldePort > 0xFFFF {
-		log.Fatal().Str("lde_port", viper.GetString("lde-port")).Msg("invalid lde-port")
-	}
-
 	promRegistry := prometheus.NewPedanticRegistry()
 	promRegistry.MustRegister(
 		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
 		prometheus.NewGoCollector(),
+		version.NewCollector(),
 	)
+	checker := health.NewChecker()
+	configLoaded := checker.AddCondition("config", "configuration not loaded")
 
-	ldeServer := lde.NewServer(
+	ldeConfigs, err := ldeListenerConfigs()
+	if err != nil {
+		cmd.Usage()
+		log.Fatal().Err(err).Msg("invalid LDE listener configuration")
+	}
+	promAddr := listenAddr("prom")
+
+	quarantine := lde.NewQuarantine(viper.GetInt("quarantine-size"), quarantineMaxSources)
+	ldeOptions := []lde.ServerOption{
 		lde.WithPrometheus(promRegistry),
-		lde.WithSecrets(secrets),
-	)
+		lde.WithQuarantine(quarantine),
+		lde.WithMaxBodySize(viper.GetInt64("lde-max-body-size")),
+		lde.WithStaleAfter(viper.GetDuration("stale-after")),
+	}
+	if tz := viper.GetString("timezone"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			log.Fatal().Err(err).Msg("parsing timezone")
+		}
+		ldeOptions = append(ldeOptions, lde.WithLocation(loc))
+	}
+	if rate := viper.GetFloat64("lde-rate-limit"); rate > 0 {
+		burst := viper.GetInt("lde-rate-burst")
+		if burst < 1 {
+			log.Fatal().Int("lde-rate-burst", burst).Msg("lde-rate-burst must be at least 1")
+		}
+		ldeOptions = append(ldeOptions, lde.WithRateLimit(rate, burst))
+		if cidrs := viper.GetStringSlice("lde-rate-limit-exempt-cidr"); len(cidrs) > 0 {
+			exempt, err := realip.ParseCIDRs(cidrs)
+			if err != nil {
+				log.Fatal().Err(err).Msg("invalid lde-rate-limit-exempt-cidr")
+			}
+			ldeOptions = append(ldeOptions, lde.WithRateLimitExempt(exempt))
+		}
+	} else if rate < 0 {
+		log.Fatal().Float64("lde-rate-limit", rate).Msg("lde-rate-limit must not be negative")
+	}
+	if cidrs := viper.GetStringSlice("lde-allow-cidr"); len(cidrs) > 0 {
+		allowed, err := realip.ParseCIDRs(cidrs)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid lde-allow-cidr")
+		}
+		ldeOptions = append(ldeOptions, lde.WithAllowedNetworks(allowed))
+	}
+	relays, err := ldeRelayTargets()
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid LDE relay configuration")
+	}
+	if len(relays) > 0 {
+		ldeOptions = append(ldeOptions, lde.WithRelay(relays...))
+	}
+	sinks, err := sinksFromConfig(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("configuring sinks")
+	}
+	for _, sink := range sinks {
+		ldeOptions = append(ldeOptions, lde.WithSink(sink))
+	}
+	if url := viper.GetString("pushgateway-url"); url != "" {
+		pusher, err := pushgateway.New(pushgateway.Config{
+			URL:      url,
+			Job:      viper.GetString("pushgateway-job"),
+			Gatherer: promRegistry,
+			Username: viper.GetString("pushgateway-username"),
+			Password: viper.GetString("pushgateway-password"),
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("configuring pushgateway")
+		}
+		ldeOptions = append(ldeOptions, lde.WithSink(pusher))
+	}
+	alerts, err := alertManager()
+	if err != nil {
+		log.Fatal().Err(err).Msg("configuring alerts")
+	}
+	if alerts != nil {
+		ldeOptions = append(ldeOptions, lde.WithSink(alerts))
+	}
+	var events *api.Events
+	if viper.GetBool("dashboard") {
+		events = api.NewEvents()
+		ldeOptions = append(ldeOptions, lde.WithSink(events))
+	}
+	ldeServer, err := lde.NewServer(ldeOptions...)
+	if err != nil {
+		log.Fatal().Err(err).Msg("configuring LDE server")
+	}
 
-	ldeMux := http.NewServeMux()
-	promMux := ldeMux
-	if promPort != ldePort {
-		promMux = http.NewServeMux()
+	ldeWeb := webConfigFromFlags("lde")
+	ldeTLS := tlsConfigFromFlags("lde", ldeWeb)
+	var listeners []*listener
+	var promListener *listener
+	for i, c := range ldeConfigs {
+		if listen.Conflict(c.Listen, promAddr) {
+			log.Fatal().Str("lde-listen", c.Listen).Str("prom-listen", promAddr).
+				Msg("the LDE and prometheus servers can't listen on a wildcard and a specific address on the same port; use the same address to share a server")
+		}
+		name := "lde"
+		if len(ldeConfigs) > 1 {
+			name = fmt.Sprintf("lde-%d", i)
+		}
+		l := newListener(name, c.Listen, ldeWeb, ldeTLS, checker)
+		// LDE pushes are authenticated by their signature, and the SCA can't present credentials.
+		l.mux.Handle("/lde", ldeServer.Handler(c.secrets))
+		listeners = append(listeners, l)
+		if listen.Equal(c.Listen, promAddr) {
+			promListener = l
+		}
+	}
+	if promListener != nil {
+		// The prometheus endpoints share the LDE server listening on the same address.
+		if viper.GetString("prom-web-config") != "" {
+			log.Fatal().Msg("prom-web-config cannot be used when the prometheus and LDE servers share an address; use lde-web-config")
+		}
+		if tlsConfigFromFlags("prom", &webconfig.Config{}).Enabled() {
+			log.Fatal().Msg("prom-tls-* flags cannot be used when the prometheus and LDE servers share an address; use lde-tls-*")
+		}
+	} else {
+		promWeb := webConfigFromFlags("prom")
+		promListener = newListener("prom", promAddr, promWeb, tlsConfigFromFlags("prom", promWeb), checker)
+		listeners = append(listeners, promListener)
 	}
 
-	ldeMux.Handle("/lde", ldeServer)
-	promMux.Handle("/metrics", promhttp.HandlerFor(promRegistry, promhttp.HandlerOpts{}))
+	promMux, promWeb := promListener.mux, promListener.web
+	promMux.Handle("/metrics", promWeb.Authenticate(promhttp.HandlerFor(promRegistry, promhttp.HandlerOpts{})))
+	promMux.Handle("/version", promWeb.Authenticate(version.Handler()))
+	// Probes are unauthenticated so orchestrators can reach them; they reveal nothing sensitive.
+	promMux.Handle("/healthz", health.LiveHandler())
+	promMux.Handle("/readyz", checker.ReadyHandler())
+	src := historySource(sinks, viper.GetString("archive-dir"))
+	var parquetJob *export.ParquetJob
+	if dir := viper.GetString("parquet-dir"); dir != "" {
+		if src == nil {
+			log.Fatal().Msg("parquet-dir requires db or archive-dir")
+		}
+		parquetJob = &export.ParquetJob{Dir: dir, Source: src, Interval: viper.GetDuration("parquet-interval")}
+	}
+	if src != nil {
+		promMux.Handle("/api/v1/export.csv", promWeb.Authenticate(export.CSVHandler(src, time.Local)))
+	}
+	if events != nil {
+		apiOptions := []api.Option{api.WithEvents(events)}
+		if src != nil {
+			apiOptions = append(apiOptions, api.WithHistory(src))
+		}
+		api.New(ldeServer, apiOptions...).Register(promMux, promWeb.Authenticate)
+		promMux.Handle("/dashboard/", promWeb.Authenticate(http.StripPrefix("/dashboard/", dashboard.Handler())))
+		promMux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				http.NotFound(w, r)
+				return
+			}
+			http.Redirect(w, r, "/dashboard/", http.StatusFound)
+		}))
+	}
+	if adminToken := viper.GetString("admin-token"); adminToken != "" {
+		promMux.Handle("/admin/quarantine", promWeb.Authenticate(requireAdminToken(adminToken, quarantine)))
+	}
+	// Sinks delivering to remote services are excluded, and exported by seneye_sink_up instead.
+	checker.Add("sinks", ldeServer.SinksHealthy)
+	stateFile := viper.GetString("state-file")
+	if stateFile != "" {
+		if err := ldeServer.LoadStateFile(stateFile); err != nil {
+			log.Fatal().Err(err).Msg("loading state")
+		}
+	}
+	running := checker.AddCondition("running", "not started")
+	configLoaded.SetReady()
 
-	eg, runCtx := errgroup.WithContext(ctx)
-	var ldeHTTP, promHTTP *http.Server
-	eg.Go(func() error {
-		l := log.With().Uint("server_port", ldePort).Logger()
-		ldeHTTP = &http.Server{
-			Addr:    fmt.Sprintf(":%d", ldePort),
-			Handler: logHandler(ldeMux),
-			BaseContext: func(net.Listener) context.Context {
-				return l.WithContext(ctx)
-			},
-		}
-		l.Info().Msg("starting lde http server")
-		err := ldeHTTP.ListenAndServe()
-		if err != nil && err != http.ErrServerClosed {
-			log.Error().Err(err).Uint("lde-port", ldePort).Msg("failed to start LDE http server")
-			return err
-		}
-		return nil
-	})
+	// Signals are registered before serving so an early signal still shuts down gracefully.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 
-	if promPort != ldePort {
-		eg.Go(func() error {
-			l := log.With().Uint("server_port", ldePort).Logger()
-			promHTTP = &http.Server{
-				Addr:    fmt.Sprintf(":%d", promPort),
-				Handler: logHandler(promMux),
-				BaseContext: func(net.Listener) context.Context {
-					return l.WithContext(ctx)
-				},
-			}
-			l.Info().Msg("starting prometheus http server")
-			err := promHTTP.ListenAndServe()
-			if err != nil && err != http.ErrServerClosed {
-				log.Error().Err(err).Uint("prom-port", promPort).Msg("failed to start prometheus http server")
-				return err
-			}
-			return nil
-		})
+	eg, runCtx := errgroup.WithContext(ctx)
+	for _, l := range listeners {
+		l := l
+		eg.Go(l.listenAndServe)
+	}
+	running.SetReady()
+	jobCtx, stopJobs := context.WithCancel(ctx)
+	var jobs sync.WaitGroup
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		ldeServer.RunStaleExpiry(jobCtx)
+	}()
+	if parquetJob != nil {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			parquetJob.Run(jobCtx)
+		}()
 	}
 
-	sigint := make(chan os.Signal, 1)
-	signal.Notify(sigint, os.Interrupt)
+	exitCode := 0
 	select {
-	case <-sigint:
-		log.Info().Msg("Got SIGINT; shutting down")
+	case sig := <-signals:
+		log.Info().Str("signal", sig.String()).Msg("shutting down")
 	case <-runCtx.Done():
 		// One of the listeners failed, but these log their own error.
+		exitCode = 1
 	}
+	running.SetNotReady(errors.New("shutting down"))
+	stopJobs()
+	jobs.Wait()
 
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
+	ctx, cancel := context.WithTimeout(ctx, viper.GetDuration("shutdown-timeout"))
+	if events != nil {
+		// Event streams never complete on their own, so would hold up the HTTP servers' shutdown.
+		events.Close(ctx)
+	}
 	var shutdownEG errgroup.Group
-	shutdownEG.Go(func() error {
-		return ldeHTTP.Shutdown(ctx)
-	})
-	if promPort != ldePort {
+	for _, l := range listeners {
+		l := l
 		shutdownEG.Go(func() error {
-			return promHTTP.Shutdown(ctx)
+			return l.server.Shutdown(ctx)
 		})
 	}
 	eg.Wait() // We've now told all servers to shutdown, wait for their goroutines to exit.
 	if err := shutdownEG.Wait(); err != nil {
 		log.Warn().Err(err).Msg("shutting down HTTP servers")
-		os.Exit(1)
+		exitCode = 1
 	}
+	// In-flight pushes have now completed, so sinks and state are complete.
+	if err := ldeServer.Close(ctx); err != nil {
+		log.Warn().Err(err).Msg("flushing sinks")
+		exitCode = 1
+	}
+	if stateFile != "" {
+		if err := ldeServer.SaveStateFile(stateFile); err != nil {
+			log.Warn().Err(err).Msg("saving state")
+			exitCode = 1
+		}
+	}
+	log.Info().Int("exit_code", exitCode).Msg("shutdown complete")
+	cancel()
+	os.Exit(exitCode)
 }
 
 func initConfig() {
@@ -181,7 +460,7 @@ func initConfig() {
 	viper.SetConfigFile(cfgFile)
 	viper.AutomaticEnv()
 
-	if err := viper.ReadInConfig(); err == nil {
+	if err := viper.ReadInConfig(); err != nil {
 		log.Fatal().Err(err).Str("config", viper.ConfigFileUsed()).Msg("failed to read config file")
 	}
 }
@@ -204,34 +483,6 @@ func configureLog(cmd *cobra.Command, args []string) {
 	ctx = log.Logger.WithContext(ctx)
 }
 
-func parseSecrets(cmd *cobra.Command) map[string][]byte {
-	out := make(map[string][]byte)
-	secrets := viper.GetStringSlice("lde-secret")
-	if len(secrets) == 0 {
-		cmd.Usage()
-		log.Fatal().Msg("lde-secret is required")
-	}
-	for _, s := range secrets {
-		if s == "" {
-			cmd.Usage()
-			log.Fatal().Msg("invalid lde-secret")
-		}
-		splitSecret := strings.SplitN(s, "=", 1)
-		if len(splitSecret) == 1 {
-			if _, ok := out[""]; ok {
-				log.Fatal().Msg("only one default secret may be provided")
-			}
-			out[""] = []byte(s)
-			continue
-		}
-		if _, ok := out[splitSecret[0]]; ok {
-			log.Fatal().Str("sud_id", splitSecret[0]).Msg("SUD ID had >1 secrets")
-		}
-		out[splitSecret[0]] = []byte(splitSecret[1])
-	}
-	return out
-}
-
 func logHandler(h http.Handler) http.Handler {
 	h = hlog.NewHandler(log.Logger)(h)
 	h = hlog.RemoteAddrHandler("ip")(h)
@@ -253,3 +504,23 @@ func logHandler(h http.Handler) http.Handler {
 
 	return h
 }
+
+// requireAdminToken rejects requests which don't present the token in the X-Admin-Token header,
+// or as a bearer token. The header leaves Authorization free for the web config's credentials.
+func requireAdminToken(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented := r.Header.Get("X-Admin-Token")
+		if presented == "" {
+			auth := r.Header.Get("Authorization")
+			if presented = strings.TrimPrefix(auth, "Bearer "); presented == auth {
+				presented = ""
+			}
+		}
+		if presented == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
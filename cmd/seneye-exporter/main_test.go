@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testMainEnv = "SENEYE_EXPORTER_TEST_MAIN"
+	testArgsEnv = "SENEYE_EXPORTER_TEST_ARGS"
+)
+
+// TestMain runs the exporter itself when re-executed by startExporter.
+func TestMain(m *testing.M) {
+	if os.Getenv(testMainEnv) == "1" {
+		os.Args = append([]string{"seneye-exporter"}, strings.Split(os.Getenv(testArgsEnv), "\n")...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// startExporter runs the exporter in a subprocess with the given args.
+func startExporter(t *testing.T, args ...string) *exec.Cmd {
+	t.Helper()
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), testMainEnv+"=1", testArgsEnv+"="+strings.Join(args, "\n"))
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() { cmd.Process.Kill() })
+	return cmd
+}
+
+func unixClient(path string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+		Timeout: time.Second,
+	}
+}
+
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func signToken(t *testing.T, claims string, secret []byte) string {
+	t.Helper()
+	signingString := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(claims))
+	sig, err := jwt.SigningMethodHS256.Sign(signingString, secret)
+	require.NoError(t, err)
+	return signingString + "." + sig
+}
+
+func TestInvalidRateBurst(t *testing.T) {
+	dir := t.TempDir()
+	cmd := startExporter(t,
+		"--lde-secret=AAAAAAAA",
+		"--lde-listen=unix:"+filepath.Join(dir, "lde.sock"),
+		"--prom-listen=unix:"+filepath.Join(dir, "prom.sock"),
+		"--lde-rate-burst=0",
+	)
+	// A burst below 1 would reject every push, so the exporter refuses to start.
+	err := cmd.Wait()
+	require.IsType(t, &exec.ExitError{}, err)
+	assert.NotEqual(t, 0, err.(*exec.ExitError).ExitCode())
+}
+
+func TestListenConflict(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		// The wildcard can't be bound alongside a specific address on the same port.
+		{"--lde-listen=:" + port, "--prom-listen=127.0.0.1:" + port},
+		{"--lde-listen=:" + port, "--lde-listen=127.0.0.1:" + port, "--prom-listen=unix:" + filepath.Join(dir, "prom.sock")},
+	} {
+		cmd := startExporter(t, append(args, "--lde-secret=AAAAAAAA")...)
+		err := cmd.Wait()
+		require.IsType(t, &exec.ExitError{}, err, "%v", args)
+		assert.NotEqual(t, 0, err.(*exec.ExitError).ExitCode(), "%v", args)
+	}
+
+	// Wildcards written differently share one server.
+	startExporter(t, "--lde-secret=AAAAAAAA", "--lde-listen=:"+port, "--prom-listen=0.0.0.0:"+port)
+	waitFor(t, "the shared server", func() bool {
+		res, err := http.Get("http://127.0.0.1:" + port + "/readyz")
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return res.StatusCode == http.StatusOK
+	})
+}
+
+func TestGracefulShutdownMidPush(t *testing.T) {
+	dir := t.TempDir()
+	ldeSock := filepath.Join(dir, "lde.sock")
+	promSock := filepath.Join(dir, "prom.sock")
+	stateFile := filepath.Join(dir, "state.json")
+	cmd := startExporter(t,
+		"--lde-secret=AAAAAAAA",
+		"--lde-listen=unix:"+ldeSock,
+		"--prom-listen=unix:"+promSock,
+		"--state-file="+stateFile,
+		"--log-level=info",
+	)
+
+	promClient := unixClient(promSock)
+	waitFor(t, "readiness", func() bool {
+		res, err := promClient.Get("http://exporter/readyz")
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return res.StatusCode == http.StatusOK
+	})
+
+	body := signToken(t, `{"version":"1.0.0","SUD":{"id":"1234","name":"Office","type":1,"TS":1609561222,`+
+		`"data":{"S":{"W":1},"T":21.125,"P":7.94,"N":0.001}}}`, []byte("AAAAAAAA"))
+	conn, err := net.Dial("unix", ldeSock)
+	require.NoError(t, err)
+	defer conn.Close()
+	half := len(body) / 2
+	_, err = fmt.Fprintf(conn, "POST /lde HTTP/1.1\r\nHost: exporter\r\nContent-Length: %d\r\n\r\n%s", len(body), body[:half])
+	require.NoError(t, err)
+	// Give the server a moment to begin reading the request so the connection is active.
+	time.Sleep(100 * time.Millisecond)
+
+	require.NoError(t, cmd.Process.Signal(syscall.SIGTERM))
+	waitFor(t, "listener to close", func() bool {
+		c, err := net.Dial("unix", ldeSock)
+		if err != nil {
+			return true
+		}
+		c.Close()
+		return false
+	})
+
+	// The in-flight push completes despite the shutdown.
+	_, err = conn.Write([]byte(body[half:]))
+	require.NoError(t, err)
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+
+	require.NoError(t, cmd.Wait())
+	assert.Equal(t, 0, cmd.ProcessState.ExitCode())
+	state, err := ioutil.ReadFile(stateFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(state), `"id":"1234"`)
+}
+
+func TestAdminAuthentication(t *testing.T) {
+	dir := t.TempDir()
+	webConfig := filepath.Join(dir, "web.yml")
+	require.NoError(t, ioutil.WriteFile(webConfig, []byte("bearer_tokens: [WEB]\n"), 0600))
+	promSock := filepath.Join(dir, "prom.sock")
+	startExporter(t,
+		"--lde-secret=AAAAAAAA",
+		"--lde-listen=unix:"+filepath.Join(dir, "lde.sock"),
+		"--prom-listen=unix:"+promSock,
+		"--prom-web-config="+webConfig,
+		"--admin-token=ADMIN",
+	)
+
+	client := unixClient(promSock)
+	waitFor(t, "readiness", func() bool {
+		res, err := client.Get("http://exporter/readyz")
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return res.StatusCode == http.StatusOK
+	})
+	for _, tc := range []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{"none", nil, http.StatusUnauthorized},
+		{"admin only", map[string]string{"Authorization": "Bearer ADMIN"}, http.StatusUnauthorized},
+		{"web only", map[string]string{"Authorization": "Bearer WEB"}, http.StatusUnauthorized},
+		{"both", map[string]string{"Authorization": "Bearer WEB", "X-Admin-Token": "ADMIN"}, http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodGet, "http://exporter/admin/quarantine", nil)
+		require.NoError(t, err)
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		res, err := client.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, tc.status, res.StatusCode, tc.name)
+	}
+}
+
+func TestDashboardEvents(t *testing.T) {
+	dir := t.TempDir()
+	ldeSock := filepath.Join(dir, "lde.sock")
+	promSock := filepath.Join(dir, "prom.sock")
+	cmd := startExporter(t,
+		"--lde-secret=AAAAAAAA",
+		"--lde-listen=unix:"+ldeSock,
+		"--prom-listen=unix:"+promSock,
+		"--http-write-timeout=200ms",
+		"--log-level=info",
+	)
+
+	promClient := unixClient(promSock)
+	waitFor(t, "readiness", func() bool {
+		res, err := promClient.Get("http://exporter/readyz")
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return res.StatusCode == http.StatusOK
+	})
+	promClient.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	res, err := promClient.Get("http://exporter/")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.Equal(t, "/dashboard/", res.Header.Get("Location"))
+	res, err = promClient.Get("http://exporter/dashboard/")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	streamClient := unixClient(promSock)
+	streamClient.Timeout = 0
+	stream, err := streamClient.Get("http://exporter/api/v1/events")
+	require.NoError(t, err)
+	defer stream.Body.Close()
+	require.Equal(t, http.StatusOK, stream.StatusCode)
+	events := bufio.NewScanner(stream.Body)
+	require.True(t, events.Scan()) // The retry interval is sent once subscribed.
+
+	// The stream outlives the write timeout.
+	time.Sleep(300 * time.Millisecond)
+	body := signToken(t, `{"version":"1.0.0","SUD":{"id":"1234","name":"Office","type":1,"TS":1609561222,`+
+		`"data":{"S":{"W":1},"T":21.125,"P":7.94,"N":0.001}}}`, []byte("AAAAAAAA"))
+	res, err = unixClient(ldeSock).Post("http://exporter/lde", "application/x-www-form-urlencoded", strings.NewReader(body))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	var data string
+	for data == "" && events.Scan() {
+		if strings.HasPrefix(events.Text(), "data: ") {
+			data = events.Text()
+		}
+	}
+	assert.Contains(t, data, `"sud_id":"1234"`)
+
+	// An open stream doesn't hold up shutdown.
+	start := time.Now()
+	require.NoError(t, cmd.Process.Signal(syscall.SIGTERM))
+	require.NoError(t, cmd.Wait())
+	assert.Equal(t, 0, cmd.ProcessState.ExitCode())
+	assert.True(t, time.Since(start) < 5*time.Second, "shutdown took %s", time.Since(start))
+}
+
+func TestPushgatewayStaleExpiry(t *testing.T) {
+	requests := make(chan string, 10)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.Method + " " + r.URL.Path
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer gateway.Close()
+
+	dir := t.TempDir()
+	ldeSock := filepath.Join(dir, "lde.sock")
+	promSock := filepath.Join(dir, "prom.sock")
+	cmd := startExporter(t,
+		"--lde-secret=AAAAAAAA",
+		"--lde-listen=unix:"+ldeSock,
+		"--prom-listen=unix:"+promSock,
+		"--pushgateway-url="+gateway.URL,
+		"--stale-after=2s",
+		"--log-level=info",
+	)
+	promClient := unixClient(promSock)
+	waitFor(t, "readiness", func() bool {
+		res, err := promClient.Get("http://exporter/readyz")
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return res.StatusCode == http.StatusOK
+	})
+
+	body := signToken(t, fmt.Sprintf(`{"version":"1.0.0","SUD":{"id":"1234","name":"Office","type":1,"TS":%d,`+
+		`"data":{"S":{"W":1},"T":21.125,"P":7.94,"N":0.001}}}`, time.Now().Unix()), []byte("AAAAAAAA"))
+	res, err := unixClient(ldeSock).Post("http://exporter/lde", "application/x-www-form-urlencoded", strings.NewReader(body))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+
+	// The SUD's group is pushed, then deleted once the SUD goes stale.
+	for _, want := range []string{
+		"PUT /metrics/job/seneye-exporter/sud_id/1234",
+		"DELETE /metrics/job/seneye-exporter/sud_id/1234",
+	} {
+		select {
+		case got := <-requests:
+			assert.Equal(t, want, got)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for %s", want)
+		}
+	}
+	res, err = promClient.Get("http://exporter/metrics")
+	require.NoError(t, err)
+	metrics, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.NotContains(t, string(metrics), `id="1234"`)
+
+	require.NoError(t, cmd.Process.Signal(syscall.SIGTERM))
+	require.NoError(t, cmd.Wait())
+}
+
+func TestRelay(t *testing.T) {
+	relayed := make(chan string, 10)
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		relayed <- r.URL.Path + " " + string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer downstream.Close()
+
+	dir := t.TempDir()
+	ldeSock := filepath.Join(dir, "lde.sock")
+	promSock := filepath.Join(dir, "prom.sock")
+	cmd := startExporter(t,
+		"--lde-secret=AAAAAAAA",
+		"--lde-listen=unix:"+ldeSock,
+		"--prom-listen=unix:"+promSock,
+		"--lde-relay="+downstream.URL+"/lde",
+		"--log-level=info",
+	)
+	promClient := unixClient(promSock)
+	waitFor(t, "readiness", func() bool {
+		res, err := promClient.Get("http://exporter/readyz")
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return res.StatusCode == http.StatusOK
+	})
+
+	body := signToken(t, `{"version":"1.0.0","SUD":{"id":"1234","name":"Office","type":1,"TS":1609561222,`+
+		`"data":{"S":{"W":1},"T":21.125,"P":7.94,"N":0.001}}}`, []byte("AAAAAAAA"))
+	res, err := unixClient(ldeSock).Post("http://exporter/lde", "application/x-www-form-urlencoded", strings.NewReader(body))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	select {
+	case got := <-relayed:
+		assert.Equal(t, "/lde "+body, got)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the relayed push")
+	}
+
+	require.NoError(t, cmd.Process.Signal(syscall.SIGTERM))
+	require.NoError(t, cmd.Wait())
+}
+
+// startSMTPServer starts a minimal plaintext SMTP server, sending each message's data to the
+// returned channel.
+func startSMTPServer(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				fmt.Fprint(conn, "220 localhost\r\n")
+				var data strings.Builder
+				inData := false
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch {
+					case inData && line == ".\r\n":
+						inData = false
+						messages <- data.String()
+						fmt.Fprint(conn, "250 OK\r\n")
+					case inData:
+						data.WriteString(line)
+					case strings.HasPrefix(line, "DATA"):
+						inData = true
+						fmt.Fprint(conn, "354 go ahead\r\n")
+					case strings.HasPrefix(line, "QUIT"):
+						fmt.Fprint(conn, "221 bye\r\n")
+						return
+					default:
+						fmt.Fprint(conn, "250 OK\r\n")
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), messages
+}
+
+func TestEmailAlerts(t *testing.T) {
+	smtpAddr, messages := startSMTPServer(t)
+	dir := t.TempDir()
+	ldeSock := filepath.Join(dir, "lde.sock")
+	promSock := filepath.Join(dir, "prom.sock")
+	config := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(config, []byte(`
+alert-rules:
+  - name: cold
+    reading: temperature
+    min: 24
+    severity: critical
+`), 0600))
+	cmd := startExporter(t,
+		"--config="+config,
+		"--lde-secret=AAAAAAAA",
+		"--lde-listen=unix:"+ldeSock,
+		"--prom-listen=unix:"+promSock,
+		"--smtp-address="+smtpAddr,
+		"--smtp-tls=none",
+		"--smtp-from=alerts@example.com",
+		"--smtp-to=reef@example.com",
+		"--log-level=info",
+	)
+	promClient := unixClient(promSock)
+	waitFor(t, "readiness", func() bool {
+		res, err := promClient.Get("http://exporter/readyz")
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return res.StatusCode == http.StatusOK
+	})
+
+	body := signToken(t, `{"version":"1.0.0","SUD":{"id":"1234","name":"Office","type":1,"TS":1609561222,`+
+		`"data":{"S":{"W":1},"T":21.125,"P":7.94,"N":0.001}}}`, []byte("AAAAAAAA"))
+	res, err := unixClient(ldeSock).Post("http://exporter/lde", "application/x-www-form-urlencoded", strings.NewReader(body))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNoContent, res.StatusCode)
+	select {
+	case got := <-messages:
+		assert.Contains(t, got, "Subject: =?utf-8?q?[CRITICAL]_Office:_Temperature_below_24=C2=B0C?=\r\n")
+		assert.Contains(t, got, "To: <reef@example.com>\r\n")
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the alert email")
+	}
+
+	require.NoError(t, cmd.Process.Signal(syscall.SIGTERM))
+	require.NoError(t, cmd.Wait())
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/archive"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay ARCHIVE...",
+	Short: "Replay archived LDE pushes into an exporter or sinks.",
+	Long: `Replay the LDE pushes archived by --archive-dir, from archive files or directories. With
+--target, each push is POSTed to a running exporter. Otherwise each push is validated with
+--lde-secret and delivered to the sinks configured by flags, config file, and environment.`,
+	Args: cobra.MinimumNArgs(1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindSinkFlags(cmd)
+		configureLog(cmd, args)
+	},
+	Run: replayExecute,
+}
+
+func init() {
+	replayCmd.Flags().String("target", "", "URL of an exporter's LDE endpoint to POST the pushes to")
+	replayCmd.Flags().StringSlice("lde-secret", nil, `Secret used to validate the pushes when replaying into sinks, in the same format as
+the root command's --lde-secret. Defaults to the lde-secret configured by config file or
+environment.`)
+	replayCmd.Flags().Bool("rejected", false, "Also replay archived pushes which were rejected, ex. after fixing a secret")
+	replayCmd.Flags().String("since", "", "Only replay pushes received at or after this RFC 3339 time")
+	replayCmd.Flags().String("until", "", "Only replay pushes received before this RFC 3339 time")
+	addSinkFlags(replayCmd)
+	rootCmd.AddCommand(replayCmd)
+}
+
+func replayExecute(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	target, _ := flags.GetString("target")
+	rejected, _ := flags.GetBool("rejected")
+	since, err := parseTimeFlag(cmd, "since")
+	if err != nil {
+		log.Fatal().Err(err).Msg("parsing since")
+	}
+	until, err := parseTimeFlag(cmd, "until")
+	if err != nil {
+		log.Fatal().Err(err).Msg("parsing until")
+	}
+
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			log.Fatal().Err(err).Msg("opening archive")
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		if sameDir(arg, viper.GetString("archive-dir")) {
+			log.Fatal().Str("archive", arg).Msg("cannot replay an archive into itself")
+		}
+		dirFiles, err := archive.Files(arg, "")
+		if err != nil {
+			log.Fatal().Err(err).Msg("listing archive")
+		}
+		files = append(files, dirFiles...)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	var deliver func(*archive.Record) error
+	var server *lde.Server
+	if target != "" {
+		client := &http.Client{Timeout: 30 * time.Second}
+		deliver = func(r *archive.Record) error {
+			return postLDE(ctx, client, target, []byte(r.Body))
+		}
+	} else {
+		secrets, err := secretsFromFlags(cmd)
+		if err != nil {
+			log.Fatal().Err(err).Msg("parsing lde-secret")
+		}
+		if secrets == nil {
+			log.Fatal().Msg("lde-secret is required to replay into sinks")
+		}
+		sinks, err := sinksFromConfig(ctx)
+		if err != nil {
+			log.Fatal().Err(err).Msg("configuring sinks")
+		}
+		if len(sinks) == 0 {
+			log.Fatal().Msg("either target or a sink is required")
+		}
+		options := []lde.ServerOption{lde.WithSecrets(secrets)}
+		for _, sink := range sinks {
+			options = append(options, lde.WithSink(sink))
+		}
+		if server, err = lde.NewServer(options...); err != nil {
+			log.Fatal().Err(err).Msg("configuring LDE server")
+		}
+		deliver = func(r *archive.Record) error {
+			return server.Ingest(ctx, r.Push())
+		}
+	}
+
+	var replayed, failed int
+	exitCode := 0
+	for _, f := range files {
+		ll := log.With().Str("archive", f).Logger()
+		err := archive.ReadFile(f, func(r *archive.Record) error {
+			if (!r.Accepted && !rejected) || r.Received.Before(since) || (!until.IsZero() && !r.Received.Before(until)) {
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := deliver(r); err != nil {
+				if errors.Is(err, context.Canceled) {
+					return err
+				}
+				ll.Warn().Err(err).Time("received", r.Received).Str("source", r.Source).Msg("replaying LDE push")
+				failed++
+				return nil
+			}
+			replayed++
+			return nil
+		})
+		if errors.Is(err, context.Canceled) {
+			exitCode = 1
+			break
+		}
+		if err != nil {
+			ll.Error().Err(err).Msg("reading archive")
+			exitCode = 1
+		}
+	}
+	if server != nil {
+		closeCtx, closeCancel := context.WithTimeout(context.Background(), viper.GetDuration("shutdown-timeout"))
+		if err := server.Close(closeCtx); err != nil {
+			log.Error().Err(err).Msg("flushing sinks")
+			exitCode = 1
+		}
+		closeCancel()
+	}
+	log.Info().Int("replayed", replayed).Int("failed", failed).Msg("replay complete")
+	if failed > 0 {
+		exitCode = 1
+	}
+	os.Exit(exitCode)
+}
+
+// parseTimeFlag parses the named RFC 3339 flag, returning the zero time if it's unset.
+func parseTimeFlag(cmd *cobra.Command, name string) (time.Time, error) {
+	s, _ := cmd.Flags().GetString(name)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// sameDir is true if a and b name the same directory.
+func sameDir(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return os.SameFile(aInfo, bInfo)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/archive"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestArchive(t *testing.T, dir string, pushes ...*lde.Push) {
+	t.Helper()
+	w, err := archive.NewWriter(archive.Config{Dir: dir, Rejected: true, Compress: true})
+	require.NoError(t, err)
+	ctx := context.Background()
+	for _, p := range pushes {
+		if p.LDE == nil {
+			require.NoError(t, w.SendRejected(ctx, p, errors.New("signature is invalid")))
+		} else {
+			require.NoError(t, w.Send(ctx, p))
+		}
+	}
+	require.NoError(t, w.Close(ctx))
+}
+
+func TestReplayToTarget(t *testing.T) {
+	dir := t.TempDir()
+	secret := []byte("secret")
+	first := time.Date(2021, 1, 13, 12, 0, 0, 0, time.UTC)
+	good := signToken(t, `{"version":"1.0.0","SUD":{"id":"abc","type":1,"TS":1610539200}}`, secret)
+	writeTestArchive(t, dir,
+		&lde.Push{Received: first, Raw: []byte(good), LDE: &lde.LDE{}},
+		&lde.Push{Received: first.Add(time.Minute), Raw: []byte("rejected")},
+		&lde.Push{Received: first.Add(time.Hour), Raw: []byte(good + "late"), LDE: &lde.LDE{}},
+	)
+
+	var lock sync.Mutex
+	var bodies []string
+	limited := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if !limited {
+			// The replay must wait and retry when rate limited.
+			limited = true
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	cmd := startExporter(t, "replay", "--target="+srv.URL, "--until=2021-01-13T12:30:00Z", dir)
+	require.NoError(t, cmd.Wait())
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []string{good}, bodies)
+}
+
+func TestReplayToSink(t *testing.T) {
+	dir, out := t.TempDir(), t.TempDir()
+	secret := []byte("secret")
+	good := signToken(t, `{"version":"1.0.0","SUD":{"id":"abc","type":1,"TS":1610539200}}`, secret)
+	received := time.Date(2021, 1, 13, 12, 0, 0, 0, time.UTC)
+	writeTestArchive(t, dir,
+		&lde.Push{Received: received, Source: "192.0.2.1", Raw: []byte(good), LDE: &lde.LDE{}},
+		// Rejected while the exporter had the wrong secret.
+		&lde.Push{Received: received.Add(time.Minute), Source: "192.0.2.1", Raw: []byte(good)},
+	)
+	files, err := archive.Files(dir, "")
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	cmd := startExporter(t, "replay", "--lde-secret=secret", "--rejected", "--archive-dir="+out, files[0])
+	require.NoError(t, cmd.Wait())
+
+	var records []*archive.Record
+	outFiles, err := archive.Files(out, "")
+	require.NoError(t, err)
+	for _, f := range outFiles {
+		require.NoError(t, archive.ReadFile(f, func(r *archive.Record) error {
+			records = append(records, r)
+			return nil
+		}))
+	}
+	require.Len(t, records, 2)
+	for i, r := range records {
+		assert.True(t, r.Accepted)
+		assert.Equal(t, "abc", r.SUDID)
+		assert.Equal(t, "192.0.2.1", r.Source)
+		assert.Equal(t, received.Add(time.Duration(i)*time.Minute), r.Received)
+	}
+
+	cmd = startExporter(t, "replay", "--lde-secret=secret", "--archive-dir="+dir, dir)
+	assert.Error(t, cmd.Wait(), "replaying an archive into itself fails")
+}
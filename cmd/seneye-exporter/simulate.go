@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/internal/queue"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+	"github.com/jcodybaker/seneye-exporter/pkg/simulate"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var simulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Push simulated LDE events from virtual SUDs to an exporter.",
+	Long: `Push simulated LDE events to an exporter, or anything else which accepts LDE pushes, from
+virtual SUDs of each type. Readings drift over time, light follows a daily curve, and
+out-of-water and alarm events are injected at random.`,
+	Args:   cobra.NoArgs,
+	PreRun: configureLog,
+	Run:    simulateExecute,
+}
+
+func init() {
+	simulateCmd.Flags().String("target", "http://localhost:8080/lde", "URL to POST the LDE events to")
+	simulateCmd.Flags().String("secret", "", "Secret used to sign the LDE events (required)")
+	simulateCmd.Flags().Int("devices", 1, "Number of virtual SUDs of each type")
+	simulateCmd.Flags().StringSlice("types", []string{"home", "pond", "reef"}, "SUD types to simulate")
+	simulateCmd.Flags().Duration("interval", time.Minute, "Interval between pushes from each SUD")
+	simulateCmd.Flags().Int("count", 0, "Number of pushes from each SUD before exiting; 0 runs until interrupted")
+	simulateCmd.Flags().Float64("drift", simulate.DefaultConfig.Drift,
+		"Scale of the drift in readings between pushes; 0 holds readings steady")
+	simulateCmd.Flags().Float64("out-of-water-probability", simulate.DefaultConfig.OutOfWater,
+		"Probability that a push begins an out-of-water event")
+	simulateCmd.Flags().Float64("alarm-probability", simulate.DefaultConfig.Alarm,
+		"Probability that a push begins an alarm event, with a reading out of its safe range")
+	simulateCmd.Flags().Int("event-pushes", simulate.DefaultConfig.EventPushes,
+		"Number of pushes an out-of-water or alarm event lasts")
+	simulateCmd.Flags().Int64("seed", 0, "Seed for the simulation; 0 uses the current time")
+	simulateCmd.Flags().Bool("std-encoding", true,
+		"Encode the JWT with the standard base64 alphabet, as the Seneye Connect App does")
+	rootCmd.AddCommand(simulateCmd)
+}
+
+func simulateExecute(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	target, _ := flags.GetString("target")
+	secret, _ := flags.GetString("secret")
+	if secret == "" {
+		log.Fatal().Msg("secret is required")
+	}
+	n, _ := flags.GetInt("devices")
+	typeNames, _ := flags.GetStringSlice("types")
+	interval, _ := flags.GetDuration("interval")
+	count, _ := flags.GetInt("count")
+	stdEncoding, _ := flags.GetBool("std-encoding")
+	seed, _ := flags.GetInt64("seed")
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	config := simulate.Config{}
+	config.Drift, _ = flags.GetFloat64("drift")
+	config.OutOfWater, _ = flags.GetFloat64("out-of-water-probability")
+	config.Alarm, _ = flags.GetFloat64("alarm-probability")
+	config.EventPushes, _ = flags.GetInt("event-pushes")
+
+	var devices []*simulate.Device
+	for _, name := range typeNames {
+		t, err := parseSUDType(name)
+		if err != nil {
+			log.Fatal().Err(err).Msg("parsing types")
+		}
+		for i := 0; i < n; i++ {
+			d, err := simulate.NewDevice(
+				fmt.Sprintf("sim-%s-%d", t, i),
+				fmt.Sprintf("Simulated %s %d", t, i),
+				t, config, seed+int64(len(devices)))
+			if err != nil {
+				log.Fatal().Err(err).Msg("creating virtual SUD")
+			}
+			devices = append(devices, d)
+		}
+	}
+	if len(devices) == 0 {
+		log.Fatal().Msg("no SUDs to simulate")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+	client := &http.Client{Timeout: 30 * time.Second}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for i := 0; count == 0 || i < count; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+		now := time.Now()
+		for _, d := range devices {
+			ll := log.With().Str("sud_id", d.ID).Logger()
+			body, err := lde.ToRequestBody(d.Next(now), []byte(secret))
+			if err != nil {
+				ll.Fatal().Err(err).Msg("signing LDE")
+			}
+			if stdEncoding {
+				body = []byte(strings.NewReplacer("-", "+", "_", "/").Replace(string(body)))
+			}
+			if err := postLDE(ctx, client, target, body); err != nil {
+				ll.Warn().Err(err).Msg("pushing LDE")
+				continue
+			}
+			ll.Debug().Msg("pushed LDE")
+		}
+	}
+}
+
+// postLDE POSTs the LDE body to the target, waiting and retrying while it's rate limited.
+func postLDE(ctx context.Context, client *http.Client, target string, body []byte) error {
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusTooManyRequests {
+			if resp.StatusCode/100 != 2 {
+				return fmt.Errorf("unexpected status: %s", resp.Status)
+			}
+			return nil
+		}
+		wait := time.Second
+		if d := queue.ParseRetryAfter(resp); d > 0 {
+			wait = d
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// parseSUDType parses the SUD type's name, as returned by lde.SUDType.String().
+func parseSUDType(name string) (lde.SUDType, error) {
+	for _, t := range []lde.SUDType{lde.HomeSUD, lde.PondSUD, lde.ReefSUD} {
+		if strings.EqualFold(name, t.String()) {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown SUD type: %q", name)
+}
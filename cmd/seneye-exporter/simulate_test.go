@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+	"github.com/jcodybaker/seneye-exporter/pkg/simulate"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulatedPush(t *testing.T) {
+	secret := []byte("secret")
+	var sinkPushes []*lde.Push
+	server, err := lde.NewServer(
+		lde.WithSecrets(map[string][]byte{"": secret}),
+		lde.WithSink(sinkFunc(func(p *lde.Push) { sinkPushes = append(sinkPushes, p) })),
+	)
+	require.NoError(t, err)
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	for _, name := range []string{"home", "Pond", "reef"} {
+		typ, err := parseSUDType(name)
+		require.NoError(t, err)
+		d, err := simulate.NewDevice("sim-"+name, name, typ, simulate.DefaultConfig, 1)
+		require.NoError(t, err)
+		body, err := lde.ToRequestBody(d.Next(time.Now()), secret)
+		require.NoError(t, err)
+		body = []byte(strings.NewReplacer("-", "+", "_", "/").Replace(string(body)))
+		require.NoError(t, postLDE(context.Background(), http.DefaultClient, srv.URL, body))
+	}
+	require.Len(t, sinkPushes, 3)
+	assert.Equal(t, lde.PondSUD, sinkPushes[1].LDE.SUD.Type)
+
+	err = postLDE(context.Background(), http.DefaultClient, srv.URL, []byte("garbage"))
+	assert.EqualError(t, err, "unexpected status: 400 Bad Request")
+
+	_, err = parseSUDType("nano")
+	assert.EqualError(t, err, `unknown SUD type: "nano"`)
+}
+
+// sinkFunc adapts a func to an lde.Sink.
+type sinkFunc func(*lde.Push)
+
+func (f sinkFunc) Send(ctx context.Context, p *lde.Push) error {
+	f(p)
+	return nil
+}
+
+func (f sinkFunc) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/archive"
+	"github.com/jcodybaker/seneye-exporter/pkg/graphite"
+	"github.com/jcodybaker/seneye-exporter/pkg/history"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+	"github.com/jcodybaker/seneye-exporter/pkg/otlp"
+	"github.com/jcodybaker/seneye-exporter/pkg/statsd"
+	"github.com/jcodybaker/seneye-exporter/pkg/store"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// sinkFlags lists the flags registered by addSinkFlags, so they can be bound to viper.
+var sinkFlags = []string{
+	"archive-dir",
+	"archive-max-size",
+	"archive-max-age",
+	"archive-compress",
+	"archive-rejected",
+	"db",
+	"db-downsample-after",
+	"db-retention",
+	"graphite-address",
+	"graphite-network",
+	"graphite-template",
+	"graphite-label",
+	"statsd-address",
+	"statsd-flavor",
+	"statsd-prefix",
+	"statsd-tag",
+	"statsd-service-checks",
+	"otlp-endpoint",
+	"otlp-protocol",
+	"otlp-header",
+	"otlp-insecure",
+	"otlp-timeout",
+	"otlp-retry-max-elapsed",
+}
+
+// addSinkFlags registers the flags configuring where LDE pushes are delivered, beyond the
+// prometheus metrics.
+func addSinkFlags(cmd *cobra.Command) {
+	cmd.Flags().String("archive-dir", "", "Directory to archive every accepted raw LDE push to, as JSON lines. Disabled if unset.")
+	cmd.Flags().Int64("archive-max-size", 64*1024*1024, "Size in bytes after which the archive file is rotated; 0 disables")
+	cmd.Flags().Duration("archive-max-age", 0, "Age after which the archive file is rotated (ex. 24h); 0 disables")
+	cmd.Flags().Bool("archive-compress", true, "Compress rotated archive files with gzip")
+	cmd.Flags().Bool("archive-rejected", false, "Also archive pushes which failed parsing or validation")
+	cmd.Flags().String("db", "", "SQLite database file to record every accepted reading in. Disabled if unset.")
+	cmd.Flags().Duration("db-downsample-after", 30*24*time.Hour, "Age after which readings in the database are replaced by hourly aggregates; 0 disables")
+	cmd.Flags().Duration("db-retention", 0, "Age after which readings are deleted from the database; 0 keeps them forever")
+	cmd.Flags().String("graphite-address", "", "host:port of a Graphite Carbon plaintext receiver to send every accepted reading to. Disabled if unset.")
+	cmd.Flags().String("graphite-network", "tcp", `Network used to reach graphite-address: "tcp" or "udp"`)
+	cmd.Flags().String("graphite-template", graphite.DefaultTemplate, `Go template for the Graphite path of each reading. Available: {{.ID}}, {{.Name}},
+{{.Type}}, {{.Metric}} and {{.Labels.KEY}}`)
+	cmd.Flags().StringSlice("graphite-label", nil, "Custom KEY=VALUE label available to graphite-template. May be specified multiple times.")
+	cmd.Flags().String("statsd-address", "", `StatsD agent to send every accepted reading to, as host:port for UDP or
+unix:/path/to.sock for a Unix datagram socket. Disabled if unset.`)
+	cmd.Flags().String("statsd-flavor", statsd.DogStatsD, `StatsD protocol: "dogstatsd" for tagged metrics and service checks, or "statsd" for
+untagged gauges named by SUD ID`)
+	cmd.Flags().String("statsd-prefix", "seneye.", "Prefix of StatsD metric and service check names")
+	cmd.Flags().StringSlice("statsd-tag", nil, "Tag (ex. env:home) added to every DogStatsD metric and service check. May be specified multiple times.")
+	cmd.Flags().Bool("statsd-service-checks", true, "Also send DogStatsD status flags as service checks")
+	cmd.Flags().String("otlp-endpoint", "", `OpenTelemetry collector to export every accepted reading to, ex. http://localhost:4318 for
+http/protobuf or localhost:4317 for grpc. Disabled if unset.`)
+	cmd.Flags().String("otlp-protocol", otlp.ProtocolHTTP, `OTLP transport: "http/protobuf" or "grpc"`)
+	cmd.Flags().StringSlice("otlp-header", nil, "KEY=VALUE header sent with every OTLP request. May be specified multiple times.")
+	cmd.Flags().Bool("otlp-insecure", false, "Disable TLS for grpc otlp-endpoints without an http:// or https:// scheme")
+	cmd.Flags().Duration("otlp-timeout", 10*time.Second, "Timeout of each OTLP request")
+	cmd.Flags().Duration("otlp-retry-max-elapsed", time.Minute, "Time spent retrying a failed OTLP export before its readings are dropped; 0 disables retries")
+}
+
+// bindSinkFlags binds the sink flags registered on cmd to viper. Flags can only be bound for one
+// command, so subcommands sharing the sink flags bind them when they run.
+func bindSinkFlags(cmd *cobra.Command) {
+	for _, name := range sinkFlags {
+		viper.BindPFlag(name, cmd.Flags().Lookup(name))
+	}
+	viper.SetDefault("archive-max-size", int64(64*1024*1024))
+	viper.SetDefault("archive-compress", true)
+	viper.SetDefault("db-downsample-after", 30*24*time.Hour)
+	viper.SetDefault("graphite-network", "tcp")
+	viper.SetDefault("graphite-template", graphite.DefaultTemplate)
+	viper.SetDefault("statsd-flavor", statsd.DogStatsD)
+	viper.SetDefault("statsd-prefix", "seneye.")
+	viper.SetDefault("statsd-service-checks", true)
+	viper.SetDefault("otlp-protocol", otlp.ProtocolHTTP)
+	viper.SetDefault("otlp-timeout", 10*time.Second)
+	viper.SetDefault("otlp-retry-max-elapsed", time.Minute)
+}
+
+// sinksFromConfig creates the sinks configured by flags, config file, and environment.
+func sinksFromConfig(ctx context.Context) ([]lde.Sink, error) {
+	var sinks []lde.Sink
+	if dir := viper.GetString("archive-dir"); dir != "" {
+		w, err := archive.NewWriter(archive.Config{
+			Dir:      dir,
+			MaxSize:  viper.GetInt64("archive-max-size"),
+			MaxAge:   viper.GetDuration("archive-max-age"),
+			Compress: viper.GetBool("archive-compress"),
+			Rejected: viper.GetBool("archive-rejected"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, w)
+	}
+	if path := viper.GetString("db"); path != "" {
+		s, err := store.Open(ctx, store.Config{
+			Path:            path,
+			DownsampleAfter: viper.GetDuration("db-downsample-after"),
+			Retention:       viper.GetDuration("db-retention"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if addr := viper.GetString("graphite-address"); addr != "" {
+		labels, err := parseLabels(viper.GetStringSlice("graphite-label"))
+		if err != nil {
+			return nil, fmt.Errorf("parsing graphite-label: %w", err)
+		}
+		s, err := graphite.New(graphite.Config{
+			Address:  addr,
+			Network:  viper.GetString("graphite-network"),
+			Template: viper.GetString("graphite-template"),
+			Labels:   labels,
+		})
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if addr := viper.GetString("statsd-address"); addr != "" {
+		s, err := statsd.New(statsd.Config{
+			Address:       addr,
+			Flavor:        viper.GetString("statsd-flavor"),
+			Prefix:        viper.GetString("statsd-prefix"),
+			Tags:          viper.GetStringSlice("statsd-tag"),
+			ServiceChecks: viper.GetBool("statsd-service-checks"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if endpoint := viper.GetString("otlp-endpoint"); endpoint != "" {
+		headers, err := parseLabels(viper.GetStringSlice("otlp-header"))
+		if err != nil {
+			return nil, fmt.Errorf("parsing otlp-header: %w", err)
+		}
+		s, err := otlp.New(otlp.Config{
+			Endpoint:        endpoint,
+			Protocol:        viper.GetString("otlp-protocol"),
+			Headers:         headers,
+			Insecure:        viper.GetBool("otlp-insecure"),
+			Timeout:         viper.GetDuration("otlp-timeout"),
+			RetryMaxElapsed: viper.GetDuration("otlp-retry-max-elapsed"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
+// parseLabels parses KEY=VALUE pairs.
+func parseLabels(pairs []string) (map[string]string, error) {
+	labels := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("expected KEY=VALUE: %q", pair)
+		}
+		labels[kv[0]] = kv[1]
+	}
+	return labels, nil
+}
+
+// historySource returns the source of past readings: a sink which records them, ex. the
+// database, or otherwise the archive. It returns nil if neither is configured.
+func historySource(sinks []lde.Sink, archiveDir string) history.Source {
+	for _, sink := range sinks {
+		if src, ok := sink.(history.Source); ok {
+			return src
+		}
+	}
+	if archiveDir != "" {
+		return &archive.History{Dir: archiveDir}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/archive"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+	"github.com/jcodybaker/seneye-exporter/pkg/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistorySource(t *testing.T) {
+	assert.Nil(t, historySource(nil, ""))
+	assert.Equal(t, &archive.History{Dir: "/archive"}, historySource(nil, "/archive"))
+
+	ctx := context.Background()
+	s, err := store.Open(ctx, store.Config{Path: filepath.Join(t.TempDir(), "seneye.db")})
+	require.NoError(t, err)
+	defer s.Close(ctx)
+	w, err := archive.NewWriter(archive.Config{Dir: t.TempDir()})
+	require.NoError(t, err)
+	// The database is preferred to the archive.
+	assert.Same(t, s, historySource([]lde.Sink{w, s}, "/archive"))
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := parseLabels([]string{"site=home", "room=office=2"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"site": "home", "room": "office=2"}, labels)
+	_, err = parseLabels([]string{"site"})
+	assert.Error(t, err)
+	_, err = parseLabels([]string{"=home"})
+	assert.Error(t, err)
+}
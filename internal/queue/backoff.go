@@ -0,0 +1,76 @@
+package queue
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Backoff is the delay between retries, which doubles after each failure.
+type Backoff struct {
+	min, max, next time.Duration
+}
+
+// NewBackoff creates a Backoff from min up to max, which default to 1 second and 1 minute.
+func NewBackoff(min, max time.Duration) *Backoff {
+	if min <= 0 {
+		min = time.Second
+	}
+	if max < min {
+		max = time.Minute
+	}
+	return &Backoff{min: min, max: max, next: min}
+}
+
+// Next returns the delay before retrying after err, and doubles the following delay. The delay is
+// the one requested by err with RetryAfter, if any.
+func (b *Backoff) Next(err error) time.Duration {
+	wait := b.next
+	if b.next *= 2; b.next > b.max {
+		b.next = b.max
+	}
+	var ra *retryAfterError
+	if errors.As(err, &ra) && ra.after > 0 {
+		wait = ra.after
+	}
+	return wait
+}
+
+// Reset restores the minimum delay, ex. after a success.
+func (b *Backoff) Reset() {
+	b.next = b.min
+}
+
+// retryAfterError is a failure which the service asked to be retried after a delay.
+type retryAfterError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *retryAfterError) Error() string { return e.err.Error() }
+func (e *retryAfterError) Unwrap() error { return e.err }
+
+// RetryAfter marks err as a failure which should be retried after the delay, rather than the
+// backoff, ex. as requested by a rate limited service. A delay of 0 leaves err unchanged.
+func RetryAfter(err error, after time.Duration) error {
+	if after <= 0 {
+		return err
+	}
+	return &retryAfterError{err: err, after: after}
+}
+
+// ParseRetryAfter returns the delay requested by the response's Retry-After header, in seconds or
+// as an HTTP date, or 0 if it has none.
+func ParseRetryAfter(resp *http.Response) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if s, err := strconv.Atoi(v); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
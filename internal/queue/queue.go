@@ -0,0 +1,226 @@
+// Package queue delivers items to a remote service in the background, retrying failed deliveries
+// with backoff, so sinks don't block the LDE handler while the service is slow or unavailable.
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Config configures a Queue.
+type Config struct {
+	// Name identifies the queue's sink in errors and logs, ex. "graphite".
+	Name string
+	// Size is the number of items queued while a delivery is in progress or being retried.
+	// Further items are dropped. Defaults to 1000.
+	Size int
+	// MaxBatch bounds the number of queued items delivered together. Defaults to 1.
+	MaxBatch int
+	// MinBackoff is the delay before the first retry of a failed delivery. Defaults to 1 second.
+	MinBackoff time.Duration
+	// MaxBackoff bounds the delay between retries, which doubles after each failure. Defaults to
+	// 1 minute.
+	MaxBackoff time.Duration
+	// MaxElapsed bounds the time spent retrying a batch, after which it's dropped. 0 retries until
+	// the batch is delivered.
+	MaxElapsed time.Duration
+}
+
+// DeliverFunc delivers a batch of items. Failures are retried, unless the error is Permanent.
+type DeliverFunc[T any] func(ctx context.Context, batch []T) error
+
+// Stats counts the outcomes of the items sent to a Queue.
+type Stats struct {
+	// Delivered is the number of items delivered.
+	Delivered int
+	// Retries is the number of failed deliveries which were retried.
+	Retries int
+	// Dropped is the number of items not delivered because the queue was full, the delivery
+	// failed permanently or exceeded MaxElapsed, or the queue was abandoned.
+	Dropped int
+	// Queued is the number of items waiting to be delivered.
+	Queued int
+}
+
+// Queue delivers items sent to it in order, one batch at a time.
+type Queue[T any] struct {
+	config  Config
+	deliver DeliverFunc[T]
+
+	items chan T
+	stop  context.CancelFunc
+	done  chan struct{}
+
+	lock   sync.Mutex
+	closed bool
+	err    error
+	stats  Stats
+}
+
+// New creates a Queue and starts delivering the items sent to it.
+func New[T any](config Config, deliver DeliverFunc[T]) *Queue[T] {
+	if config.Size <= 0 {
+		config.Size = 1000
+	}
+	if config.MaxBatch <= 0 {
+		config.MaxBatch = 1
+	}
+	q := &Queue[T]{
+		config:  config,
+		deliver: deliver,
+		items:   make(chan T, config.Size),
+		done:    make(chan struct{}),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q.stop = cancel
+	go q.run(ctx, NewBackoff(config.MinBackoff, config.MaxBackoff))
+	return q
+}
+
+// Send queues the item. It never blocks; if the queue is full the item is dropped and an error
+// returned.
+func (q *Queue[T]) Send(item T) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return fmt.Errorf("%s sink is closed", q.config.Name)
+	}
+	select {
+	case q.items <- item:
+		return nil
+	default:
+		q.stats.Dropped++
+		return fmt.Errorf("%s queue is full; dropping", q.config.Name)
+	}
+}
+
+// Err returns the error of the last delivery, or nil if it succeeded.
+func (q *Queue[T]) Err() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.err
+}
+
+// Stats returns the outcomes of the items sent to the queue so far.
+func (q *Queue[T]) Stats() Stats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	s := q.stats
+	s.Queued = len(q.items)
+	return s
+}
+
+// Close delivers any queued items, and stops the queue. Queued items are abandoned if ctx is done
+// first. The DeliverFunc isn't called once Close returns.
+func (q *Queue[T]) Close(ctx context.Context) error {
+	q.lock.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.items)
+	}
+	q.lock.Unlock()
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		q.stop()
+		<-q.done
+		return ctx.Err()
+	}
+}
+
+// run delivers queued items, batching those which queued while a delivery was in progress, until
+// the queue is closed and drained, or ctx is done.
+func (q *Queue[T]) run(ctx context.Context, backoff *Backoff) {
+	defer close(q.done)
+	for item := range q.items {
+		batch := []T{item}
+	fill:
+		for len(batch) < q.config.MaxBatch {
+			select {
+			case item, ok := <-q.items:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, item)
+			default:
+				break fill
+			}
+		}
+		if !q.deliverBatch(ctx, batch, backoff) {
+			// Items still queued when the queue is stopped are abandoned.
+			q.lock.Lock()
+			q.stats.Dropped += len(batch) + len(q.items)
+			q.lock.Unlock()
+			return
+		}
+	}
+}
+
+// deliverBatch delivers the batch, retrying failures with backoff. It returns false if ctx was
+// done first.
+func (q *Queue[T]) deliverBatch(ctx context.Context, batch []T, backoff *Backoff) bool {
+	var deadline time.Time
+	if q.config.MaxElapsed > 0 {
+		deadline = time.Now().Add(q.config.MaxElapsed)
+	}
+	ll := log.With().Str("sink", q.config.Name).Logger()
+	for {
+		err := q.deliver(ctx, batch)
+		if ctx.Err() != nil {
+			return false
+		}
+		q.lock.Lock()
+		q.err = err
+		q.lock.Unlock()
+		if err == nil {
+			q.count(func(s *Stats) { s.Delivered += len(batch) })
+			backoff.Reset()
+			return true
+		}
+		wait := backoff.Next(err)
+		if IsPermanent(err) || (!deadline.IsZero() && time.Now().Add(wait).After(deadline)) {
+			ll.Warn().Err(err).Int("items", len(batch)).Msg("delivery failed; dropping")
+			q.count(func(s *Stats) { s.Dropped += len(batch) })
+			return true
+		}
+		ll.Warn().Err(err).Dur("backoff", wait).Msg("delivery failed; retrying")
+		q.count(func(s *Stats) { s.Retries++ })
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (q *Queue[T]) count(fn func(*Stats)) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	fn(&q.stats)
+}
+
+// permanentError marks a failure which won't succeed if retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as a failure which won't succeed if retried, ex. a rejected request, so the
+// batch is dropped.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// IsPermanent is true if err, or any error it wraps, was marked Permanent.
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
@@ -0,0 +1,122 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a DeliverFunc which fails while err is set.
+type recorder struct {
+	lock    sync.Mutex
+	err     error
+	batches [][]int
+}
+
+func (r *recorder) deliver(ctx context.Context, batch []int) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.batches = append(r.batches, batch)
+	return nil
+}
+
+func (r *recorder) setErr(err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.err = err
+}
+
+func (r *recorder) delivered() [][]int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([][]int(nil), r.batches...)
+}
+
+func TestQueue(t *testing.T) {
+	r := &recorder{err: errors.New("unavailable")}
+	q := New(Config{Name: "test", MaxBatch: 2, MinBackoff: 10 * time.Millisecond}, r.deliver)
+	require.NoError(t, q.Send(1))
+	require.Eventually(t, func() bool { return q.Err() != nil }, 5*time.Second, time.Millisecond)
+	// Items queued while the first is retried are delivered in batches once it succeeds.
+	for i := 2; i <= 4; i++ {
+		require.NoError(t, q.Send(i))
+	}
+	r.setErr(nil)
+	require.NoError(t, q.Close(context.Background()))
+	assert.NoError(t, q.Err())
+	assert.Equal(t, [][]int{{1}, {2, 3}, {4}}, r.delivered())
+	s := q.Stats()
+	assert.Equal(t, 4, s.Delivered)
+	assert.GreaterOrEqual(t, s.Retries, 1)
+	assert.Equal(t, 0, s.Dropped)
+	assert.EqualError(t, q.Send(5), "test sink is closed")
+}
+
+func TestQueueDropsPermanent(t *testing.T) {
+	r := &recorder{err: Permanent(errors.New("rejected"))}
+	q := New(Config{Name: "test", MinBackoff: time.Hour}, r.deliver)
+	require.NoError(t, q.Send(1))
+	require.NoError(t, q.Close(context.Background()))
+	assert.EqualError(t, q.Err(), "rejected")
+	assert.Equal(t, Stats{Dropped: 1}, q.Stats())
+}
+
+func TestQueueMaxElapsed(t *testing.T) {
+	r := &recorder{err: errors.New("unavailable")}
+	q := New(Config{Name: "test", MinBackoff: 10 * time.Millisecond, MaxElapsed: 50 * time.Millisecond}, r.deliver)
+	require.NoError(t, q.Send(1))
+	require.NoError(t, q.Close(context.Background()))
+	assert.Empty(t, r.delivered())
+	assert.Equal(t, 1, q.Stats().Dropped)
+}
+
+func TestQueueCloseAbandons(t *testing.T) {
+	r := &recorder{err: errors.New("unavailable")}
+	q := New(Config{Name: "test", Size: 1, MinBackoff: time.Hour}, r.deliver)
+	require.NoError(t, q.Send(1))
+	// The first item is being retried, filling the queue.
+	require.Eventually(t, func() bool { return q.Send(2) == nil }, 5*time.Second, time.Millisecond)
+	assert.EqualError(t, q.Send(3), "test queue is full; dropping")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, q.Close(ctx))
+	assert.Equal(t, 3, q.Stats().Dropped)
+}
+
+func TestBackoff(t *testing.T) {
+	b := NewBackoff(time.Second, 3*time.Second)
+	err := errors.New("unavailable")
+	assert.Equal(t, time.Second, b.Next(err))
+	assert.Equal(t, 2*time.Second, b.Next(err))
+	assert.Equal(t, 3*time.Second, b.Next(err))
+	assert.Equal(t, 3*time.Second, b.Next(err))
+	// A requested delay takes precedence, even wrapped.
+	assert.Equal(t, time.Minute, b.Next(Permanent(RetryAfter(err, time.Minute))))
+	b.Reset()
+	assert.Equal(t, time.Second, b.Next(err))
+	assert.Same(t, err, RetryAfter(err, 0))
+
+	b = NewBackoff(0, 0)
+	assert.Equal(t, time.Second, b.Next(err))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	assert.Equal(t, time.Duration(0), ParseRetryAfter(resp))
+	resp.Header.Set("Retry-After", "120")
+	assert.Equal(t, 2*time.Minute, ParseRetryAfter(resp))
+	resp.Header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	assert.InDelta(t, float64(time.Hour), float64(ParseRetryAfter(resp)), float64(5*time.Second))
+	resp.Header.Set("Retry-After", "soon")
+	assert.Equal(t, time.Duration(0), ParseRetryAfter(resp))
+}
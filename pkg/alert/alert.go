@@ -0,0 +1,297 @@
+// Package alert raises alerts from LDE readings, for each status flag the SUD raises and each
+// configured threshold a reading crosses, and delivers notifications of them.
+package alert
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+)
+
+// Severity ranks alerts, and the notifications about them.
+type Severity int
+
+const (
+	// Info is the severity of notifications which only report resolved alerts.
+	Info Severity = iota
+	// Warning alerts need attention soon.
+	Warning
+	// Critical alerts need attention now, ex. the SUD is out of the water.
+	Critical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	case Critical:
+		return "critical"
+	default:
+		return "Severity(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// ParseSeverity parses the severity's name, as returned by Severity.String().
+func ParseSeverity(name string) (Severity, error) {
+	for _, s := range []Severity{Info, Warning, Critical} {
+		if strings.EqualFold(name, s.String()) {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown severity: %q", name)
+}
+
+// OutOfWater names the alert raised while the SUD isn't submerged.
+const OutOfWater = "out_of_water"
+
+// flags names the alerts raised for the SUD's status flags, other than OutOfWater, in the order
+// they're evaluated.
+var flags = []string{"temperature", "ph", "nh3", "slide", "kelvin"}
+
+// StatusFlags returns the names of the alerts raised for the SUD's status flags, other than
+// OutOfWater, which are also the names of the flags' readings.
+func StatusFlags() []string {
+	return append([]string(nil), flags...)
+}
+
+// FlagSummary describes the alert raised for the status flag, or OutOfWater, ex. "pH flagged out of
+// range by the SUD".
+func FlagSummary(flag string) string {
+	switch flag {
+	case OutOfWater:
+		return "SUD out of the water"
+	case "slide":
+		return "Slide expired or not installed"
+	default:
+		return labels[flag].name + " flagged out of range by the SUD"
+	}
+}
+
+// labels describes each reading, for alert summaries.
+var labels = map[string]struct{ name, unit string }{
+	"temperature":   {"Temperature", "°C"},
+	"ph":            {"pH", ""},
+	"nh3":           {"Free ammonia (NH3)", " ppm"},
+	"total_ammonia": {"Total ammonia", " ppm"},
+	"kelvin":        {"Light color temperature", " K"},
+	"lux":           {"Light intensity", " lx"},
+	"par":           {"PAR", " µmol/m²/s"},
+	"slide":         {"Slide", ""},
+}
+
+// Rule raises an alert while a reading is outside its limits.
+type Rule struct {
+	// Name identifies the alert, ex. "high_temperature".
+	Name string
+	// Reading is the name of the reading tested, ex. "temperature" or "total_ammonia".
+	Reading string
+	// Min and Max are the limits of the reading, if set. The alert is raised while the reading
+	// is below Min or above Max.
+	Min, Max *float64
+	// Severity of the alert. Defaults to Warning.
+	Severity Severity
+	// SUDs limits the rule to these SUD IDs, if set.
+	SUDs []string
+}
+
+// Validate reports whether the rule can be evaluated.
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("alert rule for %q has no name", r.Reading)
+	}
+	if r.Name == OutOfWater {
+		return fmt.Errorf("alert rule %q: name is reserved for status flags", r.Name)
+	}
+	for _, f := range flags {
+		if r.Name == f {
+			return fmt.Errorf("alert rule %q: name is reserved for status flags", r.Name)
+		}
+	}
+	if _, ok := labels[r.Reading]; !ok || r.Reading == "slide" {
+		return fmt.Errorf("alert rule %q: unknown reading %q", r.Name, r.Reading)
+	}
+	if r.Min == nil && r.Max == nil {
+		return fmt.Errorf("alert rule %q: min or max is required", r.Name)
+	}
+	if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+		return fmt.Errorf("alert rule %q: min is greater than max", r.Name)
+	}
+	return nil
+}
+
+// Summary describes the reading crossing the rule's Max if above is set, or its Min otherwise, ex.
+// "pH above 8.4".
+func (r *Rule) Summary(above bool) string {
+	label := labels[r.Reading]
+	if above {
+		return fmt.Sprintf("%s above %s%s", label.name, formatFloat(*r.Max), label.unit)
+	}
+	return fmt.Sprintf("%s below %s%s", label.name, formatFloat(*r.Min), label.unit)
+}
+
+// appliesTo reports whether the rule tests readings from the SUD.
+func (r *Rule) appliesTo(id string) bool {
+	if len(r.SUDs) == 0 {
+		return true
+	}
+	for _, s := range r.SUDs {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Alert describes a condition of a SUD which needs attention.
+type Alert struct {
+	// Name identifies the alert: OutOfWater, the name of the raised status flag (ex. "ph"), or
+	// the name of the Rule.
+	Name     string
+	Severity Severity
+	// Summary describes the condition, ex. "pH above 8.4".
+	Summary string
+	// Reading is the name of the reading which raised the alert, and Value its value, for
+	// threshold alerts.
+	Reading string
+	Value   float64
+	// StartsAt is when the reading which first raised the alert was taken.
+	StartsAt time.Time
+}
+
+// Evaluate returns the alerts raised by the LDE: first its status flags, then the rules in order.
+// Rules aren't evaluated while the SUD is out of the water, as its readings are meaningless; the
+// Manager carries their alerts forward until it's back in.
+func Evaluate(l *lde.LDE, rules []Rule) []Alert {
+	t := time.Unix(l.SUD.Timestamp, 0)
+	var alerts []Alert
+	status := map[string]float64{}
+	values := map[string]float64{}
+	for _, r := range l.Readings() {
+		if r.Status {
+			status[r.Name] = r.Value
+		} else {
+			values[r.Name] = r.Value
+		}
+	}
+	if status["water"] == 0 {
+		alerts = append(alerts, Alert{Name: OutOfWater, Severity: Critical, Summary: FlagSummary(OutOfWater), StartsAt: t})
+	}
+	for _, f := range flags {
+		if v, ok := status[f]; ok && v != 0 {
+			alerts = append(alerts, Alert{Name: f, Severity: Warning, Summary: FlagSummary(f), StartsAt: t})
+		}
+	}
+	if status["water"] == 0 {
+		return alerts
+	}
+	for _, r := range rules {
+		v, ok := values[r.Reading]
+		if !ok || !r.appliesTo(l.SUD.ID) {
+			continue
+		}
+		var summary string
+		switch {
+		case r.Min != nil && v < *r.Min:
+			summary = r.Summary(false)
+		case r.Max != nil && v > *r.Max:
+			summary = r.Summary(true)
+		default:
+			continue
+		}
+		alerts = append(alerts, Alert{
+			Name:     r.Name,
+			Severity: r.Severity,
+			Summary:  summary,
+			Reading:  r.Reading,
+			Value:    v,
+			StartsAt: t,
+		})
+	}
+	return alerts
+}
+
+// FormatReading describes the reading with its unit, ex. "Temperature: 25.5°C". Values are rounded
+// to 3 decimal places, as derived readings like total ammonia aren't.
+func FormatReading(r lde.Reading) string {
+	v := formatFloat(math.Round(r.Value*1000) / 1000)
+	label, ok := labels[r.Name]
+	if !ok {
+		return r.Name + ": " + v
+	}
+	return label.name + ": " + v + label.unit
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// Notification reports the changes to a SUD's alerts, batching those raised or resolved by the
+// same reading.
+type Notification struct {
+	// SUD is the SUD and its latest reading.
+	SUD lde.SUD
+	// Firing are all of the SUD's alerts which are raised.
+	Firing []Alert
+	// Resolved are the alerts which have cleared since the last notification.
+	Resolved []Alert
+	// Repeat is set when the notification is resent because alerts are still firing, rather than
+	// because they changed.
+	Repeat bool
+}
+
+// Time is when the SUD's latest reading was taken.
+func (n *Notification) Time() time.Time {
+	return time.Unix(n.SUD.Timestamp, 0)
+}
+
+// Severity is the highest severity of the firing alerts, or Info if they've all resolved.
+func (n *Notification) Severity() Severity {
+	s := Info
+	for _, a := range n.Firing {
+		if a.Severity > s {
+			s = a.Severity
+		}
+	}
+	return s
+}
+
+// SUDName is the SUD's name, or its ID if it's unnamed.
+func (n *Notification) SUDName() string {
+	if n.SUD.Name != "" {
+		return n.SUD.Name
+	}
+	return n.SUD.ID
+}
+
+// Title summarizes the notification in a line, ex. "[CRITICAL] Reef: SUD out of the water".
+func (n *Notification) Title() string {
+	if len(n.Firing) == 0 {
+		var summaries []string
+		for _, a := range n.Resolved {
+			summaries = append(summaries, a.Summary)
+		}
+		return fmt.Sprintf("[RESOLVED] %s: %s", n.SUDName(), strings.Join(summaries, ", "))
+	}
+	var summaries []string
+	for _, a := range n.Firing {
+		summaries = append(summaries, a.Summary)
+	}
+	return fmt.Sprintf("[%s] %s: %s", strings.ToUpper(n.Severity().String()), n.SUDName(), strings.Join(summaries, ", "))
+}
+
+// Readings returns the SUD's latest readings, excluding status flags.
+func (n *Notification) Readings() []lde.Reading {
+	var out []lde.Reading
+	for _, r := range (&lde.LDE{SUD: n.SUD}).Readings() {
+		if !r.Status {
+			out = append(out, r)
+		}
+	}
+	return out
+}
@@ -0,0 +1,105 @@
+package alert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func float(f float64) *float64 {
+	return &f
+}
+
+// reading returns an LDE from a submerged reef SUD with in-range readings.
+func reading(ts int64) *lde.LDE {
+	return &lde.LDE{SUD: lde.SUD{
+		ID:        "1234",
+		Name:      "Reef",
+		Type:      lde.ReefSUD,
+		Timestamp: ts,
+		Data: lde.Data{
+			Status:      lde.SUDStatus{Water: 1},
+			Temperature: 25.5,
+			PH:          8.1,
+			NH3:         0.004,
+		},
+	}}
+}
+
+func TestEvaluate(t *testing.T) {
+	rules := []Rule{
+		{Name: "high_ph", Reading: "ph", Max: float(8.4), Severity: Critical},
+		{Name: "cold", Reading: "temperature", Min: float(24), Severity: Warning},
+		{Name: "other_sud", Reading: "temperature", Min: float(30), SUDs: []string{"5678"}},
+	}
+	l := reading(1609561222)
+	assert.Empty(t, Evaluate(l, rules))
+
+	l.SUD.Data.PH = 8.5
+	l.SUD.Data.Temperature = 23
+	l.SUD.Data.Status.Slide = 1
+	assert.Equal(t, []Alert{
+		{Name: "slide", Severity: Warning, Summary: "Slide expired or not installed", StartsAt: time.Unix(1609561222, 0)},
+		{Name: "high_ph", Severity: Critical, Summary: "pH above 8.4", Reading: "ph", Value: 8.5, StartsAt: time.Unix(1609561222, 0)},
+		{Name: "cold", Severity: Warning, Summary: "Temperature below 24°C", Reading: "temperature", Value: 23, StartsAt: time.Unix(1609561222, 0)},
+	}, Evaluate(l, rules))
+
+	// Readings are meaningless out of the water, so only status flags are raised.
+	l.SUD.Data.Status.Water = 0
+	l.SUD.Data.Status.PH = 1
+	assert.Equal(t, []Alert{
+		{Name: OutOfWater, Severity: Critical, Summary: "SUD out of the water", StartsAt: time.Unix(1609561222, 0)},
+		{Name: "ph", Severity: Warning, Summary: "pH flagged out of range by the SUD", StartsAt: time.Unix(1609561222, 0)},
+		{Name: "slide", Severity: Warning, Summary: "Slide expired or not installed", StartsAt: time.Unix(1609561222, 0)},
+	}, Evaluate(l, rules))
+}
+
+func TestRuleValidate(t *testing.T) {
+	for _, r := range []Rule{
+		{Reading: "ph", Max: float(8.4)},
+		{Name: "ph", Reading: "ph", Max: float(8.4)},
+		{Name: OutOfWater, Reading: "ph", Max: float(8.4)},
+		{Name: "x", Reading: "salinity", Max: float(35)},
+		{Name: "x", Reading: "slide", Max: float(0)},
+		{Name: "x", Reading: "ph"},
+		{Name: "x", Reading: "ph", Min: float(8.4), Max: float(7.8)},
+	} {
+		assert.Error(t, r.Validate(), "%+v", r)
+	}
+	assert.NoError(t, (&Rule{Name: "x", Reading: "total_ammonia", Max: float(0.02)}).Validate())
+}
+
+func TestParseSeverity(t *testing.T) {
+	s, err := ParseSeverity("Critical")
+	require.NoError(t, err)
+	assert.Equal(t, Critical, s)
+	_, err = ParseSeverity("urgent")
+	assert.Error(t, err)
+}
+
+func TestNotificationTitle(t *testing.T) {
+	n := &Notification{
+		SUD: reading(1609561222).SUD,
+		Firing: []Alert{
+			{Name: OutOfWater, Severity: Critical, Summary: "SUD out of the water"},
+			{Name: "slide", Severity: Warning, Summary: "Slide expired or not installed"},
+		},
+	}
+	assert.Equal(t, Critical, n.Severity())
+	assert.Equal(t, "[CRITICAL] Reef: SUD out of the water, Slide expired or not installed", n.Title())
+
+	n = &Notification{SUD: lde.SUD{ID: "1234"}, Resolved: []Alert{{Name: "cold", Summary: "Temperature below 24°C"}}}
+	assert.Equal(t, Info, n.Severity())
+	assert.Equal(t, "[RESOLVED] 1234: Temperature below 24°C", n.Title())
+}
+
+func TestFormatReading(t *testing.T) {
+	assert.Equal(t, "Temperature: 25.5°C", FormatReading(lde.Reading{Name: "temperature", Value: 25.5}))
+	assert.Equal(t, "pH: 8.1", FormatReading(lde.Reading{Name: "ph", Value: 8.1}))
+	assert.Equal(t, "Free ammonia (NH3): 0.004 ppm", FormatReading(lde.Reading{Name: "nh3", Value: 0.004}))
+	assert.Equal(t, "Total ammonia: 0.069 ppm", FormatReading(lde.Reading{Name: "total_ammonia", Value: 0.06860578299738075}))
+}
@@ -0,0 +1,331 @@
+// Package email delivers alert notifications by SMTP.
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/alert"
+)
+
+// TLS modes.
+const (
+	// TLSStartTLS upgrades the connection with STARTTLS, failing if the server doesn't offer it.
+	TLSStartTLS = "starttls"
+	// TLSImplicit connects with TLS, typically to port 465.
+	TLSImplicit = "tls"
+	// TLSNone never encrypts the connection. Credentials are only sent to localhost.
+	TLSNone = "none"
+)
+
+// Default templates, executed with the *alert.Notification.
+const (
+	DefaultSubject = `{{.Title}}`
+	DefaultText    = `{{.Title}}
+{{range .Firing}}
+FIRING [{{.Severity}}] {{.Summary}}, since {{.StartsAt.Format "2006-01-02 15:04 MST"}}
+{{- end}}
+{{- range .Resolved}}
+RESOLVED {{.Summary}}
+{{- end}}
+
+Latest readings from {{.SUDName}} at {{.Time.Format "2006-01-02 15:04 MST"}}:
+{{range .Readings}}
+  {{reading .}}
+{{- end}}
+`
+	DefaultHTML = `<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif">
+<h2>{{.Title}}</h2>
+{{- if .Firing}}
+<h3>Firing</h3>
+<ul>
+{{- range .Firing}}
+<li><strong>{{.Severity}}</strong>: {{.Summary}}, since {{.StartsAt.Format "2006-01-02 15:04 MST"}}</li>
+{{- end}}
+</ul>
+{{- end}}
+{{- if .Resolved}}
+<h3>Resolved</h3>
+<ul>
+{{- range .Resolved}}
+<li>{{.Summary}}</li>
+{{- end}}
+</ul>
+{{- end}}
+<p>Latest readings from {{.SUDName}} at {{.Time.Format "2006-01-02 15:04 MST"}}:</p>
+<ul>
+{{- range .Readings}}
+<li>{{reading .}}</li>
+{{- end}}
+</ul>
+</body>
+</html>
+`
+)
+
+// funcs are available to the templates.
+var funcs = map[string]interface{}{
+	"reading": alert.FormatReading,
+}
+
+// Config configures a Notifier.
+type Config struct {
+	// Address is the host:port of the SMTP server, ex. smtp.example.com:587.
+	Address string
+	// TLS is the TLS mode: TLSStartTLS, TLSImplicit or TLSNone. Defaults to TLSStartTLS.
+	TLS string
+	// TLSConfig configures TLS connections, ex. to trust a private CA. The server name defaults
+	// to Address's host.
+	TLSConfig *tls.Config
+	// Username and Password authenticate with PLAIN auth, if Username is set.
+	Username string
+	Password string
+	// From is the sender's address, ex. "Aquarium <alerts@example.com>".
+	From string
+	// To are the recipients' addresses.
+	To []string
+	// Subject is a text/template producing the subject. Defaults to DefaultSubject.
+	Subject string
+	// Text is a text/template producing the plain text body. Defaults to DefaultText.
+	Text string
+	// HTML is an html/template producing the HTML body. Defaults to DefaultHTML.
+	HTML string
+	// Timeout bounds connecting and sending each message. Defaults to 30 seconds.
+	Timeout time.Duration
+}
+
+// Notifier is an alert.Notifier which emails each notification as a multipart message with plain
+// text and HTML bodies.
+type Notifier struct {
+	config  Config
+	host    string
+	from    *mail.Address
+	to      []*mail.Address
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+var _ alert.Notifier = (*Notifier)(nil)
+
+// New creates a Notifier.
+func New(config Config) (*Notifier, error) {
+	if config.Address == "" {
+		return nil, errors.New("smtp address is required")
+	}
+	host, _, err := net.SplitHostPort(config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("parsing smtp address: %w", err)
+	}
+	switch config.TLS {
+	case "":
+		config.TLS = TLSStartTLS
+	case TLSStartTLS, TLSImplicit, TLSNone:
+	default:
+		return nil, fmt.Errorf("unknown smtp tls mode: %q", config.TLS)
+	}
+	if config.Subject == "" {
+		config.Subject = DefaultSubject
+	}
+	if config.Text == "" {
+		config.Text = DefaultText
+	}
+	if config.HTML == "" {
+		config.HTML = DefaultHTML
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+	n := &Notifier{config: config, host: host}
+	if n.from, err = mail.ParseAddress(config.From); err != nil {
+		return nil, fmt.Errorf("parsing smtp from address: %w", err)
+	}
+	if len(config.To) == 0 {
+		return nil, errors.New("at least one smtp recipient is required")
+	}
+	for _, to := range config.To {
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return nil, fmt.Errorf("parsing smtp recipient: %w", err)
+		}
+		n.to = append(n.to, addr)
+	}
+	if n.subject, err = texttemplate.New("subject").Funcs(funcs).Parse(config.Subject); err != nil {
+		return nil, fmt.Errorf("parsing email subject template: %w", err)
+	}
+	if n.text, err = texttemplate.New("text").Funcs(funcs).Parse(config.Text); err != nil {
+		return nil, fmt.Errorf("parsing email text template: %w", err)
+	}
+	if n.html, err = htmltemplate.New("html").Funcs(funcs).Parse(config.HTML); err != nil {
+		return nil, fmt.Errorf("parsing email html template: %w", err)
+	}
+	return n, nil
+}
+
+// Name implements alert.Notifier.
+func (n *Notifier) Name() string {
+	return "email"
+}
+
+// Notify implements alert.Notifier, emailing the notification to the recipients.
+func (n *Notifier) Notify(ctx context.Context, notification *alert.Notification) error {
+	msg, err := n.message(notification, time.Now())
+	if err != nil {
+		return err
+	}
+	return n.send(ctx, msg)
+}
+
+// message renders the notification as a MIME message.
+func (n *Notifier) message(notification *alert.Notification, now time.Time) ([]byte, error) {
+	var subject, text, html bytes.Buffer
+	if err := n.subject.Execute(&subject, notification); err != nil {
+		return nil, fmt.Errorf("executing email subject template: %w", err)
+	}
+	if err := n.text.Execute(&text, notification); err != nil {
+		return nil, fmt.Errorf("executing email text template: %w", err)
+	}
+	if err := n.html.Execute(&html, notification); err != nil {
+		return nil, fmt.Errorf("executing email html template: %w", err)
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write(part.content); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	to := make([]string, len(n.to))
+	for i, addr := range n.to {
+		to[i] = addr.String()
+	}
+	var msg bytes.Buffer
+	for _, h := range [][2]string{
+		{"From", n.from.String()},
+		{"To", strings.Join(to, ", ")},
+		// Newlines would inject headers.
+		{"Subject", mime.QEncoding.Encode("utf-8", strings.Join(strings.Fields(subject.String()), " "))},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"Message-ID", messageID(n.from.Address)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + parts.Boundary()},
+	} {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], h[1])
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// messageID returns a unique Message-ID in the sender's domain.
+func messageID(from string) string {
+	domain := "localhost"
+	if i := strings.LastIndexByte(from, '@'); i >= 0 {
+		domain = from[i+1:]
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
+
+// send delivers the message over a new connection to the server.
+func (n *Notifier) send(ctx context.Context, msg []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, n.config.Timeout)
+	defer cancel()
+	tlsConfig := &tls.Config{}
+	if n.config.TLSConfig != nil {
+		tlsConfig = n.config.TLSConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = n.host
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", n.config.Address)
+	if err != nil {
+		return fmt.Errorf("connecting to smtp server: %w", err)
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	if n.config.TLS == TLSImplicit {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	c, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		return fmt.Errorf("connecting to smtp server: %w", err)
+	}
+	defer c.Close()
+	if n.config.TLS == TLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server doesn't support STARTTLS")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("starting tls: %w", err)
+		}
+	}
+	if n.config.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", n.config.Username, n.config.Password, n.host)); err != nil {
+			return fmt.Errorf("authenticating with smtp server: %w", err)
+		}
+	}
+	if err := c.Mail(n.from.Address); err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	for _, to := range n.to {
+		if err := c.Rcpt(to.Address); err != nil {
+			return fmt.Errorf("sending email to %q: %w", to.Address, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	return c.Quit()
+}
@@ -0,0 +1,297 @@
+package email
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/alert"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCert returns a self-signed certificate for 127.0.0.1, and a pool trusting it.
+func newTestCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// smtpServer is a fake SMTP server, recording the messages it receives.
+type smtpServer struct {
+	listener net.Listener
+	// tls, if set, is offered with STARTTLS.
+	tls *tls.Config
+
+	lock     sync.Mutex
+	messages []message
+}
+
+// message is an email received by the smtpServer.
+type message struct {
+	// auth is the decoded AUTH PLAIN response, if the client authenticated.
+	auth string
+	// tls is whether the message was sent over TLS.
+	tls  bool
+	from string
+	to   []string
+	data []byte
+}
+
+// startSMTPServer starts a fake SMTP server, offering STARTTLS, only accepting TLS connections,
+// or never using TLS, depending on the TLS mode.
+func startSMTPServer(t *testing.T, cert tls.Certificate, mode string) *smtpServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &smtpServer{listener: l}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	switch mode {
+	case TLSStartTLS:
+		s.tls = config
+	case TLSImplicit:
+		s.listener = tls.NewListener(l, config)
+	}
+	implicit := mode == TLSImplicit
+	t.Cleanup(func() { s.listener.Close() })
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, implicit)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) serve(conn net.Conn, secure bool) {
+	defer func() { conn.Close() }()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP")
+	var m message
+	m.tls = secure
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			cmd, arg = line[:i], line[i+1:]
+		}
+		switch strings.ToUpper(cmd) {
+		case "EHLO":
+			ext := []string{"250-localhost"}
+			if s.tls != nil && !m.tls {
+				ext = append(ext, "250-STARTTLS")
+			}
+			tp.PrintfLine("%s\r\n250 AUTH PLAIN", strings.Join(ext, "\r\n"))
+		case "STARTTLS":
+			tp.PrintfLine("220 ready")
+			conn = tls.Server(conn, s.tls)
+			tp = textproto.NewConn(conn)
+			m.tls = true
+		case "AUTH":
+			b, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(arg, "PLAIN "))
+			m.auth = string(b)
+			tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			m.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			m.to = append(m.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			m.data, err = tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.lock.Lock()
+			s.messages = append(s.messages, m)
+			s.lock.Unlock()
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 unsupported")
+		}
+	}
+}
+
+func (s *smtpServer) received() []message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]message(nil), s.messages...)
+}
+
+func testNotification() *alert.Notification {
+	return &alert.Notification{
+		SUD: lde.SUD{
+			ID:        "1234",
+			Name:      "Reef",
+			Type:      lde.ReefSUD,
+			Timestamp: 1609561222,
+			Data:      lde.Data{Status: lde.SUDStatus{Water: 1}, Temperature: 23, PH: 8.1, NH3: 0.004},
+		},
+		Firing: []alert.Alert{
+			{Name: "cold", Severity: alert.Critical, Summary: "Temperature below 24°C", StartsAt: time.Unix(1609561222, 0).UTC()},
+		},
+		Resolved: []alert.Alert{{Name: "slide", Summary: "Slide expired or not installed"}},
+	}
+}
+
+// parts returns the message's headers, and its bodies by content type.
+func parts(t *testing.T, data []byte) (mail.Header, map[string]string) {
+	t.Helper()
+	msg, err := mail.ReadMessage(strings.NewReader(string(data)))
+	require.NoError(t, err)
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/alternative", mediaType)
+	bodies := map[string]string{}
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err != nil {
+			break
+		}
+		// The multipart reader decodes quoted-printable parts.
+		b, err := ioutil.ReadAll(p)
+		require.NoError(t, err)
+		contentType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		bodies[contentType] = string(b)
+	}
+	return msg.Header, bodies
+}
+
+func TestNotify(t *testing.T) {
+	cert, pool := newTestCert(t)
+	for _, mode := range []string{TLSStartTLS, TLSImplicit} {
+		t.Run(mode, func(t *testing.T) {
+			s := startSMTPServer(t, cert, mode)
+			n, err := New(Config{
+				Address:   s.listener.Addr().String(),
+				TLS:       mode,
+				TLSConfig: &tls.Config{RootCAs: pool},
+				Username:  "user",
+				Password:  "hunter2",
+				From:      "Aquarium <alerts@example.com>",
+				To:        []string{"a@example.com", "B <b@example.com>"},
+			})
+			require.NoError(t, err)
+			require.NoError(t, n.Notify(context.Background(), testNotification()))
+
+			got := s.received()
+			require.Len(t, got, 1)
+			assert.True(t, got[0].tls)
+			assert.Equal(t, "\x00user\x00hunter2", got[0].auth)
+			assert.Equal(t, "alerts@example.com", got[0].from)
+			assert.Equal(t, []string{"a@example.com", "b@example.com"}, got[0].to)
+
+			header, bodies := parts(t, got[0].data)
+			subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+			require.NoError(t, err)
+			assert.Equal(t, "[CRITICAL] Reef: Temperature below 24°C", subject)
+			assert.Equal(t, `"Aquarium" <alerts@example.com>`, header.Get("From"))
+			assert.Equal(t, `<a@example.com>, "B" <b@example.com>`, header.Get("To"))
+			assert.Contains(t, bodies["text/plain"], "FIRING [critical] Temperature below 24°C, since 2021-01-02 04:20 UTC\nRESOLVED Slide expired or not installed\n")
+			assert.Contains(t, bodies["text/plain"], "  Temperature: 23°C\n  pH: 8.1\n")
+			assert.Contains(t, bodies["text/html"], "<li><strong>critical</strong>: Temperature below 24°C, since 2021-01-02 04:20 UTC</li>")
+			assert.Contains(t, bodies["text/html"], "<li>Free ammonia (NH3): 0.004 ppm</li>")
+		})
+	}
+}
+
+func TestNotifyRequiresStartTLS(t *testing.T) {
+	cert, _ := newTestCert(t)
+	s := startSMTPServer(t, cert, TLSNone)
+	n, err := New(Config{Address: s.listener.Addr().String(), From: "alerts@example.com", To: []string{"a@example.com"}})
+	require.NoError(t, err)
+	assert.EqualError(t, n.Notify(context.Background(), testNotification()), "smtp server doesn't support STARTTLS")
+	assert.Empty(t, s.received())
+}
+
+func TestNotifyTemplates(t *testing.T) {
+	cert, _ := newTestCert(t)
+	s := startSMTPServer(t, cert, TLSNone)
+	n, err := New(Config{
+		Address: s.listener.Addr().String(),
+		TLS:     TLSNone,
+		From:    "alerts@example.com",
+		To:      []string{"a@example.com"},
+		Subject: "{{.SUDName}}\nBcc: evil@example.com",
+		Text:    "{{range .Firing}}{{.Name}}{{end}}",
+		HTML:    "<b>{{.SUDName}}</b> <i>{{(index .Firing 0).Summary}}</i>",
+	})
+	require.NoError(t, err)
+	notification := testNotification()
+	notification.SUD.Name = "<Reef>"
+	require.NoError(t, n.Notify(context.Background(), notification))
+
+	got := s.received()
+	require.Len(t, got, 1)
+	assert.False(t, got[0].tls)
+	header, bodies := parts(t, got[0].data)
+	assert.Equal(t, "<Reef> Bcc: evil@example.com", header.Get("Subject"), "newlines can't inject headers")
+	assert.Empty(t, header.Get("Bcc"))
+	assert.Equal(t, "cold", bodies["text/plain"])
+	assert.Equal(t, "<b>&lt;Reef&gt;</b> <i>Temperature below 24°C</i>", bodies["text/html"])
+}
+
+func TestNewValidates(t *testing.T) {
+	valid := Config{Address: "localhost:25", From: "alerts@example.com", To: []string{"a@example.com"}}
+	_, err := New(valid)
+	require.NoError(t, err)
+	for _, mutate := range []func(c *Config){
+		func(c *Config) { c.Address = "" },
+		func(c *Config) { c.Address = "localhost" },
+		func(c *Config) { c.TLS = "ssl" },
+		func(c *Config) { c.From = "" },
+		func(c *Config) { c.To = nil },
+		func(c *Config) { c.To = []string{"not an address"} },
+		func(c *Config) { c.Text = "{{.Missing" },
+	} {
+		c := valid
+		mutate(&c)
+		_, err := New(c)
+		assert.Error(t, err, "%+v", c)
+	}
+}
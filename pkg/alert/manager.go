@@ -0,0 +1,309 @@
+package alert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Notifier delivers notifications, ex. by email.
+type Notifier interface {
+	// Name identifies the notifier in logs and errors, ex. "email".
+	Name() string
+	// Notify delivers the notification.
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// Config configures a Manager.
+type Config struct {
+	// Rules raise alerts when readings cross thresholds, in addition to the SUD's status flags.
+	Rules []Rule
+	// Notifiers deliver each notification.
+	Notifiers []Notifier
+	// RepeatInterval is the interval at which notifications are resent while alerts are firing.
+	// 0 only notifies when alerts change.
+	RepeatInterval time.Duration
+	// Timeout bounds the delivery of each notification by each notifier. Defaults to 1 minute.
+	Timeout time.Duration
+	// QueueSize is the number of notifications queued while notifiers are busy. Further
+	// notifications are dropped. Defaults to 100.
+	QueueSize int
+}
+
+// sudState is the alerting state of a SUD.
+type sudState struct {
+	// sud is the SUD and its latest reading.
+	sud lde.SUD
+	// firing are the SUD's raised alerts, by name.
+	firing map[string]Alert
+	// notified is when the SUD's alerts were last notified.
+	notified time.Time
+}
+
+// Manager is an lde.Sink which evaluates alerts for each accepted reading, and notifies when a
+// SUD's alerts are raised or resolved, and periodically while they're firing. Notifications are
+// delivered in the background.
+type Manager struct {
+	config Config
+
+	queue chan *Notification
+	stop  context.CancelFunc
+	done  chan struct{}
+	now   func() time.Time
+
+	lock   sync.Mutex
+	suds   map[string]*sudState
+	closed bool
+	// errs holds the last delivery error of each notifier, by name.
+	errs map[string]error
+}
+
+var (
+	_ lde.RemoteSink = (*Manager)(nil)
+	_ lde.ExpireSink = (*Manager)(nil)
+)
+
+// New creates a Manager and starts its background notifier.
+func New(config Config) (*Manager, error) {
+	names := map[string]struct{}{}
+	for i := range config.Rules {
+		r := &config.Rules[i]
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := names[r.Name]; ok {
+			return nil, fmt.Errorf("alert rule %q is defined more than once", r.Name)
+		}
+		names[r.Name] = struct{}{}
+		if r.Severity == Info {
+			r.Severity = Warning
+		}
+	}
+	if len(config.Notifiers) == 0 {
+		return nil, errors.New("at least one notifier is required")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Minute
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 100
+	}
+	m := &Manager{
+		config: config,
+		queue:  make(chan *Notification, config.QueueSize),
+		done:   make(chan struct{}),
+		now:    time.Now,
+		suds:   make(map[string]*sudState),
+		errs:   make(map[string]error),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.stop = cancel
+	go m.run(ctx)
+	return m, nil
+}
+
+// Send implements lde.Sink, evaluating the reading's alerts and queueing a notification if they
+// changed. It never blocks; if the queue is full the notification is dropped and an error
+// returned.
+func (m *Manager) Send(ctx context.Context, p *lde.Push) error {
+	alerts := Evaluate(p.LDE, m.config.Rules)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		return errors.New("alert manager is closed")
+	}
+	st, ok := m.suds[p.LDE.SUD.ID]
+	if !ok {
+		st = &sudState{firing: map[string]Alert{}}
+		m.suds[p.LDE.SUD.ID] = st
+	}
+	st.sud = p.LDE.SUD
+	n := &Notification{SUD: p.LDE.SUD}
+	changed := false
+	firing := make(map[string]Alert, len(alerts))
+	outOfWater := false
+	for _, a := range alerts {
+		if prev, ok := st.firing[a.Name]; ok {
+			a.StartsAt = prev.StartsAt
+		} else {
+			changed = true
+		}
+		firing[a.Name] = a
+		n.Firing = append(n.Firing, a)
+		outOfWater = outOfWater || a.Name == OutOfWater
+	}
+	if outOfWater {
+		// Rules aren't evaluated while the SUD is out of the water, so their alerts are carried
+		// forward unchanged rather than resolved, until it's back in and they can be tested.
+		for _, r := range m.config.Rules {
+			if a, ok := st.firing[r.Name]; ok {
+				firing[a.Name] = a
+				n.Firing = append(n.Firing, a)
+			}
+		}
+	}
+	for name, a := range st.firing {
+		if _, ok := firing[name]; !ok {
+			changed = true
+			n.Resolved = append(n.Resolved, a)
+		}
+	}
+	sortAlerts(n.Resolved)
+	st.firing = firing
+	if !changed {
+		return nil
+	}
+	st.notified = m.now()
+	return m.enqueueLocked(n)
+}
+
+// Expire implements lde.ExpireSink, forgetting the SUD's alerts without notifying.
+func (m *Manager) Expire(ctx context.Context, l *lde.LDE) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.suds, l.SUD.ID)
+	return nil
+}
+
+// repeat queues notifications for SUDs whose alerts have been firing for RepeatInterval since they
+// were last notified.
+func (m *Manager) repeat() {
+	if m.config.RepeatInterval <= 0 {
+		return
+	}
+	now := m.now()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		return
+	}
+	for _, st := range m.suds {
+		if len(st.firing) == 0 || now.Sub(st.notified) < m.config.RepeatInterval {
+			continue
+		}
+		n := &Notification{SUD: st.sud, Repeat: true}
+		for _, a := range st.firing {
+			n.Firing = append(n.Firing, a)
+		}
+		sortAlerts(n.Firing)
+		st.notified = now
+		if err := m.enqueueLocked(n); err != nil {
+			log.Warn().Err(err).Str("sud_id", st.sud.ID).Msg("repeating alert notification")
+		}
+	}
+}
+
+// enqueueLocked queues the notification. The caller must hold m.lock.
+func (m *Manager) enqueueLocked(n *Notification) error {
+	select {
+	case m.queue <- n:
+		return nil
+	default:
+		return errors.New("alert notification queue is full; dropping notification")
+	}
+}
+
+// sortAlerts orders alerts as Evaluate does: status flags, then rules, each by name.
+func sortAlerts(alerts []Alert) {
+	rank := func(a Alert) int {
+		if a.Name == OutOfWater {
+			return 0
+		}
+		for i, f := range flags {
+			if a.Name == f {
+				return i + 1
+			}
+		}
+		return len(flags) + 1
+	}
+	sort.SliceStable(alerts, func(i, j int) bool {
+		if ri, rj := rank(alerts[i]), rank(alerts[j]); ri != rj {
+			return ri < rj
+		}
+		return alerts[i].Name < alerts[j].Name
+	})
+}
+
+// Name implements lde.RemoteSink.
+func (m *Manager) Name() string {
+	return "alerts"
+}
+
+// Healthy implements lde.HealthySink, reporting the notifiers whose last delivery failed.
+func (m *Manager) Healthy(ctx context.Context) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, n := range m.config.Notifiers {
+		if err := m.errs[n.Name()]; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close implements lde.Sink, delivering any queued notifications. They're abandoned if ctx is
+// done first.
+func (m *Manager) Close(ctx context.Context) error {
+	m.lock.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.queue)
+	}
+	m.lock.Unlock()
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		m.stop()
+		<-m.done
+		return ctx.Err()
+	}
+}
+
+// run delivers queued notifications, and periodically queues repeats, until the queue is closed
+// and drained or ctx is done.
+func (m *Manager) run(ctx context.Context) {
+	defer close(m.done)
+	interval := time.Minute
+	if m.config.RepeatInterval > 0 && m.config.RepeatInterval/4 < interval {
+		interval = m.config.RepeatInterval / 4
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case n, ok := <-m.queue:
+			if !ok {
+				return
+			}
+			m.notify(ctx, n)
+		case <-t.C:
+			m.repeat()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// notify delivers the notification with each notifier.
+func (m *Manager) notify(ctx context.Context, n *Notification) {
+	for _, notifier := range m.config.Notifiers {
+		nctx, cancel := context.WithTimeout(ctx, m.config.Timeout)
+		err := notifier.Notify(nctx, n)
+		cancel()
+		if err != nil {
+			err = fmt.Errorf("notifying with %s: %w", notifier.Name(), err)
+			log.Warn().Err(err).Str("sud_id", n.SUD.ID).Str("title", n.Title()).Msg("delivering alert notification")
+		}
+		m.lock.Lock()
+		m.errs[notifier.Name()] = err
+		m.lock.Unlock()
+	}
+}
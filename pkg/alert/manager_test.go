@@ -0,0 +1,172 @@
+package alert
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNotifier records notifications, failing with err if set.
+type fakeNotifier struct {
+	lock          sync.Mutex
+	notifications []*Notification
+	err           error
+}
+
+func (f *fakeNotifier) Name() string {
+	return "fake"
+}
+
+func (f *fakeNotifier) Notify(ctx context.Context, n *Notification) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.notifications = append(f.notifications, n)
+	return nil
+}
+
+func (f *fakeNotifier) received() []*Notification {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]*Notification(nil), f.notifications...)
+}
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeNotifier{}
+	m, err := New(Config{
+		Rules:     []Rule{{Name: "high_ph", Reading: "ph", Max: float(8.4)}},
+		Notifiers: []Notifier{f},
+	})
+	require.NoError(t, err)
+
+	l := reading(1609561222)
+	require.NoError(t, m.Send(ctx, &lde.Push{LDE: l}))
+	// Alerts raised by the same reading are batched into one notification.
+	l = reading(1609561522)
+	l.SUD.Data.PH = 8.5
+	l.SUD.Data.Status.Slide = 1
+	require.NoError(t, m.Send(ctx, &lde.Push{LDE: l}))
+	// Unchanged alerts aren't notified again.
+	l = reading(1609561822)
+	l.SUD.Data.PH = 8.6
+	l.SUD.Data.Status.Slide = 1
+	require.NoError(t, m.Send(ctx, &lde.Push{LDE: l}))
+	l = reading(1609562122)
+	l.SUD.Data.Status.Slide = 1
+	require.NoError(t, m.Send(ctx, &lde.Push{LDE: l}))
+	require.NoError(t, m.Close(ctx))
+
+	got := f.received()
+	require.Len(t, got, 2)
+	assert.Equal(t, "[WARNING] Reef: Slide expired or not installed, pH above 8.4", got[0].Title())
+	assert.Empty(t, got[0].Resolved)
+	assert.Equal(t, []string{"slide"}, names(got[1].Firing))
+	assert.Equal(t, []string{"high_ph"}, names(got[1].Resolved))
+	assert.Equal(t, time.Unix(1609561522, 0), got[1].Firing[0].StartsAt, "alerts keep the time they were first raised")
+	assert.Error(t, m.Send(ctx, &lde.Push{LDE: l}), "closed managers reject readings")
+}
+
+func TestManagerOutOfWater(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeNotifier{}
+	m, err := New(Config{
+		Rules:     []Rule{{Name: "high_ph", Reading: "ph", Max: float(8.4)}},
+		Notifiers: []Notifier{f},
+	})
+	require.NoError(t, err)
+
+	l := reading(1609561222)
+	l.SUD.Data.PH = 8.5
+	require.NoError(t, m.Send(ctx, &lde.Push{LDE: l}))
+	// Rules aren't evaluated out of the water, but their alerts aren't resolved.
+	l = reading(1609561522)
+	l.SUD.Data.Status.Water = 0
+	require.NoError(t, m.Send(ctx, &lde.Push{LDE: l}))
+	// Back in the water, the alert is still firing from when it was first raised.
+	l = reading(1609561822)
+	l.SUD.Data.PH = 8.6
+	require.NoError(t, m.Send(ctx, &lde.Push{LDE: l}))
+	// And resolves once the reading is back within limits.
+	l = reading(1609562122)
+	require.NoError(t, m.Send(ctx, &lde.Push{LDE: l}))
+	require.NoError(t, m.Close(ctx))
+
+	got := f.received()
+	require.Len(t, got, 4)
+	assert.Equal(t, []string{"high_ph"}, names(got[0].Firing))
+	assert.Equal(t, []string{OutOfWater, "high_ph"}, names(got[1].Firing))
+	assert.Empty(t, got[1].Resolved)
+	assert.Equal(t, []string{"high_ph"}, names(got[2].Firing))
+	assert.Equal(t, []string{OutOfWater}, names(got[2].Resolved))
+	assert.Equal(t, time.Unix(1609561222, 0), got[2].Firing[0].StartsAt)
+	assert.Empty(t, got[3].Firing)
+	assert.Equal(t, []string{"high_ph"}, names(got[3].Resolved))
+}
+
+func names(alerts []Alert) []string {
+	var out []string
+	for _, a := range alerts {
+		out = append(out, a.Name)
+	}
+	return out
+}
+
+func TestManagerRepeat(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeNotifier{}
+	m, err := New(Config{Notifiers: []Notifier{f}, RepeatInterval: time.Hour})
+	require.NoError(t, err)
+	now := time.Unix(1609561222, 0)
+	m.now = func() time.Time { return now }
+
+	l := reading(1609561222)
+	l.SUD.Data.Status.Water = 0
+	require.NoError(t, m.Send(ctx, &lde.Push{LDE: l}))
+	m.repeat()
+	now = now.Add(time.Hour)
+	m.repeat()
+	// Expired SUDs are forgotten without notifying.
+	require.NoError(t, m.Expire(ctx, l))
+	now = now.Add(time.Hour)
+	m.repeat()
+	require.NoError(t, m.Close(ctx))
+
+	got := f.received()
+	require.Len(t, got, 2)
+	assert.False(t, got[0].Repeat)
+	assert.True(t, got[1].Repeat)
+	assert.Equal(t, []string{OutOfWater}, names(got[1].Firing))
+}
+
+func TestManagerHealthy(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeNotifier{err: errors.New("unavailable")}
+	m, err := New(Config{Notifiers: []Notifier{f}})
+	require.NoError(t, err)
+	l := reading(1609561222)
+	l.SUD.Data.Status.Water = 0
+	require.NoError(t, m.Send(ctx, &lde.Push{LDE: l}))
+	require.Eventually(t, func() bool { return m.Healthy(ctx) != nil }, 5*time.Second, 10*time.Millisecond)
+	assert.EqualError(t, m.Healthy(ctx), "notifying with fake: unavailable")
+	require.NoError(t, m.Close(ctx))
+}
+
+func TestNewValidates(t *testing.T) {
+	_, err := New(Config{Notifiers: []Notifier{&fakeNotifier{}}, Rules: []Rule{
+		{Name: "high_ph", Reading: "ph", Max: float(8.4)},
+		{Name: "high_ph", Reading: "ph", Max: float(8.5)},
+	}})
+	assert.EqualError(t, err, `alert rule "high_ph" is defined more than once`)
+	_, err = New(Config{})
+	assert.EqualError(t, err, "at least one notifier is required")
+}
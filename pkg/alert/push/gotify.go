@@ -0,0 +1,103 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/alert"
+)
+
+// GotifyConfig configures a Gotify notifier.
+type GotifyConfig struct {
+	Options
+	// URL is the Gotify server, ex. https://gotify.example.com.
+	URL string
+	// Token is the application's token.
+	Token string
+}
+
+// Gotify is an alert.Notifier sending notifications as messages from a Gotify application.
+// Critical notifications have the highest priority, which the Android app shows as a popup.
+type Gotify struct {
+	*client
+	config GotifyConfig
+	// endpoint is the server's message endpoint.
+	endpoint string
+}
+
+var _ alert.Notifier = (*Gotify)(nil)
+
+// NewGotify creates a Gotify notifier.
+func NewGotify(config GotifyConfig) (*Gotify, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid gotify url %q", config.URL)
+	}
+	if config.Token == "" {
+		return nil, errors.New("gotify token is required")
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/message"
+	g := &Gotify{config: config, endpoint: u.String()}
+	g.client, err = newClient(config.Options, priorities{
+		quiet: 0,
+		bySeverity: map[alert.Severity]int{
+			alert.Info:     2,
+			alert.Warning:  5,
+			alert.Critical: 10,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Name implements alert.Notifier.
+func (g *Gotify) Name() string {
+	return "gotify"
+}
+
+// Notify implements alert.Notifier, creating a message with the notification.
+func (g *Gotify) Notify(ctx context.Context, notification *alert.Notification) error {
+	click, err := g.click(notification)
+	if err != nil {
+		return err
+	}
+	msg := struct {
+		Title    string                 `json:"title"`
+		Message  string                 `json:"message"`
+		Priority int                    `json:"priority"`
+		Extras   map[string]interface{} `json:"extras,omitempty"`
+	}{
+		Title:    notification.Title(),
+		Message:  message(notification),
+		Priority: g.priority(notification),
+	}
+	if click != "" {
+		msg.Extras = map[string]interface{}{
+			"client::notification": map[string]interface{}{
+				"click": map[string]string{"url": click},
+			},
+		}
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, g.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", g.config.Token)
+	if err := g.do(ctx, req); err != nil {
+		return fmt.Errorf("sending gotify message: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/alert"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGotify(t *testing.T) {
+	s := startService(t, http.StatusOK)
+	quiet, err := alert.ParseQuietHours("00:00-23:59", time.UTC)
+	require.NoError(t, err)
+	g, err := NewGotify(GotifyConfig{
+		Options: Options{ClickURL: "https://exporter.example.com/dashboard/", QuietHours: quiet},
+		URL:     s.URL + "/gotify/",
+		Token:   "AppToken",
+	})
+	require.NoError(t, err)
+	n := outOfWater()
+	require.NoError(t, g.Notify(context.Background(), n))
+	// Warnings are silent during quiet hours.
+	n.Firing[0].Severity = alert.Warning
+	require.NoError(t, g.Notify(context.Background(), n))
+
+	got := s.received()
+	require.Len(t, got, 2)
+	assert.Equal(t, "/gotify/message", got[0].path)
+	assert.Equal(t, "AppToken", got[0].header.Get("X-Gotify-Key"))
+	var msg map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(got[0].body), &msg))
+	assert.Equal(t, map[string]interface{}{
+		"title":    "[CRITICAL] Reef: SUD out of the water",
+		"message":  message(outOfWater()),
+		"priority": 10.0,
+		"extras": map[string]interface{}{
+			"client::notification": map[string]interface{}{
+				"click": map[string]interface{}{"url": "https://exporter.example.com/dashboard/"},
+			},
+		},
+	}, msg)
+	require.NoError(t, json.Unmarshal([]byte(got[1].body), &msg))
+	assert.Equal(t, 0.0, msg["priority"])
+}
+
+func TestNewGotifyValidates(t *testing.T) {
+	_, err := NewGotify(GotifyConfig{URL: "https://gotify.example.com"})
+	assert.EqualError(t, err, "gotify token is required")
+	_, err = NewGotify(GotifyConfig{URL: "gotify.example.com", Token: "AppToken"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,119 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/alert"
+)
+
+// NtfyConfig configures a Ntfy notifier.
+type NtfyConfig struct {
+	Options
+	// URL is the topic's URL, ex. https://ntfy.sh/my-aquarium.
+	URL string
+	// Token is an access token, if the topic requires one.
+	Token string
+	// Username and Password are used for basic auth, if Username is set and Token isn't.
+	Username string
+	Password string
+}
+
+// ntfyTags are the emoji shown with notifications of each severity.
+var ntfyTags = map[alert.Severity]string{
+	alert.Info:     "white_check_mark",
+	alert.Warning:  "warning",
+	alert.Critical: "rotating_light",
+}
+
+// Ntfy is an alert.Notifier publishing notifications to a ntfy topic. Critical notifications are
+// urgent, so they break through do not disturb on phones.
+type Ntfy struct {
+	*client
+	config NtfyConfig
+	// server is the ntfy server's URL, to which messages are published as JSON.
+	server string
+	topic  string
+}
+
+var _ alert.Notifier = (*Ntfy)(nil)
+
+// NewNtfy creates a Ntfy notifier.
+func NewNtfy(config NtfyConfig) (*Ntfy, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid ntfy url %q", config.URL)
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndexByte(path, '/')
+	if i < 0 || path[i+1:] == "" {
+		return nil, errors.New("ntfy url must include the topic, ex. https://ntfy.sh/my-aquarium")
+	}
+	n := &Ntfy{config: config, topic: path[i+1:]}
+	u.Path, u.RawQuery, u.Fragment = path[:i]+"/", "", ""
+	n.server = u.String()
+	n.client, err = newClient(config.Options, priorities{
+		quiet: 1,
+		bySeverity: map[alert.Severity]int{
+			alert.Info:     2,
+			alert.Warning:  4,
+			alert.Critical: 5,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Name implements alert.Notifier.
+func (n *Ntfy) Name() string {
+	return "ntfy"
+}
+
+// Notify implements alert.Notifier, publishing the notification to the topic.
+func (n *Ntfy) Notify(ctx context.Context, notification *alert.Notification) error {
+	click, err := n.click(notification)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(struct {
+		Topic    string   `json:"topic"`
+		Title    string   `json:"title"`
+		Message  string   `json:"message"`
+		Priority int      `json:"priority"`
+		Tags     []string `json:"tags"`
+		Click    string   `json:"click,omitempty"`
+	}{
+		Topic:    n.topic,
+		Title:    notification.Title(),
+		Message:  message(notification),
+		Priority: n.priority(notification),
+		Tags:     []string{ntfyTags[notification.Severity()]},
+		Click:    click,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, n.server, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	switch {
+	case n.config.Token != "":
+		req.Header.Set("Authorization", "Bearer "+n.config.Token)
+	case n.config.Username != "":
+		req.SetBasicAuth(n.config.Username, n.config.Password)
+	}
+	if err := n.do(ctx, req); err != nil {
+		return fmt.Errorf("publishing to ntfy: %w", err)
+	}
+	return nil
+}
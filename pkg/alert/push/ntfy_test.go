@@ -0,0 +1,58 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNtfy(t *testing.T) {
+	s := startService(t, http.StatusOK)
+	n, err := NewNtfy(NtfyConfig{
+		Options: Options{ClickURL: "https://exporter.example.com/dashboard/"},
+		URL:     s.URL + "/aquarium",
+		Token:   "tk_secret",
+	})
+	require.NoError(t, err)
+	require.NoError(t, n.Notify(context.Background(), outOfWater()))
+
+	got := s.received()
+	require.Len(t, got, 1)
+	assert.Equal(t, "POST /", got[0].method+" "+got[0].path)
+	assert.Equal(t, "Bearer tk_secret", got[0].header.Get("Authorization"))
+	var msg map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(got[0].body), &msg))
+	assert.Equal(t, map[string]interface{}{
+		"topic":    "aquarium",
+		"title":    "[CRITICAL] Reef: SUD out of the water",
+		"message":  message(outOfWater()),
+		"priority": 5.0,
+		"tags":     []interface{}{"rotating_light"},
+		"click":    "https://exporter.example.com/dashboard/",
+	}, msg)
+}
+
+func TestNtfyError(t *testing.T) {
+	s := startService(t, http.StatusForbidden)
+	n, err := NewNtfy(NtfyConfig{URL: s.URL + "/ntfy/aquarium/", Username: "user", Password: "pass"})
+	require.NoError(t, err)
+	assert.EqualError(t, n.Notify(context.Background(), outOfWater()),
+		`publishing to ntfy: unexpected status: 403 Forbidden: {"error":"invalid token"}`)
+	got := s.received()
+	require.Len(t, got, 1)
+	// Servers behind a path prefix are supported.
+	assert.Equal(t, "/ntfy/", got[0].path)
+	assert.Contains(t, got[0].body, `"topic":"aquarium"`)
+	assert.Equal(t, "Basic dXNlcjpwYXNz", got[0].header.Get("Authorization"))
+}
+
+func TestNewNtfyValidates(t *testing.T) {
+	for _, u := range []string{"", "ntfy.sh/aquarium", "https://ntfy.sh", "https://ntfy.sh/"} {
+		_, err := NewNtfy(NtfyConfig{URL: u})
+		assert.Error(t, err, u)
+	}
+}
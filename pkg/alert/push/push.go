@@ -0,0 +1,119 @@
+// Package push delivers alert notifications to phones through push notification services: ntfy,
+// Gotify, and Pushover or compatible APIs.
+package push
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/alert"
+)
+
+// Options configures what's common to every push notifier.
+type Options struct {
+	// ClickURL is a text/template producing the URL opened when the notification is tapped, ex.
+	// the dashboard. It's executed with the *alert.Notification. Disabled if unset.
+	ClickURL string
+	// QuietHours, if set, is when notifications which aren't critical are delivered at the
+	// service's lowest priority, so they don't disturb anyone.
+	QuietHours *alert.QuietHours
+	// Timeout bounds each request. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// priorities maps notifications to a service's priorities.
+type priorities struct {
+	// quiet is the priority of notifications which aren't critical during quiet hours.
+	quiet int
+	// bySeverity is the priority of notifications of each severity. Resolved notifications are
+	// Info.
+	bySeverity map[alert.Severity]int
+}
+
+// client holds the state common to every push notifier.
+type client struct {
+	options    Options
+	priorities priorities
+	clickURL   *template.Template
+	http       *http.Client
+	now        func() time.Time
+}
+
+func newClient(options Options, p priorities) (*client, error) {
+	if options.Timeout <= 0 {
+		options.Timeout = 10 * time.Second
+	}
+	c := &client{
+		options:    options,
+		priorities: p,
+		http:       &http.Client{Timeout: options.Timeout},
+		now:        time.Now,
+	}
+	if options.ClickURL != "" {
+		var err error
+		if c.clickURL, err = template.New("click").Parse(options.ClickURL); err != nil {
+			return nil, fmt.Errorf("parsing click url template: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// priority returns the service's priority for the notification.
+func (c *client) priority(n *alert.Notification) int {
+	s := n.Severity()
+	if s < alert.Critical && c.options.QuietHours.Contains(c.now()) {
+		return c.priorities.quiet
+	}
+	return c.priorities.bySeverity[s]
+}
+
+// click returns the notification's click-through URL, or "" if none is configured.
+func (c *client) click(n *alert.Notification) (string, error) {
+	if c.clickURL == nil {
+		return "", nil
+	}
+	var b strings.Builder
+	if err := c.clickURL.Execute(&b, n); err != nil {
+		return "", fmt.Errorf("executing click url template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// message describes the notification's alerts and the SUD's latest readings, in plain text.
+func message(n *alert.Notification) string {
+	var b strings.Builder
+	for _, a := range n.Firing {
+		fmt.Fprintf(&b, "%s: %s\n", strings.ToUpper(a.Severity.String()), a.Summary)
+	}
+	for _, a := range n.Resolved {
+		fmt.Fprintf(&b, "Resolved: %s\n", a.Summary)
+	}
+	b.WriteString("\n")
+	for _, r := range n.Readings() {
+		b.WriteString(alert.FormatReading(r) + "\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// do sends the request, returning an error if it doesn't succeed.
+func (c *client) do(ctx context.Context, req *http.Request) error {
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	// The services explain the failure in the body, ex. an invalid token.
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("unexpected status: %s: %s", resp.Status, bytes.TrimSpace(body))
+}
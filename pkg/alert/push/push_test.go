@@ -0,0 +1,113 @@
+package push
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/alert"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// request is a request received by the service.
+type request struct {
+	method, path string
+	header       http.Header
+	body         string
+}
+
+// service is a fake push notification service, recording requests and responding with status.
+type service struct {
+	*httptest.Server
+	status int
+
+	lock     sync.Mutex
+	requests []request
+}
+
+func startService(t *testing.T, status int) *service {
+	t.Helper()
+	s := &service{status: status}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.lock.Lock()
+		s.requests = append(s.requests, request{r.Method, r.URL.Path, r.Header, string(body)})
+		s.lock.Unlock()
+		w.WriteHeader(s.status)
+		w.Write([]byte(`{"error":"invalid token"}`))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *service) received() []request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]request(nil), s.requests...)
+}
+
+func outOfWater() *alert.Notification {
+	return &alert.Notification{
+		SUD: lde.SUD{
+			ID:        "1234",
+			Name:      "Reef",
+			Type:      lde.HomeSUD,
+			Timestamp: 1609561222,
+			Data:      lde.Data{Temperature: 23, PH: 8.1, NH3: 0.004},
+		},
+		Firing: []alert.Alert{{Name: alert.OutOfWater, Severity: alert.Critical, Summary: "SUD out of the water"}},
+	}
+}
+
+func TestPriority(t *testing.T) {
+	quiet, err := alert.ParseQuietHours("22:00-07:00", time.UTC)
+	require.NoError(t, err)
+	c, err := newClient(Options{QuietHours: quiet}, priorities{
+		quiet:      1,
+		bySeverity: map[alert.Severity]int{alert.Info: 2, alert.Warning: 4, alert.Critical: 5},
+	})
+	require.NoError(t, err)
+	critical := outOfWater()
+	warning := &alert.Notification{Firing: []alert.Alert{{Name: "slide", Severity: alert.Warning}}}
+	resolved := &alert.Notification{Resolved: critical.Firing}
+
+	c.now = func() time.Time { return time.Date(2021, 1, 2, 12, 0, 0, 0, time.UTC) }
+	assert.Equal(t, 5, c.priority(critical))
+	assert.Equal(t, 4, c.priority(warning))
+	assert.Equal(t, 2, c.priority(resolved))
+	// Only critical notifications disturb during quiet hours.
+	c.now = func() time.Time { return time.Date(2021, 1, 2, 23, 0, 0, 0, time.UTC) }
+	assert.Equal(t, 5, c.priority(critical))
+	assert.Equal(t, 1, c.priority(warning))
+	assert.Equal(t, 1, c.priority(resolved))
+}
+
+func TestMessage(t *testing.T) {
+	n := outOfWater()
+	n.Resolved = []alert.Alert{{Name: "cold", Summary: "Temperature below 24°C"}}
+	assert.Equal(t, `CRITICAL: SUD out of the water
+Resolved: Temperature below 24°C
+
+Temperature: 23°C
+pH: 8.1
+Free ammonia (NH3): 0.004 ppm
+Light intensity: 0 lx
+Total ammonia: 0.069 ppm`, message(n))
+}
+
+func TestClickURL(t *testing.T) {
+	c, err := newClient(Options{ClickURL: "https://exporter.example.com/dashboard/#{{.SUD.ID}}"}, priorities{})
+	require.NoError(t, err)
+	url, err := c.click(outOfWater())
+	require.NoError(t, err)
+	assert.Equal(t, "https://exporter.example.com/dashboard/#1234", url)
+
+	_, err = newClient(Options{ClickURL: "{{.SUD.ID"}, priorities{})
+	assert.Error(t, err)
+}
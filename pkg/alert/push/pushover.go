@@ -0,0 +1,148 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/alert"
+)
+
+// DefaultPushoverURL is Pushover's message API.
+const DefaultPushoverURL = "https://api.pushover.net/1/messages.json"
+
+// Pushover limits the length of titles and messages, in characters.
+const (
+	pushoverMaxTitle   = 250
+	pushoverMaxMessage = 1024
+)
+
+// Pushover rejects emergency priority messages which repeat more often than pushoverMinRetry, or
+// for longer than pushoverMaxExpire.
+const (
+	pushoverMinRetry  = 30 * time.Second
+	pushoverMaxExpire = 3 * time.Hour
+)
+
+// PushoverConfig configures a Pushover notifier.
+type PushoverConfig struct {
+	Options
+	// URL is the message API, for Pushover compatible services. Defaults to DefaultPushoverURL.
+	URL string
+	// Token is the application's API token.
+	Token string
+	// User is the user or group key to notify.
+	User string
+	// Device limits the notifications to the user's named devices, if set.
+	Device string
+	// Retry is the interval at which critical notifications are repeated until they're
+	// acknowledged. Defaults to 1 minute; Pushover requires at least 30 seconds.
+	Retry time.Duration
+	// Expire is how long critical notifications are repeated for if they aren't acknowledged.
+	// Defaults to 1 hour; Pushover allows at most 3 hours.
+	Expire time.Duration
+}
+
+// Pushover is an alert.Notifier sending notifications with the Pushover API. Critical
+// notifications have emergency priority, so they're repeated every Retry until acknowledged.
+type Pushover struct {
+	*client
+	config PushoverConfig
+}
+
+var _ alert.Notifier = (*Pushover)(nil)
+
+// NewPushover creates a Pushover notifier.
+func NewPushover(config PushoverConfig) (*Pushover, error) {
+	if config.URL == "" {
+		config.URL = DefaultPushoverURL
+	}
+	if u, err := url.Parse(config.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid pushover url %q", config.URL)
+	}
+	if config.Token == "" || config.User == "" {
+		return nil, errors.New("pushover token and user are required")
+	}
+	if config.Retry <= 0 {
+		config.Retry = time.Minute
+	}
+	if config.Expire <= 0 {
+		config.Expire = time.Hour
+	}
+	// Critical notifications would be rejected at runtime, so fail at startup instead.
+	if config.Retry < pushoverMinRetry {
+		return nil, fmt.Errorf("pushover retry %s is less than the minimum of %s", config.Retry, pushoverMinRetry)
+	}
+	if config.Expire > pushoverMaxExpire {
+		return nil, fmt.Errorf("pushover expire %s exceeds the maximum of %s", config.Expire, pushoverMaxExpire)
+	}
+	p := &Pushover{config: config}
+	var err error
+	p.client, err = newClient(config.Options, priorities{
+		quiet: -1,
+		bySeverity: map[alert.Severity]int{
+			alert.Info:     -1,
+			alert.Warning:  0,
+			alert.Critical: 2,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Name implements alert.Notifier.
+func (p *Pushover) Name() string {
+	return "pushover"
+}
+
+// Notify implements alert.Notifier, sending the notification to the user.
+func (p *Pushover) Notify(ctx context.Context, notification *alert.Notification) error {
+	click, err := p.click(notification)
+	if err != nil {
+		return err
+	}
+	priority := p.priority(notification)
+	form := url.Values{
+		"token":     {p.config.Token},
+		"user":      {p.config.User},
+		"title":     {truncate(notification.Title(), pushoverMaxTitle)},
+		"message":   {truncate(message(notification), pushoverMaxMessage)},
+		"priority":  {strconv.Itoa(priority)},
+		"timestamp": {strconv.FormatInt(notification.SUD.Timestamp, 10)},
+	}
+	if priority == 2 {
+		form.Set("retry", strconv.Itoa(int(p.config.Retry.Seconds())))
+		form.Set("expire", strconv.Itoa(int(p.config.Expire.Seconds())))
+	}
+	if p.config.Device != "" {
+		form.Set("device", p.config.Device)
+	}
+	if click != "" {
+		form.Set("url", click)
+	}
+	req, err := http.NewRequest(http.MethodPost, p.config.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := p.do(ctx, req); err != nil {
+		return fmt.Errorf("sending pushover message: %w", err)
+	}
+	return nil
+}
+
+// truncate shortens s to at most max characters, marking it with an ellipsis.
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-1]) + "…"
+}
@@ -0,0 +1,96 @@
+package push
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/alert"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushover(t *testing.T) {
+	s := startService(t, http.StatusOK)
+	p, err := NewPushover(PushoverConfig{
+		Options: Options{ClickURL: "https://exporter.example.com/dashboard/"},
+		URL:     s.URL + "/1/messages.json",
+		Token:   "apptoken",
+		User:    "userkey",
+		Device:  "phone",
+	})
+	require.NoError(t, err)
+	n := outOfWater()
+	require.NoError(t, p.Notify(context.Background(), n))
+	n.Resolved, n.Firing = n.Firing, nil
+	require.NoError(t, p.Notify(context.Background(), n))
+
+	got := s.received()
+	require.Len(t, got, 2)
+	assert.Equal(t, "/1/messages.json", got[0].path)
+	form, err := url.ParseQuery(got[0].body)
+	require.NoError(t, err)
+	// Critical notifications are emergencies, repeated until acknowledged.
+	assert.Equal(t, url.Values{
+		"token":     {"apptoken"},
+		"user":      {"userkey"},
+		"device":    {"phone"},
+		"title":     {"[CRITICAL] Reef: SUD out of the water"},
+		"message":   {message(outOfWater())},
+		"priority":  {"2"},
+		"retry":     {"60"},
+		"expire":    {"3600"},
+		"timestamp": {"1609561222"},
+		"url":       {"https://exporter.example.com/dashboard/"},
+	}, form)
+	form, err = url.ParseQuery(got[1].body)
+	require.NoError(t, err)
+	assert.Equal(t, "-1", form.Get("priority"))
+	assert.Equal(t, "[RESOLVED] Reef: SUD out of the water", form.Get("title"))
+	assert.Empty(t, form.Get("retry"))
+}
+
+func TestPushoverTruncates(t *testing.T) {
+	s := startService(t, http.StatusOK)
+	p, err := NewPushover(PushoverConfig{URL: s.URL, Token: "apptoken", User: "userkey"})
+	require.NoError(t, err)
+	n := outOfWater()
+	for i := 0; i < 100; i++ {
+		n.Firing = append(n.Firing, alert.Alert{Severity: alert.Warning, Summary: "Slide expired or not installed"})
+	}
+	require.NoError(t, p.Notify(context.Background(), n))
+	form, err := url.ParseQuery(s.received()[0].body)
+	require.NoError(t, err)
+	assert.Len(t, []rune(form.Get("title")), pushoverMaxTitle)
+	assert.Len(t, []rune(form.Get("message")), pushoverMaxMessage)
+	assert.True(t, strings.HasSuffix(form.Get("message"), "…"))
+}
+
+func TestPushoverError(t *testing.T) {
+	s := startService(t, http.StatusBadRequest)
+	p, err := NewPushover(PushoverConfig{URL: s.URL, Token: "apptoken", User: "userkey"})
+	require.NoError(t, err)
+	assert.EqualError(t, p.Notify(context.Background(), outOfWater()),
+		`sending pushover message: unexpected status: 400 Bad Request: {"error":"invalid token"}`)
+
+	_, err = NewPushover(PushoverConfig{Token: "apptoken"})
+	assert.EqualError(t, err, "pushover token and user are required")
+}
+
+func TestPushoverLimits(t *testing.T) {
+	config := PushoverConfig{Token: "apptoken", User: "userkey", Retry: 10 * time.Second}
+	_, err := NewPushover(config)
+	assert.EqualError(t, err, "pushover retry 10s is less than the minimum of 30s")
+
+	config.Retry, config.Expire = 30*time.Second, 4*time.Hour
+	_, err = NewPushover(config)
+	assert.EqualError(t, err, "pushover expire 4h0m0s exceeds the maximum of 3h0m0s")
+
+	config.Expire = 3 * time.Hour
+	_, err = NewPushover(config)
+	assert.NoError(t, err)
+}
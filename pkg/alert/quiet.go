@@ -0,0 +1,54 @@
+package alert
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// QuietHours is a daily period, ex. overnight, during which only critical notifications should
+// disturb anyone. Notifiers deliver other notifications silently.
+type QuietHours struct {
+	// Start and End are the period's times of day, as offsets from midnight. The period wraps past
+	// midnight if End is before Start.
+	Start, End time.Duration
+	// Location is the time zone of Start and End. Defaults to the local time zone.
+	Location *time.Location
+}
+
+// ParseQuietHours parses a period of the form "22:00-07:00" in the location.
+func ParseQuietHours(s string, loc *time.Location) (*QuietHours, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid quiet hours %q: expected START-END, ex. 22:00-07:00", s)
+	}
+	q := &QuietHours{Location: loc}
+	for i, bound := range []*time.Duration{&q.Start, &q.End} {
+		t, err := time.Parse("15:04", strings.TrimSpace(parts[i]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid quiet hours %q: %w", s, err)
+		}
+		*bound = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	if q.Start == q.End {
+		return nil, fmt.Errorf("invalid quiet hours %q: start and end are equal", s)
+	}
+	return q, nil
+}
+
+// Contains reports whether t is within the quiet hours.
+func (q *QuietHours) Contains(t time.Time) bool {
+	if q == nil {
+		return false
+	}
+	loc := q.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	t = t.In(loc)
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if q.Start < q.End {
+		return offset >= q.Start && offset < q.End
+	}
+	return offset >= q.Start || offset < q.End
+}
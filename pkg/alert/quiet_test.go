@@ -0,0 +1,42 @@
+package alert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuietHours(t *testing.T) {
+	loc := time.FixedZone("EST", -5*60*60)
+	overnight, err := ParseQuietHours("22:00-07:30", loc)
+	require.NoError(t, err)
+	daytime, err := ParseQuietHours("09:00-17:00", loc)
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		time               string
+		overnight, daytime bool
+	}{
+		{"21:59", false, false},
+		{"22:00", true, false},
+		{"03:00", true, false},
+		{"07:29", true, false},
+		{"07:30", false, false},
+		{"09:00", false, true},
+		{"16:59", false, true},
+		{"17:00", false, false},
+	} {
+		tod, err := time.ParseInLocation("15:04", tc.time, loc)
+		require.NoError(t, err)
+		now := time.Date(2021, 1, 2, tod.Hour(), tod.Minute(), 0, 0, loc).UTC()
+		assert.Equal(t, tc.overnight, overnight.Contains(now), "overnight at %s", tc.time)
+		assert.Equal(t, tc.daytime, daytime.Contains(now), "daytime at %s", tc.time)
+	}
+	assert.False(t, (*QuietHours)(nil).Contains(time.Now()))
+
+	for _, s := range []string{"", "22:00", "22:00-25:00", "7pm-7am", "08:00-08:00"} {
+		_, err := ParseQuietHours(s, loc)
+		assert.Error(t, err, s)
+	}
+}
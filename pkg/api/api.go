@@ -0,0 +1,175 @@
+// Package api serves the latest readings, their history, and a live stream of new readings as
+// JSON, for the built-in dashboard and other clients.
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/history"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// defaultHistoryWindow is the period of history returned when a request doesn't specify since.
+const defaultHistoryWindow = 24 * time.Hour
+
+// Reading is the JSON representation of an LDE reading.
+type Reading struct {
+	SUDID   string    `json:"sud_id"`
+	SUDName string    `json:"sud_name"`
+	SUDType string    `json:"sud_type"`
+	Time    time.Time `json:"time"`
+	// Values holds the readings of the sensors the SUD has, keyed by name, ex. "temperature".
+	Values map[string]float64 `json:"values"`
+	// Status holds the SUD's status flags, keyed by name, ex. "water".
+	Status map[string]int `json:"status"`
+	// Alerts names the status flags which are raised, ex. "out_of_water" or "ph".
+	Alerts []string `json:"alerts"`
+}
+
+// NewReading converts the LDE to its JSON representation. Readings of sensors the SUD lacks are
+// omitted.
+func NewReading(l *lde.LDE) *Reading {
+	d := l.SUD.Data
+	caps := l.SUD.Type.Capabilities()
+	r := &Reading{
+		SUDID:   l.SUD.ID,
+		SUDName: l.SUD.Name,
+		SUDType: l.SUD.Type.String(),
+		Time:    time.Unix(l.SUD.Timestamp, 0).UTC(),
+		Values: map[string]float64{
+			"temperature": d.Temperature,
+			"ph":          d.PH,
+			"nh3":         d.NH3,
+		},
+		Status: map[string]int{
+			"water":       d.Status.Water,
+			"temperature": d.Status.Temperature,
+			"ph":          d.Status.PH,
+			"nh3":         d.Status.NH3,
+			"slide":       d.Status.Slide,
+		},
+		Alerts: []string{},
+	}
+	if caps.FreshWater {
+		if nh4, ok := d.TotalAmmonia(); ok {
+			r.Values["total_ammonia"] = nh4
+		}
+	}
+	if caps.Lux {
+		r.Values["lux"] = d.Lux
+	}
+	if caps.Kelvin {
+		r.Values["kelvin"] = d.Kelvin
+		r.Status["kelvin"] = d.Status.Kelvin
+	}
+	if caps.PAR {
+		r.Values["par"] = d.PAR
+	}
+	if d.Status.Water == 0 {
+		r.Alerts = append(r.Alerts, "out_of_water")
+	}
+	for _, s := range []string{"temperature", "ph", "nh3", "slide", "kelvin"} {
+		if r.Status[s] != 0 {
+			r.Alerts = append(r.Alerts, s)
+		}
+	}
+	return r
+}
+
+// API serves the JSON API. Its handlers perform no authentication of their own.
+type API struct {
+	server  *lde.Server
+	history history.Source
+	events  *Events
+	now     func() time.Time
+}
+
+// Option describes a func which implements the functional option pattern for the API.
+type Option func(*API)
+
+// WithHistory serves past readings from the source. Without it, the history endpoint responds
+// 404 Not Found.
+func WithHistory(src history.Source) Option {
+	return func(a *API) {
+		a.history = src
+	}
+}
+
+// WithEvents streams the readings received by the Events sink. Without it, the events endpoint
+// responds 404 Not Found.
+func WithEvents(e *Events) Option {
+	return func(a *API) {
+		a.events = e
+	}
+}
+
+// New creates an API serving the latest readings recorded by the LDE server.
+func New(server *lde.Server, options ...Option) *API {
+	a := &API{server: server, now: time.Now}
+	for _, o := range options {
+		o(a)
+	}
+	return a
+}
+
+// Register adds the API's endpoints to the mux, under /api/v1/. wrap is applied to each handler,
+// ex. to authenticate requests.
+func (a *API) Register(mux *http.ServeMux, wrap func(http.Handler) http.Handler) {
+	mux.Handle("/api/v1/suds", wrap(http.HandlerFunc(a.serveSUDs)))
+	mux.Handle("/api/v1/history", wrap(http.HandlerFunc(a.serveHistory)))
+	mux.Handle("/api/v1/events", wrap(http.HandlerFunc(a.serveEvents)))
+}
+
+// serveSUDs responds with the latest reading from each SUD, ordered by SUD ID.
+func (a *API) serveSUDs(w http.ResponseWriter, r *http.Request) {
+	latest := a.server.Latest()
+	out := make([]*Reading, 0, len(latest))
+	for _, l := range latest {
+		out = append(out, NewReading(l))
+	}
+	writeJSON(w, r, out)
+}
+
+// serveHistory responds with the readings selected by the sud_id, since and until parameters,
+// oldest first. since defaults to 24 hours ago.
+func (a *API) serveHistory(w http.ResponseWriter, r *http.Request) {
+	if a.history == nil {
+		http.Error(w, "history is not configured", http.StatusNotFound)
+		return
+	}
+	q, _, err := history.ParseQuery(r, time.UTC)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.Since.IsZero() {
+		q.Since = a.now().Add(-defaultHistoryWindow)
+	}
+	out := []*Reading{}
+	err = a.history.Readings(r.Context(), q, func(l *lde.LDE) error {
+		out = append(out, NewReading(l))
+		return nil
+	})
+	if err != nil {
+		hlog.FromRequest(r).Error().Err(err).Msg("reading history")
+		http.Error(w, "reading history", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, r, out)
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		hlog.FromRequest(r).Error().Err(err).Msg("encoding response")
+		http.Error(w, "encoding response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(b)
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/history"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sliceSource is a history.Source of a fixed set of readings.
+type sliceSource []*lde.LDE
+
+func (s sliceSource) Readings(ctx context.Context, q history.Query, fn func(*lde.LDE) error) error {
+	for _, l := range s {
+		if q.Match(l) {
+			if err := fn(l); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	reefReading = &lde.LDE{Version: "1.0.0", SUD: lde.SUD{ID: "abc", Name: "Reef", Type: lde.ReefSUD, Timestamp: 1610539200, Data: lde.Data{
+		Status:      lde.SUDStatus{Water: 1, PH: 1},
+		Temperature: 25.5, PH: 8.5, NH3: 0.002, Kelvin: 14000, Lux: 9000, PAR: 166,
+	}}}
+	pondReading = &lde.LDE{Version: "1.0.0", SUD: lde.SUD{ID: "xyz", Name: "Pond", Type: lde.PondSUD, Timestamp: 1610539260, Data: lde.Data{
+		Temperature: 12.125,
+	}}}
+)
+
+// newTestServer returns an LDE server which has received the LDEs.
+func newTestServer(t *testing.T, ldes ...*lde.LDE) *lde.Server {
+	secret := []byte("AAAAAAAA")
+	s, err := lde.NewServer(lde.WithSecrets(map[string][]byte{"": secret}))
+	require.NoError(t, err)
+	for _, l := range ldes {
+		body, err := lde.ToRequestBody(l, secret)
+		require.NoError(t, err)
+		require.NoError(t, s.Ingest(context.Background(), &lde.Push{Raw: body}))
+	}
+	return s
+}
+
+func get(t *testing.T, mux *http.ServeMux, target string, v interface{}) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if v != nil && rec.Code == http.StatusOK {
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), v))
+	}
+	return rec
+}
+
+func noWrap(h http.Handler) http.Handler { return h }
+
+func TestNewReading(t *testing.T) {
+	r := NewReading(reefReading)
+	assert.Equal(t, "abc", r.SUDID)
+	assert.Equal(t, "reef", r.SUDType)
+	assert.Equal(t, time.Date(2021, 1, 13, 12, 0, 0, 0, time.UTC), r.Time)
+	assert.Equal(t, 166.0, r.Values["par"])
+	// The total ammonia estimate only holds for fresh water.
+	assert.NotContains(t, r.Values, "total_ammonia")
+	assert.Equal(t, 0, r.Status["kelvin"])
+	assert.Equal(t, []string{"ph"}, r.Alerts)
+
+	// Pond SUDs lack the kelvin and PAR sensors, and this one is out of the water.
+	r = NewReading(pondReading)
+	assert.NotContains(t, r.Values, "par")
+	assert.NotContains(t, r.Values, "kelvin")
+	assert.NotContains(t, r.Status, "kelvin")
+	assert.Equal(t, 12.125, r.Values["temperature"])
+	assert.Equal(t, []string{"out_of_water"}, r.Alerts)
+
+	r = NewReading(&lde.LDE{SUD: lde.SUD{ID: "def", Type: lde.HomeSUD, Data: lde.Data{
+		Status: lde.SUDStatus{Water: 1}, Temperature: 25, PH: 7.5, NH3: 0.002,
+	}}})
+	assert.Contains(t, r.Values, "total_ammonia")
+}
+
+func TestSUDs(t *testing.T) {
+	mux := http.NewServeMux()
+	New(newTestServer(t, pondReading, reefReading)).Register(mux, noWrap)
+
+	var readings []*Reading
+	rec := get(t, mux, "/api/v1/suds", &readings)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, readings, 2)
+	assert.Equal(t, "abc", readings[0].SUDID)
+	assert.Equal(t, "Pond", readings[1].SUDName)
+
+	mux = http.NewServeMux()
+	New(newTestServer(t)).Register(mux, noWrap)
+	rec = get(t, mux, "/api/v1/suds", nil)
+	assert.JSONEq(t, `[]`, rec.Body.String())
+}
+
+func TestHistory(t *testing.T) {
+	mux := http.NewServeMux()
+	New(newTestServer(t)).Register(mux, noWrap)
+	rec := get(t, mux, "/api/v1/history", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	a := New(newTestServer(t), WithHistory(sliceSource{reefReading, pondReading}))
+	a.now = func() time.Time { return time.Unix(1610539200, 0).Add(time.Hour) }
+	mux = http.NewServeMux()
+	a.Register(mux, noWrap)
+
+	var readings []*Reading
+	rec = get(t, mux, "/api/v1/history", &readings)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, readings, 2)
+
+	rec = get(t, mux, "/api/v1/history?sud_id=xyz", &readings)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, readings, 1)
+	assert.Equal(t, "xyz", readings[0].SUDID)
+
+	// since defaults to a day before now.
+	a.now = func() time.Time { return time.Unix(1610539200, 0).Add(25 * time.Hour) }
+	rec = get(t, mux, "/api/v1/history", nil)
+	assert.JSONEq(t, `[]`, rec.Body.String())
+	rec = get(t, mux, "/api/v1/history?since=2021-01-13T12:00:30Z", &readings)
+	require.Len(t, readings, 1)
+	assert.Equal(t, "xyz", readings[0].SUDID)
+
+	rec = get(t, mux, "/api/v1/history?since=yesterday", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestEvents(t *testing.T) {
+	events := NewEvents()
+	mux := http.NewServeMux()
+	New(newTestServer(t), WithEvents(events)).Register(mux, noWrap)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/v1/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	// Sends before the subscription are not delivered, so wait for it.
+	require.Eventually(t, func() bool {
+		events.lock.Lock()
+		defer events.lock.Unlock()
+		return len(events.subscribers) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, events.Send(context.Background(), &lde.Push{LDE: reefReading}))
+	scanner := bufio.NewScanner(resp.Body)
+	var lines []string
+	for scanner.Scan() && len(lines) < 2 {
+		if line := scanner.Text(); line != "" && !strings.HasPrefix(line, "retry:") {
+			lines = append(lines, line)
+		}
+	}
+	require.Len(t, lines, 2)
+	assert.Equal(t, "event: reading", lines[0])
+	var r Reading
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &r))
+	assert.Equal(t, "abc", r.SUDID)
+
+	// Closing disconnects subscribers, and refuses new ones.
+	require.NoError(t, events.Close(context.Background()))
+	for scanner.Scan() {
+	}
+	require.NoError(t, scanner.Err())
+	require.NoError(t, events.Close(context.Background()))
+	resp, err = http.Get(srv.URL + "/api/v1/events")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestEventsSlowSubscriber(t *testing.T) {
+	events := NewEvents()
+	ch, unsubscribe, ok := events.subscribe()
+	require.True(t, ok)
+	defer unsubscribe()
+	for i := 0; i <= subscriberBuffer; i++ {
+		require.NoError(t, events.Send(context.Background(), &lde.Push{LDE: reefReading}))
+	}
+	// The subscriber fell behind, so was disconnected after its buffer filled.
+	n := 0
+	for range ch {
+		n++
+	}
+	assert.Equal(t, subscriberBuffer, n)
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+const (
+	// subscriberBuffer is the number of events queued for each subscriber. Subscribers which fall
+	// further behind are disconnected, and are expected to reconnect and reload.
+	subscriberBuffer = 16
+	// keepaliveInterval is how often an idle event stream is sent a comment, so proxies don't
+	// close it.
+	keepaliveInterval = 15 * time.Second
+)
+
+// Events is an lde.Sink which broadcasts each accepted push to the API's event stream
+// subscribers. It's safe for concurrent use.
+type Events struct {
+	lock        sync.Mutex
+	subscribers map[chan []byte]struct{}
+	closed      bool
+}
+
+var _ lde.Sink = (*Events)(nil)
+
+// NewEvents creates an Events sink with no subscribers.
+func NewEvents() *Events {
+	return &Events{subscribers: make(map[chan []byte]struct{})}
+}
+
+// Send implements lde.Sink, queueing the reading for every subscriber. It never blocks.
+func (e *Events) Send(ctx context.Context, p *lde.Push) error {
+	b, err := json.Marshal(NewReading(p.LDE))
+	if err != nil {
+		return err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for ch := range e.subscribers {
+		select {
+		case ch <- b:
+		default:
+			// The subscriber has fallen behind; it will miss events, so disconnect it.
+			delete(e.subscribers, ch)
+			close(ch)
+		}
+	}
+	return nil
+}
+
+// Close implements lde.Sink, disconnecting all subscribers. Event streams are long lived, so
+// Close should be called before shutting down the HTTP server which serves them. It may be called
+// more than once.
+func (e *Events) Close(ctx context.Context) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	for ch := range e.subscribers {
+		delete(e.subscribers, ch)
+		close(ch)
+	}
+	return nil
+}
+
+// subscribe returns a channel of encoded readings, which is closed when the subscriber is
+// disconnected, and a func to unsubscribe. ok is false if Events is closed.
+func (e *Events) subscribe() (ch chan []byte, unsubscribe func(), ok bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return nil, nil, false
+	}
+	ch = make(chan []byte, subscriberBuffer)
+	e.subscribers[ch] = struct{}{}
+	return ch, func() {
+		e.lock.Lock()
+		defer e.lock.Unlock()
+		if _, ok := e.subscribers[ch]; ok {
+			delete(e.subscribers, ch)
+			close(ch)
+		}
+	}, true
+}
+
+// serveEvents streams new readings as server-sent events named "reading", until the client
+// disconnects or Events is closed.
+func (a *API) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if a.events == nil {
+		http.Error(w, "events are not configured", http.StatusNotFound)
+		return
+	}
+	ch, unsubscribe, ok := a.events.subscribe()
+	if !ok {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer unsubscribe()
+	rc := http.NewResponseController(w)
+	// The stream outlives the server's write timeout.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		hlog.FromRequest(r).Debug().Err(err).Msg("clearing event stream write deadline")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	// Tell the client how soon to reconnect if the stream is closed.
+	fmt.Fprint(w, "retry: 5000\n\n")
+	if err := rc.Flush(); err != nil {
+		hlog.FromRequest(r).Error().Err(err).Msg("event streams require a flushable response")
+		return
+	}
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case b, ok := <-ch:
+			if !ok {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: reading\ndata: %s\n\n", b); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,428 @@
+// Package archive records raw LDE pushes to append-only JSON lines files for auditing and
+// reprocessing. Files are rotated by size and age, and optionally compressed with gzip once
+// rotated.
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/history"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+)
+
+const (
+	// fileExt is the extension of archive files.
+	fileExt = ".jsonl"
+	// gzipExt is appended to the extension of compressed archive files.
+	gzipExt = ".gz"
+	// timeFormat names archive files by the time they were opened, so they sort chronologically.
+	timeFormat = "20060102T150405.000000000Z"
+)
+
+// Record is one archived push.
+type Record struct {
+	// Received is when the push was received.
+	Received time.Time `json:"received"`
+	// Source is the IP address of the pushing client.
+	Source string `json:"source"`
+	// Accepted is true if the push was parsed and validated.
+	Accepted bool `json:"accepted"`
+	// Reason describes why the push was rejected.
+	Reason string `json:"reason,omitempty"`
+	// SUDID is the ID of the SUD which sent an accepted push.
+	SUDID string `json:"sud_id,omitempty"`
+	// Body is the raw request body.
+	Body string `json:"body"`
+}
+
+// Push converts the record back to the push it archived, without its parsed LDE.
+func (r *Record) Push() *lde.Push {
+	return &lde.Push{
+		Received: r.Received,
+		Source:   r.Source,
+		Raw:      []byte(r.Body),
+	}
+}
+
+// Config configures an archive Writer.
+type Config struct {
+	// Dir is the directory archive files are written to. It's created if it doesn't exist.
+	Dir string
+	// Prefix is prepended to the name of each archive file. Defaults to "lde".
+	Prefix string
+	// MaxSize is the size in bytes after which the archive file is rotated. 0 disables size
+	// based rotation.
+	MaxSize int64
+	// MaxAge is the age after which the archive file is rotated. 0 disables time based rotation.
+	MaxAge time.Duration
+	// Compress gzips archive files once they're rotated.
+	Compress bool
+	// Rejected archives pushes which failed parsing or validation, in addition to accepted pushes.
+	Rejected bool
+}
+
+// Writer is an lde.Sink which archives pushes. It's safe for concurrent use.
+type Writer struct {
+	config Config
+
+	lock   sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+	err    error
+	// compressing tracks background compression of rotated files.
+	compressing sync.WaitGroup
+
+	now func() time.Time
+}
+
+var _ lde.RejectSink = (*Writer)(nil)
+var _ lde.HealthySink = (*Writer)(nil)
+
+// NewWriter creates a Writer. Files are opened lazily, on the first push. If Compress is set, files
+// left uncompressed by a previous Writer are compressed in the background.
+func NewWriter(config Config) (*Writer, error) {
+	if config.Dir == "" {
+		return nil, fmt.Errorf("archive directory is required")
+	}
+	if config.Prefix == "" {
+		config.Prefix = "lde"
+	}
+	if strings.ContainsAny(config.Prefix, `/\`) {
+		return nil, fmt.Errorf("invalid archive prefix: %q", config.Prefix)
+	}
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating archive directory: %w", err)
+	}
+	w := &Writer{config: config, now: time.Now}
+	if config.Compress {
+		if err := w.compressLeftovers(); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Send implements lde.Sink.
+func (w *Writer) Send(ctx context.Context, p *lde.Push) error {
+	r := Record{
+		Received: p.Received,
+		Source:   p.Source,
+		Accepted: true,
+		Body:     string(p.Raw),
+	}
+	if p.LDE != nil {
+		r.SUDID = p.LDE.SUD.ID
+	}
+	return w.write(r)
+}
+
+// SendRejected implements lde.RejectSink.
+func (w *Writer) SendRejected(ctx context.Context, p *lde.Push, reason error) error {
+	if !w.config.Rejected {
+		return nil
+	}
+	return w.write(Record{
+		Received: p.Received,
+		Source:   p.Source,
+		Reason:   reason.Error(),
+		Body:     string(p.Raw),
+	})
+}
+
+// Healthy implements lde.HealthySink, reporting the last write error.
+func (w *Writer) Healthy(ctx context.Context) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.err
+}
+
+// Close implements lde.Sink, closing the current file and waiting for compression to finish. The
+// current file is left uncompressed, and is compressed when the next Writer for the directory is
+// created.
+func (w *Writer) Close(ctx context.Context) error {
+	w.lock.Lock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.lock.Unlock()
+	done := make(chan struct{})
+	go func() {
+		w.compressing.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return err
+}
+
+func (w *Writer) write(r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.err = w.writeLocked(line)
+	return w.err
+}
+
+func (w *Writer) writeLocked(line []byte) error {
+	now := w.now()
+	if w.file != nil && w.shouldRotate(now, int64(len(line))) {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	if w.file == nil {
+		if err := w.open(now); err != nil {
+			return err
+		}
+	}
+	n, err := w.file.Write(line)
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("writing archive: %w", err)
+	}
+	return nil
+}
+
+func (w *Writer) shouldRotate(now time.Time, n int64) bool {
+	if w.config.MaxSize > 0 && w.size > 0 && w.size+n > w.config.MaxSize {
+		return true
+	}
+	return w.config.MaxAge > 0 && now.Sub(w.opened) >= w.config.MaxAge
+}
+
+// open starts a new archive file.
+func (w *Writer) open(now time.Time) error {
+	name := filepath.Join(w.config.Dir, w.config.Prefix+"-"+now.UTC().Format(timeFormat)+fileExt)
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening archive: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("opening archive: %w", err)
+	}
+	w.file, w.size, w.opened = f, info.Size(), now
+	return nil
+}
+
+func (w *Writer) rotate() error {
+	name := w.file.Name()
+	err := w.file.Close()
+	w.file = nil
+	if err != nil {
+		return fmt.Errorf("closing archive: %w", err)
+	}
+	if w.config.Compress {
+		w.compressing.Add(1)
+		go func() {
+			defer w.compressing.Done()
+			if err := compressFile(name); err != nil {
+				w.lock.Lock()
+				w.err = err
+				w.lock.Unlock()
+			}
+		}()
+	}
+	return nil
+}
+
+// compressLeftovers starts compressing any uncompressed archive files, ex. the last file of a
+// previous Writer, in the background. It's only called before the first file is opened, so the
+// Writer's own files aren't included.
+func (w *Writer) compressLeftovers() error {
+	files, err := Files(w.config.Dir, w.config.Prefix)
+	if err != nil {
+		return err
+	}
+	var leftovers []string
+	for _, f := range files {
+		if strings.HasSuffix(f, fileExt) {
+			leftovers = append(leftovers, f)
+		}
+	}
+	if len(leftovers) == 0 {
+		return nil
+	}
+	w.compressing.Add(1)
+	go func() {
+		defer w.compressing.Done()
+		for _, f := range leftovers {
+			if err := compressFile(f); err != nil {
+				w.lock.Lock()
+				w.err = err
+				w.lock.Unlock()
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// compressFile gzips the archive file, replacing it with a .gz file.
+func compressFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("compressing archive: %w", err)
+	}
+	defer in.Close()
+	tmp := name + gzipExt + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("compressing archive: %w", err)
+	}
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name+gzipExt)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("compressing archive %q: %w", name, err)
+	}
+	return os.Remove(name)
+}
+
+// Files lists the archive files in dir with the prefix, oldest first.
+func Files(dir, prefix string) ([]string, error) {
+	if prefix == "" {
+		prefix = "lde"
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("listing archive: %w", err)
+	}
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix+"-") {
+			continue
+		}
+		if strings.HasSuffix(name, fileExt) || strings.HasSuffix(name, fileExt+gzipExt) {
+			out = append(out, filepath.Join(dir, name))
+		}
+	}
+	// The timestamp in the name sorts chronologically, regardless of compression.
+	sort.Slice(out, func(i, j int) bool {
+		return strings.TrimSuffix(out[i], gzipExt) < strings.TrimSuffix(out[j], gzipExt)
+	})
+	return out, nil
+}
+
+// ReadFile calls fn with each record in the archive file, which may be gzip compressed. Reading
+// stops at the first error returned by fn.
+func ReadFile(name string, fn func(*Record) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(name, gzipExt) {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("reading %q: %w", name, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return Read(r, fn)
+}
+
+// Read calls fn with each record read from r. Reading stops at the first error returned by fn.
+func Read(r io.Reader, fn func(*Record) error) error {
+	scanner := bufio.NewScanner(r)
+	// Records hold a whole request body, which may exceed the default token size.
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := fn(&rec); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// History is a history.Source of the accepted pushes in an archive directory.
+type History struct {
+	// Dir is the archive directory.
+	Dir string
+	// Prefix is the prefix of the archive files. Defaults to "lde".
+	Prefix string
+}
+
+var _ history.Source = (*History)(nil)
+
+// Readings implements history.Source. Readings are returned in the order they were received. The
+// archive only holds pushes which were validated when received, so they aren't validated again.
+func (h *History) Readings(ctx context.Context, q history.Query, fn func(*lde.LDE) error) error {
+	files, err := Files(h.Dir, h.Prefix)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		err := ReadFile(f, func(r *Record) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !r.Accepted || (len(q.SUDIDs) > 0 && r.SUDID != "" && !contains(q.SUDIDs, r.SUDID)) {
+				return nil
+			}
+			d := lde.Decode([]byte(r.Body), nil)
+			if d.LDE == nil || !q.Match(d.LDE) {
+				return nil
+			}
+			return fn(d.LDE)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/history"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, dir string) []*Record {
+	t.Helper()
+	files, err := Files(dir, "")
+	require.NoError(t, err)
+	var out []*Record
+	for _, f := range files {
+		require.NoError(t, ReadFile(f, func(r *Record) error {
+			out = append(out, r)
+			return nil
+		}))
+	}
+	return out
+}
+
+func TestWriter(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(Config{Dir: dir, Rejected: true})
+	require.NoError(t, err)
+	ctx := context.Background()
+	received := time.Date(2021, 1, 13, 12, 0, 0, 0, time.UTC)
+
+	l := &lde.LDE{SUD: lde.SUD{ID: "1234"}}
+	require.NoError(t, w.Send(ctx, &lde.Push{Received: received, Source: "192.0.2.1", Raw: []byte("a.b.c"), LDE: l}))
+	require.NoError(t, w.SendRejected(ctx, &lde.Push{Received: received, Source: "192.0.2.2", Raw: []byte("bad")}, errors.New("signature is invalid")))
+	require.NoError(t, w.Close(ctx))
+
+	records := readAll(t, dir)
+	require.Len(t, records, 2)
+	assert.Equal(t, &Record{Received: received, Source: "192.0.2.1", Accepted: true, SUDID: "1234", Body: "a.b.c"}, records[0])
+	assert.Equal(t, &Record{Received: received, Source: "192.0.2.2", Reason: "signature is invalid", Body: "bad"}, records[1])
+	assert.Equal(t, &lde.Push{Received: received, Source: "192.0.2.1", Raw: []byte("a.b.c")}, records[0].Push())
+}
+
+func TestWriterSkipsRejected(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(Config{Dir: dir})
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, w.SendRejected(ctx, &lde.Push{Raw: []byte("bad")}, errors.New("bad")))
+	require.NoError(t, w.Close(ctx))
+	assert.Empty(t, readAll(t, dir))
+}
+
+func TestWriterRotation(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(Config{Dir: dir, MaxSize: 200, MaxAge: time.Hour, Compress: true})
+	require.NoError(t, err)
+	now := time.Date(2021, 1, 13, 12, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+	ctx := context.Background()
+	send := func(body string) {
+		require.NoError(t, w.Send(ctx, &lde.Push{Received: now, Raw: []byte(body)}))
+	}
+
+	send("1")
+	now = now.Add(time.Second)
+	send("2")
+	// Exceeds the max size.
+	now = now.Add(time.Second)
+	send(strings.Repeat("3", 150))
+	// Exceeds the max age.
+	now = now.Add(time.Hour)
+	send("4")
+	require.NoError(t, w.Close(ctx))
+
+	files, err := Files(dir, "lde")
+	require.NoError(t, err)
+	require.Len(t, files, 3)
+	assert.True(t, strings.HasSuffix(files[0], ".jsonl.gz"))
+	assert.True(t, strings.HasSuffix(files[1], ".jsonl.gz"))
+	assert.True(t, strings.HasSuffix(files[2], ".jsonl"), "the current file isn't compressed")
+
+	var bodies []string
+	for _, r := range readAll(t, dir) {
+		bodies = append(bodies, r.Body)
+	}
+	assert.Equal(t, []string{"1", "2", strings.Repeat("3", 150), "4"}, bodies)
+
+	// A new writer compresses the previous writer's last file.
+	w, err = NewWriter(Config{Dir: dir, Compress: true})
+	require.NoError(t, err)
+	w.now = func() time.Time { return now.Add(time.Minute) }
+	send("5")
+	require.NoError(t, w.Close(ctx))
+	files, err = Files(dir, "lde")
+	require.NoError(t, err)
+	require.Len(t, files, 4)
+	assert.True(t, strings.HasSuffix(files[2], ".jsonl.gz"))
+	assert.Len(t, readAll(t, dir), 5)
+
+	// Leftovers are compressed in the background, without waiting for a push.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "lde-20210113T130200.000000000Z.jsonl"), []byte(`{"body":"6"}`+"\n"), 0o644))
+	w, err = NewWriter(Config{Dir: dir, Compress: true})
+	require.NoError(t, err)
+	require.NoError(t, w.Close(ctx))
+	files, err = Files(dir, "lde")
+	require.NoError(t, err)
+	require.Len(t, files, 5)
+	for _, f := range files {
+		assert.True(t, strings.HasSuffix(f, ".jsonl.gz"), f)
+	}
+	assert.Len(t, readAll(t, dir), 6)
+}
+
+func TestFilesIgnoresOthers(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"lde-1.jsonl", "lde-0.jsonl.gz", "other-0.jsonl", "lde-2.txt", "lde-3.jsonl.gz.tmp"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), nil, 0o644))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "lde-4.jsonl"), 0o755))
+	files, err := Files(dir, "lde")
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "lde-0.jsonl.gz"), filepath.Join(dir, "lde-1.jsonl")}, files)
+}
+
+func TestReadInvalid(t *testing.T) {
+	err := Read(strings.NewReader("{\"body\":\"a\"}\n\n{\"bo"), func(*Record) error { return nil })
+	assert.EqualError(t, err, "line 3: unexpected end of JSON input")
+}
+
+func TestHistory(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(Config{Dir: dir, Rejected: true})
+	require.NoError(t, err)
+	ctx := context.Background()
+	for i, id := range []string{"abc", "xyz", "abc"} {
+		l := &lde.LDE{Version: "1.0.0", SUD: lde.SUD{ID: id, Timestamp: 1610539200 + int64(i)}}
+		body, err := lde.ToRequestBody(l, []byte("secret"))
+		require.NoError(t, err)
+		require.NoError(t, w.Send(ctx, &lde.Push{Raw: body, LDE: l}))
+	}
+	require.NoError(t, w.SendRejected(ctx, &lde.Push{Raw: []byte("bad")}, errors.New("bad")))
+	require.NoError(t, w.Close(ctx))
+
+	h := &History{Dir: dir}
+	var got []int64
+	q := history.Query{SUDIDs: []string{"abc"}}
+	require.NoError(t, h.Readings(ctx, q, func(l *lde.LDE) error {
+		assert.Equal(t, "abc", l.SUD.ID)
+		got = append(got, l.SUD.Timestamp)
+		return nil
+	}))
+	assert.Equal(t, []int64{1610539200, 1610539202}, got)
+
+	got = nil
+	q = history.Query{Since: time.Unix(1610539201, 0)}
+	require.NoError(t, h.Readings(ctx, q, func(l *lde.LDE) error {
+		got = append(got, l.SUD.Timestamp)
+		return nil
+	}))
+	assert.Equal(t, []int64{1610539201, 1610539202}, got)
+}
@@ -0,0 +1,223 @@
+"use strict";
+
+// The API is served alongside the dashboard, which is served from /dashboard/.
+const API = "../api/v1/";
+// HISTORY_MS is the period of history shown by the sparklines.
+const HISTORY_MS = 24 * 60 * 60 * 1000;
+// STALE_MS is the age after which a SUD's latest reading is flagged as stale. SUDs normally push
+// every 30 minutes.
+const STALE_MS = 2 * 60 * 60 * 1000;
+
+const METRICS = [
+  { key: "temperature", label: "Temperature", unit: "°C", digits: 1, status: "temperature" },
+  { key: "ph", label: "pH", unit: "", digits: 2, status: "ph" },
+  { key: "nh3", label: "NH₃", unit: "ppm", digits: 3, status: "nh3" },
+  { key: "total_ammonia", label: "NH₃ + NH₄", unit: "ppm", digits: 3 },
+  { key: "lux", label: "Light", unit: "lx", digits: 0 },
+  { key: "kelvin", label: "Colour", unit: "K", digits: 0, status: "kelvin" },
+  { key: "par", label: "PAR", unit: "µmol/m²/s", digits: 0 },
+];
+
+const STATUS = [
+  { key: "water", ok: "In water", alert: "Out of water", raised: (v) => v === 0 },
+  { key: "temperature", ok: "Temperature", alert: "Temperature", raised: (v) => v !== 0 },
+  { key: "ph", ok: "pH", alert: "pH", raised: (v) => v !== 0 },
+  { key: "nh3", ok: "NH₃", alert: "NH₃", raised: (v) => v !== 0 },
+  { key: "slide", ok: "Slide", alert: "Slide", raised: (v) => v !== 0 },
+  { key: "kelvin", ok: "Kelvin", alert: "Kelvin", raised: (v) => v !== 0 },
+];
+
+const ALERT_LABELS = {
+  out_of_water: "Out of water",
+  temperature: "Temperature out of range",
+  ph: "pH out of range",
+  nh3: "NH₃ out of range",
+  slide: "Slide expired or missing",
+  kelvin: "Kelvin out of range",
+};
+
+// suds holds the state of each SUD by ID: its latest reading, history, and card element.
+const suds = new Map();
+
+async function getJSON(path) {
+  const resp = await fetch(API + path, { credentials: "same-origin" });
+  if (resp.status === 404) {
+    return null;
+  }
+  if (!resp.ok) {
+    throw new Error(`${path}: ${resp.status} ${resp.statusText}`);
+  }
+  return resp.json();
+}
+
+async function load() {
+  const latest = (await getJSON("suds")) || [];
+  const since = new Date(Date.now() - HISTORY_MS).toISOString().replace(/\.\d+Z$/, "Z");
+  await Promise.all(latest.map(async (reading) => {
+    const params = new URLSearchParams({ sud_id: reading.sud_id, since });
+    let history = null;
+    try {
+      history = await getJSON("history?" + params);
+    } catch (err) {
+      console.warn("loading history", err);
+    }
+    const sud = sudFor(reading.sud_id);
+    sud.history = history && history.length ? history : [reading];
+    sud.latest = reading;
+    render(sud);
+  }));
+}
+
+function sudFor(id) {
+  let sud = suds.get(id);
+  if (!sud) {
+    const el = document.getElementById("sud-template").content.firstElementChild.cloneNode(true);
+    const main = document.getElementById("suds");
+    // Keep the cards ordered by SUD ID, as the API returns them.
+    const next = [...suds.keys()].sort().find((other) => other > id);
+    main.insertBefore(el, next ? suds.get(next).el : null);
+    sud = { latest: null, history: [], el };
+    suds.set(id, sud);
+    document.getElementById("empty").hidden = true;
+  }
+  return sud;
+}
+
+function apply(reading) {
+  const sud = sudFor(reading.sud_id);
+  if (!sud.latest || reading.time >= sud.latest.time) {
+    sud.latest = reading;
+  }
+  if (!sud.history.some((h) => h.time === reading.time)) {
+    sud.history.push(reading);
+  }
+  sud.history.sort((a, b) => (a.time < b.time ? -1 : a.time > b.time ? 1 : 0));
+  render(sud);
+}
+
+function render(sud) {
+  const r = sud.latest;
+  const el = sud.el;
+  const cutoff = Date.now() - HISTORY_MS;
+  sud.history = sud.history.filter((h) => Date.parse(h.time) >= cutoff || h === r);
+
+  const age = Date.now() - Date.parse(r.time);
+  const stale = age > STALE_MS;
+  el.classList.toggle("alerting", r.alerts.length > 0);
+  el.classList.toggle("stale", stale);
+  el.querySelector(".sud-name").textContent = r.sud_name || r.sud_id;
+  el.querySelector(".sud-type").textContent = r.sud_type;
+  el.querySelector(".sud-id").textContent = r.sud_id;
+  const time = el.querySelector(".sud-time");
+  time.textContent = formatAge(age);
+  time.title = new Date(r.time).toLocaleString();
+
+  const alerts = el.querySelector(".alerts");
+  if (stale) {
+    alerts.textContent = `No reading for ${formatAge(age).replace(/ ago$/, "")}`;
+  } else if (r.alerts.length) {
+    alerts.textContent = r.alerts.map((a) => ALERT_LABELS[a] || a).join(", ");
+  } else {
+    alerts.textContent = "No alerts";
+  }
+
+  const values = el.querySelector(".values");
+  values.replaceChildren();
+  for (const m of METRICS) {
+    if (!(m.key in r.values)) {
+      continue;
+    }
+    const name = document.createElement("span");
+    name.className = "value-name";
+    name.textContent = m.label;
+    const value = document.createElement("span");
+    value.className = "value";
+    value.classList.toggle("alert", Boolean(m.status && r.status[m.status]));
+    value.textContent = `${r.values[m.key].toFixed(m.digits)} ${m.unit}`.trim();
+    const points = sud.history.filter((h) => m.key in h.values)
+      .map((h) => [Date.parse(h.time), h.values[m.key]]);
+    values.append(name, value, sparkline(points, cutoff));
+  }
+
+  const status = el.querySelector(".status");
+  status.replaceChildren();
+  for (const s of STATUS) {
+    if (!(s.key in r.status)) {
+      continue;
+    }
+    const raised = s.raised(r.status[s.key]);
+    const li = document.createElement("li");
+    li.textContent = raised ? s.alert : s.ok;
+    li.classList.toggle("alert", raised);
+    status.append(li);
+  }
+}
+
+// sparkline draws the points, [time, value] pairs, as an SVG line spanning the history period.
+function sparkline(points, start) {
+  const ns = "http://www.w3.org/2000/svg";
+  const svg = document.createElementNS(ns, "svg");
+  svg.setAttribute("class", "sparkline");
+  svg.setAttribute("viewBox", "0 0 100 20");
+  svg.setAttribute("preserveAspectRatio", "none");
+  if (points.length < 2) {
+    return svg;
+  }
+  const values = points.map((p) => p[1]);
+  const min = Math.min(...values);
+  const range = Math.max(...values) - min || 1;
+  const line = document.createElementNS(ns, "polyline");
+  line.setAttribute("points", points.map(([t, v]) => {
+    const x = Math.max(0, (t - start) / HISTORY_MS * 100);
+    const y = 19 - (v - min) / range * 18;
+    return `${x.toFixed(2)},${y.toFixed(2)}`;
+  }).join(" "));
+  svg.append(line);
+  return svg;
+}
+
+function formatAge(ms) {
+  const minutes = Math.max(0, Math.round(ms / 60000));
+  if (minutes < 1) {
+    return "just now";
+  }
+  if (minutes < 60) {
+    return `${minutes} min ago`;
+  }
+  const hours = Math.round(minutes / 60);
+  if (hours < 48) {
+    return `${hours} h ago`;
+  }
+  return `${Math.round(hours / 24)} days ago`;
+}
+
+function setConnection(state, text) {
+  const el = document.getElementById("connection");
+  el.className = "connection " + state;
+  el.textContent = text;
+}
+
+function connect() {
+  const events = new EventSource(API + "events");
+  let dropped = false;
+  events.addEventListener("open", () => {
+    setConnection("live", "live");
+    if (dropped) {
+      // Readings may have been missed while disconnected.
+      load().catch((err) => console.error("reloading", err));
+    }
+  });
+  events.addEventListener("error", () => {
+    dropped = true;
+    setConnection("down", "reconnecting");
+  });
+  events.addEventListener("reading", (e) => apply(JSON.parse(e.data)));
+}
+
+load().catch((err) => {
+  console.error("loading", err);
+  setConnection("down", "failed to load readings");
+});
+connect();
+// Refresh the reading ages and staleness.
+setInterval(() => suds.forEach(render), 60 * 1000);
@@ -0,0 +1,30 @@
+// Package dashboard serves a single-page dashboard of each SUD's latest readings, status, alerts
+// and recent history, for users who don't run Grafana. It's backed by the JSON API in package
+// api, and its assets are embedded in the binary.
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed assets
+var assets embed.FS
+
+// Handler serves the dashboard's assets. The dashboard fetches the API relative to its own path,
+// so it must be served at /dashboard/ alongside /api/v1/, ex. with
+// http.StripPrefix("/dashboard/", Handler()). It performs no authentication of its own.
+func Handler() http.Handler {
+	sub, err := fs.Sub(assets, "assets")
+	if err != nil {
+		// The embedded directory is fixed at build time.
+		panic(err)
+	}
+	files := http.FileServer(http.FS(sub))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Assets aren't versioned, so have browsers revalidate them after an upgrade.
+		w.Header().Set("Cache-Control", "no-cache")
+		files.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,28 @@
+package dashboard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	h := http.StripPrefix("/dashboard/", Handler())
+	for path, contentType := range map[string]string{
+		"/dashboard/":          "text/html; charset=utf-8",
+		"/dashboard/app.js":    "text/javascript; charset=utf-8",
+		"/dashboard/style.css": "text/css; charset=utf-8",
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+		assert.Equal(t, contentType, rec.Header().Get("Content-Type"), path)
+		assert.Equal(t, "no-cache", rec.Header().Get("Cache-Control"), path)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dashboard/missing.js", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
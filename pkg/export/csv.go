@@ -0,0 +1,93 @@
+// Package export writes past LDE readings in formats suited to spreadsheets and analysis tools.
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/history"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// csvHeader names the columns written by WriteCSV.
+var csvHeader = []string{
+	"time",
+	"sud_id",
+	"sud_name",
+	"sud_type",
+	"temperature",
+	"ph",
+	"nh3",
+	"kelvin",
+	"lux",
+	"par",
+	"status_water",
+	"status_temperature",
+	"status_ph",
+	"status_nh3",
+	"status_slide",
+	"status_kelvin",
+}
+
+// WriteCSV writes the readings matching the query as CSV, one row per push. Times are formatted
+// as RFC 3339 in loc.
+func WriteCSV(ctx context.Context, w io.Writer, src history.Source, q history.Query, loc *time.Location) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	err := src.Readings(ctx, q, func(l *lde.LDE) error {
+		d := l.SUD.Data
+		return cw.Write([]string{
+			time.Unix(l.SUD.Timestamp, 0).In(loc).Format(time.RFC3339),
+			l.SUD.ID,
+			l.SUD.Name,
+			l.SUD.Type.String(),
+			formatFloat(d.Temperature),
+			formatFloat(d.PH),
+			formatFloat(d.NH3),
+			formatFloat(d.Kelvin),
+			formatFloat(d.Lux),
+			formatFloat(d.PAR),
+			strconv.Itoa(d.Status.Water),
+			strconv.Itoa(d.Status.Temperature),
+			strconv.Itoa(d.Status.PH),
+			strconv.Itoa(d.Status.NH3),
+			strconv.Itoa(d.Status.Slide),
+			strconv.Itoa(d.Status.Kelvin),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// CSVHandler serves the readings from src as a CSV download, selected by the parameters
+// described by history.ParseQuery. It performs no authentication of its own.
+func CSVHandler(src history.Source, defaultLoc *time.Location) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, loc, err := history.ParseQuery(r, defaultLoc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="seneye.csv"`)
+		if err := WriteCSV(r.Context(), w, src, q, loc); err != nil {
+			// The response has likely started, so the error can only be logged.
+			hlog.FromRequest(r).Error().Err(err).Msg("exporting CSV")
+		}
+	})
+}
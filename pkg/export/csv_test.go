@@ -0,0 +1,75 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/history"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sliceSource is a history.Source of a fixed set of readings.
+type sliceSource []*lde.LDE
+
+func (s sliceSource) Readings(ctx context.Context, q history.Query, fn func(*lde.LDE) error) error {
+	for _, l := range s {
+		if q.Match(l) {
+			if err := fn(l); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var testReadings = sliceSource{
+	{SUD: lde.SUD{ID: "abc", Name: "Reef, main", Type: lde.ReefSUD, Timestamp: 1610539200, Data: lde.Data{
+		Status:      lde.SUDStatus{Water: 1, PH: 1},
+		Temperature: 25.5, PH: 7.85, NH3: 0.002, Kelvin: 14000, Lux: 9000, PAR: 166,
+	}}},
+	{SUD: lde.SUD{ID: "xyz", Name: "Pond", Type: lde.PondSUD, Timestamp: 1610539260, Data: lde.Data{
+		Status:      lde.SUDStatus{Water: 1},
+		Temperature: 12.125, PH: 7.6, NH3: 0.01, Lux: 30000,
+	}}},
+}
+
+func TestWriteCSV(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, WriteCSV(context.Background(), &buf, testReadings, history.Query{}, loc))
+	assert.Equal(t, `time,sud_id,sud_name,sud_type,temperature,ph,nh3,kelvin,lux,par,status_water,status_temperature,status_ph,status_nh3,status_slide,status_kelvin
+2021-01-13T07:00:00-05:00,abc,"Reef, main",reef,25.5,7.85,0.002,14000,9000,166,1,0,1,0,0,0
+2021-01-13T07:01:00-05:00,xyz,Pond,pond,12.125,7.6,0.01,0,30000,0,1,0,0,0,0,0
+`, buf.String())
+}
+
+func TestCSVHandler(t *testing.T) {
+	h := CSVHandler(testReadings, time.UTC)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/export.csv?sud_id=xyz&since=2021-01-13T00:00:00Z", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `time,sud_id,sud_name,sud_type,temperature,ph,nh3,kelvin,lux,par,status_water,status_temperature,status_ph,status_nh3,status_slide,status_kelvin
+2021-01-13T12:01:00Z,xyz,Pond,pond,12.125,7.6,0.01,0,30000,0,1,0,0,0,0,0
+`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/export.csv?until=2021-01-13T12:00:00Z&tz=Asia/Tokyo", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "time,sud_id,sud_name,sud_type,temperature,ph,nh3,kelvin,lux,par,status_water,status_temperature,status_ph,status_nh3,status_slide,status_kelvin\n", rec.Body.String())
+
+	for _, query := range []string{"since=yesterday", "until=1", "tz=Mars/Olympus_Mons"} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/export.csv?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+}
@@ -0,0 +1,273 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/history"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/rs/zerolog"
+)
+
+// parquetFile is the name of the file written to each partition directory.
+const parquetFile = "readings.parquet"
+
+// parquetRow is the schema of the Parquet export. Readings and statuses of sensors the SUD lacks
+// are null.
+type parquetRow struct {
+	Time              time.Time `parquet:"time,timestamp(millisecond:utc)"`
+	SUDID             string    `parquet:"sud_id,dict"`
+	SUDName           string    `parquet:"sud_name,dict"`
+	SUDType           string    `parquet:"sud_type,dict"`
+	Temperature       float64   `parquet:"temperature"`
+	PH                float64   `parquet:"ph"`
+	NH3               float64   `parquet:"nh3"`
+	Kelvin            *float64  `parquet:"kelvin,optional"`
+	Lux               *float64  `parquet:"lux,optional"`
+	PAR               *float64  `parquet:"par,optional"`
+	StatusWater       int32     `parquet:"status_water"`
+	StatusTemperature int32     `parquet:"status_temperature"`
+	StatusPH          int32     `parquet:"status_ph"`
+	StatusNH3         int32     `parquet:"status_nh3"`
+	StatusSlide       int32     `parquet:"status_slide"`
+	StatusKelvin      *int32    `parquet:"status_kelvin,optional"`
+}
+
+func newParquetRow(l *lde.LDE) parquetRow {
+	d := l.SUD.Data
+	caps := l.SUD.Type.Capabilities()
+	row := parquetRow{
+		Time:              time.Unix(l.SUD.Timestamp, 0).UTC(),
+		SUDID:             l.SUD.ID,
+		SUDName:           l.SUD.Name,
+		SUDType:           l.SUD.Type.String(),
+		Temperature:       d.Temperature,
+		PH:                d.PH,
+		NH3:               d.NH3,
+		StatusWater:       int32(d.Status.Water),
+		StatusTemperature: int32(d.Status.Temperature),
+		StatusPH:          int32(d.Status.PH),
+		StatusNH3:         int32(d.Status.NH3),
+		StatusSlide:       int32(d.Status.Slide),
+	}
+	if caps.Kelvin {
+		kelvin, status := d.Kelvin, int32(d.Status.Kelvin)
+		row.Kelvin, row.StatusKelvin = &kelvin, &status
+	}
+	if caps.Lux {
+		lux := d.Lux
+		row.Lux = &lux
+	}
+	if caps.PAR {
+		par := d.PAR
+		row.PAR = &par
+	}
+	return row
+}
+
+// partition identifies the Parquet file holding a SUD's readings for a month.
+type partition struct {
+	sudID string
+	month string
+}
+
+// path is the partition's file, relative to the export directory. Directories are named
+// key=value, so DuckDB and Spark recognize the partitioning.
+func (p partition) path() string {
+	// SUD IDs are serial numbers, but mustn't be able to escape the export directory.
+	id := strings.ReplaceAll(url.PathEscape(p.sudID), ".", "%2E")
+	return filepath.Join("sud="+id, "month="+p.month, parquetFile)
+}
+
+// MonthStart returns the start of t's month, in UTC.
+func MonthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// WriteParquet writes the readings matching the query to Parquet files in dir, partitioned by
+// SUD and month (UTC) as dir/sud=ID/month=YYYY-MM/readings.parquet. Partitions are rewritten
+// whole, so the query's Since and Until are widened to month boundaries; partitions without
+// readings are left untouched. It returns the files written.
+func WriteParquet(ctx context.Context, dir string, src history.Source, q history.Query) ([]string, error) {
+	return writeParquet(ctx, dir, src, q, nil)
+}
+
+// writeParquet implements WriteParquet. If merge is set, it's called with each partition's file
+// and rows, and returns the rows to write, or nil to leave the partition untouched.
+func writeParquet(ctx context.Context, dir string, src history.Source, q history.Query, merge func(name string, rows []parquetRow) ([]parquetRow, error)) ([]string, error) {
+	if !q.Since.IsZero() {
+		q.Since = MonthStart(q.Since)
+	}
+	if !q.Until.IsZero() && !q.Until.Equal(MonthStart(q.Until)) {
+		q.Until = MonthStart(q.Until).AddDate(0, 1, 0)
+	}
+	partitions := make(map[partition][]parquetRow)
+	err := src.Readings(ctx, q, func(l *lde.LDE) error {
+		row := newParquetRow(l)
+		p := partition{sudID: row.SUDID, month: row.Time.Format("2006-01")}
+		partitions[p] = append(partitions[p], row)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]partition, 0, len(partitions))
+	for p := range partitions {
+		keys = append(keys, p)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].sudID != keys[j].sudID {
+			return keys[i].sudID < keys[j].sudID
+		}
+		return keys[i].month < keys[j].month
+	})
+	var files []string
+	for _, p := range keys {
+		rows := partitions[p]
+		// Archives return readings in the order they were received, which may not be the order
+		// they were taken.
+		sort.SliceStable(rows, func(i, j int) bool { return rows[i].Time.Before(rows[j].Time) })
+		name := filepath.Join(dir, p.path())
+		if merge != nil {
+			var err error
+			if rows, err = merge(name, rows); err != nil {
+				return files, err
+			}
+			if rows == nil {
+				continue
+			}
+		}
+		if err := writeParquetFile(name, rows); err != nil {
+			return files, err
+		}
+		zerolog.Ctx(ctx).Debug().Str("file", name).Int("readings", len(rows)).Msg("wrote parquet partition")
+		files = append(files, name)
+	}
+	return files, nil
+}
+
+// writeParquetFile atomically replaces the file with the rows.
+func writeParquetFile(name string, rows []parquetRow) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return fmt.Errorf("creating partition: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), "."+parquetFile+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("writing %q: %w", name, err)
+	}
+	defer os.Remove(f.Name())
+	w := parquet.NewGenericWriter[parquetRow](f, parquet.Compression(&parquet.Zstd))
+	_, err = w.Write(rows)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		return fmt.Errorf("writing %q: %w", name, err)
+	}
+	return nil
+}
+
+// ParquetJob periodically exports the current and previous month's readings to Parquet, so late
+// readings and the end of each month are captured.
+type ParquetJob struct {
+	// Dir is the export directory.
+	Dir string
+	// Source provides the readings.
+	Source history.Source
+	// Interval is the time between exports. Defaults to an hour.
+	Interval time.Duration
+
+	now func() time.Time
+}
+
+// Run exports immediately, then every Interval until ctx is done. Failed exports are logged and
+// retried at the next interval.
+func (j *ParquetJob) Run(ctx context.Context) {
+	interval := j.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := j.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("dir", j.Dir).Msg("exporting parquet")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce exports the current and previous month's readings. If the Source implements
+// history.Horizon, readings beyond its horizon may have been downsampled or deleted, so they're
+// kept from the existing partitions rather than replaced.
+func (j *ParquetJob) RunOnce(ctx context.Context) error {
+	now := time.Now
+	if j.now != nil {
+		now = j.now
+	}
+	since := MonthStart(now()).AddDate(0, -1, 0)
+	var merge func(string, []parquetRow) ([]parquetRow, error)
+	if h, ok := j.Source.(history.Horizon); ok && h.Horizon() > 0 {
+		cutoff := now().Add(-h.Horizon())
+		merge = func(name string, rows []parquetRow) ([]parquetRow, error) {
+			return mergeParquet(name, rows, cutoff)
+		}
+	}
+	files, err := writeParquet(ctx, j.Dir, j.Source, history.Query{Since: since}, merge)
+	if err != nil {
+		return err
+	}
+	zerolog.Ctx(ctx).Debug().Int("files", len(files)).Str("dir", j.Dir).Msg("exported parquet")
+	return nil
+}
+
+// mergeParquet returns the rows of the existing partition file taken before cutoff, followed by
+// the rows taken since, or nil if there are no rows since. The source's rows from before cutoff
+// may be downsampled or incomplete, so they're only used if the partition hasn't been written.
+func mergeParquet(name string, rows []parquetRow, cutoff time.Time) ([]parquetRow, error) {
+	existing, err := parquet.ReadFile[parquetRow](name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return rows, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %q: %w", name, err)
+	}
+	i := sort.Search(len(rows), func(i int) bool { return !rows[i].Time.Before(cutoff) })
+	if i == len(rows) {
+		return nil, nil
+	}
+	merged := make([]parquetRow, 0, len(existing)+len(rows)-i)
+	for _, row := range existing {
+		if row.Time.Before(cutoff) {
+			merged = append(merged, row)
+		}
+	}
+	return append(merged, rows[i:]...), nil
+}
@@ -0,0 +1,142 @@
+package export
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/history"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+	"github.com/jcodybaker/seneye-exporter/pkg/store"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteParquet(t *testing.T) {
+	dir := t.TempDir()
+	february := &lde.LDE{SUD: lde.SUD{ID: "abc", Name: "Reef, main", Type: lde.ReefSUD, Timestamp: 1612180800, Data: lde.Data{
+		Status: lde.SUDStatus{Water: 1}, Temperature: 25,
+	}}}
+	// The earlier January reading is returned last, as an archive might.
+	january := &lde.LDE{SUD: lde.SUD{ID: "abc", Name: "Reef, main", Type: lde.ReefSUD, Timestamp: 1610000000}}
+	src := append(append(sliceSource{}, testReadings...), february, january)
+
+	// since is widened to the start of the month, so the partitions are complete.
+	files, err := WriteParquet(context.Background(), dir, src, history.Query{Since: time.Unix(1610539200, 0)})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "sud=abc", "month=2021-01", "readings.parquet"),
+		filepath.Join(dir, "sud=abc", "month=2021-02", "readings.parquet"),
+		filepath.Join(dir, "sud=xyz", "month=2021-01", "readings.parquet"),
+	}, files)
+
+	rows, err := parquet.ReadFile[parquetRow](files[0])
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, time.Unix(1610000000, 0).UTC(), rows[0].Time)
+	reef := rows[1]
+	assert.Equal(t, time.Date(2021, 1, 13, 12, 0, 0, 0, time.UTC), reef.Time)
+	assert.Equal(t, "Reef, main", reef.SUDName)
+	assert.Equal(t, "reef", reef.SUDType)
+	assert.Equal(t, 7.85, reef.PH)
+	require.NotNil(t, reef.PAR)
+	assert.Equal(t, 166.0, *reef.PAR)
+	assert.Equal(t, int32(1), reef.StatusPH)
+	require.NotNil(t, reef.StatusKelvin)
+	assert.Equal(t, int32(0), *reef.StatusKelvin)
+
+	// Pond SUDs have no kelvin or PAR sensors.
+	rows, err = parquet.ReadFile[parquetRow](files[2])
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, 12.125, rows[0].Temperature)
+	assert.Nil(t, rows[0].PAR)
+	assert.Nil(t, rows[0].Kelvin)
+	assert.Nil(t, rows[0].StatusKelvin)
+	require.NotNil(t, rows[0].Lux)
+	assert.Equal(t, 30000.0, *rows[0].Lux)
+
+	// Partitions are replaced whole, and those without readings are left alone.
+	_, err = WriteParquet(context.Background(), dir, src, history.Query{SUDIDs: []string{"abc"}, Until: time.Unix(1610000000, 0)})
+	require.NoError(t, err)
+	rows, err = parquet.ReadFile[parquetRow](files[0])
+	require.NoError(t, err)
+	assert.Len(t, rows, 2)
+	_, err = os.Stat(files[1])
+	assert.NoError(t, err)
+}
+
+func TestParquetJob(t *testing.T) {
+	dir := t.TempDir()
+	old := &lde.LDE{SUD: lde.SUD{ID: "abc", Type: lde.ReefSUD, Timestamp: 1604188800}} // 2020-11-01
+	j := &ParquetJob{
+		Dir:    dir,
+		Source: append(sliceSource{old}, testReadings...),
+		now:    func() time.Time { return time.Date(2021, 2, 3, 0, 0, 0, 0, time.UTC) },
+	}
+	require.NoError(t, j.RunOnce(context.Background()))
+	// Only the current and previous month are exported.
+	matches, err := filepath.Glob(filepath.Join(dir, "*", "*", "*.parquet"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "sud=abc", "month=2021-01", "readings.parquet"),
+		filepath.Join(dir, "sud=xyz", "month=2021-01", "readings.parquet"),
+	}, matches)
+}
+
+func TestParquetJobDownsampled(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	start := MonthStart(now).AddDate(0, -1, 0)
+	path := filepath.Join(t.TempDir(), "seneye.db")
+	s, err := store.Open(ctx, store.Config{Path: path})
+	require.NoError(t, err)
+	send := func(s *store.Store, ts time.Time) {
+		require.NoError(t, s.Send(ctx, &lde.Push{LDE: &lde.LDE{SUD: lde.SUD{
+			ID: "abc", Type: lde.ReefSUD, Timestamp: ts.Unix(), Data: lde.Data{Temperature: 25},
+		}}}))
+	}
+	for ts := start; ts.Before(start.Add(96 * time.Hour)); ts = ts.Add(10 * time.Minute) {
+		send(s, ts)
+	}
+	dir := t.TempDir()
+	require.NoError(t, (&ParquetJob{Dir: dir, Source: s}).RunOnce(ctx))
+	require.NoError(t, s.Close(ctx))
+	name := filepath.Join(dir, "sud=abc", "month="+start.Format("2006-01"), "readings.parquet")
+	rows, err := parquet.ReadFile[parquetRow](name)
+	require.NoError(t, err)
+	require.Equal(t, 96*6, len(rows))
+
+	// Reopened with downsampling, the first two days of the previous month are downsampled.
+	s, err = store.Open(ctx, store.Config{Path: path, DownsampleAfter: now.Sub(start.Add(48 * time.Hour))})
+	require.NoError(t, err)
+	defer s.Close(ctx)
+	require.NoError(t, s.Maintain(ctx))
+	j := &ParquetJob{Dir: dir, Source: s}
+	require.NoError(t, j.RunOnce(ctx))
+	// Readings beyond the store's horizon are kept from the existing partition.
+	merged, err := parquet.ReadFile[parquetRow](name)
+	require.NoError(t, err)
+	assert.Equal(t, len(rows), len(merged))
+	assert.True(t, reflect.DeepEqual(rows, merged))
+
+	// Later readings are still added.
+	late := start.Add(96*time.Hour + 5*time.Minute)
+	send(s, late)
+	require.NoError(t, j.RunOnce(ctx))
+	merged, err = parquet.ReadFile[parquetRow](name)
+	require.NoError(t, err)
+	require.Equal(t, len(rows)+1, len(merged))
+	assert.True(t, reflect.DeepEqual(rows, merged[:len(rows)]))
+	assert.Equal(t, late, merged[len(rows)].Time)
+}
+
+func TestPartitionPath(t *testing.T) {
+	assert.Equal(t, filepath.Join("sud=%2E%2E", "month=2021-01", "readings.parquet"), partition{"..", "2021-01"}.path())
+	assert.Equal(t, filepath.Join("sud=a%2Fb", "month=2021-01", "readings.parquet"), partition{"a/b", "2021-01"}.path())
+}
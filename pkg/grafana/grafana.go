@@ -0,0 +1,438 @@
+// Package grafana generates a Grafana dashboard of the metrics the exporter exports, and
+// provisions it through Grafana's HTTP API.
+package grafana
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// DefaultTitle is the dashboard's title.
+	DefaultTitle = "Seneye"
+	// DefaultUID identifies the dashboard, so pushing it again replaces it.
+	DefaultUID = "seneye-exporter"
+	// DefaultLookback is the window in which each SUD's latest reading is found.
+	DefaultLookback = time.Hour
+
+	// schemaVersion is the version of Grafana's dashboard schema the dashboard is generated for.
+	schemaVersion = 39
+
+	// sudVariable is the templating variable selecting the SUDs shown.
+	sudVariable = "sud"
+	// legend identifies each SUD's series.
+	legend = "{{name}} {{id}}"
+)
+
+// units are Grafana's IDs for the metrics' units. Other units are shown as a suffix.
+var units = map[string]string{
+	"":    "none",
+	"°C":  "celsius",
+	"°F":  "fahrenheit",
+	"ppm": "ppm",
+	"lx":  "lux",
+}
+
+// Config configures the generated dashboard.
+type Config struct {
+	// Title is the dashboard's title. Defaults to DefaultTitle.
+	Title string
+	// UID identifies the dashboard. Defaults to DefaultUID.
+	UID string
+	// Tags are the dashboard's tags.
+	Tags []string
+	// Lookback is the window in which each SUD's latest reading is found. Readings are exported
+	// with the time the SUD took them, and SUDs report less often than prometheus' 5 minute
+	// lookback, so it must exceed the SUD's reporting interval. Defaults to DefaultLookback.
+	Lookback time.Duration
+	// Fahrenheit shows temperatures in °F, rather than the °C they're exported in.
+	Fahrenheit bool
+}
+
+// Dashboard is a Grafana dashboard.
+type Dashboard struct {
+	UID           string     `json:"uid"`
+	Title         string     `json:"title"`
+	Description   string     `json:"description,omitempty"`
+	Tags          []string   `json:"tags"`
+	Editable      bool       `json:"editable"`
+	GraphTooltip  int        `json:"graphTooltip"`
+	Refresh       string     `json:"refresh"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Time          TimeRange  `json:"time"`
+	Timezone      string     `json:"timezone"`
+	Templating    Templating `json:"templating"`
+	Panels        []*Panel   `json:"panels"`
+}
+
+// TimeRange is the dashboard's default time range.
+type TimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Templating holds the dashboard's variables.
+type Templating struct {
+	List []*Variable `json:"list"`
+}
+
+// Variable is a templating variable.
+type Variable struct {
+	Name       string          `json:"name"`
+	Label      string          `json:"label,omitempty"`
+	Type       string          `json:"type"`
+	Query      interface{}     `json:"query"`
+	Datasource *DatasourceRef  `json:"datasource,omitempty"`
+	Definition string          `json:"definition,omitempty"`
+	Refresh    int             `json:"refresh"`
+	Multi      bool            `json:"multi"`
+	IncludeAll bool            `json:"includeAll"`
+	Sort       int             `json:"sort,omitempty"`
+	Current    *VariableOption `json:"current,omitempty"`
+}
+
+// VariableOption is a variable's value.
+type VariableOption struct {
+	Text  interface{} `json:"text"`
+	Value interface{} `json:"value"`
+}
+
+// DatasourceRef refers to a datasource.
+type DatasourceRef struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+// GridPos is a panel's position and size. The dashboard is 24 columns wide.
+type GridPos struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// Panel is a dashboard panel, or a row of panels.
+type Panel struct {
+	ID          int                    `json:"id"`
+	Type        string                 `json:"type"`
+	Title       string                 `json:"title"`
+	Description string                 `json:"description,omitempty"`
+	GridPos     GridPos                `json:"gridPos"`
+	Datasource  *DatasourceRef         `json:"datasource,omitempty"`
+	Targets     []Target               `json:"targets,omitempty"`
+	FieldConfig map[string]interface{} `json:"fieldConfig,omitempty"`
+	Options     map[string]interface{} `json:"options,omitempty"`
+	Collapsed   *bool                  `json:"collapsed,omitempty"`
+	Panels      []*Panel               `json:"panels,omitempty"`
+}
+
+// Target is a panel's query.
+type Target struct {
+	RefID        string         `json:"refId"`
+	Datasource   *DatasourceRef `json:"datasource"`
+	Expr         string         `json:"expr"`
+	LegendFormat string         `json:"legendFormat"`
+}
+
+// datasource refers to the datasource selected by the dashboard's datasource variable.
+var datasource = &DatasourceRef{Type: "prometheus", UID: "${datasource}"}
+
+// Generate returns a dashboard of the metrics lde.Metrics describes, with each SUD's latest
+// readings and status flags, and their history. The sud variable selects the SUDs shown, and the
+// datasource variable the prometheus they're queried from.
+func Generate(config Config) *Dashboard {
+	if config.Title == "" {
+		config.Title = DefaultTitle
+	}
+	if config.UID == "" {
+		config.UID = DefaultUID
+	}
+	if config.Lookback <= 0 {
+		config.Lookback = DefaultLookback
+	}
+	tags := config.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	b := &builder{lookback: model.Duration(config.Lookback).String()}
+
+	var readings, flags []lde.Metric
+	var water lde.Metric
+	for _, m := range lde.Metrics() {
+		switch {
+		case !m.Status:
+			if config.Fahrenheit && m.Unit == "°C" {
+				m.Unit = "°F"
+			}
+			readings = append(readings, m)
+		case m.Reading == "water":
+			water = m
+			flags = append(flags, m)
+		default:
+			flags = append(flags, m)
+		}
+	}
+
+	b.row("Latest Readings")
+	for _, m := range readings {
+		b.add(&Panel{
+			Type:        "stat",
+			Title:       m.Title,
+			Description: description(m),
+			Targets:     []Target{b.target("A", b.value(m), legend)},
+			FieldConfig: fieldConfig(m, nil),
+			Options: map[string]interface{}{
+				"colorMode": "value",
+				"graphMode": "area",
+				"reduceOptions": map[string]interface{}{
+					"calcs":  []string{"lastNotNull"},
+					"fields": "",
+					"values": false,
+				},
+			},
+		}, 24/len(readings), 4)
+	}
+
+	b.row("Status")
+	for _, m := range flags {
+		b.add(&Panel{
+			Type:        "stat",
+			Title:       m.Title,
+			Description: description(m),
+			Targets:     []Target{b.target("A", b.latest(m.Name), legend)},
+			FieldConfig: fieldConfig(m, statusMappings(m)),
+			Options: map[string]interface{}{
+				"colorMode": "background",
+				"graphMode": "none",
+				"textMode":  "value_and_name",
+				"reduceOptions": map[string]interface{}{
+					"calcs":  []string{"lastNotNull"},
+					"fields": "",
+					"values": false,
+				},
+			},
+		}, 24/len(flags), 4)
+	}
+
+	b.row("History")
+	for _, m := range readings {
+		b.add(&Panel{
+			Type:        "timeseries",
+			Title:       m.Title,
+			Description: description(m),
+			Targets:     []Target{b.target("A", b.value(m), legend)},
+			FieldConfig: fieldConfig(m, nil),
+			Options: map[string]interface{}{
+				"legend":  map[string]interface{}{"displayMode": "list", "placement": "bottom", "showLegend": true},
+				"tooltip": map[string]interface{}{"mode": "multi", "sort": "none"},
+			},
+		}, 12, 8)
+	}
+	// Flags are shown as alerts: 0 while they're within limits, and 1 otherwise.
+	var targets []Target
+	for i, m := range flags {
+		expr := b.latest(m.Name)
+		title := m.Title
+		if m.Name == water.Name {
+			expr = "1 - " + expr
+			title = "Out of Water"
+		}
+		targets = append(targets, b.target(string(rune('A'+i)), expr, legend+" "+title))
+	}
+	b.add(&Panel{
+		Type:        "state-timeline",
+		Title:       "Status History",
+		Description: "Status flags raised by each SUD.",
+		Targets:     targets,
+		FieldConfig: map[string]interface{}{
+			"defaults": map[string]interface{}{
+				"mappings": []interface{}{
+					valueMappings(map[string]mapping{
+						"0": {"OK", "green"},
+						"1": {"Alert", "red"},
+					}),
+				},
+				"thresholds": thresholds("green"),
+			},
+			"overrides": []interface{}{},
+		},
+		Options: map[string]interface{}{
+			"mergeValues": true,
+			"showValue":   "never",
+			"legend":      map[string]interface{}{"displayMode": "list", "placement": "bottom", "showLegend": false},
+		},
+	}, 24, 10)
+
+	return &Dashboard{
+		UID:           config.UID,
+		Title:         config.Title,
+		Description:   "Readings and status of Seneye USB devices from seneye-exporter.",
+		Tags:          tags,
+		Editable:      true,
+		GraphTooltip:  1,
+		Refresh:       "5m",
+		SchemaVersion: schemaVersion,
+		Time:          TimeRange{From: "now-24h", To: "now"},
+		Timezone:      "browser",
+		Templating: Templating{List: []*Variable{
+			{
+				Name:    "datasource",
+				Label:   "Data source",
+				Type:    "datasource",
+				Query:   "prometheus",
+				Current: &VariableOption{Text: "default", Value: "default"},
+			},
+			{
+				Name:       sudVariable,
+				Label:      "SUD",
+				Type:       "query",
+				Datasource: datasource,
+				Query: map[string]string{
+					"query": fmt.Sprintf("label_values(%s, id)", water.Name),
+					"refId": "PrometheusVariableQueryEditor-VariableQuery",
+				},
+				Definition: fmt.Sprintf("label_values(%s, id)", water.Name),
+				Refresh:    2,
+				Multi:      true,
+				IncludeAll: true,
+				Sort:       1,
+				Current:    &VariableOption{Text: []string{"All"}, Value: []string{"$__all"}},
+			},
+		}},
+		Panels: b.panels,
+	}
+}
+
+// builder lays out panels in rows, left to right.
+type builder struct {
+	lookback string
+	panels   []*Panel
+	// nextID is the ID of the next panel.
+	nextID int
+	// x and y are the position of the next panel, and rowHeight the height of the current row.
+	x, y, rowHeight int
+}
+
+// row starts a new row of panels under a title.
+func (b *builder) row(title string) {
+	b.newline()
+	collapsed := false
+	b.add(&Panel{Type: "row", Title: title, Collapsed: &collapsed, Panels: []*Panel{}}, 24, 1)
+	b.newline()
+}
+
+// newline moves the next panel below the current row.
+func (b *builder) newline() {
+	if b.x > 0 {
+		b.y += b.rowHeight
+		b.x, b.rowHeight = 0, 0
+	}
+}
+
+func (b *builder) add(p *Panel, w, h int) {
+	if b.x+w > 24 {
+		b.newline()
+	}
+	b.nextID++
+	p.ID = b.nextID
+	p.GridPos = GridPos{X: b.x, Y: b.y, W: w, H: h}
+	if p.Type != "row" {
+		p.Datasource = datasource
+	}
+	b.panels = append(b.panels, p)
+	b.x += w
+	if h > b.rowHeight {
+		b.rowHeight = h
+	}
+}
+
+// latest returns an expression for each selected SUD's latest value of the metric.
+func (b *builder) latest(metric string) string {
+	return fmt.Sprintf(`last_over_time(%s{id=~"$%s"}[%s])`, metric, sudVariable, b.lookback)
+}
+
+// value returns an expression for each selected SUD's latest reading of the metric, in the unit
+// it's shown in.
+func (b *builder) value(m lde.Metric) string {
+	if m.Unit == "°F" {
+		return b.latest(m.Name) + " * 9 / 5 + 32"
+	}
+	return b.latest(m.Name)
+}
+
+func (b *builder) target(refID, expr, legendFormat string) Target {
+	return Target{RefID: refID, Datasource: datasource, Expr: expr, LegendFormat: legendFormat}
+}
+
+// description returns the panel description of the metric, noting metrics the exporter derives
+// rather than the SUD measures.
+func description(m lde.Metric) string {
+	if m.Derived {
+		return fmt.Sprintf("%s (%s, derived by seneye-exporter)", strings.TrimSpace(m.Help), m.Name)
+	}
+	return fmt.Sprintf("%s (%s)", strings.TrimSpace(m.Help), m.Name)
+}
+
+// unit returns Grafana's unit for the metric.
+func unit(m lde.Metric) string {
+	if u, ok := units[m.Unit]; ok {
+		return u
+	}
+	return "suffix:" + m.Unit
+}
+
+func fieldConfig(m lde.Metric, mappings []interface{}) map[string]interface{} {
+	defaults := map[string]interface{}{
+		"unit":       unit(m),
+		"thresholds": thresholds("blue"),
+		"color":      map[string]interface{}{"mode": "palette-classic"},
+	}
+	if mappings != nil {
+		defaults["mappings"] = mappings
+		defaults["color"] = map[string]interface{}{"mode": "thresholds"}
+	}
+	return map[string]interface{}{
+		"defaults":  defaults,
+		"overrides": []interface{}{},
+	}
+}
+
+// statusMappings maps the status flag's values to text and colors. Water is 1 while the SUD is
+// submerged; other flags are 1 while they're raised.
+func statusMappings(m lde.Metric) []interface{} {
+	if m.Reading == "water" {
+		return []interface{}{valueMappings(map[string]mapping{
+			"0": {"Out of Water", "red"},
+			"1": {"In Water", "green"},
+		})}
+	}
+	return []interface{}{valueMappings(map[string]mapping{
+		"0": {"OK", "green"},
+		"1": {"Alert", "red"},
+	})}
+}
+
+// mapping is the text and color a value is shown with.
+type mapping struct {
+	text, color string
+}
+
+func valueMappings(values map[string]mapping) map[string]interface{} {
+	options := map[string]interface{}{}
+	for v, m := range values {
+		options[v] = map[string]interface{}{"text": m.text, "color": m.color}
+	}
+	return map[string]interface{}{"type": "value", "options": options}
+}
+
+func thresholds(color string) map[string]interface{} {
+	return map[string]interface{}{
+		"mode":  "absolute",
+		"steps": []interface{}{map[string]interface{}{"color": color, "value": nil}},
+	}
+}
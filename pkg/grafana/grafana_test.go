@@ -0,0 +1,110 @@
+package grafana
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update contrib/grafana/dashboard.json")
+
+// TestContribDashboard checks the dashboard in contrib is the generated default, so it doesn't
+// drift from the exported metrics. Run with -update after changing them.
+func TestContribDashboard(t *testing.T) {
+	got, err := json.MarshalIndent(Generate(Config{}), "", "  ")
+	require.NoError(t, err)
+	got = append(got, '\n')
+	path := filepath.Join("..", "..", "contrib", "grafana", "dashboard.json")
+	if *update {
+		require.NoError(t, ioutil.WriteFile(path, got, 0644))
+	}
+	want, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got))
+}
+
+func TestGenerate(t *testing.T) {
+	d := Generate(Config{Title: "Reef", UID: "reef", Tags: []string{"aquarium"}, Lookback: 90 * time.Minute})
+	assert.Equal(t, "Reef", d.Title)
+	assert.Equal(t, "reef", d.UID)
+	assert.Equal(t, []string{"aquarium"}, d.Tags)
+
+	require.Len(t, d.Templating.List, 2)
+	sud := d.Templating.List[1]
+	assert.Equal(t, "sud", sud.Name)
+	assert.Equal(t, "label_values(seneye_status_water, id)", sud.Definition)
+	assert.True(t, sud.Multi)
+	assert.True(t, sud.IncludeAll)
+
+	// Every metric is queried, for the selected SUDs.
+	exprs := map[string]bool{}
+	for _, p := range d.Panels {
+		for _, target := range p.Targets {
+			exprs[target.Expr] = true
+		}
+	}
+	for _, m := range lde.Metrics() {
+		assert.Contains(t, exprs, `last_over_time(`+m.Name+`{id=~"$sud"}[1h30m])`, m.Name)
+	}
+	assert.Contains(t, exprs, `1 - last_over_time(seneye_status_water{id=~"$sud"}[1h30m])`)
+
+	// Panels have unique IDs, fit the 24 column grid, and don't overlap.
+	ids := map[int]bool{}
+	for i, p := range d.Panels {
+		assert.False(t, ids[p.ID], "duplicate id %d", p.ID)
+		ids[p.ID] = true
+		assert.LessOrEqual(t, p.GridPos.X+p.GridPos.W, 24, p.Title)
+		for _, q := range d.Panels[:i] {
+			overlap := p.GridPos.X < q.GridPos.X+q.GridPos.W && q.GridPos.X < p.GridPos.X+p.GridPos.W &&
+				p.GridPos.Y < q.GridPos.Y+q.GridPos.H && q.GridPos.Y < p.GridPos.Y+p.GridPos.H
+			assert.False(t, overlap, "%q overlaps %q", p.Title, q.Title)
+		}
+	}
+}
+
+func TestGenerateFahrenheit(t *testing.T) {
+	d := Generate(Config{Fahrenheit: true})
+	var temperature []*Panel
+	for _, p := range d.Panels {
+		if len(p.Targets) > 0 && strings.Contains(p.Targets[0].Expr, "temperature_celsius") {
+			temperature = append(temperature, p)
+		}
+	}
+	// The latest reading and history panels are converted.
+	require.Len(t, temperature, 2)
+	for _, p := range temperature {
+		assert.Equal(t, `last_over_time(temperature_celsius{id=~"$sud"}[1h]) * 9 / 5 + 32`, p.Targets[0].Expr)
+		assert.Equal(t, "fahrenheit", p.FieldConfig["defaults"].(map[string]interface{})["unit"])
+	}
+}
+
+func TestDescription(t *testing.T) {
+	assert.Equal(t, "Water pH (ph)", description(lde.Metric{Name: "ph", Help: "Water pH"}))
+	assert.Equal(t, "Estimated total ammonia (total_ammonia, derived by seneye-exporter)",
+		description(lde.Metric{Name: "total_ammonia", Help: "Estimated total ammonia", Derived: true}))
+}
+
+func TestUnit(t *testing.T) {
+	for _, tc := range []struct {
+		unit     string
+		expected string
+	}{
+		{"", "none"},
+		{"°C", "celsius"},
+		{"°F", "fahrenheit"},
+		{"lx", "lux"},
+		{"K", "suffix:K"},
+		{"µmol/m²/s", "suffix:µmol/m²/s"},
+	} {
+		assert.Equal(t, tc.expected, unit(lde.Metric{Unit: tc.unit}), tc.unit)
+	}
+}
@@ -0,0 +1,97 @@
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxResponseSize bounds the response read from Grafana.
+const maxResponseSize = 1 << 20
+
+// PushConfig configures pushing a dashboard to Grafana.
+type PushConfig struct {
+	// URL is the Grafana server, ex. https://grafana.example.com.
+	URL string
+	// Token is a service account token, used as a bearer token if set.
+	Token string
+	// Username and Password are used for basic auth, if Username is set.
+	Username string
+	Password string
+	// FolderUID is the folder the dashboard is saved in; the General folder if unset.
+	FolderUID string
+	// Message describes the dashboard's new version.
+	Message string
+	// Timeout bounds the request. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// Push creates or replaces the dashboard with the same UID, returning its URL.
+func Push(ctx context.Context, config PushConfig, d *Dashboard) (string, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid grafana url %q", config.URL)
+	}
+	if config.Token == "" && config.Username == "" {
+		return "", errors.New("grafana token or username is required")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	base := strings.TrimSuffix(u.String(), "/")
+
+	body, err := json.Marshal(struct {
+		Dashboard *Dashboard `json:"dashboard"`
+		FolderUID string     `json:"folderUid,omitempty"`
+		Message   string     `json:"message,omitempty"`
+		Overwrite bool       `json:"overwrite"`
+	}{
+		Dashboard: d,
+		FolderUID: config.FolderUID,
+		Message:   config.Message,
+		Overwrite: true,
+	})
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+"/api/dashboards/db", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+config.Token)
+	} else {
+		req.SetBasicAuth(config.Username, config.Password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("pushing dashboard to grafana: %w", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		URL     string `json:"url"`
+		Message string `json:"message"`
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err := json.Unmarshal(b, &result); err != nil && resp.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("decoding grafana response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if result.Message == "" {
+			result.Message = strings.TrimSpace(string(b))
+		}
+		return "", fmt.Errorf("pushing dashboard to grafana: %s: %s", resp.Status, result.Message)
+	}
+	return u.ResolveReference(&url.URL{Path: result.URL}).String(), nil
+}
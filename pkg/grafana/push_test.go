@@ -0,0 +1,65 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPush(t *testing.T) {
+	var req struct {
+		Dashboard Dashboard `json:"dashboard"`
+		FolderUID string    `json:"folderUid"`
+		Message   string    `json:"message"`
+		Overwrite bool      `json:"overwrite"`
+	}
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/grafana/api/dashboards/db", r.URL.Path)
+		auth = r.Header.Get("Authorization")
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		w.Write([]byte(`{"id":1,"uid":"seneye-exporter","url":"/grafana/d/seneye-exporter/seneye","status":"success","version":2}`))
+	}))
+	defer srv.Close()
+
+	u, err := Push(context.Background(), PushConfig{
+		URL:       srv.URL + "/grafana/",
+		Token:     "TOKEN",
+		FolderUID: "aquarium",
+		Message:   "update",
+	}, Generate(Config{}))
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL+"/grafana/d/seneye-exporter/seneye", u)
+	assert.Equal(t, "Bearer TOKEN", auth)
+	assert.Equal(t, DefaultUID, req.Dashboard.UID)
+	assert.NotEmpty(t, req.Dashboard.Panels)
+	assert.Equal(t, "aquarium", req.FolderUID)
+	assert.Equal(t, "update", req.Message)
+	assert.True(t, req.Overwrite)
+
+	_, err = Push(context.Background(), PushConfig{URL: srv.URL + "/grafana", Username: "admin", Password: "secret"}, Generate(Config{}))
+	require.NoError(t, err)
+	assert.Equal(t, "Basic YWRtaW46c2VjcmV0", auth)
+}
+
+func TestPushError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		w.Write([]byte(`{"message":"A dashboard with the same name in the folder already exists","status":"name-exists"}`))
+	}))
+	defer srv.Close()
+
+	_, err := Push(context.Background(), PushConfig{URL: srv.URL, Token: "TOKEN"}, Generate(Config{}))
+	assert.EqualError(t, err, "pushing dashboard to grafana: 412 Precondition Failed: A dashboard with the same name in the folder already exists")
+
+	_, err = Push(context.Background(), PushConfig{URL: "grafana:3000", Token: "TOKEN"}, Generate(Config{}))
+	assert.Error(t, err)
+	_, err = Push(context.Background(), PushConfig{URL: srv.URL}, Generate(Config{}))
+	assert.EqualError(t, err, "grafana token or username is required")
+}
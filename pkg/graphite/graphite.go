@@ -0,0 +1,227 @@
+// Package graphite delivers LDE readings to Graphite as Carbon plaintext protocol lines.
+package graphite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/internal/queue"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+)
+
+// DefaultTemplate names each reading's series by the SUD's name.
+const DefaultTemplate = "seneye.{{.Name}}.{{.Metric}}"
+
+// unsafePathChars matches the characters replaced in path components, which would otherwise
+// split a component or break the plaintext protocol.
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9_\-]+`)
+
+// Config configures a Sink.
+type Config struct {
+	// Address is the host:port of the Carbon plaintext receiver, typically port 2003.
+	Address string
+	// Network is "tcp" or "udp". Defaults to "tcp".
+	Network string
+	// Template is a text/template producing each reading's path. It's executed with PathData.
+	// Defaults to DefaultTemplate.
+	Template string
+	// Labels are custom values available to the template as .Labels.
+	Labels map[string]string
+	// QueueSize is the number of pushes queued while the receiver is unavailable. Further pushes
+	// are dropped. Defaults to 1000.
+	QueueSize int
+	// Timeout bounds connecting and each write. Defaults to 10 seconds.
+	Timeout time.Duration
+	// MinBackoff is the delay before the first reconnection attempt. Defaults to 1 second.
+	MinBackoff time.Duration
+	// MaxBackoff bounds the delay between reconnection attempts, which doubles after each
+	// failure. Defaults to 1 minute.
+	MaxBackoff time.Duration
+}
+
+// PathData is available to the path template. Values other than Metric are sanitized to a single
+// path component.
+type PathData struct {
+	// ID is the SUD's ID.
+	ID string
+	// Name is the SUD's name, or its ID if it has no name.
+	Name string
+	// Type is the SUD's type, ex. "reef".
+	Type string
+	// Metric is the reading, ex. "temperature" or "status.water".
+	Metric string
+	// Labels holds the configured custom labels.
+	Labels map[string]string
+}
+
+// Sink is an lde.Sink which writes each accepted reading to Graphite. Pushes are queued and
+// written in the background, reconnecting with backoff if the connection fails.
+type Sink struct {
+	config   Config
+	template *template.Template
+	labels   map[string]string
+	queue    *queue.Queue[[]byte]
+	// conn is only used by the queue's deliveries.
+	conn net.Conn
+}
+
+var _ lde.RemoteSink = (*Sink)(nil)
+
+// New creates a Sink and starts its background writer. The connection is made lazily.
+func New(config Config) (*Sink, error) {
+	if config.Address == "" {
+		return nil, errors.New("graphite address is required")
+	}
+	switch config.Network {
+	case "":
+		config.Network = "tcp"
+	case "tcp", "udp":
+	default:
+		return nil, fmt.Errorf("unsupported graphite network: %q", config.Network)
+	}
+	if config.Template == "" {
+		config.Template = DefaultTemplate
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	tmpl, err := template.New("path").Option("missingkey=error").Parse(config.Template)
+	if err != nil {
+		return nil, fmt.Errorf("parsing graphite template: %w", err)
+	}
+	s := &Sink{
+		config:   config,
+		template: tmpl,
+		labels:   make(map[string]string, len(config.Labels)),
+	}
+	for k, v := range config.Labels {
+		s.labels[k] = sanitize(v)
+	}
+	// Catch template errors, ex. unknown labels, now rather than on every push.
+	if _, err := s.path(PathData{ID: "id", Name: "name", Type: "type", Metric: "metric", Labels: s.labels}); err != nil {
+		return nil, err
+	}
+	s.queue = queue.New(queue.Config{
+		Name:       s.Name(),
+		Size:       config.QueueSize,
+		MinBackoff: config.MinBackoff,
+		MaxBackoff: config.MaxBackoff,
+	}, s.write)
+	return s, nil
+}
+
+// Send implements lde.Sink, queueing the reading's lines. It never blocks; if the queue is full
+// the reading is dropped and an error returned.
+func (s *Sink) Send(ctx context.Context, p *lde.Push) error {
+	lines, err := s.Format(p.LDE)
+	if err != nil {
+		return err
+	}
+	return s.queue.Send(lines)
+}
+
+// Format returns the Carbon plaintext lines for the LDE's readings, timestamped with the time the
+// SUD took them.
+func (s *Sink) Format(l *lde.LDE) ([]byte, error) {
+	data := PathData{
+		ID:     sanitize(l.SUD.ID),
+		Name:   sanitize(l.SUD.Name),
+		Type:   sanitize(l.SUD.Type.String()),
+		Labels: s.labels,
+	}
+	if l.SUD.Name == "" {
+		data.Name = data.ID
+	}
+	ts := strconv.FormatInt(l.SUD.Timestamp, 10)
+	var buf bytes.Buffer
+	for _, r := range l.Readings() {
+		data.Metric = r.Name
+		if r.Status {
+			data.Metric = "status." + r.Name
+		}
+		path, err := s.path(data)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(path)
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatFloat(r.Value, 'f', -1, 64))
+		buf.WriteByte(' ')
+		buf.WriteString(ts)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *Sink) path(data PathData) (string, error) {
+	var b strings.Builder
+	if err := s.template.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("executing graphite template: %w", err)
+	}
+	path := b.String()
+	if path == "" || strings.ContainsAny(path, " \t\r\n") {
+		return "", fmt.Errorf("invalid graphite path: %q", path)
+	}
+	return path, nil
+}
+
+// sanitize makes s safe to use as a single path component.
+func sanitize(s string) string {
+	return strings.Trim(unsafePathChars.ReplaceAllString(s, "_"), "_")
+}
+
+// Name implements lde.RemoteSink.
+func (s *Sink) Name() string {
+	return "graphite"
+}
+
+// Healthy implements lde.HealthySink, reporting an error while the receiver is unreachable.
+func (s *Sink) Healthy(ctx context.Context) error {
+	return s.queue.Err()
+}
+
+// Close implements lde.Sink, writing any queued readings before closing the connection. Queued
+// readings are abandoned if ctx is done first.
+func (s *Sink) Close(ctx context.Context) error {
+	err := s.queue.Close(ctx)
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+// write writes each reading's lines, connecting first if needed. A failed connection is closed,
+// and reopened on the next attempt.
+func (s *Sink) write(ctx context.Context, batch [][]byte) error {
+	for _, lines := range batch {
+		var err error
+		if s.conn == nil {
+			s.conn, err = s.dial(ctx)
+		}
+		if err == nil {
+			s.conn.SetWriteDeadline(time.Now().Add(s.config.Timeout))
+			if _, err = s.conn.Write(lines); err != nil {
+				s.conn.Close()
+				s.conn = nil
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("writing to graphite: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *Sink) dial(ctx context.Context) (net.Conn, error) {
+	d := net.Dialer{Timeout: s.config.Timeout}
+	return d.DialContext(ctx, s.config.Network, s.config.Address)
+}
@@ -0,0 +1,146 @@
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testLDE = &lde.LDE{SUD: lde.SUD{ID: "abc", Name: "Reef Tank #1", Type: lde.ReefSUD, Timestamp: 1610539200, Data: lde.Data{
+	Status:      lde.SUDStatus{Water: 1},
+	Temperature: 25.5, PH: 8.1, NH3: 0.002, Kelvin: 14000, Lux: 9000, PAR: 166,
+}}}
+
+// readLines returns the first n lines received by the listener, across connections.
+func readLines(t *testing.T, ln net.Listener, n int) []string {
+	t.Helper()
+	lines := make(chan string)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					lines <- scanner.Text()
+				}
+			}()
+		}
+	}()
+	var out []string
+	for len(out) < n {
+		select {
+		case l := <-lines:
+			out = append(out, l)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of %d lines", len(out), n)
+		}
+	}
+	return out
+}
+
+func TestFormat(t *testing.T) {
+	s, err := New(Config{Address: "127.0.0.1:0"})
+	require.NoError(t, err)
+	defer s.Close(context.Background())
+	lines, err := s.Format(testLDE)
+	require.NoError(t, err)
+	assert.Equal(t, `seneye.Reef_Tank_1.temperature 25.5 1610539200
+seneye.Reef_Tank_1.ph 8.1 1610539200
+seneye.Reef_Tank_1.nh3 0.002 1610539200
+seneye.Reef_Tank_1.kelvin 14000 1610539200
+seneye.Reef_Tank_1.lux 9000 1610539200
+seneye.Reef_Tank_1.par 166 1610539200
+seneye.Reef_Tank_1.status.water 1 1610539200
+seneye.Reef_Tank_1.status.temperature 0 1610539200
+seneye.Reef_Tank_1.status.ph 0 1610539200
+seneye.Reef_Tank_1.status.nh3 0 1610539200
+seneye.Reef_Tank_1.status.slide 0 1610539200
+seneye.Reef_Tank_1.status.kelvin 0 1610539200
+`, string(lines))
+
+	s, err = New(Config{
+		Address:  "127.0.0.1:0",
+		Template: "{{.Labels.site}}.aquarium.{{.Type}}.{{.ID}}.{{.Metric}}",
+		Labels:   map[string]string{"site": "home.office"},
+	})
+	require.NoError(t, err)
+	defer s.Close(context.Background())
+	lines, err = s.Format(testLDE)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(lines), "home_office.aquarium.reef.abc.temperature 25.5 1610539200\n"), string(lines))
+}
+
+func TestNewInvalid(t *testing.T) {
+	_, err := New(Config{})
+	assert.Error(t, err)
+	_, err = New(Config{Address: "127.0.0.1:2003", Network: "unix"})
+	assert.Error(t, err)
+	_, err = New(Config{Address: "127.0.0.1:2003", Template: "{{.Nope}}"})
+	assert.Error(t, err)
+	// Labels which aren't configured are an error, rather than an empty path component.
+	_, err = New(Config{Address: "127.0.0.1:2003", Template: "{{.Labels.site}}.{{.Metric}}"})
+	assert.Error(t, err)
+}
+
+func TestSendTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	s, err := New(Config{Address: ln.Addr().String()})
+	require.NoError(t, err)
+	require.NoError(t, s.Send(context.Background(), &lde.Push{LDE: testLDE}))
+	lines := readLines(t, ln, 12)
+	assert.Equal(t, "seneye.Reef_Tank_1.temperature 25.5 1610539200", lines[0])
+	require.NoError(t, s.Close(context.Background()))
+	assert.Error(t, s.Send(context.Background(), &lde.Push{LDE: testLDE}))
+}
+
+func TestSendReconnects(t *testing.T) {
+	// Reserve an address, but don't listen on it yet.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	s, err := New(Config{Address: addr, MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond})
+	require.NoError(t, err)
+	defer s.Close(context.Background())
+	require.NoError(t, s.Send(context.Background(), &lde.Push{LDE: testLDE}))
+	require.Eventually(t, func() bool { return s.Healthy(context.Background()) != nil }, 5*time.Second, 10*time.Millisecond)
+
+	// The queued reading is delivered once the receiver is available.
+	ln, err = net.Listen("tcp", addr)
+	require.NoError(t, err)
+	defer ln.Close()
+	lines := readLines(t, ln, 12)
+	assert.Equal(t, "seneye.Reef_Tank_1.temperature 25.5 1610539200", lines[0])
+	require.Eventually(t, func() bool { return s.Healthy(context.Background()) == nil }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSendUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+	s, err := New(Config{Address: pc.LocalAddr().String(), Network: "udp"})
+	require.NoError(t, err)
+	defer s.Close(context.Background())
+	require.NoError(t, s.Send(context.Background(), &lde.Push{LDE: testLDE}))
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64*1024)
+	n, _, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	// Each reading is sent as one datagram.
+	assert.Equal(t, 12, strings.Count(string(buf[:n]), "\n"))
+}
@@ -0,0 +1,140 @@
+// Package health implements the liveness and readiness endpoints used by orchestrators like
+// Kubernetes.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// checkTimeout bounds the time each readiness check may take.
+const checkTimeout = 5 * time.Second
+
+// Check reports whether a component is ready, returning an error describing why it isn't.
+type Check func(ctx context.Context) error
+
+// Checker aggregates the readiness checks of the exporter's components.
+type Checker struct {
+	lock   sync.Mutex
+	checks map[string]Check
+}
+
+// NewChecker creates a Checker with no checks.
+func NewChecker() *Checker {
+	return &Checker{checks: make(map[string]Check)}
+}
+
+// Add registers a named readiness check, replacing any existing check of the same name.
+func (c *Checker) Add(name string, check Check) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.checks[name] = check
+}
+
+// AddCondition registers and returns a Condition which is initially not ready.
+func (c *Checker) AddCondition(name, reason string) *Condition {
+	cond := NewCondition(reason)
+	c.Add(name, cond.Check)
+	return cond
+}
+
+// Result describes the outcome of a readiness check.
+type Result struct {
+	Name string
+	Err  error
+}
+
+// Run runs all readiness checks concurrently, returning their results sorted by name.
+func (c *Checker) Run(ctx context.Context) []Result {
+	c.lock.Lock()
+	results := make([]Result, 0, len(c.checks))
+	checks := make([]Check, 0, len(c.checks))
+	for name, check := range c.checks {
+		results = append(results, Result{Name: name})
+		checks = append(checks, check)
+	}
+	c.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i].Err = checks[i](ctx)
+		}(i)
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	return results
+}
+
+// ReadyHandler serves the readiness endpoint, responding 200 if all checks pass and 503
+// otherwise. The result of each check is listed in the body.
+func (c *Checker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		results := c.Run(r.Context())
+		status := http.StatusOK
+		for _, res := range results {
+			if res.Err != nil {
+				status = http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		for _, res := range results {
+			if res.Err != nil {
+				fmt.Fprintf(w, "[-]%s failed: %v\n", res.Name, res.Err)
+				continue
+			}
+			fmt.Fprintf(w, "[+]%s ok\n", res.Name)
+		}
+	})
+}
+
+// LiveHandler serves the liveness endpoint, which responds 200 as long as the process is able to
+// serve HTTP.
+func LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// Condition is a readiness check whose state is set explicitly, ex. once a listener is bound.
+type Condition struct {
+	lock sync.Mutex
+	err  error
+}
+
+// NewCondition creates a Condition which is not ready for the given reason.
+func NewCondition(reason string) *Condition {
+	return &Condition{err: errors.New(reason)}
+}
+
+// SetReady marks the condition ready.
+func (c *Condition) SetReady() {
+	c.SetNotReady(nil)
+}
+
+// SetNotReady marks the condition not ready because of err. A nil err marks it ready.
+func (c *Condition) SetNotReady(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.err = err
+}
+
+// Check implements Check.
+func (c *Condition) Check(context.Context) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
@@ -0,0 +1,81 @@
+// Package history describes stores of past LDE readings, such as the push archive.
+package history
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+)
+
+// Query selects readings from a Source.
+type Query struct {
+	// SUDIDs limits the readings to these SUDs. All SUDs are included if empty.
+	SUDIDs []string
+	// Since excludes readings taken before it, unless it's zero.
+	Since time.Time
+	// Until excludes readings taken at or after it, unless it's zero.
+	Until time.Time
+}
+
+// Match is true if the LDE satisfies the query.
+func (q *Query) Match(l *lde.LDE) bool {
+	ts := time.Unix(l.SUD.Timestamp, 0)
+	if !q.Since.IsZero() && ts.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !ts.Before(q.Until) {
+		return false
+	}
+	if len(q.SUDIDs) == 0 {
+		return true
+	}
+	for _, id := range q.SUDIDs {
+		if id == l.SUD.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseQuery parses a query from URL parameters: sud_id (repeatable), since and until (RFC 3339)
+// and tz (an IANA time zone name, defaulting to defaultLoc).
+func ParseQuery(r *http.Request, defaultLoc *time.Location) (Query, *time.Location, error) {
+	params := r.URL.Query()
+	q := Query{SUDIDs: params["sud_id"]}
+	var err error
+	if s := params.Get("since"); s != "" {
+		if q.Since, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, nil, fmt.Errorf("invalid since: %w", err)
+		}
+	}
+	if s := params.Get("until"); s != "" {
+		if q.Until, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, nil, fmt.Errorf("invalid until: %w", err)
+		}
+	}
+	loc := defaultLoc
+	if tz := params.Get("tz"); tz != "" {
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return q, nil, fmt.Errorf("invalid tz: %w", err)
+		}
+	}
+	return q, loc, nil
+}
+
+// Source provides past readings.
+type Source interface {
+	// Readings calls fn with each reading matching the query, oldest first. Iteration stops at
+	// the first error returned by fn.
+	Readings(ctx context.Context, q Query, fn func(*lde.LDE) error) error
+}
+
+// Horizon is implemented by Sources which don't keep every reading forever, ex. a store which
+// downsamples or deletes old readings.
+type Horizon interface {
+	// Horizon returns the age beyond which readings may no longer be returned as they were
+	// taken, or 0 if they're kept forever.
+	Horizon() time.Duration
+}
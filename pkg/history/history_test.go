@@ -0,0 +1,51 @@
+package history
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryMatch(t *testing.T) {
+	ts := time.Date(2021, 1, 13, 12, 0, 0, 0, time.UTC)
+	l := &lde.LDE{SUD: lde.SUD{ID: "abc", Timestamp: ts.Unix()}}
+	tcs := []struct {
+		name  string
+		q     Query
+		match bool
+	}{
+		{name: "empty", q: Query{}, match: true},
+		{name: "sud", q: Query{SUDIDs: []string{"xyz", "abc"}}, match: true},
+		{name: "other sud", q: Query{SUDIDs: []string{"xyz"}}, match: false},
+		{name: "since inclusive", q: Query{Since: ts}, match: true},
+		{name: "since", q: Query{Since: ts.Add(time.Second)}, match: false},
+		{name: "until exclusive", q: Query{Until: ts}, match: false},
+		{name: "until", q: Query{Until: ts.Add(time.Second)}, match: true},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.match, tc.q.Match(l))
+		})
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	r := httptest.NewRequest("GET", "/?sud_id=abc&sud_id=xyz&since=2021-01-13T12:00:00Z&tz=Europe/London", nil)
+	q, loc, err := ParseQuery(r, time.UTC)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"abc", "xyz"}, q.SUDIDs)
+	assert.Equal(t, time.Date(2021, 1, 13, 12, 0, 0, 0, time.UTC), q.Since)
+	assert.True(t, q.Until.IsZero())
+	assert.Equal(t, "Europe/London", loc.String())
+
+	_, _, err = ParseQuery(httptest.NewRequest("GET", "/?until=yesterday", nil), time.UTC)
+	assert.Error(t, err)
+	_, _, err = ParseQuery(httptest.NewRequest("GET", "/?tz=Mars/Olympus", nil), time.UTC)
+	assert.Error(t, err)
+}
@@ -0,0 +1,89 @@
+package lde
+
+import (
+	"math"
+	"time"
+)
+
+// maxDailyLightGap is the longest gap between PAR readings which will be integrated into the daily
+// light integral. Longer gaps are treated as missing data.
+const maxDailyLightGap = time.Hour
+
+// Capabilities describes the sensors available on a type of Seneye USB Device. Readings from
+// sensors the device lacks are always reported as zero, and should not be exported.
+type Capabilities struct {
+	// Lux is true if the SUD measures light intensity.
+	Lux bool
+	// Kelvin is true if the SUD measures the correlated color temperature of light.
+	Kelvin bool
+	// PAR is true if the SUD measures photosynthetically active radiation.
+	PAR bool
+	// FreshWater is true if the SUD is intended for fresh rather than salt water.
+	FreshWater bool
+}
+
+// Capabilities describes the sensors available on the SUD type. Unknown types are assumed to have
+// every light sensor so no readings are discarded.
+func (t SUDType) Capabilities() Capabilities {
+	switch t {
+	case HomeSUD, PondSUD:
+		return Capabilities{Lux: true, FreshWater: true}
+	case ReefSUD:
+		return Capabilities{Lux: true, Kelvin: true, PAR: true}
+	default:
+		return Capabilities{Lux: true, Kelvin: true, PAR: true}
+	}
+}
+
+// TotalAmmonia estimates the PPM of total ammonia (NH3 + NH4) from the free ammonia, pH and
+// temperature readings, using the fresh water dissociation constant from Emerson et al. (1975).
+// ok is false if the readings are insufficient to make an estimate.
+func (d *Data) TotalAmmonia() (ppm float64, ok bool) {
+	if d.PH <= 0 {
+		return 0, false
+	}
+	pKa := 0.09018 + 2729.92/(d.Temperature+273.15)
+	freeFraction := 1 / (math.Pow(10, pKa-d.PH) + 1)
+	if freeFraction <= 0 || math.IsNaN(freeFraction) {
+		return 0, false
+	}
+	return d.NH3 / freeFraction, true
+}
+
+// dailyLight accumulates the daily light integral (DLI) for a PAR capable SUD.
+type dailyLight struct {
+	Day      string  `json:"day"`
+	LastTS   int64   `json:"last_ts"`
+	LastPAR  float64 `json:"last_par"`
+	Integral float64 `json:"integral"`
+}
+
+// add integrates the PAR reading taken at ts into the daily light integral. Days are bounded
+// in loc.
+func (d *dailyLight) add(ts int64, par float64, loc *time.Location) {
+	if ts <= d.LastTS {
+		// Ignore repeated or out of order readings.
+		return
+	}
+	day := time.Unix(ts, 0).In(loc).Format("2006-01-02")
+	if day != d.Day {
+		d.Day = day
+		d.Integral = 0
+	} else if gap := ts - d.LastTS; gap <= int64(maxDailyLightGap/time.Second) {
+		// PAR is µmol/m²/s; DLI is mol/m²/day.
+		d.Integral += d.LastPAR * float64(gap) / 1e6
+	}
+	d.LastTS = ts
+	d.LastPAR = par
+}
+
+// current returns the daily light integral so far on now's day in loc, and when it last changed.
+// Once the day has rolled over without a reading, the integral is 0 from midnight.
+func (d *dailyLight) current(now time.Time, loc *time.Location) (float64, time.Time) {
+	now = now.In(loc)
+	if day := now.Format("2006-01-02"); day > d.Day {
+		y, m, dd := now.Date()
+		return 0, time.Date(y, m, dd, 0, 0, 0, 0, loc)
+	}
+	return d.Integral, time.Unix(d.LastTS, 0)
+}
@@ -0,0 +1,77 @@
+package lde
+
+import (
+	"encoding/json"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Decoded describes an LDE body decoded without trusting it, for debugging.
+type Decoded struct {
+	// Token is the JWT after fixing its base64 encoding.
+	Token string
+	// Header is the unverified JWT header, if it could be decoded.
+	Header map[string]interface{}
+	// Claims is the unverified JWT claims, if they could be decoded.
+	Claims json.RawMessage
+	// SUDID is the unverified SUD ID claimed by the push, if it could be decoded.
+	SUDID string
+	// LDE is the parsed claims. It is set even if validation failed, as long as the claims
+	// could be parsed.
+	LDE *LDE
+	// ParseErr describes why the claims couldn't be parsed, if they couldn't.
+	ParseErr error
+	// Err describes why the body failed parsing or validation, or is nil if it was valid.
+	Err error
+}
+
+// Decode decodes the LDE body, validating it against the secrets.
+func Decode(body []byte, secrets map[string][]byte) *Decoded {
+	fixed := fixEncoding(append([]byte(nil), body...))
+	d := &Decoded{Token: string(fixed)}
+	d.Header, d.Claims, d.SUDID = decodeUnverified(d.Token)
+	_, d.Err = FromRequestBody(fixed, secrets)
+	if d.Claims == nil {
+		d.ParseErr = d.Err
+		return d
+	}
+	l := &LDE{}
+	if d.ParseErr = json.Unmarshal(d.Claims, l); d.ParseErr == nil {
+		d.LDE = l
+	}
+	return d
+}
+
+// SecretID returns the secrets key used to validate the SUD's pushes; either its SUD ID, or the
+// empty string for the default secret. ok is false if no secret applies.
+func SecretID(sudID string, secrets map[string][]byte) (id string, ok bool) {
+	if _, ok := secrets[sudID]; ok {
+		return sudID, true
+	}
+	_, ok = secrets[""]
+	return "", ok
+}
+
+// decodeUnverified decodes what it can of the JWT header and claims without verifying the
+// signature.
+func decodeUnverified(token string) (header map[string]interface{}, claims json.RawMessage, sudID string) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, ""
+	}
+	if b, err := jwt.DecodeSegment(parts[0]); err == nil {
+		json.Unmarshal(b, &header)
+	}
+	if b, err := jwt.DecodeSegment(parts[1]); err == nil && json.Valid(b) {
+		claims = b
+		var c struct {
+			SUD struct {
+				ID string `json:"id"`
+			} `json:"SUD"`
+		}
+		json.Unmarshal(b, &c)
+		sudID = c.SUD.ID
+	}
+	return header, claims, sudID
+}
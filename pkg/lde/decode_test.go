@@ -0,0 +1,72 @@
+package lde
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const decodeTestClaims = `{"version":"1.0.0","SUD":{"id":"abc","name":"tank","type":3,"ts":1610505992,"data":{"T":25.5,"P":8.1,"N":0.01,"K":9000,"L":1200,"A":150}}}`
+
+func TestDecode(t *testing.T) {
+	secret := []byte("secret")
+	body := signTestToken(t, decodeTestClaims, secret)
+
+	d := Decode(body, map[string][]byte{"": secret})
+	require.NoError(t, d.Err)
+	require.NoError(t, d.ParseErr)
+	assert.Equal(t, "HS256", d.Header["alg"])
+	assert.JSONEq(t, decodeTestClaims, string(d.Claims))
+	assert.Equal(t, "abc", d.SUDID)
+	require.NotNil(t, d.LDE)
+	assert.Equal(t, 25.5, d.LDE.SUD.Data.Temperature)
+
+	d = Decode(body, map[string][]byte{"": []byte("wrong")})
+	assert.Error(t, d.Err)
+	assert.NoError(t, d.ParseErr)
+	require.NotNil(t, d.LDE, "claims are decoded even if the signature is invalid")
+	assert.Equal(t, "abc", d.LDE.SUD.ID)
+
+	d = Decode([]byte("not a token"), nil)
+	assert.Error(t, d.Err)
+	assert.Error(t, d.ParseErr)
+	assert.Nil(t, d.Claims)
+	assert.Nil(t, d.LDE)
+}
+
+func TestDecodeFixesEncoding(t *testing.T) {
+	secret := []byte("secret")
+	body := signTestToken(t, decodeTestClaims, secret)
+	// Seneye encodes with the standard base64 alphabet.
+	std := strings.NewReplacer("-", "+", "_", "/").Replace(string(body))
+	d := Decode([]byte(std), map[string][]byte{"": secret})
+	assert.NoError(t, d.Err)
+	assert.Equal(t, string(body), d.Token)
+	assert.Equal(t, std, string([]byte(std)), "the input isn't modified")
+}
+
+func TestSecretID(t *testing.T) {
+	secrets := map[string][]byte{"abc": []byte("a"), "": []byte("default")}
+	id, ok := SecretID("abc", secrets)
+	assert.True(t, ok)
+	assert.Equal(t, "abc", id)
+	id, ok = SecretID("other", secrets)
+	assert.True(t, ok)
+	assert.Equal(t, "", id)
+	_, ok = SecretID("other", map[string][]byte{"abc": []byte("a")})
+	assert.False(t, ok)
+}
+
+func TestWriteMetrics(t *testing.T) {
+	d := Decode(signTestToken(t, decodeTestClaims, []byte("secret")), nil)
+	require.NotNil(t, d.LDE)
+	var buf bytes.Buffer
+	require.NoError(t, WriteMetrics(&buf, d.LDE))
+	out := buf.String()
+	assert.Contains(t, out, `# TYPE temperature_celsius gauge`)
+	assert.Contains(t, out, `temperature_celsius{id="abc",name="tank",sud_type="reef"} 25.5 1610505992000`)
+	assert.Contains(t, out, `light_par{id="abc",name="tank",sud_type="reef"} 150 1610505992000`)
+}
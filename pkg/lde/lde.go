@@ -1,7 +1,9 @@
 package lde
 
 import (
+	"encoding/json"
 	"fmt"
+
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
@@ -9,13 +11,13 @@ import (
 type SUDType int
 
 const (
-	// HomeSUD describes the home type Seneye USB Device..
+	// HomeSUD describes the home type Seneye USB Device.
 	HomeSUD SUDType = 1
 
-	// PondSUD describes the pond type Seneye USB Device..
+	// PondSUD describes the pond type Seneye USB Device.
 	PondSUD SUDType = 2
 
-	// ReefSUD describes the reef type Seneye USB Device..
+	// ReefSUD describes the reef type Seneye USB Device.
 	ReefSUD SUDType = 3
 )
 
@@ -25,7 +27,7 @@ func (t SUDType) String() string {
 	case HomeSUD:
 		return "home"
 	case PondSUD:
-		return "pong"
+		return "pond"
 	case ReefSUD:
 		return "reef"
 	default:
@@ -39,6 +41,8 @@ type LDE struct {
 	Version string `json:"version"`
 	// SUD describes the state of Seneye USB Device.
 	SUD SUD `json:"SUD"`
+	// Unknown holds any top-level fields not understood by this version of the exporter.
+	Unknown map[string]json.RawMessage `json:"-"`
 }
 
 // Valid implements jwt.Claims so we can make jwt parse the body.
@@ -58,6 +62,8 @@ type SUD struct {
 	Timestamp int64 `json:"TS"`
 	// Data holds the readings from the SUD.
 	Data Data `json:"data"`
+	// Unknown holds any SUD fields not understood by this version of the exporter.
+	Unknown map[string]json.RawMessage `json:"-"`
 }
 
 // Data describes readings from the SUD.
@@ -78,6 +84,9 @@ type Data struct {
 	// PAR describes the photosynthetic active radiation is a measurement of light power between 400nm and 700nm.
 	// ( https://answers.seneye.com/index.php?title=en/Aquarium_help/What_is_PAR_%26_PUR_%3F )
 	PAR float64 `json:"A"`
+	// Unknown holds any readings not understood by this version of the exporter, keyed by their
+	// LDE field name.
+	Unknown map[string]json.RawMessage `json:"-"`
 }
 
 // SUDStatus describes the condition of the SUD and any alert conditions.
@@ -94,6 +103,9 @@ type SUDStatus struct {
 	Slide int `json:"S"`
 	// Kelvin is 0 if the Kelvin measurement is within limits, 1 otherwise.
 	Kelvin int `json:"K"`
+	// Unknown holds any status flags not understood by this version of the exporter, keyed by
+	// their LDE field name.
+	Unknown map[string]json.RawMessage `json:"-"`
 }
 
 // FromRequestBody parses the LDE body.
@@ -116,6 +128,15 @@ func FromRequestBody(requestBody []byte, secrets map[string][]byte) (*LDE, error
 	return lde, err
 }
 
+// ToRequestBody signs the LDE with the secret, producing a body which FromRequestBody will accept.
+func ToRequestBody(l *LDE, secret []byte) ([]byte, error) {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, l).SignedString(secret)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(token), nil
+}
+
 // fixEncoding ensure the request is encoded data using base64 encoding with URL and filename safe
 // alphabet expected by jwt, instead of the normal base64 encoding.
 // https://datatracker.ietf.org/doc/rfc4648/
@@ -1,9 +1,13 @@
 package lde
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"testing"
 )
 
 func TestFromRequestBody(t *testing.T) {
@@ -93,3 +97,131 @@ func TestFromRequestBody(t *testing.T) {
 	}
 
 }
+
+func TestFromRequestBodyUnknownFields(t *testing.T) {
+	secret := []byte("AAAAAAAA")
+	claims := `{"version":"1.1.0","zone":"eu","SUD":{"id":"1234","name":"Office","type":1,"TS":1609561222,` +
+		`"fw":"2.0","data":{"S":{"W":1,"Z":1},"T":21.125,"P":7.94,"N":0.001,"O":8.5,"X":"text"}}}`
+	l, err := FromRequestBody(signTestToken(t, claims, secret), map[string][]byte{"": secret})
+	require.NoError(t, err)
+
+	assert.Equal(t, "1234", l.SUD.ID)
+	assert.Equal(t, 21.125, l.SUD.Data.Temperature)
+	assert.Equal(t, []string{"SUD.data.O", "SUD.data.S.Z", "SUD.data.X", "SUD.fw", "zone"}, l.UnknownFields())
+	assert.Equal(t, map[string]float64{"O": 8.5}, l.SUD.Data.UnknownValues())
+
+	b, err := json.Marshal(l)
+	require.NoError(t, err)
+	var roundTrip LDE
+	require.NoError(t, json.Unmarshal(b, &roundTrip))
+	assert.Equal(t, l, &roundTrip)
+}
+
+func TestToRequestBody(t *testing.T) {
+	secret := []byte("AAAAAAAA")
+	in := &LDE{
+		Version: "1.0.0",
+		SUD: SUD{
+			ID:        "1234",
+			Name:      "Reef",
+			Type:      ReefSUD,
+			Timestamp: 1609561222,
+			Data: Data{
+				Status:      SUDStatus{Water: 1},
+				Temperature: 25.5,
+				PH:          8.2,
+				NH3:         0.002,
+				Kelvin:      14000,
+				Lux:         9000,
+				PAR:         250,
+			},
+		},
+	}
+	body, err := ToRequestBody(in, secret)
+	require.NoError(t, err)
+
+	out, err := FromRequestBody(body, map[string][]byte{"1234": secret})
+	require.NoError(t, err)
+	assert.Equal(t, in, out)
+
+	_, err = FromRequestBody(body, map[string][]byte{"1234": []byte("wrong")})
+	assert.EqualError(t, err, "signature is invalid")
+}
+
+func TestDecoderFor(t *testing.T) {
+	var v2Called bool
+	RegisterDecoder(2, func(claims []byte, lde *LDE) error {
+		v2Called = true
+		return decodeV1(claims, lde)
+	})
+	defer func() {
+		decodersLock.Lock()
+		delete(decoders, 2)
+		decodersLock.Unlock()
+	}()
+
+	tcs := []struct {
+		version   string
+		supported bool
+		v2        bool
+	}{
+		{version: "1.0.0", supported: true},
+		{version: "1.7", supported: true},
+		{version: "2.0.0", supported: true, v2: true},
+		{version: "3.0.0", supported: false, v2: true},
+		{version: "0.9.0", supported: false},
+		{version: "", supported: false},
+		{version: "garbage", supported: false},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.version, func(t *testing.T) {
+			v2Called = false
+			d, ok := DecoderFor(tc.version)
+			assert.Equal(t, tc.supported, ok)
+			require.NoError(t, d([]byte(`{"version":"`+tc.version+`"}`), &LDE{}))
+			assert.Equal(t, tc.v2, v2Called)
+		})
+	}
+}
+
+// signTestToken builds an LDE body from the raw JSON claims, preserving their field order.
+func signTestToken(t *testing.T, claims string, secret []byte) []byte {
+	t.Helper()
+	signingString := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(claims))
+	sig, err := jwt.SigningMethodHS256.Sign(signingString, secret)
+	require.NoError(t, err)
+	return []byte(signingString + "." + sig)
+}
+
+func TestReadings(t *testing.T) {
+	reef := &LDE{SUD: SUD{Type: ReefSUD, Data: Data{
+		Status:      SUDStatus{Water: 1, Kelvin: 1},
+		Temperature: 25.5, PH: 8.1, NH3: 0.002, Kelvin: 14000, Lux: 9000, PAR: 166,
+	}}}
+	assert.Equal(t, []Reading{
+		{Name: "temperature", Value: 25.5},
+		{Name: "ph", Value: 8.1},
+		{Name: "nh3", Value: 0.002},
+		{Name: "kelvin", Value: 14000},
+		{Name: "lux", Value: 9000},
+		{Name: "par", Value: 166},
+		{Name: "water", Value: 1, Status: true},
+		{Name: "temperature", Value: 0, Status: true},
+		{Name: "ph", Value: 0, Status: true},
+		{Name: "nh3", Value: 0, Status: true},
+		{Name: "slide", Value: 0, Status: true},
+		{Name: "kelvin", Value: 1, Status: true},
+	}, reef.Readings())
+
+	// Home SUDs lack the kelvin and PAR sensors, but have the fresh water total ammonia estimate.
+	home := &LDE{SUD: SUD{Type: HomeSUD, Data: Data{Temperature: 25, PH: 7, NH3: 0.01}}}
+	var names []string
+	for _, r := range home.Readings() {
+		if !r.Status {
+			names = append(names, r.Name)
+		}
+	}
+	assert.Equal(t, []string{"temperature", "ph", "nh3", "lux", "total_ammonia"}, names)
+}
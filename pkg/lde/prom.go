@@ -1,88 +1,214 @@
 package lde
 
 import (
+	"context"
+	"io"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
 )
 
+var labels = []string{"id", "name", "sud_type"}
+
+// Metric describes a metric exported for each SUD, for generating queries, dashboards and alerts
+// over them.
+type Metric struct {
+	// Name is the metric's name, ex. "temperature_celsius".
+	Name string
+	// Help is the metric's help text.
+	Help string
+	// Title is a short human readable name, ex. "Temperature".
+	Title string
+	// Unit is the unit of the metric's value, ex. "°C", or "" if it's unitless.
+	Unit string
+	// Reading is the name of the Reading the metric exports, or "" if it isn't a Reading.
+	Reading string
+	// Status is true if the metric is a status flag.
+	Status bool
+	// Derived is true if the exporter derives the metric from other readings, rather than the
+	// SUD reporting it.
+	Derived bool
+}
+
 var (
-	labels = []string{"id", "name", "sud_type"}
+	// metrics are the metrics exported for each SUD, in the order they're collected.
+	metrics []Metric
+	// metricDescs are the descriptors of metrics.
+	metricDescs []*prometheus.Desc
+)
 
-	tempDesc = prometheus.NewDesc(
-		"temperature_celsius",
-		"Water temperature in celsius",
-		labels, nil,
-	)
-	phDesc = prometheus.NewDesc(
-		"ph",
-		"Water pH",
-		labels, nil,
-	)
-	ammoniaDesc = prometheus.NewDesc(
-		"ammonia",
-		"PPM Water NH3 free ammonia",
-		labels, nil,
-	)
-	kelvinDesc = prometheus.NewDesc(
-		"light_kelvin",
-		"Kelvin is the numeric Correlated Color Temperature value of the colour temperature in degrees Kelvin.",
-		labels, nil,
-	)
-	luxDesc = prometheus.NewDesc(
-		"light_lux",
-		"Lux describes the intensity of the light observed in the tank. ",
-		labels, nil,
-	)
-	parDesc = prometheus.NewDesc(
-		"light_par",
-		"PAR describes the photosynthetic active radiation is a measurement of light power between 400nm and 700nm.",
-		labels, nil,
-	)
+// newDesc records the metric and returns its descriptor.
+func newDesc(m Metric) *prometheus.Desc {
+	d := prometheus.NewDesc(m.Name, m.Help, labels, nil)
+	metrics = append(metrics, m)
+	metricDescs = append(metricDescs, d)
+	return d
+}
 
-	statusWaterDesc = prometheus.NewDesc(
-		"seneye_status_water",
-		"Water is 1 if the SUD is submerged in water, 0 otherwise.",
-		labels, nil,
-	)
-	statusTemperatureDesc = prometheus.NewDesc(
-		"seneye_status_temperature",
-		"Temperature is 0 if the temperature is within limits, 1 otherwise.",
-		labels, nil,
-	)
-	statusPhDesc = prometheus.NewDesc(
-		"seneye_status_ph",
-		"PH is 0 if the pH is within limits, 1 otherwise.",
-		labels, nil,
-	)
-	statusAmmoniaDesc = prometheus.NewDesc(
-		"seneye_status_ammonia",
-		"Ammonia (NH3) is 0 if the free ammonia is within limits, 1 otherwise.",
-		labels, nil,
-	)
-	statusSlideDesc = prometheus.NewDesc(
-		"seneye_status_slide",
-		"Slide is 0 if the slide is correctly installed and unexpired, 1 otherwise.",
-		labels, nil,
+// Metrics describes the metrics exported for each SUD: its readings, derived readings, and status
+// flags. Each SUD only exports the metrics its sensors support.
+func Metrics() []Metric {
+	return append([]Metric(nil), metrics...)
+}
+
+var (
+	tempDesc = newDesc(Metric{
+		Name:    "temperature_celsius",
+		Help:    "Water temperature in celsius",
+		Title:   "Temperature",
+		Unit:    "°C",
+		Reading: "temperature",
+	})
+	phDesc = newDesc(Metric{
+		Name:    "ph",
+		Help:    "Water pH",
+		Title:   "pH",
+		Reading: "ph",
+	})
+	ammoniaDesc = newDesc(Metric{
+		Name:    "ammonia",
+		Help:    "PPM Water NH3 free ammonia",
+		Title:   "Free Ammonia (NH3)",
+		Unit:    "ppm",
+		Reading: "nh3",
+	})
+	kelvinDesc = newDesc(Metric{
+		Name:    "light_kelvin",
+		Help:    "Kelvin is the numeric Correlated Color Temperature value of the colour temperature in degrees Kelvin.",
+		Title:   "Light Color Temperature",
+		Unit:    "K",
+		Reading: "kelvin",
+	})
+	luxDesc = newDesc(Metric{
+		Name:    "light_lux",
+		Help:    "Lux describes the intensity of the light observed in the tank. ",
+		Title:   "Light Intensity",
+		Unit:    "lx",
+		Reading: "lux",
+	})
+	parDesc = newDesc(Metric{
+		Name:    "light_par",
+		Help:    "PAR describes the photosynthetic active radiation is a measurement of light power between 400nm and 700nm.",
+		Title:   "PAR",
+		Unit:    "µmol/m²/s",
+		Reading: "par",
+	})
+	totalAmmoniaDesc = newDesc(Metric{
+		Name:    "total_ammonia",
+		Help:    "Estimated PPM of total ammonia (NH3 + NH4), derived from free ammonia, pH and temperature. Only exported for fresh water SUDs.",
+		Title:   "Total Ammonia (NH3 + NH4)",
+		Unit:    "ppm",
+		Reading: "total_ammonia",
+		Derived: true,
+	})
+	dailyLightDesc = newDesc(Metric{
+		Name:    "light_daily_integral",
+		Help:    "Daily light integral (DLI) so far today in mol/m², integrated from PAR readings. Only exported for PAR capable SUDs.",
+		Title:   "Daily Light Integral",
+		Unit:    "mol/m²",
+		Derived: true,
+	})
+
+	dataValueDesc = prometheus.NewDesc(
+		"seneye_data_value",
+		"Value of an LDE reading not otherwise understood by this exporter, labeled with its LDE field name.",
+		append(labels, "field"), nil,
 	)
-	statusKelvinDesc = prometheus.NewDesc(
-		"seneye_status_kelvin",
-		"Kelvin is 0 if the Kelvin measurement is within limits, 1 otherwise.",
-		labels, nil,
+
+	sinkUpDesc = prometheus.NewDesc(
+		"seneye_sink_up",
+		"1 if the sink's last delivery to its remote service succeeded, 0 otherwise.",
+		[]string{"sink"}, nil,
 	)
+
+	statusWaterDesc = newDesc(Metric{
+		Name:    "seneye_status_water",
+		Help:    "Water is 1 if the SUD is submerged in water, 0 otherwise.",
+		Title:   "In Water",
+		Reading: "water",
+		Status:  true,
+	})
+	statusTemperatureDesc = newDesc(Metric{
+		Name:    "seneye_status_temperature",
+		Help:    "Temperature is 0 if the temperature is within limits, 1 otherwise.",
+		Title:   "Temperature",
+		Reading: "temperature",
+		Status:  true,
+	})
+	statusPhDesc = newDesc(Metric{
+		Name:    "seneye_status_ph",
+		Help:    "PH is 0 if the pH is within limits, 1 otherwise.",
+		Title:   "pH",
+		Reading: "ph",
+		Status:  true,
+	})
+	statusAmmoniaDesc = newDesc(Metric{
+		Name:    "seneye_status_ammonia",
+		Help:    "Ammonia (NH3) is 0 if the free ammonia is within limits, 1 otherwise.",
+		Title:   "Ammonia",
+		Reading: "nh3",
+		Status:  true,
+	})
+	statusSlideDesc = newDesc(Metric{
+		Name:    "seneye_status_slide",
+		Help:    "Slide is 0 if the slide is correctly installed and unexpired, 1 otherwise.",
+		Title:   "Slide",
+		Reading: "slide",
+		Status:  true,
+	})
+	statusKelvinDesc = newDesc(Metric{
+		Name:    "seneye_status_kelvin",
+		Help:    "Kelvin is 0 if the Kelvin measurement is within limits, 1 otherwise.",
+		Title:   "Kelvin",
+		Reading: "kelvin",
+		Status:  true,
+	})
 )
 
+// MetricName returns the name of the metric exported for the reading, ex. "temperature_celsius" or
+// "seneye_status_water", or "" if the reading isn't known.
+func MetricName(r Reading) string {
+	for _, m := range metrics {
+		if m.Reading != "" && m.Reading == r.Name && m.Status == r.Status {
+			return m.Name
+		}
+	}
+	return ""
+}
+
 // Describe implements prometheus.Collector.
-func (l *Server) Describe(ch chan<- *prometheus.Desc) {
-	prometheus.DescribeByCollect(l, ch)
+func (s *Server) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range metricDescs {
+		ch <- d
+	}
+	ch <- dataValueDesc
+	ch <- sinkUpDesc
+	ch <- relayForwardedDesc
+	ch <- relayRetriesDesc
+	ch <- relayDroppedDesc
+	ch <- relayQueueDesc
 }
 
 // Collect implements prometheus.Collector.
-func (l *Server) Collect(ch chan<- prometheus.Metric) {
-	l.lock.Lock()
-	defer l.lock.Unlock()
-	for _, l := range l.lastLDEs {
+func (s *Server) Collect(ch chan<- prometheus.Metric) {
+	for _, r := range s.relays {
+		r.collect(ch)
+	}
+	for _, sink := range s.sinks {
+		if rs, ok := sink.(RemoteSink); ok {
+			up := 1.0
+			if rs.Healthy(context.Background()) != nil {
+				up = 0
+			}
+			ch <- prometheus.MustNewConstMetric(sinkUpDesc, prometheus.GaugeValue, up, rs.Name())
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, l := range s.lastLDEs {
 		labels := []string{l.SUD.ID, l.SUD.Name, l.SUD.Type.String()}
+		caps := l.SUD.Type.Capabilities()
 		t := time.Unix(l.SUD.Timestamp, 0)
 		ch <- prometheus.NewMetricWithTimestamp(t, prometheus.MustNewConstMetric(
 			tempDesc,
@@ -102,24 +228,49 @@ func (l *Server) Collect(ch chan<- prometheus.Metric) {
 			float64(l.SUD.Data.NH3),
 			labels...,
 		))
-		ch <- prometheus.NewMetricWithTimestamp(t, prometheus.MustNewConstMetric(
-			kelvinDesc,
-			prometheus.GaugeValue,
-			float64(l.SUD.Data.Kelvin),
-			labels...,
-		))
-		ch <- prometheus.NewMetricWithTimestamp(t, prometheus.MustNewConstMetric(
-			luxDesc,
-			prometheus.GaugeValue,
-			float64(l.SUD.Data.Lux),
-			labels...,
-		))
-		ch <- prometheus.NewMetricWithTimestamp(t, prometheus.MustNewConstMetric(
-			parDesc,
-			prometheus.GaugeValue,
-			float64(l.SUD.Data.PAR),
-			labels...,
-		))
+		if caps.Kelvin {
+			ch <- prometheus.NewMetricWithTimestamp(t, prometheus.MustNewConstMetric(
+				kelvinDesc,
+				prometheus.GaugeValue,
+				float64(l.SUD.Data.Kelvin),
+				labels...,
+			))
+		}
+		if caps.Lux {
+			ch <- prometheus.NewMetricWithTimestamp(t, prometheus.MustNewConstMetric(
+				luxDesc,
+				prometheus.GaugeValue,
+				float64(l.SUD.Data.Lux),
+				labels...,
+			))
+		}
+		if caps.PAR {
+			ch <- prometheus.NewMetricWithTimestamp(t, prometheus.MustNewConstMetric(
+				parDesc,
+				prometheus.GaugeValue,
+				float64(l.SUD.Data.PAR),
+				labels...,
+			))
+		}
+		if caps.FreshWater {
+			if ppm, ok := l.SUD.Data.TotalAmmonia(); ok {
+				ch <- prometheus.NewMetricWithTimestamp(t, prometheus.MustNewConstMetric(
+					totalAmmoniaDesc,
+					prometheus.GaugeValue,
+					ppm,
+					labels...,
+				))
+			}
+		}
+		if dl, ok := s.dailyLight[l.SUD.ID]; ok && caps.PAR {
+			integral, changed := dl.current(s.now(), s.location)
+			ch <- prometheus.NewMetricWithTimestamp(changed, prometheus.MustNewConstMetric(
+				dailyLightDesc,
+				prometheus.GaugeValue,
+				integral,
+				labels...,
+			))
+		}
 
 		ch <- prometheus.NewMetricWithTimestamp(t, prometheus.MustNewConstMetric(
 			statusWaterDesc,
@@ -151,11 +302,45 @@ func (l *Server) Collect(ch chan<- prometheus.Metric) {
 			float64(l.SUD.Data.Status.Slide),
 			labels...,
 		))
-		ch <- prometheus.NewMetricWithTimestamp(t, prometheus.MustNewConstMetric(
-			statusKelvinDesc,
-			prometheus.GaugeValue,
-			float64(l.SUD.Data.Status.Kelvin),
-			labels...,
-		))
+		if caps.Kelvin {
+			ch <- prometheus.NewMetricWithTimestamp(t, prometheus.MustNewConstMetric(
+				statusKelvinDesc,
+				prometheus.GaugeValue,
+				float64(l.SUD.Data.Status.Kelvin),
+				labels...,
+			))
+		}
+
+		for field, v := range l.SUD.Data.UnknownValues() {
+			ch <- prometheus.NewMetricWithTimestamp(t, prometheus.MustNewConstMetric(
+				dataValueDesc,
+				prometheus.GaugeValue,
+				v,
+				append(labels, field)...,
+			))
+		}
+	}
+}
+
+// WriteMetrics writes the metrics the collector would export for the LDEs, in the Prometheus text
+// exposition format.
+func WriteMetrics(w io.Writer, ldes ...*LDE) error {
+	reg := prometheus.NewPedanticRegistry()
+	s, err := NewServer(WithPrometheus(reg))
+	if err != nil {
+		return err
+	}
+	for _, l := range ldes {
+		s.record(l)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		return err
+	}
+	for _, mf := range families {
+		if _, err := expfmt.MetricFamilyToText(w, mf); err != nil {
+			return err
+		}
 	}
+	return nil
 }
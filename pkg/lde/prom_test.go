@@ -1,6 +1,10 @@
 package lde
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/stretchr/testify/assert"
@@ -9,6 +13,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestCollect(t *testing.T) {
@@ -17,7 +22,7 @@ func TestCollect(t *testing.T) {
 			Name:      "example",
 			ID:        "1234",
 			Timestamp: 1610505992,
-			Type:      HomeSUD,
+			Type:      ReefSUD,
 			Data: Data{
 				Status: SUDStatus{
 					Water:       1,
@@ -47,39 +52,255 @@ func TestCollect(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, `# HELP ammonia PPM Water NH3 free ammonia
 # TYPE ammonia gauge
-ammonia{id="1234",name="example",sud_type="home"} 0.01 1610505992000
+ammonia{id="1234",name="example",sud_type="reef"} 0.01 1610505992000
 # HELP light_kelvin Kelvin is the numeric Correlated Color Temperature value of the colour temperature in degrees Kelvin.
 # TYPE light_kelvin gauge
-light_kelvin{id="1234",name="example",sud_type="home"} 100 1610505992000
+light_kelvin{id="1234",name="example",sud_type="reef"} 100 1610505992000
 # HELP light_lux Lux describes the intensity of the light observed in the tank. 
 # TYPE light_lux gauge
-light_lux{id="1234",name="example",sud_type="home"} 200 1610505992000
+light_lux{id="1234",name="example",sud_type="reef"} 200 1610505992000
 # HELP light_par PAR describes the photosynthetic active radiation is a measurement of light power between 400nm and 700nm.
 # TYPE light_par gauge
-light_par{id="1234",name="example",sud_type="home"} 300 1610505992000
+light_par{id="1234",name="example",sud_type="reef"} 300 1610505992000
 # HELP ph Water pH
 # TYPE ph gauge
-ph{id="1234",name="example",sud_type="home"} 7 1610505992000
+ph{id="1234",name="example",sud_type="reef"} 7 1610505992000
 # HELP seneye_status_ammonia Ammonia (NH3) is 0 if the free ammonia is within limits, 1 otherwise.
 # TYPE seneye_status_ammonia gauge
-seneye_status_ammonia{id="1234",name="example",sud_type="home"} 0 1610505992000
+seneye_status_ammonia{id="1234",name="example",sud_type="reef"} 0 1610505992000
 # HELP seneye_status_kelvin Kelvin is 0 if the Kelvin measurement is within limits, 1 otherwise.
 # TYPE seneye_status_kelvin gauge
-seneye_status_kelvin{id="1234",name="example",sud_type="home"} 0 1610505992000
+seneye_status_kelvin{id="1234",name="example",sud_type="reef"} 0 1610505992000
 # HELP seneye_status_ph PH is 0 if the pH is within limits, 1 otherwise.
 # TYPE seneye_status_ph gauge
-seneye_status_ph{id="1234",name="example",sud_type="home"} 0 1610505992000
+seneye_status_ph{id="1234",name="example",sud_type="reef"} 0 1610505992000
 # HELP seneye_status_slide Slide is 0 if the slide is correctly installed and unexpired, 1 otherwise.
 # TYPE seneye_status_slide gauge
-seneye_status_slide{id="1234",name="example",sud_type="home"} 0 1610505992000
+seneye_status_slide{id="1234",name="example",sud_type="reef"} 0 1610505992000
 # HELP seneye_status_temperature Temperature is 0 if the temperature is within limits, 1 otherwise.
 # TYPE seneye_status_temperature gauge
-seneye_status_temperature{id="1234",name="example",sud_type="home"} 1 1610505992000
+seneye_status_temperature{id="1234",name="example",sud_type="reef"} 1 1610505992000
 # HELP seneye_status_water Water is 1 if the SUD is submerged in water, 0 otherwise.
 # TYPE seneye_status_water gauge
-seneye_status_water{id="1234",name="example",sud_type="home"} 1 1610505992000
+seneye_status_water{id="1234",name="example",sud_type="reef"} 1 1610505992000
 # HELP temperature_celsius Water temperature in celsius
 # TYPE temperature_celsius gauge
-temperature_celsius{id="1234",name="example",sud_type="home"} 21.3 1610505992000
+temperature_celsius{id="1234",name="example",sud_type="reef"} 21.3 1610505992000
 `, string(b))
 }
+
+func TestCollectUnknownData(t *testing.T) {
+	s := &Server{
+		lastLDEs: map[string]*LDE{
+			"1234": {
+				SUD: SUD{
+					Name:      "example",
+					ID:        "1234",
+					Timestamp: 1610505992,
+					Type:      HomeSUD,
+					Data: Data{
+						Unknown: map[string]json.RawMessage{
+							"O": json.RawMessage(`8.5`),
+							"X": json.RawMessage(`"not a number"`),
+						},
+					},
+				},
+			},
+		},
+	}
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(s)
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	var found bool
+	for _, mf := range mfs {
+		if mf.GetName() != "seneye_data_value" {
+			continue
+		}
+		found = true
+		require.Len(t, mf.Metric, 1)
+		assert.Equal(t, 8.5, mf.Metric[0].GetGauge().GetValue())
+		assert.Equal(t, "field", mf.Metric[0].Label[0].GetName())
+		assert.Equal(t, "O", mf.Metric[0].Label[0].GetValue())
+	}
+	assert.True(t, found)
+}
+
+func TestCollectByType(t *testing.T) {
+	secret := []byte("AAAAAAAA")
+	lightMetrics := []string{"light_kelvin", "light_lux", "light_par", "light_daily_integral", "seneye_status_kelvin"}
+	tcs := []struct {
+		sudType  SUDType
+		present  []string
+		absent   []string
+		expected map[string]float64
+	}{
+		{
+			sudType: HomeSUD,
+			present: []string{"light_lux", "total_ammonia"},
+			absent:  []string{"light_kelvin", "light_par", "light_daily_integral", "seneye_status_kelvin"},
+		},
+		{
+			sudType: PondSUD,
+			present: []string{"light_lux", "total_ammonia"},
+			absent:  []string{"light_kelvin", "light_par", "light_daily_integral", "seneye_status_kelvin"},
+		},
+		{
+			sudType: ReefSUD,
+			present: lightMetrics,
+			absent:  []string{"total_ammonia"},
+			expected: map[string]float64{
+				// 100 µmol/m²/s for the 600 seconds between readings.
+				"light_daily_integral": 0.06,
+			},
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.sudType.String(), func(t *testing.T) {
+			s := newTestServer(t, WithSecrets(map[string][]byte{"": secret}), WithLocation(time.UTC))
+			s.now = func() time.Time { return time.Unix(1610505600, 0) }
+			for _, ts := range []int{1610505000, 1610505600} {
+				claims := fmt.Sprintf(`{"version":"1.0.0","SUD":{"id":"1234","name":"example","type":%d,"TS":%d,`+
+					`"data":{"S":{"W":1},"T":25,"P":8.2,"N":0.02,"K":12000,"L":1500,"A":100}}}`, tc.sudType, ts)
+				req := httptest.NewRequest(http.MethodPost, "/lde", bytes.NewReader(signTestToken(t, claims, secret)))
+				rec := httptest.NewRecorder()
+				s.ServeHTTP(rec, req)
+				require.Equal(t, http.StatusNoContent, rec.Code)
+			}
+
+			reg := prometheus.NewPedanticRegistry()
+			reg.MustRegister(s)
+			mfs, err := reg.Gather()
+			require.NoError(t, err)
+			values := make(map[string]float64)
+			for _, mf := range mfs {
+				require.Len(t, mf.Metric, 1)
+				values[mf.GetName()] = mf.Metric[0].GetGauge().GetValue()
+			}
+			for _, name := range tc.present {
+				assert.Contains(t, values, name)
+			}
+			for _, name := range tc.absent {
+				assert.NotContains(t, values, name)
+			}
+			for name, v := range tc.expected {
+				assert.InDelta(t, v, values[name], 1e-9, name)
+			}
+		})
+	}
+}
+
+func TestTotalAmmonia(t *testing.T) {
+	d := Data{Temperature: 25, PH: 7, NH3: 0.01}
+	ppm, ok := d.TotalAmmonia()
+	require.True(t, ok)
+	// At 25°C and pH 7, ~0.56% of total ammonia is free NH3.
+	assert.InDelta(t, 1.77, ppm, 0.01)
+
+	_, ok = (&Data{}).TotalAmmonia()
+	assert.False(t, ok)
+}
+
+func TestMetricName(t *testing.T) {
+	s := newTestServer(t)
+	l := &LDE{SUD: SUD{ID: "1234", Type: ReefSUD, Data: Data{Status: SUDStatus{Water: 1}, Temperature: 25, PH: 8.1}}}
+	s.lastLDEs["1234"] = l
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(s)
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	exported := map[string]bool{}
+	for _, mf := range mfs {
+		exported[mf.GetName()] = true
+	}
+	// Every reading of a reef SUD, which has every sensor, is exported with its metric name.
+	for _, r := range l.Readings() {
+		assert.True(t, exported[MetricName(r)], "%+v", r)
+	}
+	// Total ammonia is only exported for fresh water SUDs.
+	assert.Equal(t, "total_ammonia", MetricName(Reading{Name: "total_ammonia"}))
+	assert.Equal(t, "", MetricName(Reading{Name: "salinity"}))
+}
+
+func TestMetrics(t *testing.T) {
+	secret := []byte("AAAAAAAA")
+	s := newTestServer(t, WithSecrets(map[string][]byte{"": secret}), WithLocation(time.UTC))
+	for id, sudType := range map[string]SUDType{"home": HomeSUD, "reef": ReefSUD} {
+		for _, ts := range []int{1610505000, 1610505600} {
+			claims := fmt.Sprintf(`{"version":"1.0.0","SUD":{"id":%q,"type":%d,"TS":%d,`+
+				`"data":{"S":{"W":1},"T":25,"P":8.2,"N":0.02,"K":12000,"L":1500,"A":100}}}`, id, sudType, ts)
+			req := httptest.NewRequest(http.MethodPost, "/lde", bytes.NewReader(signTestToken(t, claims, secret)))
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusNoContent, rec.Code)
+		}
+	}
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(s)
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	var exported []string
+	for _, mf := range mfs {
+		exported = append(exported, mf.GetName())
+	}
+
+	// Between them, home and reef SUDs export every described metric.
+	var described []string
+	for _, m := range Metrics() {
+		described = append(described, m.Name)
+		assert.NotEmpty(t, m.Title, m.Name)
+		if m.Reading != "" {
+			assert.Equal(t, m.Name, MetricName(Reading{Name: m.Reading, Status: m.Status}))
+		}
+	}
+	assert.ElementsMatch(t, exported, described)
+}
+
+func TestDescribe(t *testing.T) {
+	ch := make(chan *prometheus.Desc, 100)
+	(&Server{}).Describe(ch)
+	close(ch)
+	described := make(map[string]bool)
+	for d := range ch {
+		described[d.String()] = true
+	}
+	// The pedantic registries of the other tests check collected metrics against these.
+	assert.Len(t, described, len(Metrics())+6)
+	for _, d := range []*prometheus.Desc{dataValueDesc, sinkUpDesc, relayForwardedDesc, relayQueueDesc} {
+		assert.True(t, described[d.String()], d.String())
+	}
+}
+
+func TestCollectDailyLightRollover(t *testing.T) {
+	secret := []byte("AAAAAAAA")
+	loc := time.FixedZone("UTC+10", 10*60*60)
+	s := newTestServer(t, WithSecrets(map[string][]byte{"": secret}), WithLocation(loc))
+	// 2021-01-13 12:30 and 12:40 in UTC+10.
+	for _, ts := range []int{1610505000, 1610505600} {
+		claims := fmt.Sprintf(`{"version":"1.0.0","SUD":{"id":"1234","type":3,"TS":%d,"data":{"A":100}}}`, ts)
+		require.NoError(t, s.Ingest(context.Background(), &Push{Raw: signTestToken(t, claims, secret)}))
+	}
+	dailyLight := func(now time.Time) (float64, int64) {
+		s.now = func() time.Time { return now }
+		reg := prometheus.NewPedanticRegistry()
+		reg.MustRegister(s)
+		mfs, err := reg.Gather()
+		require.NoError(t, err)
+		for _, mf := range mfs {
+			if mf.GetName() == "light_daily_integral" {
+				return mf.Metric[0].GetGauge().GetValue(), mf.Metric[0].GetTimestampMs()
+			}
+		}
+		t.Fatal("light_daily_integral not exported")
+		return 0, 0
+	}
+
+	v, ts := dailyLight(time.Date(2021, 1, 13, 23, 59, 0, 0, loc))
+	assert.InDelta(t, 0.06, v, 1e-9)
+	assert.Equal(t, int64(1610505600000), ts)
+	// Without a reading since, the integral is reset at midnight in the location.
+	v, ts = dailyLight(time.Date(2021, 1, 14, 0, 1, 0, 0, loc))
+	assert.Equal(t, 0.0, v)
+	assert.Equal(t, time.Date(2021, 1, 14, 0, 0, 0, 0, loc).UnixNano()/1e6, ts)
+}
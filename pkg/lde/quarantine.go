@@ -0,0 +1,126 @@
+package lde
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// Rejection describes an LDE push which failed parsing or validation. The header and claims are
+// decoded without verifying the signature, and must not be trusted.
+type Rejection struct {
+	// Time is when the push was received.
+	Time time.Time `json:"time"`
+	// Source is the IP address of the pushing client.
+	Source string `json:"source"`
+	// Reason describes why the push was rejected.
+	Reason string `json:"reason"`
+	// SUDID is the unverified SUD ID claimed by the push, if it could be decoded.
+	SUDID string `json:"sud_id,omitempty"`
+	// Header is the unverified JWT header, if it could be decoded.
+	Header map[string]interface{} `json:"header,omitempty"`
+	// Claims is the unverified JWT claims, if they could be decoded.
+	Claims json.RawMessage `json:"claims,omitempty"`
+	// Body is the raw request body.
+	Body string `json:"body"`
+}
+
+// NewRejection builds a Rejection, decoding what it can of the untrusted body.
+func NewRejection(source string, body []byte, reason error) Rejection {
+	r := Rejection{
+		Time:   time.Now(),
+		Source: source,
+		Reason: reason.Error(),
+		Body:   string(body),
+	}
+	token := string(fixEncoding(append([]byte(nil), body...)))
+	r.Header, r.Claims, r.SUDID = decodeUnverified(token)
+	return r
+}
+
+// Quarantine holds the most recent rejected LDE pushes from each source so misconfigured
+// devices can be debugged. It is bounded both in the number of sources and the number of
+// rejections kept per source.
+type Quarantine struct {
+	lock       sync.Mutex
+	perSource  int
+	maxSources int
+	sources    map[string][]Rejection
+	// lastSeen records the time of each source's latest rejection, used to evict the stalest.
+	lastSeen map[string]time.Time
+}
+
+// NewQuarantine creates a Quarantine keeping up to perSource rejections from each of up to
+// maxSources sources.
+func NewQuarantine(perSource, maxSources int) *Quarantine {
+	return &Quarantine{
+		perSource:  perSource,
+		maxSources: maxSources,
+		sources:    make(map[string][]Rejection),
+		lastSeen:   make(map[string]time.Time),
+	}
+}
+
+// Add records a rejection, evicting the oldest rejections as needed.
+func (q *Quarantine) Add(r Rejection) {
+	if q.perSource <= 0 || q.maxSources <= 0 {
+		return
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if _, ok := q.sources[r.Source]; !ok && len(q.sources) >= q.maxSources {
+		var stalest string
+		for source, t := range q.lastSeen {
+			if stalest == "" || t.Before(q.lastSeen[stalest]) {
+				stalest = source
+			}
+		}
+		delete(q.sources, stalest)
+		delete(q.lastSeen, stalest)
+	}
+	rejections := append(q.sources[r.Source], r)
+	if len(rejections) > q.perSource {
+		rejections = rejections[len(rejections)-q.perSource:]
+	}
+	q.sources[r.Source] = rejections
+	q.lastSeen[r.Source] = r.Time
+}
+
+// Rejections returns all quarantined rejections, oldest first.
+func (q *Quarantine) Rejections() []Rejection {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	var out []Rejection
+	for _, rejections := range q.sources {
+		out = append(out, rejections...)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Time.Before(out[j].Time)
+	})
+	return out
+}
+
+// ServeHTTP lists the quarantined rejections as JSON. It performs no authentication of its own.
+func (q *Quarantine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rejections := q.Rejections()
+	if source := r.URL.Query().Get("source"); source != "" {
+		filtered := rejections[:0]
+		for _, rej := range rejections {
+			if rej.Source == source {
+				filtered = append(filtered, rej)
+			}
+		}
+		rejections = filtered
+	}
+	if rejections == nil {
+		rejections = []Rejection{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rejections); err != nil {
+		hlog.FromRequest(r).Warn().Err(err).Msg("writing quarantine response")
+	}
+}
@@ -0,0 +1,80 @@
+package lde
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuarantineBounds(t *testing.T) {
+	q := NewQuarantine(2, 2)
+	start := time.Unix(1610505992, 0)
+	add := func(source string, i int) {
+		q.Add(Rejection{Time: start.Add(time.Duration(i) * time.Second), Source: source, Reason: source})
+	}
+	add("10.0.0.1", 0)
+	add("10.0.0.1", 1)
+	add("10.0.0.1", 2)
+	add("10.0.0.2", 3)
+	// 10.0.0.1 is the stalest source, so it is evicted to make room.
+	add("10.0.0.3", 4)
+
+	rejections := q.Rejections()
+	require.Len(t, rejections, 2)
+	assert.Equal(t, "10.0.0.2", rejections[0].Source)
+	assert.Equal(t, "10.0.0.3", rejections[1].Source)
+
+	add("10.0.0.3", 5)
+	add("10.0.0.3", 6)
+	rejections = q.Rejections()
+	require.Len(t, rejections, 3)
+	assert.Equal(t, start.Add(5*time.Second), rejections[1].Time)
+	assert.Equal(t, start.Add(6*time.Second), rejections[2].Time)
+}
+
+func TestNewRejection(t *testing.T) {
+	claims := `{"version":"1.0.0","SUD":{"id":"1234","name":"Office","type":1,"TS":1609561222}}`
+	body := signTestToken(t, claims, []byte("wrong"))
+	r := NewRejection("10.0.0.1", body, errors.New("signature is invalid"))
+	assert.Equal(t, "1234", r.SUDID)
+	assert.Equal(t, "HS256", r.Header["alg"])
+	assert.JSONEq(t, claims, string(r.Claims))
+	assert.Equal(t, string(body), r.Body)
+
+	r = NewRejection("10.0.0.1", []byte("garbage"), errors.New("token contains an invalid number of segments"))
+	assert.Empty(t, r.SUDID)
+	assert.Nil(t, r.Header)
+	assert.Nil(t, r.Claims)
+}
+
+func TestServerQuarantinesRejections(t *testing.T) {
+	q := NewQuarantine(10, 10)
+	s := newTestServer(t, WithSecrets(map[string][]byte{"": []byte("AAAAAAAA")}), WithQuarantine(q))
+	claims := `{"version":"1.0.0","SUD":{"id":"1234","name":"Office","type":1,"TS":1609561222}}`
+	req := httptest.NewRequest(http.MethodPost, "/lde", bytes.NewReader(signTestToken(t, claims, []byte("wrong"))))
+	req.RemoteAddr = "192.0.2.10:4321"
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	q.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/quarantine?source=192.0.2.10", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var rejections []Rejection
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &rejections))
+	require.Len(t, rejections, 1)
+	assert.Equal(t, "192.0.2.10", rejections[0].Source)
+	assert.Equal(t, "1234", rejections[0].SUDID)
+	assert.Equal(t, "signature is invalid", rejections[0].Reason)
+
+	rec = httptest.NewRecorder()
+	q.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/quarantine?source=192.0.2.11", nil))
+	assert.JSONEq(t, `[]`, rec.Body.String())
+}
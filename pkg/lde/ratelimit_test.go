@@ -0,0 +1,37 @@
+package lde
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1610505992, 0)
+	rl := newRateLimiter(0.5, 2)
+	rl.now = func() time.Time { return now }
+
+	ok, _ := rl.allow("10.0.0.1")
+	assert.True(t, ok)
+	ok, _ = rl.allow("10.0.0.1")
+	assert.True(t, ok)
+	ok, wait := rl.allow("10.0.0.1")
+	assert.False(t, ok)
+	assert.Equal(t, 2*time.Second, wait)
+
+	// Other sources have their own bucket.
+	ok, _ = rl.allow("10.0.0.2")
+	assert.True(t, ok)
+
+	now = now.Add(2 * time.Second)
+	ok, _ = rl.allow("10.0.0.1")
+	assert.True(t, ok)
+	ok, _ = rl.allow("10.0.0.1")
+	assert.False(t, ok)
+
+	// Idle buckets are swept once they would have refilled.
+	now = now.Add(time.Minute)
+	rl.allow("10.0.0.3")
+	assert.Len(t, rl.buckets, 1)
+}
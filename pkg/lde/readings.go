@@ -0,0 +1,49 @@
+package lde
+
+// Reading is one named value from an LDE, for sinks which export readings individually.
+type Reading struct {
+	// Name identifies the reading, ex. "temperature", or for status flags, ex. "water".
+	Name string
+	// Value is the reading, or the status flag's value.
+	Value float64
+	// Status is true if the reading is a status flag from SUDStatus.
+	Status bool
+}
+
+// Readings returns the readings the SUD's sensors support, followed by its status flags,
+// matching the metrics exported to prometheus. The total ammonia estimate is included for fresh
+// water SUDs when the readings allow it.
+func (l *LDE) Readings() []Reading {
+	d := l.SUD.Data
+	caps := l.SUD.Type.Capabilities()
+	out := []Reading{
+		{Name: "temperature", Value: d.Temperature},
+		{Name: "ph", Value: d.PH},
+		{Name: "nh3", Value: d.NH3},
+	}
+	if caps.Kelvin {
+		out = append(out, Reading{Name: "kelvin", Value: d.Kelvin})
+	}
+	if caps.Lux {
+		out = append(out, Reading{Name: "lux", Value: d.Lux})
+	}
+	if caps.PAR {
+		out = append(out, Reading{Name: "par", Value: d.PAR})
+	}
+	if caps.FreshWater {
+		if ppm, ok := d.TotalAmmonia(); ok {
+			out = append(out, Reading{Name: "total_ammonia", Value: ppm})
+		}
+	}
+	out = append(out,
+		Reading{Name: "water", Value: float64(d.Status.Water), Status: true},
+		Reading{Name: "temperature", Value: float64(d.Status.Temperature), Status: true},
+		Reading{Name: "ph", Value: float64(d.Status.PH), Status: true},
+		Reading{Name: "nh3", Value: float64(d.Status.NH3), Status: true},
+		Reading{Name: "slide", Value: float64(d.Status.Slide), Status: true},
+	)
+	if caps.Kelvin {
+		out = append(out, Reading{Name: "kelvin", Value: float64(d.Status.Kelvin), Status: true})
+	}
+	return out
+}
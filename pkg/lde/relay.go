@@ -0,0 +1,229 @@
+package lde
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/internal/queue"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	relayLabels = []string{"target"}
+
+	relayForwardedDesc = prometheus.NewDesc(
+		"seneye_relay_forwarded_total",
+		"LDE pushes forwarded to the relay target.",
+		relayLabels, nil,
+	)
+	relayRetriesDesc = prometheus.NewDesc(
+		"seneye_relay_retries_total",
+		"Failed attempts to forward an LDE push to the relay target which were retried.",
+		relayLabels, nil,
+	)
+	relayDroppedDesc = prometheus.NewDesc(
+		"seneye_relay_dropped_total",
+		"LDE pushes not forwarded to the relay target because its queue was full, it rejected them, or the exporter shut down.",
+		relayLabels, nil,
+	)
+	relayQueueDesc = prometheus.NewDesc(
+		"seneye_relay_queue_length",
+		"LDE pushes waiting to be forwarded to the relay target.",
+		relayLabels, nil,
+	)
+)
+
+// RelayTarget describes a downstream LDE receiver, ex. another exporter, to which accepted pushes
+// are forwarded.
+type RelayTarget struct {
+	// URL receives the pushes, ex. https://example.com/lde.
+	URL string
+	// Secret, if set, re-signs each push for the target. Otherwise the body is forwarded as
+	// received, signed with the SUD's secret.
+	Secret []byte
+	// SUDs limits the forwarded pushes to those from these SUD IDs, if set.
+	SUDs []string
+	// QueueSize is the number of pushes queued while the target is unavailable. Further pushes are
+	// dropped. Defaults to 100.
+	QueueSize int
+	// Timeout bounds each request. Defaults to 10 seconds.
+	Timeout time.Duration
+	// MinBackoff is the delay before the first retry of a failed push. Defaults to 1 second.
+	MinBackoff time.Duration
+	// MaxBackoff bounds the delay between retries, which doubles after each failure. Defaults to
+	// 1 minute.
+	MaxBackoff time.Duration
+}
+
+// relay is a Sink forwarding accepted pushes to a RelayTarget in the background, retrying while
+// it's unavailable.
+type relay struct {
+	target RelayTarget
+	// name identifies the target in metrics and logs, without any credentials in its URL.
+	name   string
+	suds   map[string]struct{}
+	client *http.Client
+	queue  *queue.Queue[[]byte]
+}
+
+var _ RemoteSink = (*relay)(nil)
+
+// WithRelay forwards each accepted push's raw body to the targets. Pushes are queued for each
+// target, so a slow or unavailable target doesn't delay the others or the SCA.
+func WithRelay(targets ...RelayTarget) ServerOption {
+	return func(s *Server) error {
+		for _, t := range targets {
+			r := newRelay(t)
+			s.relays = append(s.relays, r)
+			s.sinks = append(s.sinks, r)
+		}
+		return nil
+	}
+}
+
+func newRelay(t RelayTarget) *relay {
+	if t.QueueSize <= 0 {
+		t.QueueSize = 100
+	}
+	if t.Timeout <= 0 {
+		t.Timeout = 10 * time.Second
+	}
+	r := &relay{
+		target: t,
+		name:   t.URL,
+		client: &http.Client{Timeout: t.Timeout},
+	}
+	if u, err := url.Parse(t.URL); err == nil {
+		u.User = nil
+		r.name = u.String()
+	}
+	if len(t.SUDs) > 0 {
+		r.suds = make(map[string]struct{}, len(t.SUDs))
+		for _, id := range t.SUDs {
+			r.suds[id] = struct{}{}
+		}
+	}
+	r.queue = queue.New(queue.Config{
+		Name:       r.Name(),
+		Size:       t.QueueSize,
+		MinBackoff: t.MinBackoff,
+		MaxBackoff: t.MaxBackoff,
+	}, r.forward)
+	return r
+}
+
+// Send implements Sink, queueing the push for the target if it's from a relayed SUD. It never
+// blocks; if the queue is full the push is dropped and an error returned.
+func (r *relay) Send(ctx context.Context, p *Push) error {
+	if r.suds != nil {
+		if _, ok := r.suds[p.LDE.SUD.ID]; !ok {
+			return nil
+		}
+	}
+	body := p.Raw
+	if r.target.Secret != nil {
+		var err error
+		if body, err = resign(p.Raw, r.target.Secret); err != nil {
+			return fmt.Errorf("re-signing LDE for relay %q: %w", r.name, err)
+		}
+	}
+	return r.queue.Send(body)
+}
+
+// resign replaces the signature of the LDE token with one made with the secret, keeping the
+// original claims byte for byte.
+func resign(raw, secret []byte) ([]byte, error) {
+	parts := strings.Split(string(fixEncoding(append([]byte(nil), raw...))), ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token contains an invalid number of segments")
+	}
+	headerJSON, err := jwt.DecodeSegment(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("decoding token header: %w", err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("decoding token header: %w", err)
+	}
+	method, ok := jwt.GetSigningMethod(header.Alg).(*jwt.SigningMethodHMAC)
+	if !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", header.Alg)
+	}
+	signingString := parts[0] + "." + parts[1]
+	sig, err := method.Sign(signingString, secret)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(signingString + "." + sig), nil
+}
+
+// Name implements RemoteSink.
+func (r *relay) Name() string {
+	return "relay:" + r.name
+}
+
+// Healthy implements HealthySink, reporting an error while the target is failing.
+func (r *relay) Healthy(ctx context.Context) error {
+	return r.queue.Err()
+}
+
+// Close implements Sink, forwarding any queued pushes. They're abandoned if ctx is done first.
+func (r *relay) Close(ctx context.Context) error {
+	return r.queue.Close(ctx)
+}
+
+// collect sends the relay's metrics.
+func (r *relay) collect(ch chan<- prometheus.Metric) {
+	stats := r.queue.Stats()
+	ch <- prometheus.MustNewConstMetric(relayForwardedDesc, prometheus.CounterValue, float64(stats.Delivered), r.name)
+	ch <- prometheus.MustNewConstMetric(relayRetriesDesc, prometheus.CounterValue, float64(stats.Retries), r.name)
+	ch <- prometheus.MustNewConstMetric(relayDroppedDesc, prometheus.CounterValue, float64(stats.Dropped), r.name)
+	ch <- prometheus.MustNewConstMetric(relayQueueDesc, prometheus.GaugeValue, float64(stats.Queued), r.name)
+}
+
+// forward posts each queued push to the target.
+func (r *relay) forward(ctx context.Context, batch [][]byte) error {
+	for _, body := range batch {
+		if err := r.post(ctx, body); err != nil {
+			return fmt.Errorf("relaying LDE: %w", err)
+		}
+	}
+	return nil
+}
+
+// post sends the body to the target. Rejections other than rate limiting are permanent, as the
+// target would reject the push again. Retries wait for any delay the target requests with
+// Retry-After.
+func (r *relay) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.target.URL, bytes.NewReader(body))
+	if err != nil {
+		return queue.Permanent(err)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode/100 == 5:
+		return queue.RetryAfter(fmt.Errorf("unexpected status: %s", resp.Status), queue.ParseRetryAfter(resp))
+	default:
+		return queue.Permanent(fmt.Errorf("unexpected status: %s", resp.Status))
+	}
+}
@@ -0,0 +1,147 @@
+package lde
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver is a downstream LDE receiver, responding with each status in turn and then 204.
+type receiver struct {
+	lock     sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	// retryAfter is sent as the Retry-After header of the statuses, if set.
+	retryAfter string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.statuses) > 0 {
+		status := r.statuses[0]
+		r.statuses = r.statuses[1:]
+		if r.retryAfter != "" {
+			w.Header().Set("Retry-After", r.retryAfter)
+		}
+		w.WriteHeader(status)
+		return
+	}
+	r.bodies = append(r.bodies, body)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *receiver) received() [][]byte {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([][]byte(nil), r.bodies...)
+}
+
+func TestRelay(t *testing.T) {
+	secret, downstreamSecret := []byte("AAAAAAAA"), []byte("BBBBBBBB")
+	raw, resigned, filtered := &receiver{}, &receiver{statuses: []int{http.StatusServiceUnavailable}}, &receiver{}
+	rawSrv, resignedSrv, filteredSrv := httptest.NewServer(raw), httptest.NewServer(resigned), httptest.NewServer(filtered)
+	defer rawSrv.Close()
+	defer resignedSrv.Close()
+	defer filteredSrv.Close()
+
+	reg := prometheus.NewPedanticRegistry()
+	s := newTestServer(t,
+		WithSecrets(map[string][]byte{"": secret}),
+		WithPrometheus(reg),
+		WithRelay(
+			RelayTarget{URL: rawSrv.URL + "/lde"},
+			RelayTarget{URL: resignedSrv.URL + "/lde", Secret: downstreamSecret, MinBackoff: 10 * time.Millisecond},
+			RelayTarget{URL: filteredSrv.URL + "/lde", SUDs: []string{"5678"}},
+		),
+	)
+	// The SCA encodes bodies with the standard base64 alphabet.
+	body := signTestToken(t, `{"version":"1.0.0","SUD":{"id":"1234","name":"Office>>","type":1,"TS":1609561222}}`, secret)
+	require.NoError(t, s.Ingest(context.Background(), &Push{Raw: []byte(strings.NewReplacer("-", "+", "_", "/").Replace(string(body)))}))
+
+	require.Eventually(t, func() bool {
+		return len(raw.received()) == 1 && len(resigned.received()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	// The raw body is forwarded as received.
+	assert.Equal(t, strings.NewReplacer("-", "+", "_", "/").Replace(string(body)), string(raw.received()[0]))
+	// The re-signed body is only valid with the target's secret.
+	l, err := FromRequestBody(resigned.received()[0], map[string][]byte{"": downstreamSecret})
+	require.NoError(t, err)
+	assert.Equal(t, "Office>>", l.SUD.Name)
+	_, err = FromRequestBody(resigned.received()[0], map[string][]byte{"": secret})
+	assert.Error(t, err)
+	// Pushes from other SUDs aren't forwarded to filtered targets.
+	assert.Empty(t, filtered.received())
+
+	require.NoError(t, s.Close(context.Background()))
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP seneye_relay_forwarded_total LDE pushes forwarded to the relay target.
+# TYPE seneye_relay_forwarded_total counter
+seneye_relay_forwarded_total{target="`+rawSrv.URL+`/lde"} 1
+seneye_relay_forwarded_total{target="`+resignedSrv.URL+`/lde"} 1
+seneye_relay_forwarded_total{target="`+filteredSrv.URL+`/lde"} 0
+# HELP seneye_relay_retries_total Failed attempts to forward an LDE push to the relay target which were retried.
+# TYPE seneye_relay_retries_total counter
+seneye_relay_retries_total{target="`+rawSrv.URL+`/lde"} 0
+seneye_relay_retries_total{target="`+resignedSrv.URL+`/lde"} 1
+seneye_relay_retries_total{target="`+filteredSrv.URL+`/lde"} 0
+`), "seneye_relay_forwarded_total", "seneye_relay_retries_total"))
+}
+
+func TestRelayDropsRejected(t *testing.T) {
+	secret := []byte("AAAAAAAA")
+	r := &receiver{statuses: []int{http.StatusBadRequest}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	reg := prometheus.NewPedanticRegistry()
+	s := newTestServer(t, WithSecrets(map[string][]byte{"": secret}), WithPrometheus(reg), WithRelay(RelayTarget{URL: srv.URL}))
+	ctx := context.Background()
+
+	body := signTestToken(t, `{"version":"1.0.0","SUD":{"id":"1234","type":1,"TS":1609561222}}`, secret)
+	require.NoError(t, s.Ingest(ctx, &Push{Raw: body}))
+	require.Eventually(t, func() bool { return s.relays[0].Healthy(ctx) != nil }, 5*time.Second, 10*time.Millisecond)
+	// The rejected push isn't retried, and the next is forwarded.
+	require.NoError(t, s.Ingest(ctx, &Push{Raw: body}))
+	require.Eventually(t, func() bool { return len(r.received()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, s.Close(ctx))
+	assert.NoError(t, s.relays[0].Healthy(ctx))
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP seneye_relay_dropped_total LDE pushes not forwarded to the relay target because its queue was full, it rejected them, or the exporter shut down.
+# TYPE seneye_relay_dropped_total counter
+seneye_relay_dropped_total{target="`+srv.URL+`"} 1
+`), "seneye_relay_dropped_total"))
+}
+
+func TestRelayRetryAfter(t *testing.T) {
+	secret := []byte("AAAAAAAA")
+	r := &receiver{statuses: []int{http.StatusTooManyRequests}, retryAfter: "1"}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	s := newTestServer(t, WithSecrets(map[string][]byte{"": secret}), WithRelay(RelayTarget{URL: srv.URL, MinBackoff: 10 * time.Millisecond}))
+	defer s.Close(context.Background())
+
+	body := signTestToken(t, `{"version":"1.0.0","SUD":{"id":"1234","type":1,"TS":1609561222}}`, secret)
+	start := time.Now()
+	require.NoError(t, s.Ingest(context.Background(), &Push{Raw: body}))
+	require.Eventually(t, func() bool { return len(r.received()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	// The rate limited push is retried after the requested delay, rather than the backoff.
+	assert.True(t, time.Since(start) >= time.Second, "retried after %s", time.Since(start))
+}
+
+func TestResign(t *testing.T) {
+	_, err := resign([]byte("garbage"), []byte("secret"))
+	assert.Error(t, err)
+	_, err = resign([]byte("e30.e30.sig"), []byte("secret"))
+	assert.Error(t, err, "tokens without an HMAC alg aren't re-signed")
+}
@@ -1,11 +1,23 @@
 package lde
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/realip"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/hlog"
 )
 
@@ -14,31 +26,136 @@ type Server struct {
 	lastLDEs map[string]*LDE
 	lock     sync.Mutex
 	secrets  map[string][]byte
+
+	// dailyLight tracks the daily light integral of PAR capable SUDs, by SUD ID.
+	dailyLight map[string]*dailyLight
+	// location bounds the days of the daily light integral.
+	location *time.Location
+	// now returns the current time, for rolling over the daily light integral.
+	now func() time.Time
+	// staleAfter is the age of a SUD's latest reading after which it's forgotten, if positive.
+	staleAfter time.Duration
+
+	// quarantine records rejected pushes, if set.
+	quarantine *Quarantine
+
+	// maxBodySize limits the size of LDE requests, if positive.
+	maxBodySize int64
+	// rateLimiter limits the rate of requests from each source IP, if set.
+	rateLimiter *rateLimiter
+	// rateLimitExempt are the networks whose source IPs aren't rate limited.
+	rateLimitExempt []*net.IPNet
+	// allowedNetworks restricts the source IPs which may push, if set.
+	allowedNetworks []*net.IPNet
+
+	// sinks receive each accepted push.
+	sinks []Sink
+	// relays forward each accepted push to downstream receivers. They're also sinks.
+	relays []*relay
+
+	// seen records the unknown fields and unsupported versions which have already been logged.
+	seen map[string]struct{}
 }
 
-// ServeHTTP implements an http.Handler for the LDE server.
+// ServeHTTP implements an http.Handler for the LDE server, validating pushes with the server's
+// secrets.
 func (l *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.serveHTTP(w, r, l.secrets)
+}
+
+// Handler returns an http.Handler which records pushes in the server, but validates them with
+// the provided secrets rather than the server's. This allows multiple listeners with different
+// secrets to share one set of metrics.
+func (l *Server) Handler(secrets map[string][]byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.serveHTTP(w, r, secrets)
+	})
+}
+
+func (l *Server) serveHTTP(w http.ResponseWriter, r *http.Request, secrets map[string][]byte) {
 	ll := hlog.FromRequest(r)
 	for k, values := range r.Header {
 		for _, v := range values {
 			ll.Trace().Str("http_header_name", k).Str("http_header_value", v).Msg("processing HTTP LDE request header")
 		}
 	}
-	msg, err := ioutil.ReadAll(r.Body)
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	source := remoteIP(r)
+	// Unix socket peers are local processes, admitted by the socket's file permissions. They have
+	// no address to check or to rate limit separately.
+	local := unixPeer(r)
+	if len(l.allowedNetworks) > 0 && !local {
+		if ip := net.ParseIP(source); ip == nil || !realip.Contains(l.allowedNetworks, ip) {
+			ll.Warn().Str("source", source).Msg("LDE push from disallowed network")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+	if l.rateLimiter != nil && !local && !l.rateLimitExempted(source) {
+		if ok, wait := l.rateLimiter.allow(source); !ok {
+			ll.Warn().Str("source", source).Msg("LDE push rate limited")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+	}
+	body := io.Reader(r.Body)
+	if l.maxBodySize > 0 {
+		body = io.LimitReader(r.Body, l.maxBodySize+1)
+	}
+	msg, err := ioutil.ReadAll(body)
 	if err != nil {
 		ll.Error().Err(err).Msg("reading LDE body")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	lde, err := FromRequestBody(msg, l.secrets)
-	if err != nil {
-		ll.Error().Err(err).Msg("parsing LDE body")
+	if l.maxBodySize > 0 && int64(len(msg)) > l.maxBodySize {
+		ll.Warn().Int64("max_body_size", l.maxBodySize).Msg("LDE body too large")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	p := &Push{Received: time.Now(), Source: source, Raw: msg}
+	if err := l.ingest(r.Context(), ll, p, secrets); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	l.lock.Lock()
-	l.lastLDEs[lde.SUD.ID] = lde
-	l.lock.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Ingest parses, validates and records a push received other than by HTTP, ex. replayed from an
+// archive, using the server's secrets. p.Raw must be set, and p.LDE is set if it is valid.
+func (l *Server) Ingest(ctx context.Context, p *Push) error {
+	return l.ingest(ctx, zerolog.Ctx(ctx), p, l.secrets)
+}
+
+func (l *Server) ingest(ctx context.Context, ll *zerolog.Logger, p *Push, secrets map[string][]byte) error {
+	// FromRequestBody fixes the encoding in place, but sinks should see the body as received.
+	lde, err := FromRequestBody(append([]byte(nil), p.Raw...), secrets)
+	if err != nil {
+		ll.Error().Err(err).Msg("parsing LDE body")
+		if l.quarantine != nil {
+			l.quarantine.Add(NewRejection(p.Source, p.Raw, err))
+		}
+		if err := l.sendRejectedToSinks(ctx, p, err); err != nil {
+			ll.Warn().Err(err).Msg("sending rejected LDE to sinks")
+		}
+		return err
+	}
+	p.LDE = lde
+	newVersion, newFields := l.record(lde)
+	if newVersion {
+		ll.Warn().Str("lde_version", lde.Version).Msg("unsupported LDE version; decoding on a best-effort basis")
+	}
+	for _, f := range newFields {
+		ll.Info().Str("lde_version", lde.Version).Str("lde_field", f).Msg("new LDE field observed")
+	}
+	if err := l.sendToSinks(ctx, p); err != nil {
+		ll.Warn().Err(err).Str("sud_id", lde.SUD.ID).Msg("sending LDE to sinks")
+	}
 	ll.Debug().
 		Str("lde_version", lde.Version).
 		Str("sud_id", lde.SUD.ID).
@@ -57,21 +174,103 @@ func (l *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		Int("sud_status_slide", lde.SUD.Data.Status.Slide).
 		Int("sud_status_kelvin", lde.SUD.Data.Status.Kelvin).
 		Msg("LDE event received")
-	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// record stores the LDE as the SUD's latest, reporting whether its version or any of its unknown
+// fields are being seen for the first time.
+func (l *Server) record(lde *LDE) (newVersion bool, newFields []string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.lastLDEs[lde.SUD.ID] = lde
+	if lde.SUD.Type.Capabilities().PAR {
+		dl, ok := l.dailyLight[lde.SUD.ID]
+		if !ok {
+			dl = &dailyLight{}
+			l.dailyLight[lde.SUD.ID] = dl
+		}
+		dl.add(lde.SUD.Timestamp, lde.SUD.Data.PAR, l.location)
+	}
+	newVersion = !SupportedVersion(lde.Version) && l.firstSight("version:"+lde.Version)
+	for _, f := range lde.UnknownFields() {
+		if l.firstSight("field:" + f) {
+			newFields = append(newFields, f)
+		}
+	}
+	return newVersion, newFields
+}
+
+// Latest returns the latest LDE received from each SUD, ordered by SUD ID. The LDEs must not be
+// modified.
+func (l *Server) Latest() []*LDE {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	out := make([]*LDE, 0, len(l.lastLDEs))
+	for _, lde := range l.lastLDEs {
+		out = append(out, lde)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SUD.ID < out[j].SUD.ID })
+	return out
+}
+
+// remoteIP returns the IP address of the client, without its port.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// unixPeer is true if the request was received on a Unix socket.
+func unixPeer(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+// rateLimitExempted is true if the source IP is within the networks exempt from rate limiting.
+func (l *Server) rateLimitExempted(source string) bool {
+	if len(l.rateLimitExempt) == 0 {
+		return false
+	}
+	ip := net.ParseIP(source)
+	return ip != nil && realip.Contains(l.rateLimitExempt, ip)
+}
+
+// firstSight records key as seen, returning true if it hadn't been seen before. The caller must
+// hold l.lock.
+func (l *Server) firstSight(key string) bool {
+	if _, ok := l.seen[key]; ok {
+		return false
+	}
+	l.seen[key] = struct{}{}
+	return true
 }
 
 // ServerOption describes a func which implements the functional option pattern for the LDE Server.
-type ServerOption func(*Server)
+// It returns an error if the option is invalid.
+type ServerOption func(*Server) error
 
-// NewServer creates a new LDE Server with the provided options.
-func NewServer(options ...ServerOption) *Server {
+// NewServer creates a new LDE Server with the provided options, or returns the error of the first
+// invalid option.
+func NewServer(options ...ServerOption) (*Server, error) {
 	s := &Server{
-		lastLDEs: make(map[string]*LDE),
+		lastLDEs:   make(map[string]*LDE),
+		seen:       make(map[string]struct{}),
+		dailyLight: make(map[string]*dailyLight),
+		location:   time.Local,
+		now:        time.Now,
 	}
 	for _, o := range options {
-		o(s)
+		if err := o(s); err != nil {
+			// Stop any relays started by earlier options. Other sinks belong to the caller.
+			for _, r := range s.relays {
+				r.Close(context.Background())
+			}
+			return nil, err
+		}
 	}
-	return s
+	return s, nil
 }
 
 // WithServer sets the JWT validation secrets used to verify the authenticity of an LDE request.
@@ -79,14 +278,72 @@ func NewServer(options ...ServerOption) *Server {
 // multiple SUDs / Seneye accounts. A default secret for all unspecified SUDs can be set w/ the
 // empty-string for key.
 func WithSecrets(secrets map[string][]byte) ServerOption {
-	return func(s *Server) {
+	return func(s *Server) error {
 		s.secrets = secrets
+		return nil
+	}
+}
+
+// WithQuarantine records pushes which fail parsing or validation in the quarantine.
+func WithQuarantine(q *Quarantine) ServerOption {
+	return func(s *Server) error {
+		s.quarantine = q
+		return nil
+	}
+}
+
+// WithMaxBodySize rejects LDE requests with bodies larger than n bytes.
+func WithMaxBodySize(n int64) ServerOption {
+	return func(s *Server) error {
+		s.maxBodySize = n
+		return nil
+	}
+}
+
+// WithRateLimit limits each source IP to rate LDE requests per second, with bursts of up to burst
+// requests. It's invalid unless rate is positive and burst is at least 1, as no request would
+// ever be allowed.
+func WithRateLimit(rate float64, burst int) ServerOption {
+	return func(s *Server) error {
+		if !(rate > 0) || burst < 1 {
+			return fmt.Errorf("invalid LDE rate limit: rate %v, burst %d", rate, burst)
+		}
+		s.rateLimiter = newRateLimiter(rate, burst)
+		return nil
+	}
+}
+
+// WithRateLimitExempt exempts source IPs within the networks, ex. the local network the SCA runs
+// on, from WithRateLimit.
+func WithRateLimitExempt(networks []*net.IPNet) ServerOption {
+	return func(s *Server) error {
+		s.rateLimitExempt = networks
+		return nil
+	}
+}
+
+// WithAllowedNetworks only accepts LDE requests from source IPs within the networks.
+func WithAllowedNetworks(networks []*net.IPNet) ServerOption {
+	return func(s *Server) error {
+		s.allowedNetworks = networks
+		return nil
+	}
+}
+
+// WithLocation bounds the days of the daily light integral in loc, rather than the local time zone.
+func WithLocation(loc *time.Location) ServerOption {
+	return func(s *Server) error {
+		if loc == nil {
+			return errors.New("LDE location is required")
+		}
+		s.location = loc
+		return nil
 	}
 }
 
 // WithPrometheus registers the server with a prometheus registry
 func WithPrometheus(reg prometheus.Registerer) ServerOption {
-	return func(s *Server) {
-		reg.MustRegister(s)
+	return func(s *Server) error {
+		return reg.Register(s)
 	}
 }
@@ -0,0 +1,158 @@
+package lde
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/realip"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer creates a Server with the options, failing the test if any is invalid.
+func newTestServer(t *testing.T, options ...ServerOption) *Server {
+	s, err := NewServer(options...)
+	require.NoError(t, err)
+	return s
+}
+
+func TestServerHandlerSecrets(t *testing.T) {
+	s := newTestServer(t)
+	first := s.Handler(map[string][]byte{"": []byte("AAAAAAAA")})
+	second := s.Handler(map[string][]byte{"5678": []byte("BBBBBBBB")})
+
+	push := func(h http.Handler, claims string, secret string) int {
+		req := httptest.NewRequest(http.MethodPost, "/lde", bytes.NewReader(signTestToken(t, claims, []byte(secret))))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	claims1234 := `{"version":"1.0.0","SUD":{"id":"1234","name":"Office","type":1,"TS":1609561222}}`
+	claims5678 := `{"version":"1.0.0","SUD":{"id":"5678","name":"Reef","type":3,"TS":1609561222}}`
+	assert.Equal(t, http.StatusNoContent, push(first, claims1234, "AAAAAAAA"))
+	assert.Equal(t, http.StatusBadRequest, push(second, claims1234, "AAAAAAAA"))
+	assert.Equal(t, http.StatusNoContent, push(second, claims5678, "BBBBBBBB"))
+	assert.Equal(t, http.StatusBadRequest, push(first, claims5678, "BBBBBBBB"))
+
+	// Both handlers record into the same server.
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	require.Len(t, s.lastLDEs, 2)
+	assert.Equal(t, "Reef", s.lastLDEs["5678"].SUD.Name)
+}
+
+func TestServerLatest(t *testing.T) {
+	secret := []byte("AAAAAAAA")
+	s := newTestServer(t, WithSecrets(map[string][]byte{"": secret}))
+	assert.Empty(t, s.Latest())
+	for _, claims := range []string{
+		`{"version":"1.0.0","SUD":{"id":"5678","name":"Reef","type":3,"TS":1609561222}}`,
+		`{"version":"1.0.0","SUD":{"id":"1234","name":"Office","type":1,"TS":1609561222}}`,
+		`{"version":"1.0.0","SUD":{"id":"5678","name":"Reef","type":3,"TS":1609561522}}`,
+	} {
+		require.NoError(t, s.Ingest(context.Background(), &Push{Raw: signTestToken(t, claims, secret)}))
+	}
+
+	// Each SUD's latest LDE, ordered by SUD ID.
+	latest := s.Latest()
+	require.Len(t, latest, 2)
+	assert.Equal(t, "1234", latest[0].SUD.ID)
+	assert.Equal(t, "5678", latest[1].SUD.ID)
+	assert.Equal(t, int64(1609561522), latest[1].SUD.Timestamp)
+}
+
+func TestServerHardening(t *testing.T) {
+	secret := []byte("AAAAAAAA")
+	body := signTestToken(t, `{"version":"1.0.0","SUD":{"id":"1234","name":"Office","type":1,"TS":1609561222}}`, secret)
+	allowed, err := realip.ParseCIDRs([]string{"192.0.2.0/24"})
+	require.NoError(t, err)
+	s := newTestServer(t,
+		WithSecrets(map[string][]byte{"": secret}),
+		WithMaxBodySize(int64(len(body))),
+		WithRateLimit(0.001, 2),
+		WithAllowedNetworks(allowed),
+	)
+	push := func(method, remoteAddr string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/lde", bytes.NewReader(body))
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := push(http.MethodGet, "192.0.2.1:1234", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, http.MethodPost, rec.Header().Get("Allow"))
+
+	assert.Equal(t, http.StatusForbidden, push(http.MethodPost, "198.51.100.1:1234", body).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, push(http.MethodPost, "192.0.2.1:1234", append(body, 'x')).Code)
+	assert.Equal(t, http.StatusNoContent, push(http.MethodPost, "192.0.2.1:1234", body).Code)
+
+	rec = push(http.MethodPost, "192.0.2.1:1234", body)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusNoContent, push(http.MethodPost, "192.0.2.2:1234", body).Code)
+}
+
+func TestServerUnixPeers(t *testing.T) {
+	secret := []byte("AAAAAAAA")
+	body := signTestToken(t, `{"version":"1.0.0","SUD":{"id":"1234","name":"Office","type":1,"TS":1609561222}}`, secret)
+	allowed, err := realip.ParseCIDRs([]string{"192.0.2.0/24"})
+	require.NoError(t, err)
+	s := newTestServer(t,
+		WithSecrets(map[string][]byte{"": secret}),
+		WithRateLimit(0.001, 1),
+		WithAllowedNetworks(allowed),
+	)
+	// Unix socket peers have no address, so they're neither checked against the allowed networks
+	// nor rate limited together.
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/lde", bytes.NewReader(body))
+		req.RemoteAddr = "@"
+		req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, &net.UnixAddr{Name: "/run/lde.sock", Net: "unix"}))
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	}
+}
+
+func TestServerRateLimitExempt(t *testing.T) {
+	secret := []byte("AAAAAAAA")
+	body := signTestToken(t, `{"version":"1.0.0","SUD":{"id":"1234","name":"Office","type":1,"TS":1609561222}}`, secret)
+	exempt, err := realip.ParseCIDRs([]string{"192.0.2.0/24"})
+	require.NoError(t, err)
+	s := newTestServer(t,
+		WithSecrets(map[string][]byte{"": secret}),
+		WithRateLimit(0.001, 1),
+		WithRateLimitExempt(exempt),
+	)
+	push := func(remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodPost, "/lde", bytes.NewReader(body))
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusNoContent, push("192.0.2.1:1234"))
+	}
+	assert.Equal(t, http.StatusNoContent, push("198.51.100.1:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, push("198.51.100.1:1234"))
+}
+
+func TestWithRateLimitInvalid(t *testing.T) {
+	// A burst below 1 never holds a whole token, so every request would be rejected.
+	_, err := NewServer(WithRateLimit(1, 0))
+	assert.EqualError(t, err, "invalid LDE rate limit: rate 1, burst 0")
+	_, err = NewServer(WithRateLimit(0, 10))
+	assert.EqualError(t, err, "invalid LDE rate limit: rate 0, burst 10")
+	_, err = NewServer(WithRateLimit(-1, 10))
+	assert.EqualError(t, err, "invalid LDE rate limit: rate -1, burst 10")
+	_, err = NewServer(WithRateLimit(0.5, 1))
+	assert.NoError(t, err)
+}
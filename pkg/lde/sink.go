@@ -0,0 +1,135 @@
+package lde
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Push describes an LDE push.
+type Push struct {
+	// Received is when the push was received.
+	Received time.Time
+	// Source is the IP address of the pushing client.
+	Source string
+	// Raw is the request body, as received.
+	Raw []byte
+	// LDE is the parsed and validated push, or nil if the push was rejected.
+	LDE *LDE
+}
+
+// Sink receives each accepted LDE push, ex. to forward readings to another metrics system.
+type Sink interface {
+	// Send delivers the push. Send is called synchronously by the LDE handler, so slow sinks
+	// should queue internally rather than block, and must not retain ctx.
+	Send(ctx context.Context, p *Push) error
+	// Close flushes any queued pushes and releases the sink's resources.
+	Close(ctx context.Context) error
+}
+
+// HealthySink is implemented by sinks which can report their health for readiness checks.
+type HealthySink interface {
+	Sink
+	// Healthy returns an error if the sink is unable to deliver pushes.
+	Healthy(ctx context.Context) error
+}
+
+// RemoteSink is implemented by sinks which deliver pushes to a remote service, ex. graphite. The
+// exporter can still receive pushes while the service is unavailable, so a remote sink's health
+// doesn't affect readiness; it's exported by the seneye_sink_up metric instead.
+type RemoteSink interface {
+	HealthySink
+	// Name identifies the sink in the seneye_sink_up metric, ex. "graphite".
+	Name() string
+}
+
+// RejectSink is implemented by sinks which also receive pushes that failed parsing or validation.
+type RejectSink interface {
+	Sink
+	// SendRejected delivers the rejected push, whose LDE is nil. It's called under the same
+	// conditions as Send.
+	SendRejected(ctx context.Context, p *Push, reason error) error
+}
+
+// WithSink delivers each accepted push to the sink. It may be specified multiple times.
+func WithSink(sink Sink) ServerOption {
+	return func(s *Server) error {
+		s.sinks = append(s.sinks, sink)
+		return nil
+	}
+}
+
+// sendToSinks delivers the push to every sink, returning the errors of any which failed.
+func (l *Server) sendToSinks(ctx context.Context, p *Push) error {
+	var errs multiError
+	for _, sink := range l.sinks {
+		if err := sink.Send(ctx, p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.err()
+}
+
+// sendRejectedToSinks delivers the rejected push to every RejectSink, returning the errors of any
+// which failed.
+func (l *Server) sendRejectedToSinks(ctx context.Context, p *Push, reason error) error {
+	var errs multiError
+	for _, sink := range l.sinks {
+		if rs, ok := sink.(RejectSink); ok {
+			if err := rs.SendRejected(ctx, p, reason); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs.err()
+}
+
+// SinksHealthy returns an error if any sink reports itself unhealthy. RemoteSinks are excluded.
+func (l *Server) SinksHealthy(ctx context.Context) error {
+	var errs multiError
+	for _, sink := range l.sinks {
+		if _, ok := sink.(RemoteSink); ok {
+			continue
+		}
+		if hs, ok := sink.(HealthySink); ok {
+			if err := hs.Healthy(ctx); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs.err()
+}
+
+// Close flushes and closes every sink.
+func (l *Server) Close(ctx context.Context) error {
+	var errs multiError
+	for _, sink := range l.sinks {
+		if err := sink.Close(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.err()
+}
+
+// multiError combines several errors into one.
+type multiError []error
+
+func (m multiError) err() error {
+	switch len(m) {
+	case 0:
+		return nil
+	case 1:
+		return m[0]
+	default:
+		return m
+	}
+}
+
+func (m multiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors: %s", len(m), strings.Join(msgs, "; "))
+}
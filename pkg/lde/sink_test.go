@@ -0,0 +1,129 @@
+package lde
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSink struct {
+	pushes  []*Push
+	closed  bool
+	healthy error
+}
+
+func (f *fakeSink) Send(ctx context.Context, p *Push) error {
+	f.pushes = append(f.pushes, p)
+	return nil
+}
+
+func (f *fakeSink) Close(ctx context.Context) error {
+	f.closed = true
+	return nil
+}
+
+func (f *fakeSink) Healthy(ctx context.Context) error {
+	return f.healthy
+}
+
+type fakeRemoteSink struct {
+	fakeSink
+	name string
+}
+
+func (f *fakeRemoteSink) Name() string {
+	return f.name
+}
+
+func TestServerSinks(t *testing.T) {
+	secret := []byte("AAAAAAAA")
+	first, second := &fakeSink{}, &fakeSink{}
+	s := newTestServer(t, WithSecrets(map[string][]byte{"": secret}), WithSink(first), WithSink(second))
+
+	body := signTestToken(t, `{"version":"1.0.0","SUD":{"id":"1234","name":"Office","type":1,"TS":1609561222}}`, secret)
+	req := httptest.NewRequest(http.MethodPost, "/lde", bytes.NewReader(body))
+	req.RemoteAddr = "192.0.2.1:1234"
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	for _, sink := range []*fakeSink{first, second} {
+		require.Len(t, sink.pushes, 1)
+		p := sink.pushes[0]
+		assert.Equal(t, "1234", p.LDE.SUD.ID)
+		assert.Equal(t, "192.0.2.1", p.Source)
+		assert.Equal(t, body, p.Raw)
+		assert.False(t, p.Received.IsZero())
+	}
+
+	assert.NoError(t, s.SinksHealthy(context.Background()))
+	second.healthy = errors.New("connection refused")
+	assert.EqualError(t, s.SinksHealthy(context.Background()), "connection refused")
+	first.healthy = errors.New("queue full")
+	assert.EqualError(t, s.SinksHealthy(context.Background()), "2 errors: queue full; connection refused")
+
+	require.NoError(t, s.Close(context.Background()))
+	assert.True(t, first.closed)
+	assert.True(t, second.closed)
+}
+
+func TestServerRemoteSinks(t *testing.T) {
+	local := &fakeSink{}
+	remote := &fakeRemoteSink{name: "graphite"}
+	reg := prometheus.NewPedanticRegistry()
+	s := newTestServer(t, WithPrometheus(reg), WithSink(local), WithSink(remote))
+
+	// A failing remote sink is exported, but doesn't fail readiness.
+	remote.healthy = errors.New("connection refused")
+	assert.NoError(t, s.SinksHealthy(context.Background()))
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP seneye_sink_up 1 if the sink's last delivery to its remote service succeeded, 0 otherwise.
+# TYPE seneye_sink_up gauge
+seneye_sink_up{sink="graphite"} 0
+`), "seneye_sink_up"))
+	remote.healthy = nil
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP seneye_sink_up 1 if the sink's last delivery to its remote service succeeded, 0 otherwise.
+# TYPE seneye_sink_up gauge
+seneye_sink_up{sink="graphite"} 1
+`), "seneye_sink_up"))
+}
+
+type fakeRejectSink struct {
+	fakeSink
+	rejected []error
+}
+
+func (f *fakeRejectSink) SendRejected(ctx context.Context, p *Push, reason error) error {
+	f.rejected = append(f.rejected, reason)
+	return nil
+}
+
+func TestServerIngest(t *testing.T) {
+	secret := []byte("AAAAAAAA")
+	sink := &fakeRejectSink{}
+	s := newTestServer(t, WithSecrets(map[string][]byte{"": secret}), WithSink(sink))
+	ctx := context.Background()
+
+	body := signTestToken(t, `{"version":"1.0.0","SUD":{"id":"1234","name":"Office","type":1,"TS":1609561222}}`, secret)
+	p := &Push{Raw: body}
+	require.NoError(t, s.Ingest(ctx, p))
+	require.NotNil(t, p.LDE)
+	assert.Equal(t, "1234", p.LDE.SUD.ID)
+	assert.Contains(t, s.lastLDEs, "1234")
+	require.Len(t, sink.pushes, 1)
+	assert.Empty(t, sink.rejected)
+
+	assert.Error(t, s.Ingest(ctx, &Push{Raw: []byte("garbage")}))
+	require.Len(t, sink.pushes, 1)
+	assert.Len(t, sink.rejected, 1)
+}
@@ -0,0 +1,94 @@
+package lde
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// ExpireSink is implemented by sinks which are notified when a SUD is expired as stale, ex. to
+// delete its metrics from a remote system.
+type ExpireSink interface {
+	Sink
+	// Expire is called with the SUD's latest LDE after it's been forgotten by the server.
+	Expire(ctx context.Context, l *LDE) error
+}
+
+// WithStaleAfter forgets SUDs whose latest reading was taken more than d ago, so their metrics
+// stop being exported, once RunStaleExpiry is running.
+func WithStaleAfter(d time.Duration) ServerOption {
+	return func(s *Server) error {
+		s.staleAfter = d
+		return nil
+	}
+}
+
+// RunStaleExpiry periodically expires stale SUDs until ctx is done. It returns immediately if
+// WithStaleAfter wasn't set.
+func (l *Server) RunStaleExpiry(ctx context.Context) {
+	if l.staleAfter <= 0 {
+		return
+	}
+	interval := l.staleAfter / 10
+	if interval < time.Second {
+		interval = time.Second
+	} else if interval > time.Minute {
+		interval = time.Minute
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			l.ExpireStale(ctx, now)
+		}
+	}
+}
+
+// ExpireStale forgets SUDs whose latest reading was taken more than the WithStaleAfter duration
+// before now, and notifies any ExpireSinks. It returns the expired SUDs' latest LDEs.
+func (l *Server) ExpireStale(ctx context.Context, now time.Time) []*LDE {
+	if l.staleAfter <= 0 {
+		return nil
+	}
+	cutoff := now.Add(-l.staleAfter).Unix()
+	var expired []*LDE
+	l.lock.Lock()
+	for id, lde := range l.lastLDEs {
+		if lde.SUD.Timestamp < cutoff {
+			expired = append(expired, lde)
+			delete(l.lastLDEs, id)
+			delete(l.dailyLight, id)
+		}
+	}
+	l.lock.Unlock()
+
+	ll := zerolog.Ctx(ctx)
+	for _, lde := range expired {
+		ll.Info().
+			Str("sud_id", lde.SUD.ID).
+			Time("last_reading", time.Unix(lde.SUD.Timestamp, 0)).
+			Msg("expiring stale SUD")
+		if err := l.expireInSinks(ctx, lde); err != nil {
+			ll.Warn().Err(err).Str("sud_id", lde.SUD.ID).Msg("expiring SUD in sinks")
+		}
+	}
+	return expired
+}
+
+// expireInSinks notifies every ExpireSink of the expired SUD, returning the errors of any which
+// failed.
+func (l *Server) expireInSinks(ctx context.Context, lde *LDE) error {
+	var errs multiError
+	for _, sink := range l.sinks {
+		if es, ok := sink.(ExpireSink); ok {
+			if err := es.Expire(ctx, lde); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs.err()
+}
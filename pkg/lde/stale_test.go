@@ -0,0 +1,51 @@
+package lde
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeExpireSink struct {
+	fakeSink
+	expired []string
+}
+
+func (f *fakeExpireSink) Expire(ctx context.Context, l *LDE) error {
+	f.expired = append(f.expired, l.SUD.ID)
+	return nil
+}
+
+func TestExpireStale(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	sink := &fakeExpireSink{}
+	s := newTestServer(t, WithStaleAfter(time.Hour), WithSink(sink), WithPrometheus(reg))
+	now := time.Unix(1610539200, 0)
+	s.record(&LDE{SUD: SUD{ID: "fresh", Type: ReefSUD, Timestamp: now.Add(-time.Minute).Unix()}})
+	s.record(&LDE{SUD: SUD{ID: "stale", Type: ReefSUD, Timestamp: now.Add(-2 * time.Hour).Unix()}})
+	n, err := testutil.GatherAndCount(reg, "temperature_celsius")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	expired := s.ExpireStale(context.Background(), now)
+	require.Len(t, expired, 1)
+	assert.Equal(t, "stale", expired[0].SUD.ID)
+	assert.Equal(t, []string{"stale"}, sink.expired)
+	assert.NotContains(t, s.dailyLight, "stale")
+	require.Len(t, s.Latest(), 1)
+	assert.Equal(t, "fresh", s.Latest()[0].SUD.ID)
+	n, err = testutil.GatherAndCount(reg, "temperature_celsius")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// Without WithStaleAfter, nothing expires.
+	s = newTestServer(t, WithSink(sink))
+	s.record(&LDE{SUD: SUD{ID: "stale", Timestamp: 0}})
+	assert.Empty(t, s.ExpireStale(context.Background(), now))
+	assert.Len(t, s.Latest(), 1)
+}
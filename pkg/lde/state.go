@@ -0,0 +1,74 @@
+package lde
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// state is the portion of the server persisted across restarts.
+type state struct {
+	LDEs       map[string]*LDE        `json:"ldes"`
+	DailyLight map[string]*dailyLight `json:"daily_light"`
+}
+
+// LoadStateFile restores the latest readings saved by SaveStateFile, so metrics are available
+// immediately after a restart. A missing file is not an error.
+func (l *Server) LoadStateFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading state: %w", err)
+	}
+	var st state
+	if err := json.Unmarshal(b, &st); err != nil {
+		return fmt.Errorf("parsing state %q: %w", path, err)
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for id, lde := range st.LDEs {
+		if existing, ok := l.lastLDEs[id]; !ok || existing.SUD.Timestamp < lde.SUD.Timestamp {
+			l.lastLDEs[id] = lde
+		}
+	}
+	for id, dl := range st.DailyLight {
+		if _, ok := l.dailyLight[id]; !ok {
+			l.dailyLight[id] = dl
+		}
+	}
+	return nil
+}
+
+// SaveStateFile atomically writes the latest readings to path.
+func (l *Server) SaveStateFile(path string) error {
+	l.lock.Lock()
+	b, err := json.Marshal(state{LDEs: l.lastLDEs, DailyLight: l.dailyLight})
+	l.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("encoding state: %w", err)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("writing state: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("writing state: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("writing state: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("writing state: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("writing state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package lde
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s := newTestServer(t)
+	// A missing state file isn't an error.
+	require.NoError(t, s.LoadStateFile(path))
+
+	s.lastLDEs["1234"] = &LDE{
+		Version: "1.0.0",
+		SUD: SUD{
+			ID:        "1234",
+			Name:      "Reef",
+			Type:      ReefSUD,
+			Timestamp: 1610505992,
+			Data: Data{
+				PAR:     120,
+				Unknown: map[string]json.RawMessage{"O": json.RawMessage(`8.5`)},
+			},
+		},
+	}
+	s.dailyLight["1234"] = &dailyLight{Day: "2021-01-13", LastTS: 1610505992, LastPAR: 120, Integral: 1.5}
+	require.NoError(t, s.SaveStateFile(path))
+
+	restored := newTestServer(t)
+	// Readings received before the state is loaded aren't replaced by older saved readings.
+	restored.lastLDEs["5678"] = &LDE{SUD: SUD{ID: "5678", Timestamp: 1610505992}}
+	require.NoError(t, restored.LoadStateFile(path))
+	assert.Equal(t, s.lastLDEs["1234"], restored.lastLDEs["1234"])
+	assert.Equal(t, s.dailyLight, restored.dailyLight)
+	assert.Len(t, restored.lastLDEs, 2)
+}
@@ -0,0 +1,168 @@
+package lde
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// UnmarshalJSON decodes the LDE with the decoder registered for its protocol version.
+func (l *LDE) UnmarshalJSON(b []byte) error {
+	var v struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	decoder, _ := DecoderFor(v.Version)
+	return decoder(b, l)
+}
+
+// MarshalJSON encodes the LDE, including any unknown fields captured while decoding.
+func (l LDE) MarshalJSON() ([]byte, error) {
+	type plain LDE
+	return marshalWithUnknown(plain(l), l.Unknown)
+}
+
+// UnmarshalJSON decodes the SUD, capturing any unknown fields.
+func (s *SUD) UnmarshalJSON(b []byte) error {
+	type plain SUD
+	if err := json.Unmarshal(b, (*plain)(s)); err != nil {
+		return err
+	}
+	unknown, err := unknownFields(b, plain{})
+	s.Unknown = unknown
+	return err
+}
+
+// MarshalJSON encodes the SUD, including any unknown fields captured while decoding.
+func (s SUD) MarshalJSON() ([]byte, error) {
+	type plain SUD
+	return marshalWithUnknown(plain(s), s.Unknown)
+}
+
+// UnmarshalJSON decodes the SUD readings, capturing any unknown fields.
+func (d *Data) UnmarshalJSON(b []byte) error {
+	type plain Data
+	if err := json.Unmarshal(b, (*plain)(d)); err != nil {
+		return err
+	}
+	unknown, err := unknownFields(b, plain{})
+	d.Unknown = unknown
+	return err
+}
+
+// MarshalJSON encodes the SUD readings, including any unknown fields captured while decoding.
+func (d Data) MarshalJSON() ([]byte, error) {
+	type plain Data
+	return marshalWithUnknown(plain(d), d.Unknown)
+}
+
+// UnmarshalJSON decodes the SUD status flags, capturing any unknown fields.
+func (s *SUDStatus) UnmarshalJSON(b []byte) error {
+	type plain SUDStatus
+	if err := json.Unmarshal(b, (*plain)(s)); err != nil {
+		return err
+	}
+	unknown, err := unknownFields(b, plain{})
+	s.Unknown = unknown
+	return err
+}
+
+// MarshalJSON encodes the SUD status flags, including any unknown fields captured while decoding.
+func (s SUDStatus) MarshalJSON() ([]byte, error) {
+	type plain SUDStatus
+	return marshalWithUnknown(plain(s), s.Unknown)
+}
+
+// UnknownFields lists the dotted path of every field in the LDE which wasn't understood by
+// this version of the exporter (ex. "SUD.data.X").
+func (l *LDE) UnknownFields() []string {
+	var out []string
+	for k := range l.Unknown {
+		out = append(out, k)
+	}
+	for k := range l.SUD.Unknown {
+		out = append(out, "SUD."+k)
+	}
+	for k := range l.SUD.Data.Unknown {
+		out = append(out, "SUD.data."+k)
+	}
+	for k := range l.SUD.Data.Status.Unknown {
+		out = append(out, "SUD.data.S."+k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// UnknownValues returns the numeric unknown readings keyed by their LDE field name.
+func (d *Data) UnknownValues() map[string]float64 {
+	out := make(map[string]float64)
+	for k, raw := range d.Unknown {
+		var v float64
+		if err := json.Unmarshal(raw, &v); err != nil {
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// unknownFields returns the keys of the JSON object b which don't map to a field of v. Like
+// encoding/json, field names are matched case-insensitively.
+func unknownFields(b []byte, v interface{}) (map[string]json.RawMessage, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	known := jsonFieldNames(reflect.TypeOf(v))
+	var out map[string]json.RawMessage
+rawLoop:
+	for k, v := range raw {
+		for _, name := range known {
+			if strings.EqualFold(k, name) {
+				continue rawLoop
+			}
+		}
+		if out == nil {
+			out = make(map[string]json.RawMessage)
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+func jsonFieldNames(t reflect.Type) []string {
+	var out []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		out = append(out, name)
+	}
+	return out
+}
+
+// marshalWithUnknown encodes v and merges in the unknown fields, without overriding known ones.
+func marshalWithUnknown(v interface{}, unknown map[string]json.RawMessage) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil || len(unknown) == 0 {
+		return b, err
+	}
+	var merged map[string]json.RawMessage
+	if err := json.Unmarshal(b, &merged); err != nil {
+		return nil, err
+	}
+	for k, v := range unknown {
+		if _, ok := merged[k]; !ok {
+			merged[k] = v
+		}
+	}
+	return json.Marshal(merged)
+}
@@ -0,0 +1,81 @@
+package lde
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Decoder decodes the JSON claims of an LDE message into lde.
+type Decoder func(claims []byte, lde *LDE) error
+
+var (
+	decodersLock sync.RWMutex
+	decoders     = map[int]Decoder{
+		1: decodeV1,
+	}
+)
+
+// RegisterDecoder registers the decoder used for LDE messages of the given major protocol version,
+// replacing any existing decoder for that version.
+func RegisterDecoder(major int, d Decoder) {
+	decodersLock.Lock()
+	defer decodersLock.Unlock()
+	decoders[major] = d
+}
+
+// DecoderFor returns the decoder for the LDE protocol version. If no decoder is registered for the
+// version's major number, the decoder for the newest earlier major version (or the oldest known
+// version if there is none) is returned on a best-effort basis, and ok is false.
+func DecoderFor(version string) (d Decoder, ok bool) {
+	decodersLock.RLock()
+	defer decodersLock.RUnlock()
+	var majors []int
+	for m := range decoders {
+		majors = append(majors, m)
+	}
+	sort.Ints(majors)
+	major, err := majorVersion(version)
+	if err != nil {
+		return decoders[majors[0]], false
+	}
+	if d, ok := decoders[major]; ok {
+		return d, true
+	}
+	best := majors[0]
+	for _, m := range majors {
+		if m < major {
+			best = m
+		}
+	}
+	return decoders[best], false
+}
+
+// SupportedVersion is true if a decoder is registered for the LDE protocol version.
+func SupportedVersion(version string) bool {
+	_, ok := DecoderFor(version)
+	return ok
+}
+
+func majorVersion(version string) (int, error) {
+	major := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 2)[0]
+	m, err := strconv.Atoi(major)
+	if err != nil {
+		return 0, fmt.Errorf("parsing LDE version %q: %w", version, err)
+	}
+	return m, nil
+}
+
+// decodeV1 decodes the 1.x.x LDE protocol.
+func decodeV1(claims []byte, lde *LDE) error {
+	type plain LDE
+	if err := json.Unmarshal(claims, (*plain)(lde)); err != nil {
+		return err
+	}
+	unknown, err := unknownFields(claims, plain{})
+	lde.Unknown = unknown
+	return err
+}
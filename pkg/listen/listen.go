@@ -0,0 +1,191 @@
+// Package listen opens network listeners from address strings, supporting TCP, Unix domain
+// sockets, and systemd socket activation.
+package listen
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	unixPrefix    = "unix:"
+	systemdPrefix = "systemd"
+
+	// listenFDsStart is the first file descriptor passed by systemd socket activation.
+	listenFDsStart = 3
+)
+
+// Listen opens a listener for the address, which may be:
+//
+//	host:port, [ipv6]:port, or :port - a TCP socket.
+//	unix:/path/to.sock - a Unix domain socket; a stale socket file is replaced.
+//	systemd - the first unused socket passed by systemd socket activation.
+//	systemd:name - the socket passed by systemd socket activation with FileDescriptorName=name.
+func Listen(addr string) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, unixPrefix):
+		return listenUnix(strings.TrimPrefix(addr, unixPrefix))
+	case addr == systemdPrefix:
+		return activated("")
+	case strings.HasPrefix(addr, systemdPrefix+":"):
+		name := strings.TrimPrefix(addr, systemdPrefix+":")
+		if name == "" {
+			return nil, fmt.Errorf("systemd socket name is required in %q", addr)
+		}
+		return activated(name)
+	default:
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid listen address %q: %w", addr, err)
+		}
+		return net.Listen("tcp", addr)
+	}
+}
+
+// Equal is true if two addresses refer to the same listener. An empty host, 0.0.0.0 and [::] are
+// all the wildcard address, as Go binds each of them to every interface.
+func Equal(a, b string) bool {
+	if a == b {
+		return true
+	}
+	aHost, bHost, ok := tcpHosts(a, b)
+	if !ok {
+		return false
+	}
+	aIP, bIP := net.ParseIP(aHost), net.ParseIP(bHost)
+	if aIP != nil && bIP != nil {
+		return aIP.Equal(bIP) || (aIP.IsUnspecified() && bIP.IsUnspecified())
+	}
+	return aHost == bHost
+}
+
+// Conflict is true if two different addresses can't both be bound, because they share a port and
+// one of them is the wildcard address.
+func Conflict(a, b string) bool {
+	if Equal(a, b) {
+		return false
+	}
+	aHost, bHost, ok := tcpHosts(a, b)
+	if !ok {
+		return false
+	}
+	aIP, bIP := net.ParseIP(aHost), net.ParseIP(bHost)
+	return (aIP != nil && aIP.IsUnspecified()) || (bIP != nil && bIP.IsUnspecified())
+}
+
+// tcpHosts returns the hosts of two TCP addresses with the same fixed port. Empty hosts are
+// returned as the unspecified address. ok is false if either address isn't TCP, or the ports
+// differ or are chosen by the system.
+func tcpHosts(a, b string) (aHost, bHost string, ok bool) {
+	for _, addr := range []string{a, b} {
+		if strings.HasPrefix(addr, unixPrefix) || strings.HasPrefix(addr, systemdPrefix) {
+			return "", "", false
+		}
+	}
+	aHost, aPort, aErr := net.SplitHostPort(a)
+	bHost, bPort, bErr := net.SplitHostPort(b)
+	if aErr != nil || bErr != nil || aPort != bPort || aPort == "0" {
+		return "", "", false
+	}
+	if aHost == "" {
+		aHost = net.IPv6unspecified.String()
+	}
+	if bHost == "" {
+		bHost = net.IPv6unspecified.String()
+	}
+	return aHost, bHost, true
+}
+
+func listenUnix(path string) (net.Listener, error) {
+	if path == "" {
+		return nil, fmt.Errorf("unix socket path is required")
+	}
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		// A socket left behind by a previous process would prevent binding.
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("unix socket %q is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing stale unix socket %q: %w", path, err)
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+var (
+	activatedOnce sync.Once
+	activatedErr  error
+	activatedLock sync.Mutex
+	// activatedListeners holds the unclaimed listeners passed by systemd, in order.
+	activatedListeners []activatedListener
+)
+
+type activatedListener struct {
+	name string
+	l    net.Listener
+}
+
+// activated claims a socket passed by systemd socket activation. If name is empty, the first
+// unclaimed socket is returned.
+func activated(name string) (net.Listener, error) {
+	activatedOnce.Do(func() {
+		activatedListeners, activatedErr = systemdListeners()
+	})
+	if activatedErr != nil {
+		return nil, activatedErr
+	}
+	activatedLock.Lock()
+	defer activatedLock.Unlock()
+	for i, al := range activatedListeners {
+		if name != "" && al.name != name {
+			continue
+		}
+		activatedListeners = append(activatedListeners[:i], activatedListeners[i+1:]...)
+		return al.l, nil
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no unclaimed systemd activated sockets")
+	}
+	return nil, fmt.Errorf("no unclaimed systemd activated socket named %q", name)
+}
+
+// systemdListeners builds listeners from the file descriptors passed by systemd, as described in
+// sd_listen_fds(3).
+func systemdListeners() ([]activatedListener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, fmt.Errorf("no sockets were passed by systemd socket activation")
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("no sockets were passed by systemd socket activation")
+	}
+	var names []string
+	if fdNames := os.Getenv("LISTEN_FDNAMES"); fdNames != "" {
+		names = strings.Split(fdNames, ":")
+	}
+	// The variables are unset so child processes don't inherit them.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	out := make([]activatedListener, 0, count)
+	for i := 0; i < count; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(listenFDsStart+i)
+		if i < len(names) {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("using systemd socket %q: %w", name, err)
+		}
+		out = append(out, activatedListener{name: name, l: l})
+	}
+	return out, nil
+}
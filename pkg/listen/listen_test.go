@@ -0,0 +1,135 @@
+package listen
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenTCP(t *testing.T) {
+	l, err := Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	assert.Equal(t, "tcp", l.Addr().Network())
+
+	if l6, err := net.Listen("tcp", "[::1]:0"); err == nil {
+		l6.Close()
+		l, err := Listen("[::1]:0")
+		require.NoError(t, err)
+		l.Close()
+	}
+
+	_, err = Listen("8080")
+	assert.Error(t, err)
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lde.sock")
+	l, err := Listen("unix:" + path)
+	require.NoError(t, err)
+
+	_, err = Listen("unix:" + path)
+	assert.EqualError(t, err, fmt.Sprintf("unix socket %q is in use", path))
+
+	// Simulate a socket file left behind by a crashed process.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, l.Close())
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+	l, err = Listen("unix:" + path)
+	require.NoError(t, err)
+	l.Close()
+}
+
+func TestEqual(t *testing.T) {
+	tcs := []struct {
+		a, b  string
+		equal bool
+	}{
+		{a: ":8080", b: ":8080", equal: true},
+		{a: ":8080", b: ":9090", equal: false},
+		{a: "127.0.0.1:8080", b: "127.0.0.1:8080", equal: true},
+		{a: "[::1]:8080", b: "[0:0::1]:8080", equal: true},
+		{a: "127.0.0.1:8080", b: "[::1]:8080", equal: false},
+		{a: "unix:/run/a.sock", b: "unix:/run/a.sock", equal: true},
+		{a: "unix:/run/a.sock", b: "unix:/run/b.sock", equal: false},
+		{a: "systemd:lde", b: "systemd:prom", equal: false},
+		// Wildcards bind every interface, however they're written.
+		{a: ":8080", b: "0.0.0.0:8080", equal: true},
+		{a: ":8080", b: "[::]:8080", equal: true},
+		{a: "0.0.0.0:8080", b: "[::]:8080", equal: true},
+		{a: "0.0.0.0:8080", b: "0.0.0.0:9090", equal: false},
+		{a: ":8080", b: "127.0.0.1:8080", equal: false},
+		// Ports chosen by the system are never shared.
+		{a: ":0", b: "0.0.0.0:0", equal: false},
+	}
+	for _, tc := range tcs {
+		assert.Equal(t, tc.equal, Equal(tc.a, tc.b), "%s == %s", tc.a, tc.b)
+		assert.Equal(t, tc.equal, Equal(tc.b, tc.a), "%s == %s", tc.b, tc.a)
+	}
+}
+
+func TestConflict(t *testing.T) {
+	tcs := []struct {
+		a, b     string
+		conflict bool
+	}{
+		{a: ":8080", b: "127.0.0.1:8080", conflict: true},
+		{a: "[::]:8080", b: "192.0.2.1:8080", conflict: true},
+		{a: "0.0.0.0:8080", b: "localhost:8080", conflict: true},
+		{a: ":8080", b: "0.0.0.0:8080", conflict: false},
+		{a: ":8080", b: "127.0.0.1:9090", conflict: false},
+		{a: "127.0.0.1:8080", b: "192.0.2.1:8080", conflict: false},
+		{a: ":0", b: "127.0.0.1:0", conflict: false},
+		{a: ":8080", b: "unix:/run/a.sock", conflict: false},
+	}
+	for _, tc := range tcs {
+		assert.Equal(t, tc.conflict, Conflict(tc.a, tc.b), "%s conflicts with %s", tc.a, tc.b)
+		assert.Equal(t, tc.conflict, Conflict(tc.b, tc.a), "%s conflicts with %s", tc.b, tc.a)
+	}
+}
+
+func TestListenSystemd(t *testing.T) {
+	if os.Getenv("LISTEN_TEST_HELPER") == "1" {
+		// systemd sets LISTEN_PID to the pid of the activated process, which the parent can't know.
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		l, err := Listen("systemd:prom")
+		if err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		fmt.Println("addr:", l.Addr().String())
+		_, err = Listen("systemd:prom")
+		fmt.Println("error:", err)
+		return
+	}
+
+	ldeListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ldeListener.Close()
+	promListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer promListener.Close()
+	ldeFile, err := ldeListener.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer ldeFile.Close()
+	promFile, err := promListener.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer promFile.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestListenSystemd$")
+	cmd.Env = append(os.Environ(), "LISTEN_TEST_HELPER=1", "LISTEN_FDS=2", "LISTEN_FDNAMES=lde:prom")
+	cmd.ExtraFiles = []*os.File{ldeFile, promFile}
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	assert.Contains(t, string(out), "addr: "+promListener.Addr().String())
+	assert.True(t, strings.Contains(string(out), `error: no unclaimed systemd activated socket named "prom"`), string(out))
+}
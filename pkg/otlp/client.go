@@ -0,0 +1,146 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jcodybaker/seneye-exporter/internal/queue"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxResponseSize bounds the size of an OTLP/HTTP response body which is read.
+const maxResponseSize = 64 * 1024
+
+// httpClient exports with OTLP/HTTP, encoded as protobuf.
+type httpClient struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newHTTPClient(config Config) (*httpClient, error) {
+	u, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parsing otlp endpoint: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("otlp endpoint must be an http:// or https:// URL: %q", config.Endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/metrics"
+	}
+	return &httpClient{
+		url:     u.String(),
+		headers: config.Headers,
+		client:  &http.Client{},
+	}, nil
+}
+
+func (c *httpClient) export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return nil, queue.Permanent(err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, queue.Permanent(err)
+	}
+	for k, v := range c.headers {
+		httpReq.Header.Set(k, v)
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		// Connection failures are transient.
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode/100 == 2:
+		out := &colmetricspb.ExportMetricsServiceResponse{}
+		if err := proto.Unmarshal(respBody, out); err != nil {
+			return nil, queue.Permanent(fmt.Errorf("decoding response: %w", err))
+		}
+		return out, nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusBadGateway,
+		resp.StatusCode == http.StatusServiceUnavailable, resp.StatusCode == http.StatusGatewayTimeout:
+		return nil, queue.RetryAfter(fmt.Errorf("unexpected status: %s", resp.Status), queue.ParseRetryAfter(resp))
+	default:
+		return nil, queue.Permanent(fmt.Errorf("unexpected status: %s", resp.Status))
+	}
+}
+
+func (c *httpClient) close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// grpcClient exports with OTLP/gRPC.
+type grpcClient struct {
+	conn    *grpc.ClientConn
+	client  colmetricspb.MetricsServiceClient
+	headers metadata.MD
+}
+
+func newGRPCClient(config Config) (*grpcClient, error) {
+	target, useTLS := config.Endpoint, !config.Insecure
+	switch {
+	case strings.HasPrefix(target, "http://"):
+		target, useTLS = strings.TrimPrefix(target, "http://"), false
+	case strings.HasPrefix(target, "https://"):
+		target, useTLS = strings.TrimPrefix(target, "https://"), true
+	}
+	creds := insecure.NewCredentials()
+	if useTLS {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to otlp endpoint: %w", err)
+	}
+	headers := metadata.MD{}
+	for k, v := range config.Headers {
+		headers.Set(k, v)
+	}
+	return &grpcClient{
+		conn:    conn,
+		client:  colmetricspb.NewMetricsServiceClient(conn),
+		headers: headers,
+	}, nil
+}
+
+func (c *grpcClient) export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	resp, err := c.client.Export(metadata.NewOutgoingContext(ctx, c.headers), req)
+	if err == nil {
+		return resp, nil
+	}
+	// The codes the OTLP specification marks as retryable.
+	switch status.Code(err) {
+	case codes.Canceled, codes.DeadlineExceeded, codes.Aborted, codes.OutOfRange,
+		codes.Unavailable, codes.DataLoss, codes.ResourceExhausted:
+		return nil, err
+	}
+	return nil, queue.Permanent(err)
+}
+
+func (c *grpcClient) close() error {
+	return c.conn.Close()
+}
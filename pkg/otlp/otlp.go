@@ -0,0 +1,215 @@
+// Package otlp delivers LDE readings to an OpenTelemetry collector as OTLP gauges, over HTTP or
+// gRPC.
+package otlp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/internal/queue"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+	"github.com/jcodybaker/seneye-exporter/pkg/version"
+
+	"github.com/rs/zerolog/log"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+const (
+	// ProtocolGRPC sends metrics with OTLP/gRPC.
+	ProtocolGRPC = "grpc"
+	// ProtocolHTTP sends metrics with OTLP/HTTP, encoded as protobuf.
+	ProtocolHTTP = "http/protobuf"
+
+	// scopeName identifies the exporter as the instrumentation scope of its metrics.
+	scopeName = "github.com/jcodybaker/seneye-exporter"
+	// maxBatch bounds the number of queued pushes sent in one request.
+	maxBatch = 100
+)
+
+// units maps readings to their UCUM units.
+var units = map[string]string{
+	"temperature":   "Cel",
+	"ph":            "1",
+	"nh3":           "[ppm]",
+	"total_ammonia": "[ppm]",
+	"kelvin":        "K",
+	"lux":           "lx",
+	"par":           "umol/m2/s",
+}
+
+// Config configures a Sink.
+type Config struct {
+	// Protocol is ProtocolGRPC or ProtocolHTTP. Defaults to ProtocolHTTP.
+	Protocol string
+	// Endpoint is the collector. For HTTP it's a URL, ex. http://localhost:4318, to which
+	// /v1/metrics is appended if it has no path. For gRPC it's host:port, optionally with an
+	// http:// or https:// scheme to choose whether TLS is used.
+	Endpoint string
+	// Insecure disables TLS for gRPC endpoints without a scheme.
+	Insecure bool
+	// Headers are sent with every request, ex. for authentication.
+	Headers map[string]string
+	// Timeout bounds each request. Defaults to 10 seconds.
+	Timeout time.Duration
+	// RetryMaxElapsed bounds the time spent retrying a request which failed with a retryable
+	// error, after which its readings are dropped. 0 disables retries.
+	RetryMaxElapsed time.Duration
+	// RetryInitialInterval is the delay before the first retry, which doubles after each attempt.
+	// Defaults to 1 second.
+	RetryInitialInterval time.Duration
+	// RetryMaxInterval bounds the delay between retries. Defaults to 30 seconds.
+	RetryMaxInterval time.Duration
+	// QueueSize is the number of pushes queued while the collector is unavailable. Further pushes
+	// are dropped. Defaults to 1000.
+	QueueSize int
+}
+
+// client sends export requests to a collector. Failures which won't succeed if retried are
+// marked queue.Permanent.
+type client interface {
+	export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error)
+	close() error
+}
+
+// Sink is an lde.Sink which exports each accepted reading to an OpenTelemetry collector. Pushes
+// are queued and exported in the background.
+type Sink struct {
+	config Config
+	client client
+	queue  *queue.Queue[*metricspb.ResourceMetrics]
+}
+
+var _ lde.RemoteSink = (*Sink)(nil)
+
+// New creates a Sink and starts its background exporter.
+func New(config Config) (*Sink, error) {
+	if config.Endpoint == "" {
+		return nil, errors.New("otlp endpoint is required")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.RetryInitialInterval <= 0 {
+		config.RetryInitialInterval = time.Second
+	}
+	if config.RetryMaxInterval < config.RetryInitialInterval {
+		config.RetryMaxInterval = 30 * time.Second
+	}
+	var c client
+	var err error
+	switch config.Protocol {
+	case "", ProtocolHTTP:
+		config.Protocol = ProtocolHTTP
+		c, err = newHTTPClient(config)
+	case ProtocolGRPC:
+		c, err = newGRPCClient(config)
+	default:
+		return nil, fmt.Errorf("unsupported otlp protocol: %q", config.Protocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &Sink{config: config, client: c}
+	s.queue = queue.New(queue.Config{
+		Name:       s.Name(),
+		Size:       config.QueueSize,
+		MaxBatch:   maxBatch,
+		MinBackoff: config.RetryInitialInterval,
+		MaxBackoff: config.RetryMaxInterval,
+		MaxElapsed: config.RetryMaxElapsed,
+	}, s.export)
+	return s, nil
+}
+
+// ResourceMetrics maps the LDE to OTLP: a resource describing the SUD, with a gauge for each
+// reading and status flag timestamped with the time the SUD took the reading.
+func ResourceMetrics(l *lde.LDE) *metricspb.ResourceMetrics {
+	ts := uint64(time.Unix(l.SUD.Timestamp, 0).UnixNano())
+	var metrics []*metricspb.Metric
+	for _, r := range l.Readings() {
+		m := &metricspb.Metric{
+			Name: "seneye." + r.Name,
+			Unit: units[r.Name],
+			Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+				DataPoints: []*metricspb.NumberDataPoint{{
+					TimeUnixNano: ts,
+					Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: r.Value},
+				}},
+			}},
+		}
+		if r.Status {
+			m.Name, m.Unit = "seneye.status."+r.Name, "1"
+		}
+		metrics = append(metrics, m)
+	}
+	return &metricspb.ResourceMetrics{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+			stringAttr("service.name", "seneye-exporter"),
+			stringAttr("service.version", version.Version),
+			stringAttr("seneye.sud.id", l.SUD.ID),
+			stringAttr("seneye.sud.name", l.SUD.Name),
+			stringAttr("seneye.sud.type", l.SUD.Type.String()),
+		}},
+		ScopeMetrics: []*metricspb.ScopeMetrics{{
+			Scope:   &commonpb.InstrumentationScope{Name: scopeName, Version: version.Version},
+			Metrics: metrics,
+		}},
+	}
+}
+
+func stringAttr(k, v string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: k, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}}
+}
+
+// Send implements lde.Sink, queueing the reading. It never blocks; if the queue is full the
+// reading is dropped and an error returned.
+func (s *Sink) Send(ctx context.Context, p *lde.Push) error {
+	return s.queue.Send(ResourceMetrics(p.LDE))
+}
+
+// Name implements lde.RemoteSink.
+func (s *Sink) Name() string {
+	return "otlp"
+}
+
+// Healthy implements lde.HealthySink, reporting the last export error.
+func (s *Sink) Healthy(ctx context.Context) error {
+	return s.queue.Err()
+}
+
+// Close implements lde.Sink, exporting any queued readings before closing the connection. Queued
+// readings are abandoned if ctx is done first.
+func (s *Sink) Close(ctx context.Context) error {
+	err := s.queue.Close(ctx)
+	if cerr := s.client.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// export sends the batch of readings, queued while earlier requests were in flight, in one
+// request. Failures are retried by the queue until RetryMaxElapsed.
+func (s *Sink) export(ctx context.Context, batch []*metricspb.ResourceMetrics) error {
+	reqCtx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+	resp, err := s.client.export(reqCtx, &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: batch})
+	if err != nil {
+		err = fmt.Errorf("exporting to otlp: %w", err)
+		if s.config.RetryMaxElapsed <= 0 {
+			return queue.Permanent(err)
+		}
+		return err
+	}
+	if ps := resp.GetPartialSuccess(); ps.GetRejectedDataPoints() > 0 {
+		log.Warn().
+			Int64("rejected_data_points", ps.GetRejectedDataPoints()).
+			Str("error_message", ps.GetErrorMessage()).
+			Msg("otlp collector rejected some data points")
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package otlp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+var testLDE = &lde.LDE{SUD: lde.SUD{ID: "abc", Name: "Reef Tank", Type: lde.ReefSUD, Timestamp: 1610539200, Data: lde.Data{
+	Status:      lde.SUDStatus{Water: 1},
+	Temperature: 25.5, PH: 8.1, NH3: 0.002, Kelvin: 14000, Lux: 9000, PAR: 166,
+}}}
+
+// collector is an in-process stand-in for an OpenTelemetry collector, recording the requests it
+// receives and failing the first failures of them.
+type collector struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+
+	lock     sync.Mutex
+	failures int
+	requests []*colmetricspb.ExportMetricsServiceRequest
+	headers  []string
+}
+
+func (c *collector) record(req *colmetricspb.ExportMetricsServiceRequest, header string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.failures > 0 {
+		c.failures--
+		return false
+	}
+	c.requests = append(c.requests, req)
+	c.headers = append(c.headers, header)
+	return true
+}
+
+func (c *collector) received() ([]*colmetricspb.ExportMetricsServiceRequest, []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]*colmetricspb.ExportMetricsServiceRequest(nil), c.requests...), append([]string(nil), c.headers...)
+}
+
+func (c *collector) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var header string
+	if v := md.Get("authorization"); len(v) > 0 {
+		header = v[0]
+	}
+	if !c.record(req, header) {
+		return nil, status.Error(codes.Unavailable, "try again")
+	}
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/metrics" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &colmetricspb.ExportMetricsServiceRequest{}
+	if err := proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !c.record(req, r.Header.Get("Authorization")) {
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+		return
+	}
+	resp, _ := proto.Marshal(&colmetricspb.ExportMetricsServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(resp)
+}
+
+// waitForRequests returns the requests received by the collector once there are n.
+func waitForRequests(t *testing.T, c *collector, n int) ([]*colmetricspb.ExportMetricsServiceRequest, []string) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		reqs, _ := c.received()
+		return len(reqs) >= n
+	}, 5*time.Second, 10*time.Millisecond)
+	return c.received()
+}
+
+func TestResourceMetrics(t *testing.T) {
+	rm := ResourceMetrics(testLDE)
+	attrs := map[string]string{}
+	for _, kv := range rm.GetResource().GetAttributes() {
+		attrs[kv.GetKey()] = kv.GetValue().GetStringValue()
+	}
+	assert.Equal(t, "seneye-exporter", attrs["service.name"])
+	assert.Equal(t, "abc", attrs["seneye.sud.id"])
+	assert.Equal(t, "Reef Tank", attrs["seneye.sud.name"])
+	assert.Equal(t, "reef", attrs["seneye.sud.type"])
+
+	require.Len(t, rm.GetScopeMetrics(), 1)
+	metrics := rm.GetScopeMetrics()[0].GetMetrics()
+	require.Len(t, metrics, 12)
+	var names []string
+	for _, m := range metrics {
+		names = append(names, m.GetName())
+		points := m.GetGauge().GetDataPoints()
+		require.Len(t, points, 1, m.GetName())
+		// Data points are timestamped when the SUD took the reading, not when it was received.
+		assert.Equal(t, uint64(1610539200)*uint64(time.Second), points[0].GetTimeUnixNano())
+	}
+	assert.Equal(t, []string{
+		"seneye.temperature", "seneye.ph", "seneye.nh3", "seneye.kelvin", "seneye.lux", "seneye.par",
+		"seneye.status.water", "seneye.status.temperature", "seneye.status.ph", "seneye.status.nh3",
+		"seneye.status.slide", "seneye.status.kelvin",
+	}, names)
+	assert.Equal(t, "Cel", metrics[0].GetUnit())
+	assert.Equal(t, 25.5, metrics[0].GetGauge().GetDataPoints()[0].GetAsDouble())
+	assert.Equal(t, 1.0, metrics[6].GetGauge().GetDataPoints()[0].GetAsDouble())
+}
+
+func TestNewInvalid(t *testing.T) {
+	_, err := New(Config{})
+	assert.EqualError(t, err, "otlp endpoint is required")
+	// HTTP endpoints require a scheme.
+	_, err = New(Config{Endpoint: "localhost:4318"})
+	assert.EqualError(t, err, `otlp endpoint must be an http:// or https:// URL: "localhost:4318"`)
+	_, err = New(Config{Endpoint: "http://localhost:4318", Protocol: "http/json"})
+	assert.Error(t, err)
+}
+
+func TestSendHTTP(t *testing.T) {
+	c := &collector{failures: 1}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	s, err := New(Config{
+		Endpoint:             srv.URL,
+		Headers:              map[string]string{"Authorization": "Bearer secret"},
+		RetryMaxElapsed:      5 * time.Second,
+		RetryInitialInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	// The first attempt fails, and is retried.
+	require.NoError(t, s.Send(context.Background(), &lde.Push{LDE: testLDE}))
+	reqs, headers := waitForRequests(t, c, 1)
+	require.Len(t, reqs[0].GetResourceMetrics(), 1)
+	assert.True(t, proto.Equal(ResourceMetrics(testLDE), reqs[0].GetResourceMetrics()[0]))
+	assert.Equal(t, "Bearer secret", headers[0])
+	require.Eventually(t, func() bool { return s.Healthy(context.Background()) == nil }, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, s.Close(context.Background()))
+	assert.Error(t, s.Send(context.Background(), &lde.Push{LDE: testLDE}))
+}
+
+func TestSendHTTPNoRetry(t *testing.T) {
+	c := &collector{failures: 1}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+	s, err := New(Config{Endpoint: srv.URL})
+	require.NoError(t, err)
+	defer s.Close(context.Background())
+
+	// Without retries the failed reading is dropped, and the next is delivered.
+	require.NoError(t, s.Send(context.Background(), &lde.Push{LDE: testLDE}))
+	require.Eventually(t, func() bool { return s.Healthy(context.Background()) != nil }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, s.Send(context.Background(), &lde.Push{LDE: testLDE}))
+	waitForRequests(t, c, 1)
+	require.Eventually(t, func() bool { return s.Healthy(context.Background()) == nil }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSendGRPC(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	c := &collector{failures: 2}
+	srv := grpc.NewServer()
+	colmetricspb.RegisterMetricsServiceServer(srv, c)
+	go srv.Serve(ln)
+	defer srv.Stop()
+
+	s, err := New(Config{
+		Protocol:             ProtocolGRPC,
+		Endpoint:             "http://" + ln.Addr().String(),
+		Headers:              map[string]string{"Authorization": "Bearer secret"},
+		RetryMaxElapsed:      5 * time.Second,
+		RetryInitialInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Send(context.Background(), &lde.Push{LDE: testLDE}))
+	reqs, headers := waitForRequests(t, c, 1)
+	require.Len(t, reqs[0].GetResourceMetrics(), 1)
+	assert.True(t, proto.Equal(ResourceMetrics(testLDE), reqs[0].GetResourceMetrics()[0]))
+	assert.Equal(t, "Bearer secret", headers[0])
+	require.NoError(t, s.Close(context.Background()))
+}
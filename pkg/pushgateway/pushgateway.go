@@ -0,0 +1,286 @@
+// Package pushgateway pushes each SUD's metrics to a Prometheus Pushgateway, for deployments
+// which prometheus can't scrape.
+package pushgateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/internal/queue"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultJob is the job label of pushed groups.
+	DefaultJob = "seneye-exporter"
+	// GroupingLabel is the label which, with the job, identifies each SUD's group.
+	GroupingLabel = "sud_id"
+
+	// idLabel is the label of the collector's series identifying their SUD.
+	idLabel = "id"
+)
+
+// Config configures a Sink.
+type Config struct {
+	// URL is the Pushgateway, ex. http://pushgateway:9091.
+	URL string
+	// Job is the job label of pushed groups. Defaults to DefaultJob.
+	Job string
+	// Gatherer provides the series to push, ex. the registry the lde.Server is registered with.
+	// Only series labeled with the pushed SUD's id are pushed.
+	Gatherer prometheus.Gatherer
+	// Username and Password are used for basic auth, if Username is set.
+	Username string
+	Password string
+	// Timeout bounds each request. Defaults to 10 seconds.
+	Timeout time.Duration
+	// MinBackoff is the delay before the first retry of a failed request. Defaults to 1 second.
+	MinBackoff time.Duration
+	// MaxBackoff bounds the delay between retries, which doubles after each failure. Defaults to
+	// 1 minute.
+	MaxBackoff time.Duration
+}
+
+// Sink is an lde.ExpireSink which pushes the SUD's current series to a Pushgateway after each
+// accepted reading, grouped by SUD ID, and deletes the group when the SUD is expired as stale.
+// Requests are made in the background; only the latest operation for each SUD is kept while the
+// Pushgateway is unavailable.
+type Sink struct {
+	config Config
+	client *http.Client
+
+	wake chan struct{}
+	stop context.CancelFunc
+	done chan struct{}
+
+	lock sync.Mutex
+	// pending maps SUD IDs to their outstanding operation: true to delete the group, false to
+	// push it.
+	pending map[string]bool
+	closed  bool
+	err     error
+}
+
+var (
+	_ lde.RemoteSink = (*Sink)(nil)
+	_ lde.ExpireSink = (*Sink)(nil)
+)
+
+// New creates a Sink and starts its background pusher.
+func New(config Config) (*Sink, error) {
+	if config.URL == "" {
+		return nil, errors.New("pushgateway url is required")
+	}
+	if config.Gatherer == nil {
+		return nil, errors.New("pushgateway gatherer is required")
+	}
+	if config.Job == "" {
+		config.Job = DefaultJob
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	s := &Sink{
+		config:  config,
+		client:  &http.Client{Timeout: config.Timeout},
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		pending: make(map[string]bool),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stop = cancel
+	go s.run(ctx)
+	return s, nil
+}
+
+// Send implements lde.Sink, scheduling a push of the SUD's group.
+func (s *Sink) Send(ctx context.Context, p *lde.Push) error {
+	return s.schedule(p.LDE.SUD.ID, false)
+}
+
+// Expire implements lde.ExpireSink, scheduling the deletion of the SUD's group.
+func (s *Sink) Expire(ctx context.Context, l *lde.LDE) error {
+	return s.schedule(l.SUD.ID, true)
+}
+
+// schedule replaces any outstanding operation for the SUD, and wakes the pusher.
+func (s *Sink) schedule(id string, del bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return errors.New("pushgateway sink is closed")
+	}
+	s.pending[id] = del
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Name implements lde.RemoteSink.
+func (s *Sink) Name() string {
+	return "pushgateway"
+}
+
+// Healthy implements lde.HealthySink, reporting the last request's error.
+func (s *Sink) Healthy(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+// Close implements lde.Sink, completing any outstanding operations. They're abandoned if ctx is
+// done first. Groups are left on the Pushgateway, so the latest readings outlive the exporter.
+func (s *Sink) Close(ctx context.Context) error {
+	s.lock.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.wake)
+	}
+	s.lock.Unlock()
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		s.stop()
+		<-s.done
+		return ctx.Err()
+	}
+}
+
+// take removes and returns the outstanding operations.
+func (s *Sink) take() map[string]bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ops := s.pending
+	s.pending = make(map[string]bool)
+	return ops
+}
+
+// requeue restores an operation which failed, unless it has since been replaced.
+func (s *Sink) requeue(id string, del bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.pending[id]; !ok {
+		s.pending[id] = del
+	}
+}
+
+func (s *Sink) setErr(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.err = err
+}
+
+// run performs outstanding operations when woken, until the sink is closed and they're complete,
+// or ctx is done. Failed operations are retried with backoff.
+func (s *Sink) run(ctx context.Context) {
+	defer close(s.done)
+	backoff := queue.NewBackoff(s.config.MinBackoff, s.config.MaxBackoff)
+	open := true
+	for open {
+		_, open = <-s.wake
+		for {
+			ops := s.take()
+			if len(ops) == 0 {
+				break
+			}
+			ids := make([]string, 0, len(ops))
+			for id := range ops {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			var err error
+			for _, id := range ids {
+				if err = s.do(id, ops[id]); err != nil {
+					// The Pushgateway is likely unavailable, so retry this and the remaining
+					// operations after the backoff.
+					for id, del := range ops {
+						s.requeue(id, del)
+					}
+					break
+				}
+				delete(ops, id)
+			}
+			s.setErr(err)
+			if err == nil {
+				backoff.Reset()
+				continue
+			}
+			wait := backoff.Next(err)
+			log.Warn().Err(err).Dur("backoff", wait).Msg("pushgateway unavailable; retrying")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}
+}
+
+// do pushes or deletes the SUD's group.
+func (s *Sink) do(id string, del bool) error {
+	p := push.New(s.config.URL, s.config.Job).
+		Grouping(GroupingLabel, id).
+		Client(s.client)
+	if s.config.Username != "" {
+		p = p.BasicAuth(s.config.Username, s.config.Password)
+	}
+	if del {
+		if err := p.Delete(); err != nil {
+			return fmt.Errorf("deleting pushgateway group: %w", err)
+		}
+		return nil
+	}
+	if err := p.Gatherer(sudGatherer(s.config.Gatherer, id)).Push(); err != nil {
+		return fmt.Errorf("pushing to pushgateway: %w", err)
+	}
+	return nil
+}
+
+// sudGatherer returns the series of g labeled with the SUD's id, without timestamps. Prometheus
+// would treat timestamped series as stale once they're old, so the Pushgateway's scrape time is
+// used instead.
+func sudGatherer(g prometheus.Gatherer, id string) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := g.Gather()
+		if err != nil {
+			return nil, err
+		}
+		var out []*dto.MetricFamily
+		for _, mf := range families {
+			var metrics []*dto.Metric
+			for _, m := range mf.GetMetric() {
+				if hasLabel(m, idLabel, id) {
+					m.TimestampMs = nil
+					metrics = append(metrics, m)
+				}
+			}
+			if len(metrics) > 0 {
+				mf.Metric = metrics
+				out = append(out, mf)
+			}
+		}
+		return out, nil
+	})
+}
+
+func hasLabel(m *dto.Metric, name, value string) bool {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue() == value
+		}
+	}
+	return false
+}
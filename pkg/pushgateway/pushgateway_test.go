@@ -0,0 +1,187 @@
+package pushgateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// request is a request received by the fake Pushgateway.
+type request struct {
+	method   string
+	path     string
+	families map[string]*dto.MetricFamily
+}
+
+// gateway is a fake Pushgateway, recording the requests it receives and failing while down.
+type gateway struct {
+	lock     sync.Mutex
+	down     bool
+	requests []request
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.down {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	req := request{method: r.Method, path: r.URL.Path, families: map[string]*dto.MetricFamily{}}
+	dec := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+	for {
+		mf := &dto.MetricFamily{}
+		if err := dec.Decode(mf); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.families[mf.GetName()] = mf
+	}
+	g.requests = append(g.requests, req)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (g *gateway) setDown(down bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.down = down
+}
+
+// waitForRequests returns the requests received once there are n.
+func (g *gateway) waitForRequests(t *testing.T, n int) []request {
+	t.Helper()
+	var out []request
+	require.Eventually(t, func() bool {
+		g.lock.Lock()
+		defer g.lock.Unlock()
+		out = append([]request(nil), g.requests...)
+		return len(out) >= n
+	}, 5*time.Second, 10*time.Millisecond)
+	return out
+}
+
+var secret = []byte("AAAAAAAA")
+
+// newServer returns an LDE server delivering to a Sink pushing its series to url.
+func newServer(t *testing.T, url string) (*lde.Server, *Sink) {
+	t.Helper()
+	reg := prometheus.NewPedanticRegistry()
+	s, err := New(Config{URL: url, Gatherer: reg, MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond})
+	require.NoError(t, err)
+	server, err := lde.NewServer(
+		lde.WithSecrets(map[string][]byte{"": secret}),
+		lde.WithPrometheus(reg),
+		lde.WithSink(s),
+		lde.WithStaleAfter(time.Hour),
+	)
+	require.NoError(t, err)
+	return server, s
+}
+
+// ingest records the LDE in the server, which sends it to the sink.
+func ingest(t *testing.T, server *lde.Server, l *lde.LDE) {
+	t.Helper()
+	body, err := lde.ToRequestBody(l, secret)
+	require.NoError(t, err)
+	require.NoError(t, server.Ingest(context.Background(), &lde.Push{Raw: body}))
+}
+
+func TestPushAndExpire(t *testing.T) {
+	g := &gateway{}
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+	server, s := newServer(t, srv.URL)
+	ctx := context.Background()
+
+	now := time.Unix(1610539200, 0)
+	for _, l := range []*lde.LDE{
+		{SUD: lde.SUD{ID: "abc", Name: "Reef", Type: lde.ReefSUD, Timestamp: now.Unix(), Data: lde.Data{Temperature: 25.5}}},
+		{SUD: lde.SUD{ID: "xyz", Name: "Pond", Type: lde.PondSUD, Timestamp: now.Add(-2 * time.Hour).Unix(), Data: lde.Data{Temperature: 12}}},
+	} {
+		ingest(t, server, l)
+	}
+	reqs := g.waitForRequests(t, 2)
+	byPath := map[string]request{}
+	for _, r := range reqs {
+		assert.Equal(t, http.MethodPut, r.method)
+		byPath[r.path] = r
+	}
+	require.Contains(t, byPath, "/metrics/job/seneye-exporter/sud_id/abc")
+	abc := byPath["/metrics/job/seneye-exporter/sud_id/abc"]
+	require.Contains(t, abc.families, "temperature_celsius")
+	temp := abc.families["temperature_celsius"].GetMetric()
+	// Only the SUD's own series are pushed, without timestamps.
+	require.Len(t, temp, 1)
+	assert.Equal(t, 25.5, temp[0].GetGauge().GetValue())
+	assert.Nil(t, temp[0].TimestampMs)
+	require.Contains(t, byPath, "/metrics/job/seneye-exporter/sud_id/xyz")
+	assert.Equal(t, 12.0, byPath["/metrics/job/seneye-exporter/sud_id/xyz"].families["temperature_celsius"].GetMetric()[0].GetGauge().GetValue())
+
+	server.ExpireStale(ctx, now)
+	reqs = g.waitForRequests(t, 3)
+	assert.Equal(t, http.MethodDelete, reqs[2].method)
+	assert.Equal(t, "/metrics/job/seneye-exporter/sud_id/xyz", reqs[2].path)
+	assert.NoError(t, s.Healthy(ctx))
+
+	require.NoError(t, s.Close(ctx))
+	assert.Error(t, s.Send(ctx, &lde.Push{LDE: &lde.LDE{SUD: lde.SUD{ID: "abc"}}}))
+}
+
+func TestRetry(t *testing.T) {
+	g := &gateway{down: true}
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+	server, s := newServer(t, srv.URL)
+	defer s.Close(context.Background())
+	ctx := context.Background()
+
+	l := &lde.LDE{SUD: lde.SUD{ID: "abc", Type: lde.ReefSUD, Timestamp: time.Now().Unix()}}
+	ingest(t, server, l)
+	require.Eventually(t, func() bool { return s.Healthy(ctx) != nil }, 5*time.Second, 10*time.Millisecond)
+
+	// The expiry replaces the failed push while the Pushgateway is down, though a retry may
+	// already be in flight.
+	require.NoError(t, s.Expire(ctx, l))
+	g.setDown(false)
+	require.Eventually(t, func() bool { return s.Healthy(ctx) == nil }, 5*time.Second, 10*time.Millisecond)
+	reqs := g.waitForRequests(t, 1)
+	assert.LessOrEqual(t, len(reqs), 2)
+	assert.Equal(t, http.MethodDelete, reqs[len(reqs)-1].method)
+}
+
+func TestCloseAbandonsPending(t *testing.T) {
+	g := &gateway{down: true}
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+	reg := prometheus.NewPedanticRegistry()
+	s, err := New(Config{URL: srv.URL, Gatherer: reg, MinBackoff: time.Hour})
+	require.NoError(t, err)
+	require.NoError(t, s.Expire(context.Background(), &lde.LDE{SUD: lde.SUD{ID: "abc"}}))
+	require.Eventually(t, func() bool { return s.Healthy(context.Background()) != nil }, 5*time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Close(ctx))
+}
+
+func TestNewInvalid(t *testing.T) {
+	_, err := New(Config{Gatherer: prometheus.NewRegistry()})
+	assert.Error(t, err)
+	_, err = New(Config{URL: "http://pushgateway:9091"})
+	assert.Error(t, err)
+}
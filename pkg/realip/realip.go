@@ -0,0 +1,98 @@
+// Package realip determines the address of HTTP clients connecting through trusted reverse
+// proxies.
+package realip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseCIDRs parses a list of CIDRs. Bare IP addresses are treated as single host networks.
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var out []*net.IPNet
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", c)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			out = append(out, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", c, err)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// Contains is true if ip is in any of the networks.
+func Contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler replaces the request's RemoteAddr with the client address from X-Forwarded-For when the
+// request was made by a trusted proxy. X-Forwarded-For is read from right to left, skipping
+// trusted proxies, so clients can't spoof their address by sending the header themselves.
+func Handler(trusted []*net.IPNet) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if len(trusted) == 0 {
+			return h
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if addr := clientAddr(trusted, r); addr != "" {
+				r = r.Clone(r.Context())
+				r.RemoteAddr = addr
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientAddr returns the client's address if the request was forwarded by a trusted proxy, or
+// the empty string otherwise.
+func clientAddr(trusted []*net.IPNet, r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(host); ip == nil || !Contains(trusted, ip) {
+		return ""
+	}
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(h, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	var client string
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			// A malformed hop can't be trusted; the last valid address is the best we have.
+			break
+		}
+		client = ip.String()
+		if !Contains(trusted, ip) {
+			break
+		}
+	}
+	if client == "" {
+		return ""
+	}
+	// The port of the client isn't known.
+	return net.JoinHostPort(client, "0")
+}
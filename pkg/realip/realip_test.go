@@ -0,0 +1,91 @@
+package realip
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	networks, err := ParseCIDRs([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32", "::1"})
+	require.NoError(t, err)
+	require.Len(t, networks, 4)
+	assert.True(t, Contains(networks, net.ParseIP("10.1.2.3")))
+	assert.True(t, Contains(networks, net.ParseIP("192.0.2.1")))
+	assert.False(t, Contains(networks, net.ParseIP("192.0.2.2")))
+	assert.True(t, Contains(networks, net.ParseIP("2001:db8::1")))
+	assert.True(t, Contains(networks, net.ParseIP("::1")))
+
+	_, err = ParseCIDRs([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = ParseCIDRs([]string{"example.com"})
+	assert.EqualError(t, err, `invalid IP address "example.com"`)
+}
+
+func TestHandler(t *testing.T) {
+	trusted, err := ParseCIDRs([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	var remoteAddr string
+	h := Handler(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+	}))
+
+	tcs := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		expected   string
+	}{
+		{
+			name:       "untrusted proxy is ignored",
+			remoteAddr: "192.0.2.1:1234",
+			xff:        []string{"198.51.100.1"},
+			expected:   "192.0.2.1:1234",
+		},
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"198.51.100.1"},
+			expected:   "198.51.100.1:0",
+		},
+		{
+			name:       "spoofed header is skipped",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"203.0.113.1, 198.51.100.1"},
+			expected:   "198.51.100.1:0",
+		},
+		{
+			name:       "chain of trusted proxies",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"198.51.100.1, 10.0.0.2", "10.0.0.3"},
+			expected:   "198.51.100.1:0",
+		},
+		{
+			name:       "trusted proxy without header",
+			remoteAddr: "10.0.0.1:1234",
+			expected:   "10.0.0.1:1234",
+		},
+		{
+			name:       "malformed hop",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"garbage, 198.51.100.1"},
+			expected:   "198.51.100.1:0",
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/lde", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for _, v := range tc.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			assert.Equal(t, tc.expected, remoteAddr)
+		})
+	}
+}
@@ -0,0 +1,212 @@
+// Package rules generates Prometheus alerting rules over the metrics the exporter exports, raising
+// the same alerts as the exporter's own notifications.
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/alert"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// DefaultGroup is the name of the rule group.
+	DefaultGroup = "seneye"
+	// DefaultLookback is the window in which each SUD's latest reading is found.
+	DefaultLookback = time.Hour
+
+	// staleWindow is how long after a SUD's last reading it's still alerted as stale. Beyond it,
+	// the SUD is assumed to be retired.
+	staleWindow = 7 * 24 * time.Hour
+
+	// sudName is the alert template for the SUD's name, or its ID if it's unnamed.
+	sudName = `{{ with $labels.name }}{{ . }}{{ else }}{{ $labels.id }}{{ end }}`
+)
+
+// Config configures the generated rules.
+type Config struct {
+	// Group is the name of the rule group. Defaults to DefaultGroup.
+	Group string
+	// Lookback is the window in which each SUD's latest reading is found. Readings are exported
+	// with the time the SUD took them, and SUDs report less often than prometheus' 5 minute
+	// lookback, so it must exceed the SUD's reporting interval. Defaults to DefaultLookback.
+	Lookback time.Duration
+	// StaleAfter, if set, raises an alert when a SUD's latest reading is older.
+	StaleAfter time.Duration
+	// For is how long conditions must hold before alerts fire. 0 fires immediately.
+	For time.Duration
+	// Rules are threshold rules, ex. alert-rules from the config file.
+	Rules []alert.Rule
+	// Labels are added to every alert, ex. to route them in Alertmanager.
+	Labels map[string]string
+}
+
+// RuleFile is a prometheus rule file.
+type RuleFile struct {
+	Groups []Group `yaml:"groups"`
+}
+
+// Group is a group of rules, which prometheus evaluates together.
+type Group struct {
+	Name  string `yaml:"name"`
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule is a prometheus alerting rule.
+type Rule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// PrometheusRule is a prometheus-operator PrometheusRule resource.
+type PrometheusRule struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Kind       string   `yaml:"kind"`
+	Metadata   Metadata `yaml:"metadata"`
+	Spec       RuleFile `yaml:"spec"`
+}
+
+// Metadata is a kubernetes resource's metadata.
+type Metadata struct {
+	Name      string            `yaml:"name"`
+	Namespace string            `yaml:"namespace,omitempty"`
+	Labels    map[string]string `yaml:"labels,omitempty"`
+}
+
+// NewPrometheusRule wraps the rule file in a PrometheusRule resource. The labels select the
+// resource for a prometheus-operator managed prometheus, ex. release: prometheus.
+func NewPrometheusRule(name, namespace string, labels map[string]string, f *RuleFile) *PrometheusRule {
+	return &PrometheusRule{
+		APIVersion: "monitoring.coreos.com/v1",
+		Kind:       "PrometheusRule",
+		Metadata:   Metadata{Name: name, Namespace: namespace, Labels: labels},
+		Spec:       *f,
+	}
+}
+
+// Generate returns rules raising alerts while a SUD is out of the water or raises a status flag,
+// while its readings cross the thresholds of the config's Rules, and, if StaleAfter is set, while
+// it hasn't reported.
+func Generate(config Config) (*RuleFile, error) {
+	if config.Group == "" {
+		config.Group = DefaultGroup
+	}
+	if config.Lookback <= 0 {
+		config.Lookback = DefaultLookback
+	}
+	g := &generator{config: config, lookback: model.Duration(config.Lookback).String()}
+	if config.For > 0 {
+		g.forDuration = model.Duration(config.For).String()
+	}
+
+	water := lde.MetricName(lde.Reading{Name: "water", Status: true})
+	g.add("SeneyeOutOfWater", g.latest(water, "")+" == 0", alert.Critical, alert.FlagSummary(alert.OutOfWater), "")
+	for _, f := range alert.StatusFlags() {
+		metric := lde.MetricName(lde.Reading{Name: f, Status: true})
+		name := "Seneye" + camel(strings.TrimPrefix(metric, "seneye_status_")) + "Flagged"
+		g.add(name, g.latest(metric, "")+" > 0", alert.Warning, alert.FlagSummary(f), "")
+	}
+	if config.StaleAfter > 0 {
+		window := model.Duration(config.StaleAfter + staleWindow).String()
+		after := model.Duration(config.StaleAfter).String()
+		expr := fmt.Sprintf("max_over_time(%s[%s]) unless max_over_time(%s[%s])", water, window, water, after)
+		g.add("SeneyeStale", expr, alert.Warning, "No readings for over "+after, "")
+	}
+
+	// Thresholds aren't tested while the SUD is out of the water, as its readings are meaningless.
+	inWater := " unless on(id) " + g.latest(water, "") + " == 0"
+	names := map[string]struct{}{}
+	for _, r := range config.Rules {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+		name := "Seneye" + camel(r.Name)
+		if !model.IsValidMetricName(model.LabelValue(name)) {
+			return nil, fmt.Errorf("alert rule %q: name must only contain letters, digits and underscores", r.Name)
+		}
+		if _, ok := names[name]; ok {
+			return nil, fmt.Errorf("alert rule %q is defined more than once", r.Name)
+		}
+		names[name] = struct{}{}
+		if r.Severity == alert.Info {
+			r.Severity = alert.Warning
+		}
+		metric := lde.MetricName(lde.Reading{Name: r.Reading})
+		selector := ""
+		switch len(r.SUDs) {
+		case 0:
+		case 1:
+			selector = fmt.Sprintf("{id=%q}", r.SUDs[0])
+		default:
+			ids := make([]string, len(r.SUDs))
+			for i, id := range r.SUDs {
+				ids[i] = regexp.QuoteMeta(id)
+			}
+			selector = fmt.Sprintf("{id=~%q}", strings.Join(ids, "|"))
+		}
+		latest := g.latest(metric, selector)
+		description := "The latest reading is {{ $value }}."
+		if r.Min != nil {
+			g.add(name, fmt.Sprintf("%s < %s%s", latest, formatFloat(*r.Min), inWater),
+				r.Severity, r.Summary(false), description)
+		}
+		if r.Max != nil {
+			g.add(name, fmt.Sprintf("%s > %s%s", latest, formatFloat(*r.Max), inWater),
+				r.Severity, r.Summary(true), description)
+		}
+	}
+	return &RuleFile{Groups: []Group{{Name: config.Group, Rules: g.rules}}}, nil
+}
+
+// generator accumulates rules.
+type generator struct {
+	config      Config
+	lookback    string
+	forDuration string
+	rules       []Rule
+}
+
+// latest returns an expression for each SUD's latest value of the metric.
+func (g *generator) latest(metric, selector string) string {
+	return fmt.Sprintf("last_over_time(%s%s[%s])", metric, selector, g.lookback)
+}
+
+func (g *generator) add(name, expr string, severity alert.Severity, summary, description string) {
+	labels := map[string]string{"severity": severity.String()}
+	for k, v := range g.config.Labels {
+		labels[k] = v
+	}
+	annotations := map[string]string{"summary": sudName + ": " + summary}
+	if description != "" {
+		annotations["description"] = description
+	}
+	g.rules = append(g.rules, Rule{
+		Alert:       name,
+		Expr:        expr,
+		For:         g.forDuration,
+		Labels:      labels,
+		Annotations: annotations,
+	})
+}
+
+// camel converts a snake_case name to CamelCase, ex. high_ph to HighPh.
+func camel(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' || r == ' ' })
+	for i, p := range parts {
+		parts[i] = strings.ToUpper(p[:1]) + p[1:]
+	}
+	return strings.Join(parts, "")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,76 @@
+package rules
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/alert"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func float(f float64) *float64 {
+	return &f
+}
+
+// assertGolden compares v, marshaled as YAML, with the golden file.
+func assertGolden(t *testing.T, name string, v interface{}) {
+	t.Helper()
+	got, err := yaml.Marshal(v)
+	require.NoError(t, err)
+	path := filepath.Join("testdata", name)
+	if *update {
+		require.NoError(t, ioutil.WriteFile(path, got, 0644))
+	}
+	want, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got))
+}
+
+func TestGenerate(t *testing.T) {
+	f, err := Generate(Config{})
+	require.NoError(t, err)
+	assertGolden(t, "default.golden.yaml", f)
+
+	f, err = Generate(Config{
+		Group:      "aquarium",
+		Lookback:   90 * time.Minute,
+		StaleAfter: 2 * time.Hour,
+		For:        10 * time.Minute,
+		Labels:     map[string]string{"team": "home"},
+		Rules: []alert.Rule{
+			{Name: "high_temperature", Reading: "temperature", Max: float(27.5), Severity: alert.Critical},
+			{Name: "ph_range", Reading: "ph", Min: float(7.8), Max: float(8.4), SUDs: []string{"1234"}},
+			{Name: "total_ammonia", Reading: "total_ammonia", Max: float(0.02), SUDs: []string{"1234", "5678"}},
+		},
+	})
+	require.NoError(t, err)
+	assertGolden(t, "configured.golden.yaml", f)
+
+	assertGolden(t, "prometheusrule.golden.yaml",
+		NewPrometheusRule("seneye-exporter", "monitoring", map[string]string{"release": "prometheus"}, f))
+}
+
+func TestGenerateValidates(t *testing.T) {
+	for _, rules := range [][]alert.Rule{
+		{{Name: "high_ph", Reading: "salinity", Max: float(8.4)}},
+		{{Name: "high ph!", Reading: "ph", Max: float(8.4)}},
+		{{Name: "high_ph", Reading: "ph", Max: float(8.4)}, {Name: "high_ph", Reading: "ph", Max: float(8.5)}},
+	} {
+		_, err := Generate(Config{Rules: rules})
+		assert.Error(t, err, "%+v", rules)
+	}
+}
+
+func TestCamel(t *testing.T) {
+	assert.Equal(t, "HighPh", camel("high_ph"))
+	assert.Equal(t, "TotalAmmonia", camel("total-ammonia"))
+	assert.Equal(t, "Slide", camel("slide"))
+}
@@ -0,0 +1,225 @@
+// Package simulate generates realistic LDE pushes from virtual Seneye USB Devices, so the exporter
+// can be exercised without real hardware.
+package simulate
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+)
+
+// Config tunes the behavior of simulated devices.
+type Config struct {
+	// Drift scales how far readings wander between pushes. 0 holds readings at their baseline, 1 is
+	// typical of a healthy tank.
+	Drift float64
+	// OutOfWater is the probability that a push begins an out-of-water event.
+	OutOfWater float64
+	// Alarm is the probability that a push begins an alarm event, where a reading leaves its safe
+	// range.
+	Alarm float64
+	// EventPushes is the number of pushes an out-of-water or alarm event lasts.
+	EventPushes int
+	// Location is the time zone used for the diurnal light curve. Defaults to time.Local.
+	Location *time.Location
+}
+
+// DefaultConfig is a reasonable Config for simulating a healthy set of tanks.
+var DefaultConfig = Config{
+	Drift:       1,
+	OutOfWater:  0.01,
+	Alarm:       0.02,
+	EventPushes: 3,
+}
+
+// profile describes the baseline readings and safe ranges for a SUD type.
+type profile struct {
+	temp, tempSwing, tempStep, tempMin, tempMax float64
+	ph, phSwing, phStep, phMin, phMax           float64
+	nh3, nh3Step, nh3Max                        float64
+	// lux is the peak light intensity at midday.
+	lux    float64
+	kelvin float64
+}
+
+var profiles = map[lde.SUDType]profile{
+	lde.HomeSUD: {
+		temp: 25, tempSwing: 0.3, tempStep: 0.05, tempMin: 22, tempMax: 28,
+		ph: 7.0, phSwing: 0.05, phStep: 0.01, phMin: 6.5, phMax: 7.8,
+		nh3: 0.004, nh3Step: 0.0005, nh3Max: 0.02,
+		lux: 2500, kelvin: 6500,
+	},
+	lde.PondSUD: {
+		temp: 15, tempSwing: 2, tempStep: 0.1, tempMin: 5, tempMax: 25,
+		ph: 7.8, phSwing: 0.15, phStep: 0.02, phMin: 7.0, phMax: 8.6,
+		nh3: 0.008, nh3Step: 0.001, nh3Max: 0.02,
+		lux: 40000, kelvin: 5500,
+	},
+	lde.ReefSUD: {
+		temp: 25.5, tempSwing: 0.3, tempStep: 0.05, tempMin: 24, tempMax: 27,
+		ph: 8.2, phSwing: 0.1, phStep: 0.01, phMin: 7.9, phMax: 8.5,
+		nh3: 0.002, nh3Step: 0.0002, nh3Max: 0.02,
+		lux: 12000, kelvin: 14000,
+	},
+}
+
+// alarm identifies the reading pushed out of range by an alarm event.
+type alarm int
+
+const (
+	noAlarm alarm = iota
+	temperatureAlarm
+	phAlarm
+	ammoniaAlarm
+)
+
+// Device is a virtual Seneye USB Device. A Device is not safe for concurrent use.
+type Device struct {
+	// ID is the SUD ID reported in pushes.
+	ID string
+	// Name is the SUD name reported in pushes.
+	Name string
+	// Type is the SUD type reported in pushes.
+	Type lde.SUDType
+
+	config  Config
+	profile profile
+	rand    *rand.Rand
+
+	// tempOffset, phOffset and nh3Offset are the drifted deviations from the baseline readings.
+	tempOffset, phOffset, nh3Offset float64
+
+	outOfWater int
+	alarm      alarm
+	alarmLeft  int
+}
+
+// NewDevice creates a virtual device. Devices created with the same seed generate the same
+// readings.
+func NewDevice(id, name string, t lde.SUDType, config Config, seed int64) (*Device, error) {
+	p, ok := profiles[t]
+	if !ok {
+		return nil, fmt.Errorf("unsupported SUD type: %d", t)
+	}
+	if config.Location == nil {
+		config.Location = time.Local
+	}
+	return &Device{
+		ID:      id,
+		Name:    name,
+		Type:    t,
+		config:  config,
+		profile: p,
+		rand:    rand.New(rand.NewSource(seed)),
+	}, nil
+}
+
+// Next advances the device's simulation and returns its push at ts.
+func (d *Device) Next(ts time.Time) *lde.LDE {
+	p := d.profile
+	d.step()
+
+	// The diurnal curve peaks at 14:00; temperatures and pH lag the light.
+	local := ts.In(d.config.Location)
+	hour := float64(local.Hour()) + float64(local.Minute())/60 + float64(local.Second())/3600
+	cycle := math.Cos(2 * math.Pi * (hour - 14) / 24)
+
+	data := lde.Data{
+		Status:      lde.SUDStatus{Water: 1},
+		Temperature: p.temp + p.tempSwing*cycle + d.tempOffset,
+		PH:          p.ph + p.phSwing*cycle + d.phOffset,
+		NH3:         math.Max(0, p.nh3+d.nh3Offset),
+	}
+	switch d.alarm {
+	case temperatureAlarm:
+		data.Temperature = p.tempMax + 2
+	case phAlarm:
+		data.PH = p.phMin - 0.3
+	case ammoniaAlarm:
+		data.NH3 = p.nh3Max * 4
+	}
+
+	caps := d.Type.Capabilities()
+	if caps.Lux {
+		data.Lux = math.Round(p.lux * daylight(hour))
+	}
+	if caps.Kelvin && data.Lux > 0 {
+		data.Kelvin = p.kelvin
+	}
+	if caps.PAR {
+		// ~0.0185 µmol/m²/s per lux for white light.
+		data.PAR = math.Round(data.Lux * 0.0185)
+	}
+
+	if data.Temperature < p.tempMin || data.Temperature > p.tempMax {
+		data.Status.Temperature = 1
+	}
+	if data.PH < p.phMin || data.PH > p.phMax {
+		data.Status.PH = 1
+	}
+	if data.NH3 > p.nh3Max {
+		data.Status.NH3 = 1
+	}
+	if d.outOfWater > 0 {
+		// Out of water the SUD reads the air; its water readings aren't meaningful.
+		data.Status.Water = 0
+		data.PH = 0
+		data.NH3 = 0
+	}
+
+	data.Temperature = round(data.Temperature, 3)
+	data.PH = round(data.PH, 2)
+	data.NH3 = round(data.NH3, 3)
+	return &lde.LDE{
+		Version: "1.0.0",
+		SUD: lde.SUD{
+			ID:        d.ID,
+			Name:      d.Name,
+			Type:      d.Type,
+			Timestamp: ts.Unix(),
+			Data:      data,
+		},
+	}
+}
+
+// step advances the random walk and any events.
+func (d *Device) step() {
+	p := d.profile
+	// Mean reverting random walks keep readings near the baseline.
+	drift := d.config.Drift
+	d.tempOffset = 0.9*d.tempOffset + d.rand.NormFloat64()*p.tempStep*drift
+	d.phOffset = 0.9*d.phOffset + d.rand.NormFloat64()*p.phStep*drift
+	d.nh3Offset = 0.9*d.nh3Offset + d.rand.NormFloat64()*p.nh3Step*drift
+
+	if d.outOfWater > 0 {
+		d.outOfWater--
+	} else if d.rand.Float64() < d.config.OutOfWater {
+		d.outOfWater = d.config.EventPushes
+	}
+	if d.alarmLeft > 0 {
+		d.alarmLeft--
+		if d.alarmLeft == 0 {
+			d.alarm = noAlarm
+		}
+	} else if d.rand.Float64() < d.config.Alarm && d.config.EventPushes > 0 {
+		d.alarm = alarm(1 + d.rand.Intn(3))
+		d.alarmLeft = d.config.EventPushes
+	}
+}
+
+// daylight is the fraction of peak light at the hour, with lights on from 08:00 to 20:00.
+func daylight(hour float64) float64 {
+	const on, off = 8, 20
+	if hour < on || hour >= off {
+		return 0
+	}
+	return math.Sin(math.Pi * (hour - on) / (off - on))
+}
+
+func round(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
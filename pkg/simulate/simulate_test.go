@@ -0,0 +1,79 @@
+package simulate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceDeterministic(t *testing.T) {
+	a, err := NewDevice("a", "A", lde.ReefSUD, DefaultConfig, 42)
+	require.NoError(t, err)
+	b, err := NewDevice("a", "A", lde.ReefSUD, DefaultConfig, 42)
+	require.NoError(t, err)
+	ts := time.Date(2021, 1, 13, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 50; i++ {
+		assert.Equal(t, a.Next(ts), b.Next(ts))
+		ts = ts.Add(30 * time.Minute)
+	}
+}
+
+func TestDeviceDiurnalLight(t *testing.T) {
+	config := DefaultConfig
+	config.Location = time.UTC
+	for _, typ := range []lde.SUDType{lde.HomeSUD, lde.PondSUD, lde.ReefSUD} {
+		d, err := NewDevice("a", "A", typ, config, 1)
+		require.NoError(t, err)
+		night := d.Next(time.Date(2021, 1, 13, 2, 0, 0, 0, time.UTC)).SUD.Data
+		day := d.Next(time.Date(2021, 1, 13, 14, 0, 0, 0, time.UTC)).SUD.Data
+		caps := typ.Capabilities()
+		assert.Zero(t, night.Lux, typ.String())
+		assert.Zero(t, night.PAR, typ.String())
+		assert.Greater(t, day.Lux, 0.0, typ.String())
+		assert.Equal(t, caps.PAR, day.PAR > 0, typ.String())
+		assert.Equal(t, caps.Kelvin, day.Kelvin > 0, typ.String())
+	}
+}
+
+func TestDeviceSteady(t *testing.T) {
+	config := Config{Location: time.UTC}
+	d, err := NewDevice("a", "A", lde.HomeSUD, config, 1)
+	require.NoError(t, err)
+	ts := time.Date(2021, 1, 13, 14, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		data := d.Next(ts).SUD.Data
+		assert.Equal(t, lde.SUDStatus{Water: 1}, data.Status)
+		assert.Equal(t, 25.3, data.Temperature)
+		assert.Equal(t, 7.05, data.PH)
+		assert.Equal(t, 0.004, data.NH3)
+	}
+}
+
+func TestDeviceEvents(t *testing.T) {
+	config := Config{OutOfWater: 1, EventPushes: 2, Location: time.UTC}
+	d, err := NewDevice("a", "A", lde.ReefSUD, config, 1)
+	require.NoError(t, err)
+	ts := time.Date(2021, 1, 13, 14, 0, 0, 0, time.UTC)
+	var water []int
+	for i := 0; i < 6; i++ {
+		water = append(water, d.Next(ts).SUD.Data.Status.Water)
+	}
+	// Each event lasts two pushes, and a new event begins as soon as the last ends.
+	assert.Equal(t, []int{0, 0, 1, 0, 0, 1}, water)
+
+	config = Config{Alarm: 1, EventPushes: 100, Location: time.UTC}
+	d, err = NewDevice("a", "A", lde.ReefSUD, config, 1)
+	require.NoError(t, err)
+	s := d.Next(ts).SUD.Data.Status
+	assert.Equal(t, 1, s.Temperature+s.PH+s.NH3, "exactly one reading alarms")
+	assert.Equal(t, s, d.Next(ts).SUD.Data.Status, "the alarm persists")
+}
+
+func TestNewDeviceUnknownType(t *testing.T) {
+	_, err := NewDevice("a", "A", lde.SUDType(9), DefaultConfig, 1)
+	assert.EqualError(t, err, "unsupported SUD type: 9")
+}
@@ -0,0 +1,236 @@
+// Package statsd delivers LDE readings to a StatsD or DogStatsD agent as gauges, and status flags
+// as DogStatsD service checks.
+package statsd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+)
+
+const (
+	// DogStatsD sends tagged metrics and service checks, as understood by the Datadog agent.
+	DogStatsD = "dogstatsd"
+	// StatsD sends untagged gauges, named by the SUD ID, for plain StatsD servers.
+	StatsD = "statsd"
+
+	// unixPrefix marks an address as a Unix datagram socket path.
+	unixPrefix = "unix:"
+
+	// Service check statuses.
+	checkOK       = 0
+	checkCritical = 2
+)
+
+var (
+	// unsafeTagChars matches the characters replaced in tag values, which would break the
+	// protocol.
+	unsafeTagChars = regexp.MustCompile(`[,|#\s]+`)
+	// unsafeNameChars matches the characters replaced in metric name components.
+	unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9_\-]+`)
+)
+
+// Config configures a Sink.
+type Config struct {
+	// Address is the agent's host:port for UDP, or unix:/path/to.sock for a Unix datagram socket.
+	Address string
+	// Flavor is DogStatsD or StatsD. Defaults to DogStatsD.
+	Flavor string
+	// Prefix is prepended to each metric name. Defaults to "seneye.".
+	Prefix string
+	// Tags are added to every DogStatsD metric and service check, ex. "env:home".
+	Tags []string
+	// ServiceChecks sends each DogStatsD status flag as a service check, in addition to a gauge.
+	ServiceChecks bool
+	// MaxPacketSize bounds the size of each datagram. Defaults to 1432 bytes for UDP, and 8192
+	// bytes for Unix sockets.
+	MaxPacketSize int
+	// Timeout bounds each write. Defaults to 1 second.
+	Timeout time.Duration
+}
+
+// Sink is an lde.Sink which writes each accepted reading to a StatsD agent. Datagrams are sent
+// synchronously, but delivery isn't confirmed.
+type Sink struct {
+	config  Config
+	network string
+	address string
+
+	lock sync.Mutex
+	conn net.Conn
+	err  error
+}
+
+var _ lde.RemoteSink = (*Sink)(nil)
+
+// New creates a Sink. The socket is opened lazily.
+func New(config Config) (*Sink, error) {
+	if config.Address == "" {
+		return nil, errors.New("statsd address is required")
+	}
+	switch config.Flavor {
+	case "":
+		config.Flavor = DogStatsD
+	case DogStatsD, StatsD:
+	default:
+		return nil, fmt.Errorf("unsupported statsd flavor: %q", config.Flavor)
+	}
+	if config.Prefix == "" {
+		config.Prefix = "seneye."
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Second
+	}
+	s := &Sink{config: config, network: "udp", address: config.Address}
+	if strings.HasPrefix(config.Address, unixPrefix) {
+		s.network, s.address = "unixgram", strings.TrimPrefix(config.Address, unixPrefix)
+	}
+	if s.config.MaxPacketSize <= 0 {
+		s.config.MaxPacketSize = 1432
+		if s.network == "unixgram" {
+			s.config.MaxPacketSize = 8192
+		}
+	}
+	return s, nil
+}
+
+// Format returns the StatsD lines for the LDE's readings.
+func (s *Sink) Format(l *lde.LDE) []string {
+	if s.config.Flavor == StatsD {
+		return s.formatStatsD(l)
+	}
+	tags := append([]string{
+		"sud_id:" + tagValue(l.SUD.ID),
+		"sud_name:" + tagValue(l.SUD.Name),
+		"sud_type:" + l.SUD.Type.String(),
+	}, s.config.Tags...)
+	tagSuffix := "|#" + strings.Join(tags, ",")
+	var lines []string
+	for _, r := range l.Readings() {
+		name := s.config.Prefix + r.Name
+		if r.Status {
+			name = s.config.Prefix + "status." + r.Name
+		}
+		lines = append(lines, name+":"+formatFloat(r.Value)+"|g"+tagSuffix)
+	}
+	if !s.config.ServiceChecks {
+		return lines
+	}
+	for _, r := range l.Readings() {
+		if !r.Status {
+			continue
+		}
+		status, message := checkOK, r.Name+" ok"
+		if r.Name == "water" {
+			// Water is the only flag which is raised when 0.
+			if r.Value == 0 {
+				status, message = checkCritical, "SUD is out of the water"
+			}
+		} else if r.Value != 0 {
+			status, message = checkCritical, r.Name+" alarm"
+		}
+		lines = append(lines, fmt.Sprintf("_sc|%s%s|%d|d:%d%s|m:%s",
+			s.config.Prefix, r.Name, status, l.SUD.Timestamp, tagSuffix, message))
+	}
+	return lines
+}
+
+// formatStatsD names each gauge by the SUD ID, as plain StatsD lacks tags.
+func (s *Sink) formatStatsD(l *lde.LDE) []string {
+	prefix := s.config.Prefix + unsafeNameChars.ReplaceAllString(l.SUD.ID, "_") + "."
+	var lines []string
+	for _, r := range l.Readings() {
+		name := prefix + r.Name
+		if r.Status {
+			name = prefix + "status." + r.Name
+		}
+		lines = append(lines, name+":"+formatFloat(r.Value)+"|g")
+	}
+	return lines
+}
+
+func tagValue(v string) string {
+	return unsafeTagChars.ReplaceAllString(v, "_")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// Send implements lde.Sink, writing the reading's lines in as few datagrams as possible.
+func (s *Sink) Send(ctx context.Context, p *lde.Push) error {
+	lines := s.Format(p.LDE)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if buf.Len() > 0 && buf.Len()+1+len(line) > s.config.MaxPacketSize {
+			if err := s.writeLocked(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	return s.writeLocked(buf.Bytes())
+}
+
+// writeLocked sends the datagram, opening the socket if needed. A failed socket is reopened on
+// the next write, ex. after the agent restarts. The caller must hold s.lock.
+func (s *Sink) writeLocked(b []byte) error {
+	var err error
+	if s.conn == nil {
+		s.conn, err = net.DialTimeout(s.network, s.address, s.config.Timeout)
+	}
+	if err == nil {
+		s.conn.SetWriteDeadline(time.Now().Add(s.config.Timeout))
+		if _, err = s.conn.Write(b); err != nil {
+			s.conn.Close()
+			s.conn = nil
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("writing to statsd: %w", err)
+	}
+	s.err = err
+	return err
+}
+
+// Name implements lde.RemoteSink.
+func (s *Sink) Name() string {
+	return "statsd"
+}
+
+// Healthy implements lde.HealthySink, reporting the last write error.
+func (s *Sink) Healthy(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+// Close implements lde.Sink, closing the socket.
+func (s *Sink) Close(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,110 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testLDE = &lde.LDE{SUD: lde.SUD{ID: "abc", Name: "Reef, Tank", Type: lde.ReefSUD, Timestamp: 1610539200, Data: lde.Data{
+	Status:      lde.SUDStatus{Water: 1, PH: 1},
+	Temperature: 25.5, PH: 8.5, NH3: 0.002, Kelvin: 14000, Lux: 9000, PAR: 166,
+}}}
+
+func TestFormat(t *testing.T) {
+	s, err := New(Config{Address: "127.0.0.1:8125", Tags: []string{"env:home"}, ServiceChecks: true})
+	require.NoError(t, err)
+	tags := "|#sud_id:abc,sud_name:Reef_Tank,sud_type:reef,env:home"
+	assert.Equal(t, []string{
+		"seneye.temperature:25.5|g" + tags,
+		"seneye.ph:8.5|g" + tags,
+		"seneye.nh3:0.002|g" + tags,
+		"seneye.kelvin:14000|g" + tags,
+		"seneye.lux:9000|g" + tags,
+		"seneye.par:166|g" + tags,
+		"seneye.status.water:1|g" + tags,
+		"seneye.status.temperature:0|g" + tags,
+		"seneye.status.ph:1|g" + tags,
+		"seneye.status.nh3:0|g" + tags,
+		"seneye.status.slide:0|g" + tags,
+		"seneye.status.kelvin:0|g" + tags,
+		"_sc|seneye.water|0|d:1610539200" + tags + "|m:water ok",
+		"_sc|seneye.temperature|0|d:1610539200" + tags + "|m:temperature ok",
+		"_sc|seneye.ph|2|d:1610539200" + tags + "|m:ph alarm",
+		"_sc|seneye.nh3|0|d:1610539200" + tags + "|m:nh3 ok",
+		"_sc|seneye.slide|0|d:1610539200" + tags + "|m:slide ok",
+		"_sc|seneye.kelvin|0|d:1610539200" + tags + "|m:kelvin ok",
+	}, s.Format(testLDE))
+
+	s, err = New(Config{Address: "127.0.0.1:8125", Flavor: StatsD, Prefix: "tank."})
+	require.NoError(t, err)
+	lines := s.Format(testLDE)
+	require.Len(t, lines, 12)
+	assert.Equal(t, "tank.abc.temperature:25.5|g", lines[0])
+	assert.Equal(t, "tank.abc.status.water:1|g", lines[6])
+
+	dry := &lde.LDE{SUD: lde.SUD{ID: "xyz", Type: lde.PondSUD}}
+	s, err = New(Config{Address: "127.0.0.1:8125", ServiceChecks: true})
+	require.NoError(t, err)
+	assert.Contains(t, s.Format(dry), "_sc|seneye.water|2|d:0|#sud_id:xyz,sud_name:,sud_type:pond|m:SUD is out of the water")
+
+	_, err = New(Config{})
+	assert.Error(t, err)
+	_, err = New(Config{Address: "127.0.0.1:8125", Flavor: "graphite"})
+	assert.Error(t, err)
+}
+
+func readPacket(t *testing.T, pc net.PacketConn) string {
+	t.Helper()
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64*1024)
+	n, _, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func TestSendUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+	s, err := New(Config{Address: pc.LocalAddr().String(), MaxPacketSize: 512})
+	require.NoError(t, err)
+	defer s.Close(context.Background())
+
+	require.NoError(t, s.Send(context.Background(), &lde.Push{LDE: testLDE}))
+	// The lines are split across datagrams no larger than MaxPacketSize.
+	var lines []string
+	for len(lines) < 12 {
+		packet := readPacket(t, pc)
+		assert.LessOrEqual(t, len(packet), 512)
+		lines = append(lines, strings.Split(packet, "\n")...)
+	}
+	assert.Equal(t, s.Format(testLDE), lines)
+	assert.NoError(t, s.Healthy(context.Background()))
+}
+
+func TestSendUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dsd.sock")
+	s, err := New(Config{Address: "unix:" + path})
+	require.NoError(t, err)
+	defer s.Close(context.Background())
+
+	// The agent isn't listening yet.
+	assert.Error(t, s.Send(context.Background(), &lde.Push{LDE: testLDE}))
+	assert.Error(t, s.Healthy(context.Background()))
+
+	pc, err := net.ListenPacket("unixgram", path)
+	require.NoError(t, err)
+	defer pc.Close()
+	require.NoError(t, s.Send(context.Background(), &lde.Push{LDE: testLDE}))
+	assert.Equal(t, strings.Join(s.Format(testLDE), "\n"), readPacket(t, pc))
+	assert.NoError(t, s.Healthy(context.Background()))
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrations are applied in order to bring the schema up to date. Released migrations must never
+// be changed; add a new migration instead.
+var migrations = []string{
+	// 1: raw readings and their hourly aggregates.
+	`
+CREATE TABLE readings (
+	sud_id             TEXT    NOT NULL,
+	ts                 INTEGER NOT NULL,
+	sud_name           TEXT    NOT NULL,
+	sud_type           INTEGER NOT NULL,
+	temperature        REAL    NOT NULL,
+	ph                 REAL    NOT NULL,
+	nh3                REAL    NOT NULL,
+	kelvin             REAL    NOT NULL,
+	lux                REAL    NOT NULL,
+	par                REAL    NOT NULL,
+	status_water       INTEGER NOT NULL,
+	status_temperature INTEGER NOT NULL,
+	status_ph          INTEGER NOT NULL,
+	status_nh3         INTEGER NOT NULL,
+	status_slide       INTEGER NOT NULL,
+	status_kelvin      INTEGER NOT NULL,
+	PRIMARY KEY (sud_id, ts)
+) WITHOUT ROWID;
+CREATE INDEX readings_ts ON readings (ts);
+
+CREATE TABLE hourly (
+	sud_id             TEXT    NOT NULL,
+	hour               INTEGER NOT NULL,
+	sud_name           TEXT    NOT NULL,
+	sud_type           INTEGER NOT NULL,
+	samples            INTEGER NOT NULL,
+	temperature_avg    REAL    NOT NULL,
+	temperature_min    REAL    NOT NULL,
+	temperature_max    REAL    NOT NULL,
+	ph_avg             REAL    NOT NULL,
+	ph_min             REAL    NOT NULL,
+	ph_max             REAL    NOT NULL,
+	nh3_avg            REAL    NOT NULL,
+	nh3_min            REAL    NOT NULL,
+	nh3_max            REAL    NOT NULL,
+	kelvin_avg         REAL    NOT NULL,
+	lux_avg            REAL    NOT NULL,
+	par_avg            REAL    NOT NULL,
+	status_water       INTEGER NOT NULL,
+	status_temperature INTEGER NOT NULL,
+	status_ph          INTEGER NOT NULL,
+	status_nh3         INTEGER NOT NULL,
+	status_slide       INTEGER NOT NULL,
+	status_kelvin      INTEGER NOT NULL,
+	PRIMARY KEY (sud_id, hour)
+) WITHOUT ROWID;
+CREATE INDEX hourly_hour ON hourly (hour);
+`,
+}
+
+// migrate applies any migrations which haven't yet been applied, returning the schema version.
+func migrate(ctx context.Context, db *sql.DB) (int, error) {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+		return 0, fmt.Errorf("creating schema_version: %w", err)
+	}
+	var version int
+	err := db.QueryRowContext(ctx, `SELECT version FROM schema_version`).Scan(&version)
+	switch {
+	case err == sql.ErrNoRows:
+		if _, err := db.ExecContext(ctx, `INSERT INTO schema_version (version) VALUES (0)`); err != nil {
+			return 0, fmt.Errorf("initializing schema_version: %w", err)
+		}
+	case err != nil:
+		return 0, fmt.Errorf("reading schema_version: %w", err)
+	}
+	if version > len(migrations) {
+		return version, fmt.Errorf("database schema version %d is newer than this exporter supports (%d)", version, len(migrations))
+	}
+	for ; version < len(migrations); version++ {
+		if err := applyMigration(ctx, db, version+1, migrations[version]); err != nil {
+			return version, err
+		}
+	}
+	return version, nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, version int, stmts string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, stmts); err != nil {
+		return fmt.Errorf("applying migration %d: %w", version, err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE schema_version SET version = ?`, version); err != nil {
+		return fmt.Errorf("applying migration %d: %w", version, err)
+	}
+	return tx.Commit()
+}
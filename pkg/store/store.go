@@ -0,0 +1,304 @@
+// Package store records LDE readings in an embedded SQLite database, so months of history are
+// available without running prometheus. Old readings are downsampled into hourly aggregates, and
+// expired after a retention period.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/history"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	// The pure Go SQLite driver and its embedded build of SQLite.
+	_ "github.com/ncruces/go-sqlite3/driver"
+	_ "github.com/ncruces/go-sqlite3/embed"
+	"github.com/rs/zerolog/log"
+)
+
+// Config configures a Store.
+type Config struct {
+	// Path is the SQLite database file. It's created if it doesn't exist.
+	Path string
+	// DownsampleAfter is the age after which readings are replaced by hourly aggregates. 0
+	// disables downsampling.
+	DownsampleAfter time.Duration
+	// Retention is the age after which readings and aggregates are deleted. 0 keeps them forever.
+	Retention time.Duration
+	// MaintenanceInterval is how often downsampling and retention are applied. Defaults to an hour.
+	MaintenanceInterval time.Duration
+}
+
+// Store is an lde.Sink and history.Source backed by SQLite. It's safe for concurrent use.
+type Store struct {
+	config Config
+	db     *sql.DB
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	lock        sync.Mutex
+	writeErr    error
+	maintainErr error
+
+	now func() time.Time
+}
+
+var _ lde.HealthySink = (*Store)(nil)
+var _ history.Source = (*Store)(nil)
+var _ history.Horizon = (*Store)(nil)
+
+// Open opens the database, applies any pending schema migrations, and starts periodic
+// maintenance.
+func Open(ctx context.Context, config Config) (*Store, error) {
+	return open(ctx, config, time.Now)
+}
+
+// open is Open with the clock maintenance is applied by.
+func open(ctx context.Context, config Config, now func() time.Time) (*Store, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("database path is required")
+	}
+	if config.MaintenanceInterval <= 0 {
+		config.MaintenanceInterval = time.Hour
+	}
+	// WAL allows readers, ex. a long export, to proceed without blocking pushes.
+	dsn := "file:" + (&url.URL{Path: config.Path}).EscapedPath() +
+		"?_pragma=busy_timeout(10000)&_pragma=journal_mode(wal)&_txlock=immediate"
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+	if _, err := migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating database %q: %w", config.Path, err)
+	}
+	s := &Store{config: config, db: db, now: now}
+	maintCtx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.wg.Add(1)
+	go s.maintain(maintCtx)
+	return s, nil
+}
+
+// Send implements lde.Sink, recording the reading. A repeated reading from the same SUD and time
+// replaces the earlier one.
+func (s *Store) Send(ctx context.Context, p *lde.Push) error {
+	l := p.LDE
+	d := l.SUD.Data
+	_, err := s.db.ExecContext(ctx, `
+INSERT OR REPLACE INTO readings (
+	sud_id, ts, sud_name, sud_type,
+	temperature, ph, nh3, kelvin, lux, par,
+	status_water, status_temperature, status_ph, status_nh3, status_slide, status_kelvin
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		l.SUD.ID, l.SUD.Timestamp, l.SUD.Name, int(l.SUD.Type),
+		d.Temperature, d.PH, d.NH3, d.Kelvin, d.Lux, d.PAR,
+		d.Status.Water, d.Status.Temperature, d.Status.PH, d.Status.NH3, d.Status.Slide, d.Status.Kelvin,
+	)
+	if err != nil {
+		err = fmt.Errorf("storing reading: %w", err)
+	}
+	s.lock.Lock()
+	s.writeErr = err
+	s.lock.Unlock()
+	return err
+}
+
+// Healthy implements lde.HealthySink, reporting the error of the last write or the last
+// maintenance pass. Each is cleared only by a later success of its own kind.
+func (s *Store) Healthy(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.writeErr != nil {
+		return s.writeErr
+	}
+	return s.maintainErr
+}
+
+// Close implements lde.Sink, stopping maintenance and closing the database.
+func (s *Store) Close(ctx context.Context) error {
+	s.cancel()
+	s.wg.Wait()
+	return s.db.Close()
+}
+
+// Readings implements history.Source. Readings which have been downsampled are returned as one
+// reading per hour, with the hour's average values and any status alarm raised during the hour.
+func (s *Store) Readings(ctx context.Context, q history.Query, fn func(*lde.LDE) error) error {
+	where, args := "1", []interface{}{}
+	hourWhere, hourArgs := "1", []interface{}{}
+	if !q.Since.IsZero() {
+		where += " AND ts >= ?"
+		args = append(args, q.Since.Unix())
+		hourWhere += " AND hour >= ?"
+		hourArgs = append(hourArgs, q.Since.Unix())
+	}
+	if !q.Until.IsZero() {
+		where += " AND ts < ?"
+		args = append(args, q.Until.Unix())
+		hourWhere += " AND hour < ?"
+		hourArgs = append(hourArgs, q.Until.Unix())
+	}
+	if len(q.SUDIDs) > 0 {
+		in := "?"
+		for range q.SUDIDs[1:] {
+			in += ", ?"
+		}
+		where += " AND sud_id IN (" + in + ")"
+		hourWhere += " AND sud_id IN (" + in + ")"
+		for _, id := range q.SUDIDs {
+			args = append(args, id)
+			hourArgs = append(hourArgs, id)
+		}
+	}
+	rows, err := s.db.QueryContext(ctx, `
+SELECT sud_id, ts, sud_name, sud_type,
+	temperature, ph, nh3, kelvin, lux, par,
+	status_water, status_temperature, status_ph, status_nh3, status_slide, status_kelvin
+FROM readings WHERE `+where+`
+UNION ALL
+SELECT sud_id, hour, sud_name, sud_type,
+	temperature_avg, ph_avg, nh3_avg, kelvin_avg, lux_avg, par_avg,
+	status_water, status_temperature, status_ph, status_nh3, status_slide, status_kelvin
+FROM hourly WHERE `+hourWhere+`
+ORDER BY 2, 1`, append(args, hourArgs...)...)
+	if err != nil {
+		return fmt.Errorf("querying readings: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		l := &lde.LDE{}
+		d := &l.SUD.Data
+		var sudType int
+		err := rows.Scan(
+			&l.SUD.ID, &l.SUD.Timestamp, &l.SUD.Name, &sudType,
+			&d.Temperature, &d.PH, &d.NH3, &d.Kelvin, &d.Lux, &d.PAR,
+			&d.Status.Water, &d.Status.Temperature, &d.Status.PH, &d.Status.NH3, &d.Status.Slide, &d.Status.Kelvin,
+		)
+		if err != nil {
+			return fmt.Errorf("reading readings: %w", err)
+		}
+		l.SUD.Type = lde.SUDType(sudType)
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Horizon implements history.Horizon. Readings older than the shorter of DownsampleAfter and
+// Retention may have been downsampled or deleted.
+func (s *Store) Horizon() time.Duration {
+	h := s.config.DownsampleAfter
+	if r := s.config.Retention; r > 0 && (h <= 0 || r < h) {
+		h = r
+	}
+	return h
+}
+
+// maintain periodically applies downsampling and retention until ctx is done.
+func (s *Store) maintain(ctx context.Context) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.config.MaintenanceInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.Maintain(ctx); ctx.Err() == nil {
+			if err != nil {
+				log.Warn().Err(err).Msg("maintaining database")
+			}
+			s.lock.Lock()
+			s.maintainErr = err
+			s.lock.Unlock()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Maintain downsamples readings older than DownsampleAfter into hourly aggregates and deletes
+// data older than Retention. It's run periodically, but may be called directly.
+func (s *Store) Maintain(ctx context.Context) error {
+	now := s.now()
+	if s.config.DownsampleAfter > 0 {
+		if err := s.downsample(ctx, now.Add(-s.config.DownsampleAfter)); err != nil {
+			return err
+		}
+	}
+	if s.config.Retention > 0 {
+		cutoff := now.Add(-s.config.Retention).Unix()
+		if _, err := s.db.ExecContext(ctx, `DELETE FROM readings WHERE ts < ?`, cutoff); err != nil {
+			return fmt.Errorf("applying retention: %w", err)
+		}
+		if _, err := s.db.ExecContext(ctx, `DELETE FROM hourly WHERE hour < ?`, cutoff); err != nil {
+			return fmt.Errorf("applying retention: %w", err)
+		}
+	}
+	return nil
+}
+
+// downsample aggregates the readings from complete hours before cutoff, replacing them.
+func (s *Store) downsample(ctx context.Context, cutoff time.Time) error {
+	hour := cutoff.Truncate(time.Hour).Unix()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("downsampling: %w", err)
+	}
+	defer tx.Rollback()
+	// Late readings for an hour which was already downsampled are merged into its aggregate,
+	// weighted by sample count.
+	_, err = tx.ExecContext(ctx, `
+INSERT INTO hourly (
+	sud_id, hour, sud_name, sud_type, samples,
+	temperature_avg, temperature_min, temperature_max,
+	ph_avg, ph_min, ph_max,
+	nh3_avg, nh3_min, nh3_max,
+	kelvin_avg, lux_avg, par_avg,
+	status_water, status_temperature, status_ph, status_nh3, status_slide, status_kelvin
+)
+SELECT sud_id, ts - ts % 3600, MAX(sud_name), MAX(sud_type), COUNT(*),
+	AVG(temperature), MIN(temperature), MAX(temperature),
+	AVG(ph), MIN(ph), MAX(ph),
+	AVG(nh3), MIN(nh3), MAX(nh3),
+	AVG(kelvin), AVG(lux), AVG(par),
+	MIN(status_water), MAX(status_temperature), MAX(status_ph), MAX(status_nh3), MAX(status_slide), MAX(status_kelvin)
+FROM readings WHERE ts < ?
+GROUP BY sud_id, ts - ts % 3600
+ON CONFLICT (sud_id, hour) DO UPDATE SET
+	samples = samples + excluded.samples,
+	temperature_avg = (temperature_avg * samples + excluded.temperature_avg * excluded.samples) / (samples + excluded.samples),
+	temperature_min = MIN(temperature_min, excluded.temperature_min),
+	temperature_max = MAX(temperature_max, excluded.temperature_max),
+	ph_avg = (ph_avg * samples + excluded.ph_avg * excluded.samples) / (samples + excluded.samples),
+	ph_min = MIN(ph_min, excluded.ph_min),
+	ph_max = MAX(ph_max, excluded.ph_max),
+	nh3_avg = (nh3_avg * samples + excluded.nh3_avg * excluded.samples) / (samples + excluded.samples),
+	nh3_min = MIN(nh3_min, excluded.nh3_min),
+	nh3_max = MAX(nh3_max, excluded.nh3_max),
+	kelvin_avg = (kelvin_avg * samples + excluded.kelvin_avg * excluded.samples) / (samples + excluded.samples),
+	lux_avg = (lux_avg * samples + excluded.lux_avg * excluded.samples) / (samples + excluded.samples),
+	par_avg = (par_avg * samples + excluded.par_avg * excluded.samples) / (samples + excluded.samples),
+	status_water = MIN(status_water, excluded.status_water),
+	status_temperature = MAX(status_temperature, excluded.status_temperature),
+	status_ph = MAX(status_ph, excluded.status_ph),
+	status_nh3 = MAX(status_nh3, excluded.status_nh3),
+	status_slide = MAX(status_slide, excluded.status_slide),
+	status_kelvin = MAX(status_kelvin, excluded.status_kelvin)`, hour)
+	if err != nil {
+		return fmt.Errorf("downsampling: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM readings WHERE ts < ?`, hour); err != nil {
+		return fmt.Errorf("downsampling: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("downsampling: %w", err)
+	}
+	return nil
+}
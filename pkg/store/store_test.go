@@ -0,0 +1,174 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/history"
+	"github.com/jcodybaker/seneye-exporter/pkg/lde"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestStore(t *testing.T, config Config) *Store {
+	t.Helper()
+	return openTestStoreAt(t, config, time.Now)
+}
+
+// openTestStoreAt opens a store maintained by the clock now.
+func openTestStoreAt(t *testing.T, config Config, now func() time.Time) *Store {
+	t.Helper()
+	if config.Path == "" {
+		config.Path = filepath.Join(t.TempDir(), "seneye.db")
+	}
+	s, err := open(context.Background(), config, now)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close(context.Background()) })
+	return s
+}
+
+func reading(id string, ts time.Time, temp float64, status lde.SUDStatus) *lde.Push {
+	return &lde.Push{LDE: &lde.LDE{SUD: lde.SUD{
+		ID:        id,
+		Name:      "Tank " + id,
+		Type:      lde.ReefSUD,
+		Timestamp: ts.Unix(),
+		Data: lde.Data{
+			Status:      status,
+			Temperature: temp,
+			PH:          8.2,
+			NH3:         0.002,
+			Kelvin:      14000,
+			Lux:         9000,
+			PAR:         166,
+		},
+	}}}
+}
+
+func collect(t *testing.T, s *Store, q history.Query) []*lde.LDE {
+	t.Helper()
+	var out []*lde.LDE
+	require.NoError(t, s.Readings(context.Background(), q, func(l *lde.LDE) error {
+		out = append(out, l)
+		return nil
+	}))
+	return out
+}
+
+func TestStoreReadings(t *testing.T) {
+	s := openTestStore(t, Config{})
+	ctx := context.Background()
+	start := time.Date(2021, 1, 13, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		ts := start.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, s.Send(ctx, reading("abc", ts, 25+float64(i), lde.SUDStatus{Water: 1})))
+		require.NoError(t, s.Send(ctx, reading("xyz", ts, 20, lde.SUDStatus{Water: 1})))
+	}
+	// A repeated reading replaces the original.
+	require.NoError(t, s.Send(ctx, reading("abc", start, 24, lde.SUDStatus{Water: 1})))
+	require.NoError(t, s.Healthy(ctx))
+
+	all := collect(t, s, history.Query{})
+	require.Len(t, all, 6)
+	assert.Equal(t, reading("abc", start, 24, lde.SUDStatus{Water: 1}).LDE, all[0])
+	assert.Equal(t, "xyz", all[1].SUD.ID)
+
+	got := collect(t, s, history.Query{
+		SUDIDs: []string{"abc"},
+		Since:  start.Add(time.Minute),
+		Until:  start.Add(2 * time.Minute),
+	})
+	require.Len(t, got, 1)
+	assert.Equal(t, 26.0, got[0].SUD.Data.Temperature)
+}
+
+func TestStoreDownsampleAndRetention(t *testing.T) {
+	now := time.Date(2021, 1, 20, 12, 30, 0, 0, time.UTC)
+	s := openTestStoreAt(t, Config{DownsampleAfter: 24 * time.Hour, Retention: 7 * 24 * time.Hour},
+		func() time.Time { return now })
+	ctx := context.Background()
+
+	old := time.Date(2021, 1, 15, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, s.Send(ctx, reading("abc", old, 24, lde.SUDStatus{Water: 1})))
+	require.NoError(t, s.Send(ctx, reading("abc", old.Add(20*time.Minute), 25, lde.SUDStatus{Water: 1, Temperature: 1})))
+	require.NoError(t, s.Send(ctx, reading("abc", old.Add(40*time.Minute), 26, lde.SUDStatus{Water: 0})))
+	expired := now.Add(-8 * 24 * time.Hour)
+	require.NoError(t, s.Send(ctx, reading("abc", expired, 24, lde.SUDStatus{Water: 1})))
+	recent := now.Add(-time.Hour)
+	require.NoError(t, s.Send(ctx, reading("abc", recent, 25.5, lde.SUDStatus{Water: 1})))
+
+	require.NoError(t, s.Maintain(ctx))
+	got := collect(t, s, history.Query{})
+	require.Len(t, got, 2)
+	assert.Equal(t, old.Unix(), got[0].SUD.Timestamp)
+	assert.Equal(t, 25.0, got[0].SUD.Data.Temperature)
+	assert.Equal(t, lde.SUDStatus{Water: 0, Temperature: 1}, got[0].SUD.Data.Status)
+	assert.Equal(t, "Tank abc", got[0].SUD.Name)
+	assert.Equal(t, lde.ReefSUD, got[0].SUD.Type)
+	assert.Equal(t, recent.Unix(), got[1].SUD.Timestamp)
+
+	// A late reading for a downsampled hour is merged into its aggregate.
+	require.NoError(t, s.Send(ctx, reading("abc", old.Add(50*time.Minute), 29, lde.SUDStatus{Water: 1})))
+	require.NoError(t, s.Maintain(ctx))
+	got = collect(t, s, history.Query{Until: old.Add(time.Hour)})
+	require.Len(t, got, 1)
+	assert.Equal(t, 26.0, got[0].SUD.Data.Temperature)
+
+	var samples int
+	var min, max float64
+	require.NoError(t, s.db.QueryRow(`SELECT samples, temperature_min, temperature_max FROM hourly`).Scan(&samples, &min, &max))
+	assert.Equal(t, 4, samples)
+	assert.Equal(t, 24.0, min)
+	assert.Equal(t, 29.0, max)
+}
+
+func TestStoreHealthy(t *testing.T) {
+	s := openTestStore(t, Config{Retention: 7 * 24 * time.Hour, MaintenanceInterval: 10 * time.Millisecond})
+	ctx := context.Background()
+	_, err := s.db.Exec(`DROP TABLE hourly`)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return s.Healthy(ctx) != nil }, 5*time.Second, 10*time.Millisecond)
+
+	// A successful write doesn't clear a failing maintenance pass.
+	require.NoError(t, s.Send(ctx, reading("abc", time.Now(), 25, lde.SUDStatus{Water: 1})))
+	assert.Error(t, s.Healthy(ctx))
+}
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seneye.db")
+	s := openTestStore(t, Config{Path: path})
+	require.NoError(t, s.Send(context.Background(), reading("abc", time.Unix(1610539200, 0), 25, lde.SUDStatus{})))
+	require.NoError(t, s.Close(context.Background()))
+
+	// Reopening keeps the data and doesn't reapply migrations.
+	s = openTestStore(t, Config{Path: path})
+	assert.Len(t, collect(t, s, history.Query{}), 1)
+	version, err := migrate(context.Background(), s.db)
+	require.NoError(t, err)
+	assert.Equal(t, len(migrations), version)
+
+	// A database from a newer exporter is refused.
+	_, err = s.db.Exec(`UPDATE schema_version SET version = 99`)
+	require.NoError(t, err)
+	require.NoError(t, s.Close(context.Background()))
+	_, err = Open(context.Background(), Config{Path: path})
+	assert.Error(t, err)
+}
+
+func TestStoreHorizon(t *testing.T) {
+	for _, tc := range []struct {
+		config   Config
+		expected time.Duration
+	}{
+		{Config{}, 0},
+		{Config{DownsampleAfter: 720 * time.Hour}, 720 * time.Hour},
+		{Config{Retention: 48 * time.Hour}, 48 * time.Hour},
+		{Config{DownsampleAfter: 720 * time.Hour, Retention: 48 * time.Hour}, 48 * time.Hour},
+		{Config{DownsampleAfter: 720 * time.Hour, Retention: 8760 * time.Hour}, 720 * time.Hour},
+	} {
+		assert.Equal(t, tc.expected, (&Store{config: tc.config}).Horizon(), "%+v", tc.config)
+	}
+}
@@ -0,0 +1,163 @@
+// Package tlsconfig builds TLS configurations for the exporter's listeners, reloading certificates
+// from disk when they are renewed.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// reloadCheckInterval limits how often certificate files are checked for changes.
+var reloadCheckInterval = 5 * time.Second
+
+// Config describes the TLS configuration of a listener.
+type Config struct {
+	// CertFile is the path to the PEM encoded certificate chain.
+	CertFile string
+	// KeyFile is the path to the PEM encoded private key.
+	KeyFile string
+	// ClientCAFile is the path to PEM encoded CA certificates. If set, clients must present a
+	// certificate signed by one of these CAs (mutual TLS).
+	ClientCAFile string
+	// MinVersion is the minimum TLS version accepted: "1.0", "1.1", "1.2", or "1.3". Defaults to
+	// "1.2".
+	MinVersion string
+}
+
+// Enabled is true if TLS has been configured.
+func (c Config) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || c.ClientCAFile != ""
+}
+
+// TLSConfig builds a tls.Config which reloads the certificate, key and client CAs whenever their
+// files change.
+func (c Config) TLSConfig() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, fmt.Errorf("both a TLS certificate and key are required")
+	}
+	minVersion, err := parseVersion(c.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	r := &reloader{config: c}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	base := &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: r.getCertificate,
+	}
+	if c.ClientCAFile != "" {
+		base.ClientAuth = tls.RequireAndVerifyClientCert
+		base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := base.Clone()
+			cfg.ClientCAs = r.getClientCAs()
+			cfg.GetConfigForClient = nil
+			return cfg, nil
+		}
+	}
+	return base, nil
+}
+
+func parseVersion(v string) (uint16, error) {
+	switch v {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown TLS version %q", v)
+	}
+}
+
+// reloader caches the certificate and client CAs, reloading them when their files change.
+type reloader struct {
+	config Config
+
+	lock      sync.Mutex
+	lastCheck time.Time
+	modTimes  map[string]time.Time
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+func (r *reloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.maybeReload()
+	return r.cert, nil
+}
+
+func (r *reloader) getClientCAs() *x509.CertPool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.maybeReload()
+	return r.clientCAs
+}
+
+// maybeReload reloads the files if they've changed since they were last loaded. On failure, the
+// previously loaded files remain in use. The caller must hold r.lock.
+func (r *reloader) maybeReload() {
+	if time.Since(r.lastCheck) < reloadCheckInterval {
+		return
+	}
+	r.lastCheck = time.Now()
+	for path, modTime := range r.modTimes {
+		fi, err := os.Stat(path)
+		if err != nil || !fi.ModTime().Equal(modTime) {
+			if err := r.reload(); err != nil {
+				log.Warn().Err(err).Str("cert_file", r.config.CertFile).
+					Msg("reloading TLS configuration; continuing with the previously loaded files")
+				return
+			}
+			log.Info().Str("cert_file", r.config.CertFile).Msg("reloaded TLS configuration")
+			return
+		}
+	}
+}
+
+// reload loads all files. The caller must hold r.lock, or have exclusive access.
+func (r *reloader) reload() error {
+	modTimes := make(map[string]time.Time)
+	for _, path := range []string{r.config.CertFile, r.config.KeyFile, r.config.ClientCAFile} {
+		if path == "" {
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		modTimes[path] = fi.ModTime()
+	}
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS key pair: %w", err)
+	}
+	var clientCAs *x509.CertPool
+	if r.config.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("loading client CAs: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no client CA certificates found in %q", r.config.ClientCAFile)
+		}
+	}
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	r.lastCheck = time.Now()
+	return nil
+}
@@ -0,0 +1,204 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns a PEM encoded certificate and key signed by the CA.
+func (ca *testCA) issue(t *testing.T, serial int64, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, b []byte, modTime time.Time) {
+	t.Helper()
+	require.NoError(t, ioutil.WriteFile(path, b, 0600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+// startServer serves HTTPS with the config, returning its URL.
+func startServer(t *testing.T, c Config) string {
+	t.Helper()
+	tlsConfig, err := c.TLSConfig()
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}),
+		ErrorLog: log.New(ioutil.Discard, "", 0),
+	}
+	go s.Serve(tls.NewListener(l, tlsConfig))
+	t.Cleanup(func() { s.Close() })
+	return "https://" + l.Addr().String()
+}
+
+func serverSerial(t *testing.T, url string, clientConfig *tls.Config) (int64, error) {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+	res, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	return res.TLS.PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestTLSReload(t *testing.T) {
+	defer func(i time.Duration) { reloadCheckInterval = i }(reloadCheckInterval)
+	reloadCheckInterval = 0
+
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	c := Config{
+		CertFile: filepath.Join(dir, "tls.crt"),
+		KeyFile:  filepath.Join(dir, "tls.key"),
+	}
+	certPEM, keyPEM := ca.issue(t, 100, x509.ExtKeyUsageServerAuth)
+	modTime := time.Now().Add(-time.Minute)
+	writeFile(t, c.CertFile, certPEM, modTime)
+	writeFile(t, c.KeyFile, keyPEM, modTime)
+	url := startServer(t, c)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	serial, err := serverSerial(t, url, &tls.Config{RootCAs: roots})
+	require.NoError(t, err)
+	assert.EqualValues(t, 100, serial)
+
+	certPEM, keyPEM = ca.issue(t, 200, x509.ExtKeyUsageServerAuth)
+	writeFile(t, c.CertFile, certPEM, time.Now())
+	writeFile(t, c.KeyFile, keyPEM, time.Now())
+	serial, err = serverSerial(t, url, &tls.Config{RootCAs: roots})
+	require.NoError(t, err)
+	assert.EqualValues(t, 200, serial)
+
+	// A broken renewal leaves the previous certificate in use.
+	writeFile(t, c.KeyFile, []byte("garbage"), time.Now().Add(time.Minute))
+	serial, err = serverSerial(t, url, &tls.Config{RootCAs: roots})
+	require.NoError(t, err)
+	assert.EqualValues(t, 200, serial)
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	c := Config{
+		CertFile:   filepath.Join(dir, "tls.crt"),
+		KeyFile:    filepath.Join(dir, "tls.key"),
+		MinVersion: "1.3",
+	}
+	certPEM, keyPEM := ca.issue(t, 100, x509.ExtKeyUsageServerAuth)
+	writeFile(t, c.CertFile, certPEM, time.Now())
+	writeFile(t, c.KeyFile, keyPEM, time.Now())
+	url := startServer(t, c)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	_, err := serverSerial(t, url, &tls.Config{RootCAs: roots, MaxVersion: tls.VersionTLS12})
+	assert.Error(t, err)
+	_, err = serverSerial(t, url, &tls.Config{RootCAs: roots})
+	assert.NoError(t, err)
+
+	_, err = Config{CertFile: c.CertFile, KeyFile: c.KeyFile, MinVersion: "2.0"}.TLSConfig()
+	assert.EqualError(t, err, `unknown TLS version "2.0"`)
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	clientCA := newTestCA(t)
+	c := Config{
+		CertFile:     filepath.Join(dir, "tls.crt"),
+		KeyFile:      filepath.Join(dir, "tls.key"),
+		ClientCAFile: filepath.Join(dir, "client-ca.crt"),
+	}
+	certPEM, keyPEM := ca.issue(t, 100, x509.ExtKeyUsageServerAuth)
+	writeFile(t, c.CertFile, certPEM, time.Now())
+	writeFile(t, c.KeyFile, keyPEM, time.Now())
+	writeFile(t, c.ClientCAFile, clientCA.pem, time.Now())
+	url := startServer(t, c)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	_, err := serverSerial(t, url, &tls.Config{RootCAs: roots})
+	assert.Error(t, err, "clients without a certificate must be rejected")
+
+	clientCertPEM, clientKeyPEM := clientCA.issue(t, 300, x509.ExtKeyUsageClientAuth)
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	require.NoError(t, err)
+	_, err = serverSerial(t, url, &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientCert}})
+	assert.NoError(t, err)
+
+	// Certificates from another CA are rejected.
+	otherCertPEM, otherKeyPEM := ca.issue(t, 400, x509.ExtKeyUsageClientAuth)
+	otherCert, err := tls.X509KeyPair(otherCertPEM, otherKeyPEM)
+	require.NoError(t, err)
+	_, err = serverSerial(t, url, &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{otherCert}})
+	assert.Error(t, err)
+}
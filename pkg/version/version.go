@@ -0,0 +1,61 @@
+// Package version describes the build of the exporter. Version and Commit are injected at build
+// time, ex:
+//
+//	go build -ldflags "-X github.com/jcodybaker/seneye-exporter/pkg/version.Version=v1.2.3 \
+//	  -X github.com/jcodybaker/seneye-exporter/pkg/version.Commit=$(git rev-parse HEAD)"
+package version
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Version is the release version of the exporter.
+	Version = "dev"
+	// Commit is the git commit the exporter was built from.
+	Commit = "unknown"
+)
+
+// Info describes the build of the exporter.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build info.
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// NewCollector returns a collector exporting the seneye_exporter_build_info metric.
+func NewCollector() prometheus.Collector {
+	info := Get()
+	g := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "seneye_exporter_build_info",
+		Help: "A metric with a constant '1' value labeled by the version, commit, and go version the exporter was built from.",
+		ConstLabels: prometheus.Labels{
+			"version":   info.Version,
+			"commit":    info.Commit,
+			"goversion": info.GoVersion,
+		},
+	})
+	g.Set(1)
+	return g
+}
+
+// Handler serves the build info as JSON.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Get())
+	})
+}
@@ -0,0 +1,31 @@
+package version
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildInfo(t *testing.T) {
+	defer func(v, c string) { Version, Commit = v, c }(Version, Commit)
+	Version, Commit = "v1.2.3", "abc123"
+
+	expected := `# HELP seneye_exporter_build_info A metric with a constant '1' value labeled by the version, commit, and go version the exporter was built from.
+# TYPE seneye_exporter_build_info gauge
+seneye_exporter_build_info{commit="abc123",goversion="` + runtime.Version() + `",version="v1.2.3"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(NewCollector(), strings.NewReader(expected)))
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	var info Info
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	assert.Equal(t, Info{Version: "v1.2.3", Commit: "abc123", GoVersion: runtime.Version()}, info)
+}
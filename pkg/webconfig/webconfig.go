@@ -0,0 +1,186 @@
+// Package webconfig loads listener configuration in the Prometheus exporter-toolkit web
+// configuration file format, providing TLS and authentication for the exporter's HTTP servers.
+// See https://github.com/prometheus/exporter-toolkit/blob/master/docs/web-configuration.md
+package webconfig
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/tlsconfig"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is a web configuration file.
+type Config struct {
+	TLSServerConfig  *TLSServerConfig  `yaml:"tls_server_config"`
+	HTTPServerConfig HTTPServerConfig  `yaml:"http_server_config"`
+	BasicAuthUsers   map[string]string `yaml:"basic_auth_users"`
+	// BearerTokens is an extension to the exporter-toolkit format, listing tokens accepted in the
+	// Authorization header.
+	BearerTokens []string `yaml:"bearer_tokens"`
+
+	// authCache records the credentials which have passed bcrypt verification so the expensive
+	// comparison is only made once.
+	authCache sync.Map
+}
+
+// TLSServerConfig describes the TLS configuration of the server.
+type TLSServerConfig struct {
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file"`
+	ClientAuthType string `yaml:"client_auth_type"`
+	ClientCAFile   string `yaml:"client_ca_file"`
+	MinVersion     string `yaml:"min_version"`
+}
+
+// HTTPServerConfig describes the HTTP configuration of the server.
+type HTTPServerConfig struct {
+	// HTTP2 enables HTTP/2 over TLS; defaults to true.
+	HTTP2 *bool `yaml:"http2"`
+	// Headers are added to every response from an authenticated handler, including rejections.
+	Headers map[string]string `yaml:"headers"`
+}
+
+// Load reads the web configuration file at path.
+func Load(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading web config: %w", err)
+	}
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return nil, fmt.Errorf("parsing web config %q: %w", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("validating web config %q: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *Config) validate() error {
+	for user, hash := range c.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("basic auth password for user %q is not a bcrypt hash: %w", user, err)
+		}
+	}
+	for _, t := range c.BearerTokens {
+		if t == "" {
+			return fmt.Errorf("bearer tokens may not be empty")
+		}
+	}
+	if c.TLSServerConfig != nil {
+		_, err := c.TLS()
+		return err
+	}
+	return nil
+}
+
+// TLS translates the tls_server_config into a tlsconfig.Config. The zero Config is returned if
+// TLS isn't configured.
+func (c *Config) TLS() (tlsconfig.Config, error) {
+	t := c.TLSServerConfig
+	if t == nil {
+		return tlsconfig.Config{}, nil
+	}
+	out := tlsconfig.Config{
+		CertFile: t.CertFile,
+		KeyFile:  t.KeyFile,
+	}
+	switch t.ClientAuthType {
+	case "", "NoClientCert":
+	case "RequireAndVerifyClientCert":
+		if t.ClientCAFile == "" {
+			return out, fmt.Errorf("client_ca_file is required for client_auth_type %q", t.ClientAuthType)
+		}
+		out.ClientCAFile = t.ClientCAFile
+	default:
+		return out, fmt.Errorf("unsupported client_auth_type %q", t.ClientAuthType)
+	}
+	switch t.MinVersion {
+	case "":
+	case "TLS10":
+		out.MinVersion = "1.0"
+	case "TLS11":
+		out.MinVersion = "1.1"
+	case "TLS12":
+		out.MinVersion = "1.2"
+	case "TLS13":
+		out.MinVersion = "1.3"
+	default:
+		return out, fmt.Errorf("unknown min_version %q", t.MinVersion)
+	}
+	return out, nil
+}
+
+// HTTP2 is true unless HTTP/2 has been disabled.
+func (c *Config) HTTP2() bool {
+	return c.HTTPServerConfig.HTTP2 == nil || *c.HTTPServerConfig.HTTP2
+}
+
+// AuthEnabled is true if any authentication method is configured.
+func (c *Config) AuthEnabled() bool {
+	return len(c.BasicAuthUsers) > 0 || len(c.BearerTokens) > 0
+}
+
+// Authenticate wraps h, rejecting requests which don't present valid basic auth or bearer
+// credentials. If no authentication is configured, all requests are allowed. The configured
+// headers are set on every response, including rejections.
+func (c *Config) Authenticate(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range c.HTTPServerConfig.Headers {
+			w.Header().Set(k, v)
+		}
+		if !c.AuthEnabled() || c.authorized(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if len(c.BasicAuthUsers) > 0 {
+			w.Header().Add("WWW-Authenticate", `Basic realm="seneye-exporter"`)
+		}
+		if len(c.BearerTokens) > 0 {
+			w.Header().Add("WWW-Authenticate", "Bearer")
+		}
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+// dummyHash is compared against when the user is unknown, so the response time doesn't reveal
+// which users exist.
+var dummyHash = []byte("$2a$10$VYrS/ELtjiAcaNPDFffja.FVGf6JHz/fRA8dEvFco8uVSw4o.6K.K")
+
+func (c *Config) authorized(r *http.Request) bool {
+	if user, pass, ok := r.BasicAuth(); ok {
+		hash, known := c.BasicAuthUsers[user]
+		if !known {
+			bcrypt.CompareHashAndPassword(dummyHash, []byte(pass))
+			return false
+		}
+		cacheKey := sha256.Sum256([]byte(user + "\x00" + hash + "\x00" + pass))
+		if _, ok := c.authCache.Load(cacheKey); ok {
+			return true
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) != nil {
+			return false
+		}
+		c.authCache.Store(cacheKey, struct{}{})
+		return true
+	}
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= len(prefix) || auth[:len(prefix)] != prefix {
+		return false
+	}
+	presented := []byte(auth[len(prefix):])
+	var match int
+	for _, t := range c.BearerTokens {
+		match |= subtle.ConstantTimeCompare(presented, []byte(t))
+	}
+	return match == 1
+}
@@ -0,0 +1,169 @@
+package webconfig
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/jcodybaker/seneye-exporter/pkg/tlsconfig"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// secretHash is the bcrypt hash of "secret".
+const secretHash = "$2a$10$gLPdUb7vcGEdFs4KmW8VyeIfOvAI4aGA/APvzUDYWsRWdxY0K4Tei"
+
+func loadConfig(t *testing.T, yaml string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "web.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(yaml), 0600))
+	return Load(path)
+}
+
+func TestLoad(t *testing.T) {
+	c, err := loadConfig(t, `
+tls_server_config:
+  cert_file: tls.crt
+  key_file: tls.key
+  client_auth_type: RequireAndVerifyClientCert
+  client_ca_file: ca.crt
+  min_version: TLS13
+http_server_config:
+  http2: false
+  headers:
+    X-Frame-Options: deny
+basic_auth_users:
+  prometheus: `+secretHash+`
+`)
+	require.NoError(t, err)
+	tc, err := c.TLS()
+	require.NoError(t, err)
+	assert.Equal(t, tlsconfig.Config{
+		CertFile:     "tls.crt",
+		KeyFile:      "tls.key",
+		ClientCAFile: "ca.crt",
+		MinVersion:   "1.3",
+	}, tc)
+	assert.False(t, c.HTTP2())
+	assert.True(t, c.AuthEnabled())
+
+	tcs := []struct {
+		name      string
+		yaml      string
+		expectErr string
+	}{
+		{
+			name:      "unknown field",
+			yaml:      "basic_auth_user: {}",
+			expectErr: "field basic_auth_user not found",
+		},
+		{
+			name:      "plaintext password",
+			yaml:      "basic_auth_users: {prometheus: secret}",
+			expectErr: `basic auth password for user "prometheus" is not a bcrypt hash`,
+		},
+		{
+			name:      "client ca required",
+			yaml:      "tls_server_config: {cert_file: a, key_file: b, client_auth_type: RequireAndVerifyClientCert}",
+			expectErr: `client_ca_file is required for client_auth_type "RequireAndVerifyClientCert"`,
+		},
+		{
+			name:      "unsupported client auth type",
+			yaml:      "tls_server_config: {cert_file: a, key_file: b, client_auth_type: RequestClientCert}",
+			expectErr: `unsupported client_auth_type "RequestClientCert"`,
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadConfig(t, tc.yaml)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectErr)
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	c, err := loadConfig(t, `
+basic_auth_users:
+  prometheus: `+secretHash+`
+bearer_tokens:
+- token1
+- token2
+http_server_config:
+  headers:
+    X-Frame-Options: deny
+`)
+	require.NoError(t, err)
+	h := c.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tcs := []struct {
+		name     string
+		setAuth  func(r *http.Request)
+		expected int
+	}{
+		{
+			name:     "no credentials",
+			setAuth:  func(r *http.Request) {},
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:     "basic auth",
+			setAuth:  func(r *http.Request) { r.SetBasicAuth("prometheus", "secret") },
+			expected: http.StatusNoContent,
+		},
+		{
+			name:     "basic auth wrong password",
+			setAuth:  func(r *http.Request) { r.SetBasicAuth("prometheus", "wrong") },
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:     "basic auth unknown user",
+			setAuth:  func(r *http.Request) { r.SetBasicAuth("grafana", "secret") },
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:     "bearer token",
+			setAuth:  func(r *http.Request) { r.Header.Set("Authorization", "Bearer token2") },
+			expected: http.StatusNoContent,
+		},
+		{
+			name:     "wrong bearer token",
+			setAuth:  func(r *http.Request) { r.Header.Set("Authorization", "Bearer token3") },
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:     "bare token",
+			setAuth:  func(r *http.Request) { r.Header.Set("Authorization", "token1") },
+			expected: http.StatusUnauthorized,
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			// Run twice to exercise the auth cache.
+			for i := 0; i < 2; i++ {
+				r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+				tc.setAuth(r)
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, r)
+				assert.Equal(t, tc.expected, rec.Code)
+				assert.Equal(t, "deny", rec.Header().Get("X-Frame-Options"))
+			}
+		})
+	}
+}
+
+func TestAuthenticateDisabled(t *testing.T) {
+	h := (&Config{}).Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+}